@@ -1,19 +1,90 @@
 package abp
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
 )
 
-// Header Flags
+// names may be at most MaxNameLength bytes, like paths on Linux
+const MaxNameLength = 4096
+
+// Priority classes; receivers and the Mux serve higher classes first
+const (
+	PRIORITY_BULK   = 0
+	PRIORITY_NORMAL = 1
+	PRIORITY_HIGH   = 2
+)
+
+// ParsePriority parses the name of a priority class: high, normal or
+// bulk.
+func ParsePriority(name string) (int, error) {
+	switch name {
+	case "high":
+		return PRIORITY_HIGH, nil
+	case "normal":
+		return PRIORITY_NORMAL, nil
+	case "bulk":
+		return PRIORITY_BULK, nil
+	}
+	return 0, fmt.Errorf("invalid priority %q, want high|normal|bulk", name)
+}
+
+// PriorityString names a priority class.
+func PriorityString(priority int) string {
+	switch priority {
+	case PRIORITY_HIGH:
+		return "high"
+	case PRIORITY_NORMAL:
+		return "normal"
+	case PRIORITY_BULK:
+		return "bulk"
+	}
+	return fmt.Sprintf("priority %d", priority)
+}
+
+// Error codes, carried in the 16 bit payload of HDR_ERROR packets with
+// which a receiver aborts a transfer
 const (
-	HDR_FILENAME    = 0x1
-	HDR_ALTERNATING = 0x2
-	HDR_FIN         = 0x4
+	ERR_NO_SPACE  = 0x1
+	ERR_REFUSED   = 0x2
+	ERR_WRITE     = 0x3
+	ERR_NOT_FOUND = 0x4
+	ERR_CANCELED  = 0x5
 )
 
+func ErrorMessage(code uint16) string {
+	switch code {
+	case ERR_NO_SPACE:
+		return "not enough disk space on the receiver"
+	case ERR_REFUSED:
+		return "the receiver refused the file"
+	case ERR_WRITE:
+		return "the receiver failed to store the file"
+	case ERR_NOT_FOUND:
+		return "the receiver has no such file"
+	case ERR_CANCELED:
+		return "the transfer was canceled on the receiver"
+	default:
+		return fmt.Sprintf("unknown error %d", code)
+	}
+}
+
+// the ACK of a data packet (but not of the FIN) may carry the receiver's
+// window: how many payload bytes it is willing to accept with the next
+// packet, as a 32 bit big endian number. 0 asks the sender to pause and
+// probe with empty data packets; an ACK without payload sets no limit.
+const WindowLength = 4
+
+// ParseWindow returns the window advertised in the payload of a data ACK,
+// or -1 if there is none.
+func ParseWindow(payload []byte) int64 {
+	if len(payload) != WindowLength {
+		return -1
+	}
+	return int64(binary.BigEndian.Uint32(payload))
+}
+
 // ABP Header structure
 type Header struct {
 	Checksum uint32
@@ -24,21 +95,130 @@ type Header struct {
 // XXX maybe calculated automatically using the unsafe-package...
 const HeaderLength int = 8
 
+// packets are at most DefaultPacketLength bytes incl. header, which fits the
+// guaranteed Internet MTU of 576, unless the sender discovered a larger path
+// MTU. receivers accept up to MaxPacketLength bytes, enough for jumbo frames
+// (9000 bytes minus IPv4 and UDP headers).
+const DefaultPacketLength int = 512
+const MaxPacketLength int = 9000 - 28
+
 func SerializeHeader(hdr Header) []byte {
-	var bin_buf bytes.Buffer
-	binary.Write(&bin_buf, binary.BigEndian, hdr)
-	return bin_buf.Bytes()
+	buf, _ := hdr.MarshalBinary()
+	return buf
 }
 
-func VerifyChecksum(buffer []byte) bool {
-	crc32q := crc32.MakeTable(0xD5828281)
+func (hdr Header) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, HeaderLength)
+	binary.BigEndian.PutUint32(buf, hdr.Checksum)
+	binary.BigEndian.PutUint16(buf[4:], hdr.Length)
+	binary.BigEndian.PutUint16(buf[6:], hdr.Flags)
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a regular header and validates it.
+func (hdr *Header) UnmarshalBinary(data []byte) error {
+	if len(data) < HeaderLength {
+		return ErrShortPacket
+	}
+	hdr.Checksum = binary.BigEndian.Uint32(data)
+	hdr.Length = binary.BigEndian.Uint16(data[4:])
+	hdr.Flags = binary.BigEndian.Uint16(data[6:])
+	return hdr.Validate()
+}
+
+// Validate rejects headers no peer sends: payloads that can't fit into a
+// packet and flags that don't go together.
+func (hdr Header) Validate() error {
+	if int(hdr.Length) > MaxPacketLength-HeaderLength {
+		return ErrInvalidLength
+	}
+	f := hdr.Flags
+	switch {
+	case isControlPacket(f):
+		// control packets stand alone
+	case f&HDR_FILENAME != 0:
+		if f&^(HDR_FILENAME|HDR_PRIORITY|optionFlags) != 0 {
+			return ErrInvalidFlags
+		}
+	case f&^dataFlags == 0:
+		// holes of sparse files aren't FEC protected
+		if f&(HDR_FEC|HDR_SKIP) == HDR_FEC|HDR_SKIP {
+			return ErrInvalidFlags
+		}
+	case f&^optionFlags == 0:
+		// the FILENAME ACK
+	default:
+		return ErrInvalidFlags
+	}
+	return nil
+}
+
+// ParsePacket checks a received packet and splits it into header and
+// payload. With compact set, the compact encoding is tried first, since a
+// peer may still retransmit its regular FILENAME packet.
+func ParsePacket(buffer []byte, compact bool) (Header, []byte, error) {
+	if compact && VerifyCompactChecksum(buffer) {
+		hdr, hdrLen, _ := ParseCompactHeader(buffer)
+		if err := hdr.Validate(); err != nil {
+			return hdr, nil, err
+		}
+		return hdr, buffer[hdrLen : hdrLen+int(hdr.Length)], nil
+	}
+
 	var hdr Header
-	binary.Read(bytes.NewReader(buffer[:HeaderLength]), binary.BigEndian, &hdr)
+	if err := hdr.UnmarshalBinary(buffer); err != nil {
+		return hdr, nil, err
+	}
+	if int(hdr.Length) > len(buffer)-HeaderLength {
+		return hdr, nil, ErrShortPacket
+	}
+	if hdr.Checksum != crc32.Checksum(buffer[4:HeaderLength+int(hdr.Length)], crc32q) {
+		return hdr, nil, ErrChecksum
+	}
+	return hdr, buffer[HeaderLength : HeaderLength+int(hdr.Length)], nil
+}
+
+// the polynomial of the packet checksum, CRC-32/Q
+var crc32q = crc32.MakeTable(0xD5828281)
+
+// Checksum computes the checksum of the header fields following the
+// checksum and the payload in one pass, without assembling the packet.
+func Checksum(hdr []byte, payload []byte) uint32 {
+	crc := crc32.Update(0, crc32q, hdr[4:])
+	return crc32.Update(crc, crc32q, payload)
+}
+
+// SetChecksum checksums an assembled packet and patches the checksum into
+// its first four bytes, which both header encodings reserve for it.
+func SetChecksum(pkt []byte) {
+	binary.BigEndian.PutUint32(pkt, crc32.Checksum(pkt[4:], crc32q))
+}
+
+// SealChecksum XORs token into the checksum of an assembled packet. a
+// receiver seals its replies with the session token the sender chose in
+// its HELLO (see EXT_TOKEN), and the sender unseals them the same way
+// before parsing them, so replies forged by someone who didn't see the
+// HELLO fail the checksum.
+func SealChecksum(pkt []byte, token uint32) {
+	if len(pkt) >= 4 {
+		binary.BigEndian.PutUint32(pkt, binary.BigEndian.Uint32(pkt)^token)
+	}
+}
+
+func VerifyChecksum(buffer []byte) bool {
+	if len(buffer) < HeaderLength {
+		return false
+	}
+	hdr := Header{
+		Checksum: binary.BigEndian.Uint32(buffer),
+		Length:   binary.BigEndian.Uint16(buffer[4:]),
+		Flags:    binary.BigEndian.Uint16(buffer[6:]),
+	}
 
 	//fmt.Printf("[NET] hdr.Length=%d hdr.Flags=%d\n", hdr.Length, hdr.Flags)
 
-	if int(hdr.Length) > len(buffer)-4 {
-		fmt.Printf("VerifyChecksum: hdr.Length > len(buffer)-4 !!!\n")
+	if int(hdr.Length) > len(buffer)-HeaderLength {
+		fmt.Printf("VerifyChecksum: hdr.Length > len(buffer)-HeaderLength !!!\n")
 		return false
 	}
 
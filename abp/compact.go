@@ -0,0 +1,69 @@
+package abp
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// Compact header encoding, negotiated with HDR_COMPACT on the FILENAME
+// packet. The checksum stays a fixed 32 bit field, followed by the flags
+// and the payload length as unsigned varints; for small packets this
+// brings the header down from 8 to 6 bytes.
+//
+// 0             15              31
+// +------------------------------+
+// |       CRC32 Checksum         |
+// +--------------+---------------+
+// | Flags (var)  | Length (var)  |
+// +--------------+---------------+
+
+const MaxCompactHeaderLength int = 4 + 2*binary.MaxVarintLen16
+
+func SerializeCompactHeader(hdr Header) []byte {
+	buf := make([]byte, MaxCompactHeaderLength)
+	binary.BigEndian.PutUint32(buf, hdr.Checksum)
+	n := 4
+	n += binary.PutUvarint(buf[n:], uint64(hdr.Flags))
+	n += binary.PutUvarint(buf[n:], uint64(hdr.Length))
+	return buf[:n]
+}
+
+// ParseCompactHeader decodes a compact header and returns it together with
+// its encoded length. ok is false if the header is malformed or announces
+// more payload than buffer holds.
+func ParseCompactHeader(buffer []byte) (hdr Header, hdrLen int, ok bool) {
+	if len(buffer) < 6 {
+		return hdr, 0, false
+	}
+	hdr.Checksum = binary.BigEndian.Uint32(buffer)
+	hdrLen = 4
+
+	flags, n := binary.Uvarint(buffer[hdrLen:])
+	if n <= 0 || flags > 0xffff {
+		return hdr, 0, false
+	}
+	hdrLen += n
+	length, n := binary.Uvarint(buffer[hdrLen:])
+	if n <= 0 || length > 0xffff {
+		return hdr, 0, false
+	}
+	hdrLen += n
+
+	hdr.Flags = uint16(flags)
+	hdr.Length = uint16(length)
+	if hdrLen+int(hdr.Length) > len(buffer) {
+		return hdr, 0, false
+	}
+	return hdr, hdrLen, true
+}
+
+// VerifyCompactChecksum is the compact-header counterpart of VerifyChecksum.
+// It doesn't log mismatches since receivers use it to tell compact packets
+// apart from regular ones.
+func VerifyCompactChecksum(buffer []byte) bool {
+	hdr, hdrLen, ok := ParseCompactHeader(buffer)
+	if !ok {
+		return false
+	}
+	return hdr.Checksum == crc32.Checksum(buffer[4:hdrLen+int(hdr.Length)], crc32q)
+}
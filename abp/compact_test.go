@@ -0,0 +1,121 @@
+package abp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// assembles a packet with a compact header, like a peer that negotiated
+// HDR_COMPACT sends it.
+func compactPacket(flags uint16, payload []byte) []byte {
+	hdr := Header{Length: uint16(len(payload)), Flags: flags}
+	pkt := append(SerializeCompactHeader(hdr), payload...)
+	SetChecksum(pkt)
+	return pkt
+}
+
+func TestCompactHeader(t *testing.T) {
+	tests := []struct {
+		hdr    Header
+		length int
+	}{
+		{Header{Flags: HDR_ALTERNATING, Length: 10}, 6},
+		{Header{Flags: 0, Length: 0}, 6},
+		{Header{Flags: HDR_ALTERNATING | HDR_FIN, Length: 127}, 6},
+		{Header{Flags: HDR_ALTERNATING, Length: 128}, 7},
+		{Header{Flags: HDR_FILENAME | HDR_COMPACT | HDR_SIZE, Length: 300}, 8},
+		{Header{Flags: HDR_ECHO, Length: 0xffff}, 10},
+	}
+	for _, tt := range tests {
+		buf := SerializeCompactHeader(tt.hdr)
+		if len(buf) != tt.length {
+			t.Errorf("%v: %d byte header, want %d", tt.hdr, len(buf),
+				tt.length)
+		}
+		// the payload has to be there for the header to parse
+		got, hdrLen, ok := ParseCompactHeader(append(buf,
+			make([]byte, tt.hdr.Length)...))
+		if !ok || got != tt.hdr || hdrLen != len(buf) {
+			t.Errorf("%v: parsed %v (%d bytes, %v)", tt.hdr, got, hdrLen, ok)
+		}
+	}
+}
+
+func TestParseCompactHeaderInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"short", []byte{0, 0, 0, 0, 2}},
+		{"payload missing", append(SerializeCompactHeader(Header{
+			Flags: HDR_ALTERNATING, Length: 10}), 1, 2, 3)},
+		{"flags overflow", []byte{0, 0, 0, 0, 0xff, 0xff, 0x7f, 0}},
+		{"unterminated varint", []byte{0, 0, 0, 0, 0x80, 0x80}},
+	}
+	for _, tt := range tests {
+		if _, _, ok := ParseCompactHeader(tt.buf); ok {
+			t.Errorf("%s: parsed", tt.name)
+		}
+	}
+}
+
+func TestParsePacketCompact(t *testing.T) {
+	payload := []byte("sensor reading")
+	pkt := compactPacket(HDR_ALTERNATING, payload)
+	if !VerifyCompactChecksum(pkt) {
+		t.Fatalf("checksum of a compact packet doesn't verify")
+	}
+	hdr, got, err := ParsePacket(pkt, true)
+	if err != nil || hdr.Flags != HDR_ALTERNATING || !bytes.Equal(got, payload) {
+		t.Fatalf("parsed %v %q (%v)", hdr, got, err)
+	}
+	// a compact packet isn't taken for a regular one
+	if _, _, err := ParsePacket(pkt, false); err == nil {
+		t.Errorf("compact packet parsed as a regular one")
+	}
+	// while a regular one, e.g. a retransmitted FILENAME packet, still
+	// parses with compact set
+	regular := append(SerializeHeader(Header{Length: uint16(len(payload)),
+		Flags: HDR_ALTERNATING}), payload...)
+	SetChecksum(regular)
+	if _, got, err := ParsePacket(regular, true); err != nil ||
+		!bytes.Equal(got, payload) {
+		t.Errorf("regular packet with compact set: %q (%v)", got, err)
+	}
+
+	pkt[len(pkt)-1] ^= 1
+	if VerifyCompactChecksum(pkt) {
+		t.Errorf("corrupted compact packet verifies")
+	}
+}
+
+// a receiver that agreed on HDR_COMPACT takes data packets with compact
+// headers and answers with compact headers as well.
+func TestReceiverCompact(t *testing.T) {
+	_, conn := startTestReceiver(t)
+	sendTestPacket(t, conn, HDR_FILENAME|HDR_COMPACT, []byte("small.bin"))
+	buf := make([]byte, MaxPacketLength)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr, _, err := ParsePacket(buf[:n], false)
+	if err != nil || hdr.Flags != HDR_COMPACT {
+		t.Fatalf("FILENAME ACK %v (%v), want COMPACT", hdr, err)
+	}
+
+	if _, err := conn.Write(compactPacket(HDR_ALTERNATING, []byte{42})); err != nil {
+		t.Fatal(err)
+	}
+	n, err = conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n >= HeaderLength {
+		t.Errorf("%d byte ACK, want a compact header", n)
+	}
+	if hdr, _, err := ParsePacket(buf[:n], true); err != nil ||
+		hdr.Flags != HDR_ALTERNATING {
+		t.Errorf("data ACK %v (%v)", hdr, err)
+	}
+}
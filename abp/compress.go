@@ -0,0 +1,94 @@
+package abp
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/v4lli/go-abp/lz4"
+	"github.com/v4lli/go-abp/zstd"
+)
+
+// Payload compression, negotiated with HDR_COMPRESSED on the FILENAME
+// packet and flagged with HDR_COMPRESSED on every data packet (or FEC group)
+// whose payload is compressed. Each payload is compressed on its own, so
+// packets can be decompressed as they arrive.
+//
+// The algorithm is the first of the sender's HELLO (Capabilities.
+// Compression) the receiver supports, gzip without a HELLO. gzip payloads
+// are raw DEFLATE streams without the gzip framing, which would cost 18
+// bytes per packet; zstd payloads are frames (see zstd.Compress) and lz4
+// payloads blocks without the frame format, the packet has a length and
+// checksum already.
+
+// the payload compression algorithms, for Capabilities.Compression
+var Compressions = []string{"gzip", "zstd", "lz4"}
+
+var (
+	ErrDecompressedTooLarge = errors.New("decompressed payload exceeds limit")
+	ErrUnknownCompression   = errors.New("unknown compression algorithm")
+)
+
+// CompressPayload compresses a payload with algorithm; only gzip has
+// levels (flate's), the others ignore level.
+func CompressPayload(algorithm string, data []byte, level int) ([]byte,
+	error) {
+	switch algorithm {
+	case "gzip":
+		return deflate(data, level)
+	case "zstd":
+		return zstd.Compress(nil, data), nil
+	case "lz4":
+		return lz4.Compress(nil, data), nil
+	}
+	return nil, ErrUnknownCompression
+}
+
+func deflate(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecompressPayload inflates a compressed payload, refusing to produce more
+// than limit bytes so a malicious packet can't exhaust the receiver's
+// memory.
+func DecompressPayload(algorithm string, data []byte, limit int) ([]byte,
+	error) {
+	var r io.Reader
+	switch algorithm {
+	case "gzip":
+		fr := flate.NewReader(bytes.NewReader(data))
+		defer fr.Close()
+		r = fr
+	case "zstd":
+		r = zstd.NewReader(bytes.NewReader(data))
+	case "lz4":
+		ret, err := lz4.Decompress(nil, data, limit)
+		if err == lz4.ErrTooLarge {
+			err = ErrDecompressedTooLarge
+		}
+		return ret, err
+	default:
+		return nil, ErrUnknownCompression
+	}
+	ret, err := ioutil.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(ret) > limit {
+		return nil, ErrDecompressedTooLarge
+	}
+	return ret, nil
+}
@@ -0,0 +1,39 @@
+package abp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressPayload(t *testing.T) {
+	data := bytes.Repeat([]byte("alternating bit "), 200)
+	for _, algorithm := range Compressions {
+		compressed, err := CompressPayload(algorithm, data, -1)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if len(compressed) >= len(data)/4 {
+			t.Errorf("%s: compressed %d bytes to %d", algorithm, len(data),
+				len(compressed))
+		}
+		got, err := DecompressPayload(algorithm, compressed, len(data))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: round trip failed (%v)", algorithm, err)
+		}
+		if _, err := DecompressPayload(algorithm, compressed,
+			len(data)-1); err != ErrDecompressedTooLarge {
+			t.Errorf("%s: decompressed beyond the limit (%v)", algorithm, err)
+		}
+		if _, err := DecompressPayload(algorithm, compressed[:len(compressed)/2],
+			len(data)); err == nil {
+			t.Errorf("%s: decompressed a truncated payload", algorithm)
+		}
+	}
+
+	if _, err := CompressPayload("brotli", data, -1); err != ErrUnknownCompression {
+		t.Errorf("compressed with an unknown algorithm: %v", err)
+	}
+	if _, err := DecompressPayload("brotli", data, len(data)); err != ErrUnknownCompression {
+		t.Errorf("decompressed with an unknown algorithm: %v", err)
+	}
+}
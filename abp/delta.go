@@ -0,0 +1,390 @@
+package abp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// Delta transfers (rsync-style). A sender requests them with HDR_DELTA on
+// its FILENAME packet, a receiver which already has a file of that name
+// echoes the flag in its ACK. The sender then fetches the block signatures
+// of the receiver's file with HDR_DELTA request packets (payload: index of
+// the first wanted block) and the receiver answers each of them with as
+// many signatures as fit into one packet. Afterwards the data packets carry
+// a delta stream instead of the plain file: a sequence of literal and copy
+// operations, the latter referring to blocks of the receiver's old file.
+
+// delta stream operations
+const (
+	DELTA_LITERAL = 0x1 // uvarint length, followed by the literal bytes
+	DELTA_COPY    = 0x2 // uvarint first block, uvarint number of blocks
+)
+
+type BlockSignature struct {
+	Weak   uint32
+	Strong [8]byte
+}
+
+const BlockSignatureLength int = 12
+
+// header of a signature reply, followed by the signatures themselves
+type SignatureHeader struct {
+	BlockSize   uint32
+	TotalBlocks uint32
+	FirstBlock  uint32
+}
+
+const SignatureHeaderLength int = 12
+
+// DeltaBlockSize picks the block size for a basis file of the given size;
+// like rsync, roughly the square root of the file size.
+func DeltaBlockSize(size int64) int {
+	blockSize := int(math.Sqrt(float64(size))) &^ 7
+	if blockSize < 700 {
+		blockSize = 700
+	}
+	if blockSize > 128*1024 {
+		blockSize = 128 * 1024
+	}
+	return blockSize
+}
+
+// WeakChecksum is the rolling checksum used to find candidate blocks.
+func WeakChecksum(block []byte) uint32 {
+	var a, b uint32
+	for i, v := range block {
+		a += uint32(v)
+		b += uint32(len(block)-i) * uint32(v)
+	}
+	return (a & 0xffff) | (b << 16)
+}
+
+func StrongChecksum(block []byte) [8]byte {
+	var ret [8]byte
+	sum := sha256.Sum256(block)
+	copy(ret[:], sum[:8])
+	return ret
+}
+
+// ComputeSignatures calculates the signatures of all blocks of basis; the
+// last block may be short.
+func ComputeSignatures(basis io.Reader, blockSize int) ([]BlockSignature, error) {
+	var sigs []BlockSignature
+	block := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(basis, block)
+		if n > 0 {
+			sigs = append(sigs, BlockSignature{
+				Weak:   WeakChecksum(block[:n]),
+				Strong: StrongChecksum(block[:n]),
+			})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sigs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func SerializeSignatures(hdr SignatureHeader, sigs []BlockSignature) []byte {
+	var bin_buf bytes.Buffer
+	binary.Write(&bin_buf, binary.BigEndian, hdr)
+	binary.Write(&bin_buf, binary.BigEndian, sigs)
+	return bin_buf.Bytes()
+}
+
+func ParseSignatures(buffer []byte) (SignatureHeader, []BlockSignature, bool) {
+	var hdr SignatureHeader
+	if len(buffer) < SignatureHeaderLength ||
+		(len(buffer)-SignatureHeaderLength)%BlockSignatureLength != 0 {
+		return hdr, nil, false
+	}
+	reader := bytes.NewReader(buffer)
+	binary.Read(reader, binary.BigEndian, &hdr)
+	sigs := make([]BlockSignature,
+		(len(buffer)-SignatureHeaderLength)/BlockSignatureLength)
+	binary.Read(reader, binary.BigEndian, sigs)
+	return hdr, sigs, true
+}
+
+// DeltaReader turns a file into a delta stream against the blocks described
+// by a set of signatures.
+type DeltaReader struct {
+	src       *bufio.Reader
+	blockSize int
+	blocks    map[uint32][]int
+	sigs      []BlockSignature
+	window    []byte
+	literal   []byte
+	copyFirst int
+	copyCount int
+	out       bytes.Buffer
+	weak      uint32
+	rolling   bool
+	eof       bool
+	done      bool
+
+	// statistics, valid once the stream has been read completely
+	LiteralBytes int64
+	CopiedBytes  int64
+}
+
+const maxDeltaLiteral = 32 * 1024
+
+func NewDeltaReader(src io.Reader, blockSize int, sigs []BlockSignature) *DeltaReader {
+	d := &DeltaReader{
+		src:       bufio.NewReader(src),
+		blockSize: blockSize,
+		blocks:    make(map[uint32][]int),
+		sigs:      sigs,
+	}
+	for i, sig := range sigs {
+		d.blocks[sig.Weak] = append(d.blocks[sig.Weak], i)
+	}
+	return d
+}
+
+// reads from src until the window holds n bytes or the file ends.
+func (d *DeltaReader) fill(n int) error {
+	for !d.eof && len(d.window) < n {
+		b, err := d.src.ReadByte()
+		if err == io.EOF {
+			d.eof = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+		d.window = append(d.window, b)
+	}
+	return nil
+}
+
+func (d *DeltaReader) flushLiteral() {
+	if len(d.literal) == 0 {
+		return
+	}
+	var tmp [binary.MaxVarintLen64]byte
+	d.out.WriteByte(DELTA_LITERAL)
+	d.out.Write(tmp[:binary.PutUvarint(tmp[:], uint64(len(d.literal)))])
+	d.out.Write(d.literal)
+	d.LiteralBytes += int64(len(d.literal))
+	d.literal = d.literal[:0]
+}
+
+func (d *DeltaReader) flushCopy() {
+	if d.copyCount == 0 {
+		return
+	}
+	var tmp [binary.MaxVarintLen64]byte
+	d.out.WriteByte(DELTA_COPY)
+	d.out.Write(tmp[:binary.PutUvarint(tmp[:], uint64(d.copyFirst))])
+	d.out.Write(tmp[:binary.PutUvarint(tmp[:], uint64(d.copyCount))])
+	d.copyCount = 0
+}
+
+// copies of consecutive blocks are merged into one operation.
+func (d *DeltaReader) emitCopy(block int, length int) {
+	d.flushLiteral()
+	if d.copyCount > 0 && d.copyFirst+d.copyCount != block {
+		d.flushCopy()
+	}
+	if d.copyCount == 0 {
+		d.copyFirst = block
+	}
+	d.copyCount++
+	d.CopiedBytes += int64(length)
+}
+
+func (d *DeltaReader) emitLiteral(data []byte) {
+	d.flushCopy()
+	d.literal = append(d.literal, data...)
+	if len(d.literal) >= maxDeltaLiteral {
+		d.flushLiteral()
+	}
+}
+
+// returns the index of the block matching the current window, or -1.
+func (d *DeltaReader) match() int {
+	candidates, ok := d.blocks[d.weak]
+	if !ok {
+		return -1
+	}
+	strong := StrongChecksum(d.window[:d.blockSize])
+	for _, block := range candidates {
+		if d.sigs[block].Strong == strong {
+			return block
+		}
+	}
+	return -1
+}
+
+// advances the delta stream by one step (a copy or a literal byte).
+func (d *DeltaReader) step() error {
+	if err := d.fill(d.blockSize); err != nil {
+		return err
+	}
+	if len(d.window) < d.blockSize {
+		// tail shorter than a block, may still match the short last
+		// block of the basis file
+		last := len(d.sigs) - 1
+		if len(d.window) > 0 && last >= 0 &&
+			d.sigs[last].Weak == WeakChecksum(d.window) &&
+			d.sigs[last].Strong == StrongChecksum(d.window) {
+			d.emitCopy(last, len(d.window))
+		} else if len(d.window) > 0 {
+			d.emitLiteral(d.window)
+		}
+		d.window = nil
+		d.flushCopy()
+		d.flushLiteral()
+		d.done = true
+		return nil
+	}
+
+	if !d.rolling {
+		d.weak = WeakChecksum(d.window[:d.blockSize])
+		d.rolling = true
+	}
+	if block := d.match(); block >= 0 {
+		d.emitCopy(block, d.blockSize)
+		d.window = d.window[d.blockSize:]
+		d.rolling = false
+		return nil
+	}
+
+	// no match, emit the first byte as literal and roll the window on
+	out := d.window[0]
+	d.emitLiteral(d.window[:1])
+	if err := d.fill(d.blockSize + 1); err != nil {
+		return err
+	}
+	if len(d.window) <= d.blockSize {
+		d.window = d.window[1:]
+		d.rolling = false
+		return nil
+	}
+	in := d.window[d.blockSize]
+	a := (d.weak & 0xffff) - uint32(out) + uint32(in)
+	b := (d.weak >> 16) - uint32(d.blockSize)*uint32(out) + a
+	d.weak = (a & 0xffff) | (b << 16)
+	d.window = d.window[1:]
+	return nil
+}
+
+func (d *DeltaReader) Read(p []byte) (int, error) {
+	for d.out.Len() < len(p) && !d.done {
+		if err := d.step(); err != nil {
+			return 0, err
+		}
+	}
+	if d.out.Len() == 0 && d.done {
+		return 0, io.EOF
+	}
+	return d.out.Read(p)
+}
+
+var ErrInvalidDelta = errors.New("invalid delta stream")
+
+// DeltaDecoder reconstructs a file from a delta stream written to it,
+// copying referenced blocks from basis.
+type DeltaDecoder struct {
+	basis     io.ReaderAt
+	blockSize int
+	out       io.Writer
+	pending   []byte
+	literal   uint64
+}
+
+func NewDeltaDecoder(basis io.ReaderAt, blockSize int, out io.Writer) *DeltaDecoder {
+	return &DeltaDecoder{basis: basis, blockSize: blockSize, out: out}
+}
+
+func (d *DeltaDecoder) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if d.literal > 0 {
+			chunk := p
+			if uint64(len(chunk)) > d.literal {
+				chunk = chunk[:d.literal]
+			}
+			if _, err := d.out.Write(chunk); err != nil {
+				return 0, err
+			}
+			d.literal -= uint64(len(chunk))
+			p = p[len(chunk):]
+			continue
+		}
+
+		// collect one operation byte by byte, it may span packets
+		d.pending = append(d.pending, p[0])
+		p = p[1:]
+		if err := d.decodeOp(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// executes the operation in pending if it is complete.
+func (d *DeltaDecoder) decodeOp() error {
+	switch d.pending[0] {
+	case DELTA_LITERAL:
+		length, n := binary.Uvarint(d.pending[1:])
+		if n < 0 {
+			return ErrInvalidDelta
+		}
+		if n > 0 {
+			d.literal = length
+			d.pending = d.pending[:0]
+		}
+	case DELTA_COPY:
+		first, n := binary.Uvarint(d.pending[1:])
+		if n < 0 {
+			return ErrInvalidDelta
+		}
+		if n == 0 {
+			return nil
+		}
+		count, m := binary.Uvarint(d.pending[1+n:])
+		if m < 0 {
+			return ErrInvalidDelta
+		}
+		if m > 0 {
+			d.pending = d.pending[:0]
+			return d.copyBlocks(int64(first), int64(count))
+		}
+	default:
+		return ErrInvalidDelta
+	}
+	return nil
+}
+
+func (d *DeltaDecoder) copyBlocks(first int64, count int64) error {
+	buf := make([]byte, d.blockSize)
+	for i := int64(0); i < count; i++ {
+		n, err := d.basis.ReadAt(buf, (first+i)*int64(d.blockSize))
+		if n == 0 {
+			return ErrInvalidDelta
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if _, err := d.out.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Complete reports whether the stream ended on an operation boundary.
+func (d *DeltaDecoder) Complete() bool {
+	return d.literal == 0 && len(d.pending) == 0
+}
@@ -0,0 +1,125 @@
+package abp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// encodes target as a delta against basis and decodes it again, feeding
+// the decoder the stream in small pieces like packets would.
+func deltaRoundTrip(t *testing.T, basis []byte, target []byte,
+	blockSize int) *DeltaReader {
+	t.Helper()
+	sigs, err := ComputeSignatures(bytes.NewReader(basis), blockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// signatures travel in SerializeSignatures packets
+	_, sigs, ok := ParseSignatures(SerializeSignatures(SignatureHeader{
+		BlockSize: uint32(blockSize), TotalBlocks: uint32(len(sigs)),
+	}, sigs))
+	if !ok {
+		t.Fatalf("signatures don't parse")
+	}
+	enc := NewDeltaReader(bytes.NewReader(target), blockSize, sigs)
+	stream, err := ioutil.ReadAll(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	dec := NewDeltaDecoder(bytes.NewReader(basis), blockSize, &out)
+	for len(stream) > 0 {
+		n := 7
+		if n > len(stream) {
+			n = len(stream)
+		}
+		if _, err := dec.Write(stream[:n]); err != nil {
+			t.Fatalf("decoding: %v", err)
+		}
+		stream = stream[n:]
+	}
+	if !dec.Complete() {
+		t.Fatalf("delta stream ends within an operation")
+	}
+	if !bytes.Equal(out.Bytes(), target) {
+		t.Fatalf("decoded %d bytes that differ from the %d encoded",
+			out.Len(), len(target))
+	}
+	if enc.LiteralBytes+enc.CopiedBytes != int64(len(target)) {
+		t.Errorf("%d literal and %d copied bytes for %d",
+			enc.LiteralBytes, enc.CopiedBytes, len(target))
+	}
+	return enc
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	const blockSize = 700
+	basis := testData(10*blockSize + 123)
+	cat := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	extra := testData(1000)
+	tests := []struct {
+		name   string
+		basis  []byte
+		target []byte
+		// the fewest bytes that have to be copied from the basis; the
+		// short last block only matches at the end of the target
+		minCopied int64
+	}{
+		{"identical", basis, basis, int64(len(basis))},
+		{"appended", basis, cat(basis, extra), 10 * blockSize},
+		{"truncated", basis, basis[:5*blockSize+10], 5 * blockSize},
+		{"truncated to blocks", basis, basis[:4*blockSize], 4 * blockSize},
+		{"shifted", basis, cat(extra[:13], basis), int64(len(basis))},
+		{"inserted", basis, cat(basis[:3*blockSize+5], extra,
+			basis[3*blockSize+5:]), 9 * blockSize},
+		{"cut out", basis, cat(basis[:2*blockSize], basis[3*blockSize+1:]),
+			8 * blockSize},
+		{"reordered", basis, cat(basis[5*blockSize:], basis[:5*blockSize]),
+			10 * blockSize},
+		{"different", basis, extra, 0},
+		{"empty target", basis, nil, 0},
+		{"empty basis", nil, basis, 0},
+		{"both empty", nil, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc := deltaRoundTrip(t, tt.basis, tt.target, blockSize)
+			if enc.CopiedBytes < tt.minCopied {
+				t.Errorf("copied %d bytes, want at least %d",
+					enc.CopiedBytes, tt.minCopied)
+			}
+			if len(tt.basis) == 0 && enc.CopiedBytes != 0 {
+				t.Errorf("copied %d bytes from an empty basis",
+					enc.CopiedBytes)
+			}
+		})
+	}
+}
+
+// copies of blocks the basis doesn't have fail instead of panicking or
+// writing garbage.
+func TestDeltaDecoderInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream []byte
+	}{
+		{"unknown operation", []byte{0x7}},
+		{"copy past the basis", []byte{DELTA_COPY, 5, 1}},
+		{"overlong varint", append([]byte{DELTA_LITERAL},
+			bytes.Repeat([]byte{0xff}, 11)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			dec := NewDeltaDecoder(bytes.NewReader(testData(1400)), 700,
+				&out)
+			if _, err := dec.Write(tt.stream); err == nil {
+				t.Fatalf("invalid stream accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package abp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// every datagram of a Duplex starts with a byte telling which side of a
+// transfer it is meant for, so data packets of one direction aren't
+// mistaken for ACKs of the other
+const (
+	duplexToReceiver = 0 // FILENAME, data and FIN packets
+	duplexToSender   = 1 // ACKs, BUSY and ERROR packets
+)
+
+// how many packets a Duplex buffers per direction before dropping them
+const duplexInBuffer = 64
+
+var (
+	ErrDuplexClosed = errors.New("duplex closed")
+	ErrDuplexBusy   = errors.New("duplex already sending a transfer")
+	ErrNoPeer       = errors.New("duplex peer unknown until it sends")
+)
+
+// Duplex runs transfers in both directions over one UDP socket, so a pair
+// of hosts can exchange files without a second listener. Each side may
+// send one transfer at a time while receiving one from the other side;
+// both ends of the socket need a Duplex.
+//
+// The direction byte in front of every datagram is neither a header flag
+// nor announced with HELLO, so Duplex is a library feature only: it can't
+// talk to a plain Sender or Receiver, nor to abp-send or abp-recv.
+//
+// On a connected socket the peer is fixed. On a listening socket it is
+// whoever sends the first datagram, and datagrams from anywhere else are
+// dropped afterwards.
+type Duplex struct {
+	conn      *net.UDPConn
+	connected bool
+	mu        sync.Mutex
+	peer      *net.UDPAddr
+	sending   bool
+	// the packets for our Sender and for our Receiver
+	toSender   chan []byte
+	toReceiver chan []byte
+	receiver   *Receiver
+	rtt        rttEstimator
+	closed     chan struct{}
+	err        error
+}
+
+// one direction of a Duplex, as seen by its Sender or Receiver
+type duplexConn struct {
+	d *Duplex
+	// the direction byte of the datagrams written
+	dir      byte
+	in       chan []byte
+	deadline time.Time
+}
+
+// NewDuplex starts receiving on conn. Incoming transfers are handed to
+// create, or read with Accept if it is nil.
+func NewDuplex(conn *net.UDPConn, create WriterFactory) *Duplex {
+	d := &Duplex{
+		conn:       conn,
+		toSender:   make(chan []byte, duplexInBuffer),
+		toReceiver: make(chan []byte, duplexInBuffer),
+		closed:     make(chan struct{}),
+	}
+	if remote, ok := conn.RemoteAddr().(*net.UDPAddr); ok && remote != nil {
+		d.peer = remote
+		d.connected = true
+	}
+	d.receiver = newReceiver(&duplexConn{d: d, dir: duplexToSender,
+		in: d.toReceiver}, create)
+	go d.readLoop()
+	go d.receiver.Serve()
+	return d
+}
+
+// Sender returns a Sender transferring name to the peer. Until its Close
+// returned, the Duplex can't start another one.
+func (d *Duplex) Sender(name string) (*Sender, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.sending {
+		return nil, ErrDuplexBusy
+	}
+	d.sending = true
+	// ACKs of an earlier transfer would be taken for ours
+	for len(d.toSender) > 0 {
+		<-d.toSender
+	}
+	c := &duplexConn{d: d, dir: duplexToReceiver, in: d.toSender}
+	return newSender(c, name, &d.rtt), nil
+}
+
+// Accept waits for the next transfer from the peer, see Receiver.Accept.
+func (d *Duplex) Accept() (*Incoming, error) {
+	return d.receiver.Accept()
+}
+
+// Close closes the socket; running transfers fail.
+func (d *Duplex) Close() error {
+	d.fail(ErrDuplexClosed)
+	return d.conn.Close()
+}
+
+func (d *Duplex) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.closed:
+		return
+	default:
+	}
+	d.err = err
+	close(d.closed)
+}
+
+// hands every datagram of the peer to the side it is meant for.
+func (d *Duplex) readLoop() {
+	buf := make([]byte, 1+MaxPacketLength)
+	for {
+		n, from, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			if _, ok := err.(net.Error); ok && !errors.Is(err, net.ErrClosed) {
+				// e.g. nobody listening (yet)
+				select {
+				case <-d.closed:
+					return
+				case <-time.After(100 * time.Millisecond):
+				}
+				continue
+			}
+			d.fail(err)
+			return
+		}
+		if n < 1 {
+			continue
+		}
+		d.mu.Lock()
+		if d.peer == nil {
+			d.peer = from
+		}
+		peer := d.peer
+		d.mu.Unlock()
+		if !from.IP.Equal(peer.IP) || from.Port != peer.Port {
+			continue
+		}
+		var in chan []byte
+		switch buf[0] {
+		case duplexToReceiver:
+			in = d.toReceiver
+		case duplexToSender:
+			in = d.toSender
+		default:
+			continue
+		}
+		select {
+		case in <- append([]byte(nil), buf[1:n]...):
+		default:
+		}
+	}
+}
+
+func (c *duplexConn) Read(b []byte) (int, error) {
+	var timeout <-chan time.Time
+	if !c.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(c.deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case pkt := <-c.in:
+		return copy(b, pkt), nil
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	case <-c.d.closed:
+		return 0, c.d.err
+	}
+}
+
+func (c *duplexConn) Write(b []byte) (int, error) {
+	d := c.d
+	d.mu.Lock()
+	peer, err := d.peer, d.err
+	d.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if peer == nil {
+		return 0, ErrNoPeer
+	}
+	pkt := append([]byte{c.dir}, b...)
+	if d.connected {
+		_, err = d.conn.Write(pkt)
+	} else {
+		_, err = d.conn.WriteToUDP(pkt, peer)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFromUDP is Read for the Receiver; everything comes from the peer.
+func (c *duplexConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	n, err := c.Read(b)
+	if err != nil {
+		return n, nil, err
+	}
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	return n, c.d.peer, nil
+}
+
+// WriteToUDP is Write for the Receiver; addr is always the peer.
+func (c *duplexConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	return c.Write(b)
+}
+
+func (c *duplexConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *duplexConn) RemoteAddr() net.Addr {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	if c.d.peer == nil {
+		return nil
+	}
+	return c.d.peer
+}
+
+// the Duplex can start the next Sender once this one is done.
+func (c *duplexConn) release() {
+	if c.dir != duplexToReceiver {
+		return
+	}
+	c.d.mu.Lock()
+	c.d.sending = false
+	c.d.mu.Unlock()
+}
@@ -0,0 +1,92 @@
+package abp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// sends data as name over d.
+func duplexSend(d *Duplex, name string, data []byte) error {
+	s, err := d.Sender(name)
+	if err != nil {
+		return err
+	}
+	s.Size = int64(len(data))
+	s.Retry = FixedRetry{Timeout: 20 * time.Millisecond, Retries: 50}
+	if _, err := s.ReadFrom(bytes.NewReader(data)); err != nil {
+		s.Close()
+		return err
+	}
+	return s.Close()
+}
+
+// accepts the next transfer of d and checks it is data sent as name.
+func duplexReceive(d *Duplex, name string, data []byte) error {
+	in, err := d.Accept()
+	if err != nil {
+		return fmt.Errorf("Accept: %v", err)
+	}
+	defer in.Close()
+	got, err := ioutil.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", in.Name, err)
+	}
+	if in.Name != name || !bytes.Equal(got, data) {
+		return fmt.Errorf("received %q with %d bytes, want %q with %d",
+			in.Name, len(got), name, len(data))
+	}
+	return nil
+}
+
+// a connected Duplex a and a listening one b exchange transfers one way,
+// the other way and both ways at once.
+func TestDuplexRoundTrip(t *testing.T) {
+	bConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewDuplex(bConn, nil)
+	defer b.Close()
+	aConn, err := net.DialUDP("udp", nil, bConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewDuplex(aConn, nil)
+	defer a.Close()
+
+	// b only knows its peer once a sent something, so a goes first
+	request, answer := testData(5000), testData(7000)
+	exchange := func(from, to *Duplex, name string, data []byte) {
+		t.Helper()
+		errs := make(chan error, 1)
+		go func() { errs <- duplexSend(from, name, data) }()
+		if err := duplexReceive(to, name, data); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatalf("sending %s: %v", name, err)
+		}
+	}
+	exchange(a, b, "request.json", request)
+	exchange(b, a, "answer.json", answer)
+
+	errs := make(chan error, 4)
+	go func() { errs <- duplexSend(a, "a.bin", request) }()
+	go func() { errs <- duplexSend(b, "b.bin", answer) }()
+	go func() { errs <- duplexReceive(b, "a.bin", request) }()
+	go func() { errs <- duplexReceive(a, "b.bin", answer) }()
+	for i := 0; i < 4; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatalf("both ways at once: %v", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("both ways at once: timed out")
+		}
+	}
+}
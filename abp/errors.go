@@ -0,0 +1,58 @@
+package abp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// the errors of the library. failed transfers return a *ProtocolError or a
+// *PeerAbortError wrapping these, failing sockets, files and writers are
+// wrapped as well, so use errors.Is and errors.As to tell them apart.
+var (
+	ErrTransferTimeout  = errors.New("transfer timed out")
+	ErrTransferAborted  = errors.New("transfer aborted by the sender")
+	ErrTransferCanceled = errors.New("transfer canceled")
+	ErrSenderClosed     = errors.New("write to closed sender")
+	ErrIncomingClosed   = errors.New("transfer closed by the reader")
+	ErrAcceptBacklog    = errors.New("too many transfers waiting for Accept")
+)
+
+// why ParsePacket dropped a packet
+var (
+	ErrShortPacket   = errors.New("packet shorter than its header says")
+	ErrChecksum      = errors.New("checksum mismatch")
+	ErrInvalidLength = errors.New("payload length exceeds the packet size")
+	ErrInvalidFlags  = errors.New("invalid flag combination")
+)
+
+// ProtocolError is a transfer failing in State, e.g. because the peer
+// stopped answering. Code is the ERR_* code the receiver sent to the peer,
+// 0 if none. Err says what went wrong.
+type ProtocolError struct {
+	State State
+	Code  uint16
+	Err   error
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("abp: %v in state %v (%s)", e.Err, e.State,
+			ErrorMessage(e.Code))
+	}
+	return fmt.Sprintf("abp: %v in state %v", e.Err, e.State)
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// PeerAbortError is the receiver aborting a transfer with an HDR_ERROR
+// packet, e.g. refusing the file name with ERR_REFUSED.
+type PeerAbortError struct {
+	Code   uint16
+	Reason string
+}
+
+func (e *PeerAbortError) Error() string {
+	return "abp: receiver aborted the transfer: " + e.Reason
+}
@@ -0,0 +1,80 @@
+package abp
+
+import (
+	"fmt"
+	"time"
+)
+
+// Event is one of StateChanged, PacketSent, AckReceived, Retransmit and
+// Completed, as delivered by Transfer.Events.
+type Event interface {
+	event()
+}
+
+// the states of a Transfer; a sending transfer walks through the WAIT_*
+// states, a receiving one stays in STATE_RECEIVING.
+type State int
+
+const (
+	STATE_STARTING State = iota
+	STATE_WAIT_FILENAME_ACK
+	STATE_WAIT_ACK
+	STATE_WAIT_FIN_ACK
+	STATE_RECEIVING
+	STATE_PAUSED
+	STATE_DONE
+)
+
+func (s State) String() string {
+	switch s {
+	case STATE_STARTING:
+		return "STARTING"
+	case STATE_WAIT_FILENAME_ACK:
+		return "WAIT_FILENAME_ACK"
+	case STATE_WAIT_ACK:
+		return "WAIT_ACK"
+	case STATE_WAIT_FIN_ACK:
+		return "WAIT_FIN_ACK"
+	case STATE_RECEIVING:
+		return "RECEIVING"
+	case STATE_PAUSED:
+		return "PAUSED"
+	case STATE_DONE:
+		return "DONE"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+type StateChanged struct {
+	From, To State
+}
+
+// a packet went out for the first time
+type PacketSent struct {
+	Flags  uint16
+	Length int
+}
+
+// a packet was acknowledged RTT after it was last sent
+type AckReceived struct {
+	Flags uint16
+	RTT   time.Duration
+}
+
+// a packet was sent again because its ACK didn't arrive in time
+type Retransmit struct {
+	Flags   uint16
+	Attempt int
+}
+
+// the last event of a transfer
+type Completed struct {
+	Err error
+}
+
+func (StateChanged) event() {}
+func (PacketSent) event()   {}
+func (AckReceived) event()  {}
+func (Retransmit) event()   {}
+func (Completed) event()    {}
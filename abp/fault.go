@@ -0,0 +1,105 @@
+package abp
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Fault mangles a packet on its way through WithFaults, returning the
+// packet to pass on or nil to drop it. Faults are meant for tests: the
+// random ones draw from a source seeded the same on every run, so a
+// failing scenario fails the same way again.
+type Fault func(dir Direction, pkt []byte) []byte
+
+// WithFaults returns middleware applying the faults in order, e.g.
+//
+//	sender.Use(abp.WithFaults(abp.DropEveryNth(3), abp.CorruptPayload(0.01)))
+//
+// A packet dropped by one fault doesn't reach the later ones.
+func WithFaults(faults ...Fault) Middleware {
+	var mu sync.Mutex
+	return func(dir Direction, pkt []byte) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, f := range faults {
+			if pkt = f(dir, pkt); pkt == nil {
+				return nil, nil
+			}
+		}
+		return pkt, nil
+	}
+}
+
+// Only restricts a fault to the packets going in one direction.
+func Only(dir Direction, f Fault) Fault {
+	return func(d Direction, pkt []byte) []byte {
+		if d != dir {
+			return pkt
+		}
+		return f(d, pkt)
+	}
+}
+
+// DropEveryNth drops every nth packet.
+func DropEveryNth(n int) Fault {
+	count := 0
+	return func(dir Direction, pkt []byte) []byte {
+		count++
+		if n > 0 && count%n == 0 {
+			return nil
+		}
+		return pkt
+	}
+}
+
+// DropRate drops the given share of packets.
+func DropRate(rate float64) Fault {
+	rnd := rand.New(rand.NewSource(1))
+	return func(dir Direction, pkt []byte) []byte {
+		if rnd.Float64() < rate {
+			return nil
+		}
+		return pkt
+	}
+}
+
+// CorruptPayload flips a bit behind the header of the given share of
+// packets, which the peer's checksum has to catch.
+func CorruptPayload(rate float64) Fault {
+	rnd := rand.New(rand.NewSource(1))
+	return func(dir Direction, pkt []byte) []byte {
+		if len(pkt) == 0 || rnd.Float64() >= rate {
+			return pkt
+		}
+		// packets without payload get their header corrupted
+		from := HeaderLength
+		if len(pkt) <= from {
+			from = 0
+		}
+		pkt = append([]byte(nil), pkt...)
+		pkt[from+rnd.Intn(len(pkt)-from)] ^= 1 << uint(rnd.Intn(8))
+		return pkt
+	}
+}
+
+// ReorderWindow holds back packets until n have piled up and passes on a
+// random one of them, so packets arrive out of order; as the one passed
+// on is random, a packet may be held back for a while. The
+// alternating bit can't tell a stale packet from a new one with the same
+// bit, so reordering may corrupt a transfer; this makes that reproducible.
+func ReorderWindow(n int) Fault {
+	rnd := rand.New(rand.NewSource(1))
+	// one window per direction
+	var windows [2][][]byte
+	return func(dir Direction, pkt []byte) []byte {
+		window := append(windows[dir], append([]byte(nil), pkt...))
+		if len(window) < n {
+			windows[dir] = window
+			return nil
+		}
+		i := rnd.Intn(len(window))
+		pkt = window[i]
+		windows[dir] = append(window[:i], window[i+1:]...)
+		return pkt
+	}
+}
@@ -0,0 +1,190 @@
+package abp
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the destination of a test transfer, recording how it was closed
+type testWriter struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+	err    error
+}
+
+func (w *testWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *testWriter) Close() error {
+	return w.CloseWithError(nil)
+}
+
+func (w *testWriter) CloseWithError(err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed, w.err = true, err
+	return nil
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+// sends data over loopback, the sender's and the receiver's packets
+// passing through the faults given for them, and returns what the
+// receiver got and the sender's error.
+func transferWithFaults(t *testing.T, data []byte, senderFaults []Fault,
+	receiverFaults []Fault) (*testWriter, error) {
+	t.Helper()
+	recvConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recvConn.Close()
+	out := &testWriter{}
+	r := NewReceiver(recvConn, func(name string) (io.WriteCloser, error) {
+		return out, nil
+	})
+	r.Use(WithFaults(receiverFaults...))
+	go r.Serve()
+
+	sendConn, err := net.DialUDP("udp", nil, recvConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sendConn.Close()
+	s := NewSender(sendConn, "test.bin")
+	s.Size = int64(len(data))
+	s.PacketLength = 512
+	s.Retry = FixedRetry{Timeout: 20 * time.Millisecond, Retries: 50}
+	s.Use(WithFaults(senderFaults...))
+	_, sendErr := s.ReadFrom(bytes.NewReader(data))
+	if sendErr == nil {
+		sendErr = s.Close()
+	}
+	return out, sendErr
+}
+
+// checks that a transfer survived its faults with its data intact.
+func checkDelivered(t *testing.T, data []byte, out *testWriter, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("transfer failed: %v", err)
+	}
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if !out.closed || out.err != nil {
+		t.Fatalf("receiver didn't complete the transfer (closed %v, %v)",
+			out.closed, out.err)
+	}
+	if !bytes.Equal(out.buf.Bytes(), data) {
+		t.Fatalf("received %d bytes that differ from the %d sent",
+			out.buf.Len(), len(data))
+	}
+}
+
+func TestTransferDropEveryNth(t *testing.T) {
+	data := testData(16 << 10)
+	out, err := transferWithFaults(t, data,
+		[]Fault{DropEveryNth(3)}, []Fault{DropEveryNth(5)})
+	checkDelivered(t, data, out, err)
+}
+
+func TestTransferDropRate(t *testing.T) {
+	data := testData(16 << 10)
+	out, err := transferWithFaults(t, data,
+		[]Fault{DropRate(0.2)}, []Fault{DropRate(0.2)})
+	checkDelivered(t, data, out, err)
+}
+
+// corrupts packets on the sender's side only, in both directions: the
+// faults draw from the same seed, so the receiver's would flip the very
+// bits back.
+func TestTransferCorruptPayload(t *testing.T) {
+	data := testData(16 << 10)
+	out, err := transferWithFaults(t, data,
+		[]Fault{CorruptPayload(0.2)}, nil)
+	checkDelivered(t, data, out, err)
+}
+
+// losing only the ACKs makes the sender retransmit packets the receiver
+// has already taken, which it has to acknowledge again without writing
+// them twice.
+func TestTransferLostAcks(t *testing.T) {
+	data := testData(16 << 10)
+	out, err := transferWithFaults(t, data,
+		[]Fault{Only(DIR_IN, DropEveryNth(2))}, nil)
+	checkDelivered(t, data, out, err)
+}
+
+// a receiver that never answers fails the transfer once the retries are
+// used up.
+func TestTransferGivesUp(t *testing.T) {
+	data := testData(4 << 10)
+	out, err := transferWithFaults(t, data, nil,
+		[]Fault{Only(DIR_OUT, DropRate(1))})
+	if err == nil {
+		t.Fatalf("transfer succeeded without a single ACK")
+	}
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if out.closed && out.err == nil && !bytes.Equal(out.buf.Bytes(), data) {
+		t.Fatalf("receiver completed the transfer with corrupted data")
+	}
+}
+
+func TestOnly(t *testing.T) {
+	drop := Only(DIR_OUT, DropEveryNth(1))
+	if drop(DIR_IN, []byte{1}) == nil {
+		t.Errorf("incoming packet dropped by a fault for outgoing ones")
+	}
+	if drop(DIR_OUT, []byte{1}) != nil {
+		t.Errorf("outgoing packet passed")
+	}
+}
+
+// ReorderWindow passes every packet exactly once, out of order, and in
+// the same order on every run.
+func TestReorderWindow(t *testing.T) {
+	const n = 4
+	run := func() []byte {
+		f := ReorderWindow(n)
+		var order []byte
+		for i := 0; i < 100; i++ {
+			if pkt := f(DIR_OUT, []byte{byte(i)}); pkt != nil {
+				order = append(order, pkt[0])
+			}
+		}
+		return order
+	}
+	order := run()
+	if len(order) != 100-(n-1) {
+		t.Fatalf("%d packets passed, want %d", len(order), 100-(n-1))
+	}
+	seen := make(map[byte]bool)
+	reordered := false
+	for i, pkt := range order {
+		if seen[pkt] {
+			t.Fatalf("packet %d passed twice", pkt)
+		}
+		seen[pkt] = true
+		reordered = reordered || int(pkt) != i
+	}
+	if !reordered {
+		t.Errorf("packets passed in order")
+	}
+	if !bytes.Equal(run(), order) {
+		t.Errorf("the order differs between runs")
+	}
+}
@@ -0,0 +1,184 @@
+package abp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// FEC sub-header, prepended to the payload of every packet carrying the
+// HDR_FEC flag. A group consists of K data shards followed by M parity
+// shards; Total is the number of payload bytes carried by the data shards
+// so the receiver can strip the padding after reconstruction.
+type FecHeader struct {
+	Index      uint8
+	K          uint8
+	M          uint8
+	Generation uint8
+	Total      uint32
+}
+
+const FecHeaderLength int = 8
+
+func SerializeFecHeader(hdr FecHeader) []byte {
+	buf := make([]byte, FecHeaderLength)
+	buf[0], buf[1], buf[2], buf[3] = hdr.Index, hdr.K, hdr.M, hdr.Generation
+	binary.BigEndian.PutUint32(buf[4:], hdr.Total)
+	return buf
+}
+
+func ParseFecHeader(buffer []byte) (FecHeader, bool) {
+	var hdr FecHeader
+	if len(buffer) < FecHeaderLength {
+		return hdr, false
+	}
+	hdr.Index, hdr.K, hdr.M, hdr.Generation =
+		buffer[0], buffer[1], buffer[2], buffer[3]
+	hdr.Total = binary.BigEndian.Uint32(buffer[4:])
+	if hdr.K == 0 || int(hdr.Index) >= int(hdr.K)+int(hdr.M) {
+		return hdr, false
+	}
+	return hdr, true
+}
+
+// FecShardLength returns the (padded) length of every shard in a group
+// carrying total bytes in k data shards.
+func FecShardLength(total int, k int) int {
+	return (total + k - 1) / k
+}
+
+// Reed-Solomon erasure code over GF(2^8). The parity rows form a Cauchy
+// matrix, so any k out of the k+m shards are enough to recover the data.
+
+var gfExp [512]byte
+var gfLog [256]byte
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// coefficient of data shard i in parity shard j
+func fecCoefficient(k int, j int, i int) byte {
+	return gfInv(byte(k+j) ^ byte(i))
+}
+
+// FecEncode fills the parity shards shards[k:] from the data shards
+// shards[:k]. The parity shards must have the same length; shorter data
+// shards, like the last one of a group, count as padded with zeros.
+func FecEncode(shards [][]byte, k int) {
+	for j := 0; j < len(shards)-k; j++ {
+		parity := shards[k+j]
+		for b := range parity {
+			parity[b] = 0
+		}
+		for i := 0; i < k; i++ {
+			c := fecCoefficient(k, j, i)
+			for b, v := range shards[i] {
+				parity[b] ^= gfMul(c, v)
+			}
+		}
+	}
+}
+
+// FecReconstruct recovers missing (nil) data shards in place. At least k
+// of the shards must be present; shorter ones count as padded with zeros,
+// and recovered ones have the length of the longest.
+func FecReconstruct(shards [][]byte, k int) error {
+	var rows []int
+	for idx, shard := range shards {
+		if shard != nil {
+			rows = append(rows, idx)
+			if len(rows) == k {
+				break
+			}
+		}
+	}
+	if len(rows) < k {
+		return errors.New("fec: not enough shards to reconstruct")
+	}
+
+	// build the k×k decode matrix from the rows we've got and invert it
+	matrix := make([][]byte, k)
+	inverse := make([][]byte, k)
+	for r, idx := range rows {
+		matrix[r] = make([]byte, k)
+		inverse[r] = make([]byte, k)
+		inverse[r][r] = 1
+		if idx < k {
+			matrix[r][idx] = 1
+		} else {
+			for i := 0; i < k; i++ {
+				matrix[r][i] = fecCoefficient(k, idx-k, i)
+			}
+		}
+	}
+	for col := 0; col < k; col++ {
+		pivot := col
+		for pivot < k && matrix[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == k {
+			return errors.New("fec: singular decode matrix")
+		}
+		matrix[col], matrix[pivot] = matrix[pivot], matrix[col]
+		inverse[col], inverse[pivot] = inverse[pivot], inverse[col]
+
+		scale := gfInv(matrix[col][col])
+		for i := 0; i < k; i++ {
+			matrix[col][i] = gfMul(matrix[col][i], scale)
+			inverse[col][i] = gfMul(inverse[col][i], scale)
+		}
+		for r := 0; r < k; r++ {
+			if r == col || matrix[r][col] == 0 {
+				continue
+			}
+			f := matrix[r][col]
+			for i := 0; i < k; i++ {
+				matrix[r][i] ^= gfMul(f, matrix[col][i])
+				inverse[r][i] ^= gfMul(f, inverse[col][i])
+			}
+		}
+	}
+
+	shardLen := 0
+	for _, idx := range rows {
+		if len(shards[idx]) > shardLen {
+			shardLen = len(shards[idx])
+		}
+	}
+	for i := 0; i < k; i++ {
+		if shards[i] != nil {
+			continue
+		}
+		out := make([]byte, shardLen)
+		for r, idx := range rows {
+			c := inverse[i][r]
+			for b, v := range shards[idx] {
+				out[b] ^= gfMul(c, v)
+			}
+		}
+		shards[i] = out
+	}
+	return nil
+}
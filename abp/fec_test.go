@@ -0,0 +1,125 @@
+package abp
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// splits data into k data shards, the last one unpadded, followed by m
+// parity shards.
+func testShards(data []byte, k int, m int) [][]byte {
+	shardLen := FecShardLength(len(data), k)
+	shards := make([][]byte, k+m)
+	for i := range shards {
+		if i >= k {
+			shards[i] = make([]byte, shardLen)
+			continue
+		}
+		end := (i + 1) * shardLen
+		if end > len(data) {
+			end = len(data)
+		}
+		shards[i] = append([]byte(nil), data[i*shardLen:end]...)
+	}
+	FecEncode(shards, k)
+	return shards
+}
+
+func TestFecReconstruct(t *testing.T) {
+	tests := []struct {
+		k, m  int
+		total int
+		lost  []int
+	}{
+		{k: 4, m: 2, total: 4096, lost: nil},
+		{k: 4, m: 2, total: 4096, lost: []int{0}},
+		{k: 4, m: 2, total: 4096, lost: []int{1, 2}},
+		{k: 4, m: 2, total: 4096, lost: []int{0, 3}},
+		{k: 4, m: 2, total: 4096, lost: []int{2, 5}},
+		{k: 4, m: 2, total: 4096, lost: []int{4, 5}},
+		// the last shard is shorter than the others
+		{k: 4, m: 2, total: 4000, lost: []int{1}},
+		{k: 4, m: 2, total: 4000, lost: []int{3}},
+		{k: 4, m: 2, total: 4000, lost: []int{0, 3}},
+		{k: 2, m: 1, total: 999, lost: []int{0}},
+		{k: 3, m: 3, total: 1000, lost: []int{0, 1, 2}},
+		{k: 1, m: 1, total: 100, lost: []int{0}},
+		{k: 16, m: 4, total: 16 * 500, lost: []int{0, 7, 8, 15}},
+		// no parity, nothing to recover
+		{k: 4, m: 0, total: 4096, lost: nil},
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("k%d_m%d_total%d_lost%v", tt.k, tt.m, tt.total,
+			tt.lost)
+		t.Run(name, func(t *testing.T) {
+			data := testData(tt.total)
+			shards := testShards(data, tt.k, tt.m)
+			for _, idx := range tt.lost {
+				shards[idx] = nil
+			}
+			if err := FecReconstruct(shards, tt.k); err != nil {
+				t.Fatalf("FecReconstruct: %v", err)
+			}
+			var got []byte
+			for _, shard := range shards[:tt.k] {
+				got = append(got, shard...)
+			}
+			if len(got) < tt.total {
+				t.Fatalf("got %d bytes, want %d", len(got), tt.total)
+			}
+			if !bytes.Equal(got[:tt.total], data) {
+				t.Errorf("reconstructed data differs")
+			}
+			for _, b := range got[tt.total:] {
+				if b != 0 {
+					t.Fatalf("padding isn't zero")
+				}
+			}
+		})
+	}
+}
+
+// losing more shards than there are parity shards fails rather than
+// making up data.
+func TestFecReconstructTooManyLost(t *testing.T) {
+	tests := []struct {
+		k, m int
+		lost []int
+	}{
+		{k: 4, m: 2, lost: []int{0, 1, 2}},
+		{k: 4, m: 2, lost: []int{0, 4, 5}},
+		{k: 4, m: 0, lost: []int{3}},
+		{k: 2, m: 1, lost: []int{0, 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("k%d_m%d_lost%v", tt.k, tt.m, tt.lost),
+			func(t *testing.T) {
+				shards := testShards(testData(1000), tt.k, tt.m)
+				for _, idx := range tt.lost {
+					shards[idx] = nil
+				}
+				if err := FecReconstruct(shards, tt.k); err == nil {
+					t.Fatalf("FecReconstruct succeeded")
+				}
+				for _, idx := range tt.lost {
+					if idx < tt.k && shards[idx] != nil {
+						t.Errorf("shard %d filled in", idx)
+					}
+				}
+			})
+	}
+}
+
+func TestFecHeader(t *testing.T) {
+	hdr := FecHeader{Index: 5, K: 4, M: 2, Generation: 3, Total: 70000}
+	got, ok := ParseFecHeader(SerializeFecHeader(hdr))
+	if !ok || got != hdr {
+		t.Errorf("got %+v (%v), want %+v", got, ok, hdr)
+	}
+	for _, bad := range []FecHeader{{K: 0}, {Index: 6, K: 4, M: 2}} {
+		if _, ok := ParseFecHeader(SerializeFecHeader(bad)); ok {
+			t.Errorf("%+v accepted", bad)
+		}
+	}
+}
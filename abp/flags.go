@@ -0,0 +1,189 @@
+package abp
+
+import (
+	"strings"
+)
+
+// Header Flags
+const (
+	HDR_FILENAME    = 0x1
+	HDR_ALTERNATING = 0x2
+	HDR_FIN         = 0x4
+	HDR_FEC         = 0x8
+	HDR_COMPACT     = 0x10
+	HDR_COMPRESSED  = 0x20
+	HDR_DELTA       = 0x40
+	HDR_BUSY        = 0x80
+	HDR_SIZE        = 0x100
+	HDR_ERROR       = 0x200
+	HDR_SKIP        = 0x400
+	HDR_STORED_NAME = 0x800
+	HDR_DRY_RUN     = 0x1000
+	HDR_HASH        = 0x2000
+	HDR_BENCH       = 0x4000
+	HDR_ECHO        = 0x8000
+)
+
+// HDR_JOIN is no flag of its own but a combination no other packet uses:
+// a sender adding a path (i.e. source address) to its transfer sends it
+// from there with the transfer ID the receiver put into the FILENAME ACK,
+// the receiver echoes it back once the path is part of the transfer.
+const HDR_JOIN = HDR_FILENAME | HDR_ECHO
+
+// HDR_STREAM starts a parallel stream of a transfer, again a combination
+// of its own: the payload is the offset the stream's range starts at (64
+// bit) followed by the transfer ID. the receiver echoes it, after which
+// the stream sends its range like a transfer of its own, data packets,
+// alternating bit and FIN.
+const HDR_STREAM = HDR_FILENAME | HDR_HASH
+
+// HDR_NAME carries part of a file name too long for the FILENAME packet,
+// a combination of its own as well: the payload is the offset of the part
+// in the name (16 bit) followed by the part. the sender sends the parts
+// in order before the FILENAME packet, which carries the rest of the name,
+// and waits for each to be acknowledged with HDR_NAME and the number of
+// name bytes received so far (16 bit).
+const HDR_NAME = HDR_FILENAME | HDR_FIN
+
+// HDR_PRIORITY isn't a flag of its own either: on a FILENAME packet,
+// HDR_BUSY announces the priority class of the transfer as one byte
+// following the file size (if any) in the payload. receivers predating it
+// reject the packet, so senders only set it for receivers that announce
+// EXT_PRIORITY with HELLO. receivers don't echo it, a FILENAME ACK of
+// HDR_BUSY alone would tell the sender to retry. transfers without it are
+// PRIORITY_NORMAL.
+const HDR_PRIORITY = HDR_BUSY
+
+// HDR_HELLO asks the receiver for its capabilities before the FILENAME
+// packet, again a combination no other packet uses. both the request and
+// the reply (of the same flags) carry the capabilities of their end, see
+// Capabilities. receivers predating it drop the packet as invalid, so a
+// sender that gets no reply falls back to the plain handshake.
+const HDR_HELLO = HDR_ECHO | HDR_SIZE
+
+// the FILENAME options, echoed alone in the FILENAME ACK
+const optionFlags = HDR_COMPACT | HDR_COMPRESSED | HDR_DELTA | HDR_SIZE |
+	HDR_SKIP | HDR_STORED_NAME | HDR_DRY_RUN | HDR_BENCH
+
+// the flags of data packets and their ACKs
+const dataFlags = HDR_ALTERNATING | HDR_FIN | HDR_FEC | HDR_COMPRESSED |
+	HDR_SKIP
+
+var flagNames = []struct {
+	flag uint16
+	name string
+}{
+	{HDR_FILENAME, "FILENAME"},
+	{HDR_ALTERNATING, "ALTERNATING"},
+	{HDR_FIN, "FIN"},
+	{HDR_FEC, "FEC"},
+	{HDR_COMPACT, "COMPACT"},
+	{HDR_COMPRESSED, "COMPRESSED"},
+	{HDR_DELTA, "DELTA"},
+	{HDR_BUSY, "BUSY"},
+	{HDR_SIZE, "SIZE"},
+	{HDR_ERROR, "ERROR"},
+	{HDR_SKIP, "SKIP"},
+	{HDR_STORED_NAME, "STORED_NAME"},
+	{HDR_DRY_RUN, "DRY_RUN"},
+	{HDR_HASH, "HASH"},
+	{HDR_BENCH, "BENCH"},
+	{HDR_ECHO, "ECHO"},
+}
+
+// the combinations standing for packets of their own rather than for
+// their flags; like the control flags HDR_BUSY, HDR_ERROR, HDR_HASH,
+// HDR_ECHO and HDR_DELTA, they stand alone.
+var packetTypes = []struct {
+	flags uint16
+	name  string
+}{
+	{HDR_JOIN, "JOIN"},
+	{HDR_STREAM, "STREAM"},
+	{HDR_NAME, "NAME"},
+	{HDR_HELLO, "HELLO"},
+}
+
+// Flags are the flags of a header as debug output prints them: the names
+// of the flags set, e.g. "ALTERNATING|FIN", the name of a packet type for
+// a combination of its own, e.g. "JOIN", or "none".
+type Flags uint16
+
+func (flags Flags) String() string {
+	for _, t := range packetTypes {
+		if uint16(flags) == t.flags {
+			return t.name
+		}
+	}
+	var names []string
+	for _, f := range flagNames {
+		switch {
+		case uint16(flags)&f.flag == 0:
+		case f.flag == HDR_PRIORITY && flags&HDR_FILENAME != 0:
+			names = append(names, "PRIORITY")
+		default:
+			names = append(names, f.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// FlagString names the flags set in flags, see Flags.
+func FlagString(flags uint16) string {
+	return Flags(flags).String()
+}
+
+// tells whether a packet of these flags is one that stands alone.
+func isControlPacket(flags uint16) bool {
+	switch flags {
+	case HDR_BUSY, HDR_ERROR, HDR_HASH, HDR_ECHO, HDR_DELTA:
+		return true
+	}
+	for _, t := range packetTypes {
+		if flags == t.flags {
+			return true
+		}
+	}
+	return false
+}
+
+// The 16 bits of the header are all taken, and a wider header would break
+// every peer deployed. Features beyond them are announced as extended
+// flags with the HELLO exchange instead (see Capabilities.Extended), a
+// namespace of 32 bits registered here.
+type ExtFlags uint32
+
+const (
+	EXT_NAME_PARTS = 0x1  // takes names in HDR_NAME parts
+	EXT_STREAMS    = 0x2  // takes parallel streams, see HDR_STREAM
+	EXT_PATHS      = 0x4  // takes additional paths, see HDR_JOIN
+	EXT_TOKEN      = 0x8  // seals replies with a session token, see SealChecksum
+	EXT_PRIORITY   = 0x10 // takes priority classes, see HDR_PRIORITY
+)
+
+var extFlagNames = []struct {
+	flag uint32
+	name string
+}{
+	{EXT_NAME_PARTS, "NAME_PARTS"},
+	{EXT_STREAMS, "STREAMS"},
+	{EXT_PATHS, "PATHS"},
+	{EXT_TOKEN, "TOKEN"},
+	{EXT_PRIORITY, "PRIORITY"},
+}
+
+func (flags ExtFlags) String() string {
+	var names []string
+	for _, f := range extFlagNames {
+		if uint32(flags)&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
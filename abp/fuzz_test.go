@@ -0,0 +1,132 @@
+package abp
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// FuzzTransfer runs whole transfers over loopback. Its input is a schedule
+// of faults: the nth byte decides what happens to the nth datagram passing
+// between Sender and Receiver in either direction (pass, drop, duplicate
+// or corrupt). A transfer has to either deliver the data intact or fail
+// with an error.
+//
+//	go test -fuzz FuzzTransfer ./abp
+func FuzzTransfer(f *testing.F) {
+	// a clean run, lost and duplicated handshakes, a corrupted FIN and
+	// a burst of losses the retries have to outlast
+	f.Add([]byte{})
+	f.Add([]byte{1, 0, 1})
+	f.Add([]byte{2, 2, 2, 2})
+	f.Add(append(make([]byte, 34), 3, 7, 11))
+	f.Add(bytes.Repeat([]byte{1}, 6))
+	f.Add([]byte{0, 3, 0, 1, 0, 2, 0, 3})
+	f.Fuzz(func(t *testing.T, schedule []byte) {
+		transferWithSchedule(t, schedule)
+	})
+}
+
+func transferWithSchedule(t *testing.T, schedule []byte) {
+	data := make([]byte, 4096)
+	rand.New(rand.NewSource(int64(len(schedule)))).Read(data)
+
+	recvConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recvConn.Close()
+	out := &testWriter{}
+	r := NewReceiver(recvConn, func(name string) (io.WriteCloser, error) {
+		return out, nil
+	})
+	go r.Serve()
+
+	relay := newFaultRelay(t, schedule, recvConn.LocalAddr().(*net.UDPAddr))
+	defer relay.conn.Close()
+	go relay.run()
+
+	sendConn, err := net.DialUDP("udp", nil, relay.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sendConn.Close()
+	s := NewSender(sendConn, "fuzz.bin")
+	s.Size = int64(len(data))
+	s.PacketLength = 256
+	s.Retry = FixedRetry{Timeout: 20 * time.Millisecond, Retries: 8}
+	_, sendErr := s.ReadFrom(bytes.NewReader(data))
+	if sendErr == nil {
+		sendErr = s.Close()
+	}
+
+	// the receiver closes its writer before it sends the FIN ACK
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if out.closed && out.err == nil && !bytes.Equal(out.buf.Bytes(), data) {
+		t.Fatalf("receiver completed the transfer with corrupted data")
+	}
+	if sendErr == nil && !(out.closed && out.err == nil) {
+		t.Fatalf("sender completed the transfer the receiver didn't")
+	}
+}
+
+// forwards datagrams between a sender and a receiver, applying a fault
+// schedule. it keeps their order, reordering isn't something the
+// alternating bit can cope with.
+type faultRelay struct {
+	conn     *net.UDPConn
+	receiver *net.UDPAddr
+	sender   *net.UDPAddr
+	schedule []byte
+	n        int
+}
+
+func newFaultRelay(t *testing.T, schedule []byte,
+	receiver *net.UDPAddr) *faultRelay {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &faultRelay{conn: conn, receiver: receiver, schedule: schedule}
+}
+
+func (f *faultRelay) run() {
+	buf := make([]byte, MaxPacketLength)
+	for {
+		n, addr, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		to := f.receiver
+		if addr.String() == f.receiver.String() {
+			if f.sender == nil {
+				continue
+			}
+			to = f.sender
+		} else {
+			f.sender = addr
+		}
+
+		pkt := buf[:n]
+		var action byte
+		if f.n < len(f.schedule) {
+			action = f.schedule[f.n]
+		}
+		f.n++
+		switch action & 3 {
+		case 1:
+			continue
+		case 2:
+			f.conn.WriteToUDP(pkt, to)
+		case 3:
+			if n > 0 {
+				pkt[int(action>>2)%n] ^= 1 << (uint(f.n) % 8)
+			}
+		}
+		f.conn.WriteToUDP(pkt, to)
+	}
+}
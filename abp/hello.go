@@ -0,0 +1,198 @@
+package abp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// the capabilities are a list of type-length-value entries of one byte
+// type and length each; unknown types are skipped, so later versions can
+// add more.
+const (
+	capOptions     = 1 // FILENAME options, 16 bit
+	capWindow      = 2 // advertises windows in data ACKs, no value
+	capCompression = 3 // comma separated names, preferred first
+	capEncryption  = 4
+	capChecksums   = 5
+	capMaxPayload  = 6 // 16 bit
+	capExtended    = 7 // ExtFlags, 32 bit
+	capToken       = 8 // session token, 32 bit
+)
+
+var ErrInvalidCapabilities = errors.New("invalid capability list")
+
+// Capabilities are the features an end of a transfer supports, exchanged
+// with HELLO packets so both ends can use the richest set they have in
+// common.
+type Capabilities struct {
+	// FILENAME options (HDR_COMPACT, HDR_COMPRESSED, ...) the receiver
+	// takes
+	Options uint16
+	// whether the receiver advertises its window in data ACKs
+	Window bool
+	// compression algorithms, e.g. gzip
+	Compression []string
+	// ciphers for encrypted payloads; there are none so far, ends
+	// encrypting on their own (see Middleware) don't announce it
+	Encryption []string
+	// packet checksums, crc32q so far
+	Checksums []string
+	// the largest payload accepted, 0 if unknown
+	MaxPayload int
+	// the features beyond the header flags, see ExtFlags
+	Extended ExtFlags
+	// a random session token of the sender, echoed by a receiver that
+	// seals its replies with it (EXT_TOKEN); 0 for none
+	Token uint32
+}
+
+func (c Capabilities) MarshalBinary() ([]byte, error) {
+	var buf []byte
+	add := func(typ byte, value []byte) error {
+		if len(value) > 255 {
+			return ErrInvalidCapabilities
+		}
+		buf = append(append(buf, typ, byte(len(value))), value...)
+		return nil
+	}
+	var u16 [2]byte
+	binary.BigEndian.PutUint16(u16[:], c.Options)
+	add(capOptions, u16[:])
+	if c.Window {
+		add(capWindow, nil)
+	}
+	lists := []struct {
+		typ   byte
+		names []string
+	}{
+		{capCompression, c.Compression},
+		{capEncryption, c.Encryption},
+		{capChecksums, c.Checksums},
+	}
+	for _, l := range lists {
+		if len(l.names) == 0 {
+			continue
+		}
+		if err := add(l.typ, []byte(strings.Join(l.names, ","))); err != nil {
+			return nil, err
+		}
+	}
+	if c.MaxPayload > 0 {
+		if c.MaxPayload > 0xffff {
+			return nil, ErrInvalidCapabilities
+		}
+		binary.BigEndian.PutUint16(u16[:], uint16(c.MaxPayload))
+		add(capMaxPayload, u16[:])
+	}
+	if c.Extended != 0 {
+		var u32 [4]byte
+		binary.BigEndian.PutUint32(u32[:], uint32(c.Extended))
+		add(capExtended, u32[:])
+	}
+	if c.Token != 0 {
+		var u32 [4]byte
+		binary.BigEndian.PutUint32(u32[:], c.Token)
+		add(capToken, u32[:])
+	}
+	return buf, nil
+}
+
+// ParseCapabilities decodes the payload of a HELLO packet.
+func ParseCapabilities(payload []byte) (Capabilities, error) {
+	var c Capabilities
+	for len(payload) > 0 {
+		if len(payload) < 2 || len(payload) < 2+int(payload[1]) {
+			return c, ErrInvalidCapabilities
+		}
+		typ, value := payload[0], payload[2:2+int(payload[1])]
+		payload = payload[2+len(value):]
+		switch typ {
+		case capOptions, capMaxPayload:
+			if len(value) != 2 {
+				return c, ErrInvalidCapabilities
+			}
+			if typ == capOptions {
+				c.Options = binary.BigEndian.Uint16(value)
+			} else {
+				c.MaxPayload = int(binary.BigEndian.Uint16(value))
+			}
+		case capExtended, capToken:
+			if len(value) != 4 {
+				return c, ErrInvalidCapabilities
+			}
+			if typ == capExtended {
+				c.Extended = ExtFlags(binary.BigEndian.Uint32(value))
+			} else {
+				c.Token = binary.BigEndian.Uint32(value)
+			}
+		case capWindow:
+			c.Window = true
+		case capCompression:
+			c.Compression = strings.Split(string(value), ",")
+		case capEncryption:
+			c.Encryption = strings.Split(string(value), ",")
+		case capChecksums:
+			c.Checksums = strings.Split(string(value), ",")
+		}
+	}
+	return c, nil
+}
+
+// Common returns the features both c and other support. Lists keep the
+// order of c, i.e. its preferences.
+func (c Capabilities) Common(other Capabilities) Capabilities {
+	common := Capabilities{
+		Options:     c.Options & other.Options,
+		Window:      c.Window && other.Window,
+		Compression: intersect(c.Compression, other.Compression),
+		Encryption:  intersect(c.Encryption, other.Encryption),
+		Checksums:   intersect(c.Checksums, other.Checksums),
+		MaxPayload:  c.MaxPayload,
+		Extended:    c.Extended & other.Extended,
+		Token:       c.Token,
+	}
+	if common.MaxPayload == 0 ||
+		(other.MaxPayload > 0 && other.MaxPayload < common.MaxPayload) {
+		common.MaxPayload = other.MaxPayload
+	}
+	return common
+}
+
+// Supports tells whether name is in list, e.g. c.Compression.
+func Supports(list []string, name string) bool {
+	for _, n := range list {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func intersect(a []string, b []string) []string {
+	var both []string
+	for _, name := range a {
+		if Supports(b, name) {
+			both = append(both, name)
+		}
+	}
+	return both
+}
+
+func (c Capabilities) String() string {
+	names := func(list []string) string {
+		if len(list) == 0 {
+			return "none"
+		}
+		return strings.Join(list, ",")
+	}
+	window := "no"
+	if c.Window {
+		window = "yes"
+	}
+	return fmt.Sprintf("options %s, window %s, compression %s, "+
+		"encryption %s, checksums %s, max payload %d, extended %v",
+		FlagString(c.Options), window, names(c.Compression),
+		names(c.Encryption), names(c.Checksums), c.MaxPayload, c.Extended)
+}
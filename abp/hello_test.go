@@ -0,0 +1,159 @@
+package abp
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCapabilitiesRoundTrip(t *testing.T) {
+	tests := []Capabilities{
+		{},
+		{Options: HDR_COMPACT | HDR_SIZE, Window: true},
+		{
+			Options:     receiverOptions,
+			Window:      true,
+			Compression: []string{"gzip", "zstd"},
+			Encryption:  []string{"aes-gcm"},
+			Checksums:   []string{"crc32q"},
+			MaxPayload:  1400,
+			Extended:    EXT_NAME_PARTS | EXT_PRIORITY,
+			Token:       0xdeadbeef,
+		},
+	}
+	for _, c := range tests {
+		buf, err := c.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%v: %v", c, err)
+		}
+		got, err := ParseCapabilities(buf)
+		if err != nil || !reflect.DeepEqual(got, c) {
+			t.Errorf("%v: parsed %v (%v)", c, got, err)
+		}
+	}
+
+	long := make([]string, 100)
+	for i := range long {
+		long[i] = "abc"
+	}
+	if _, err := (Capabilities{Compression: long}).MarshalBinary(); err == nil {
+		t.Errorf("marshalled a list longer than 255 bytes")
+	}
+	if _, err := (Capabilities{MaxPayload: 0x10000}).MarshalBinary(); err == nil {
+		t.Errorf("marshalled a max payload that doesn't fit 16 bit")
+	}
+}
+
+func TestParseCapabilities(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    Capabilities
+		ok      bool
+	}{
+		{"empty", nil, Capabilities{}, true},
+		{"unknown types skipped",
+			[]byte{200, 3, 1, 2, 3, capWindow, 0, 99, 0,
+				capMaxPayload, 2, 0x05, 0x78},
+			Capabilities{Window: true, MaxPayload: 1400}, true},
+		{"compression", append([]byte{capCompression, 9},
+			"zstd,gzip"...),
+			Capabilities{Compression: []string{"zstd", "gzip"}}, true},
+		{"truncated entry", []byte{capOptions, 2, 0}, Capabilities{}, false},
+		{"missing length", []byte{capWindow}, Capabilities{}, false},
+		{"short options", []byte{capOptions, 1, 0}, Capabilities{}, false},
+		{"long max payload", []byte{capMaxPayload, 3, 0, 0, 0},
+			Capabilities{}, false},
+		{"short extended", []byte{capExtended, 2, 0, 1}, Capabilities{},
+			false},
+		{"short token", []byte{capToken, 3, 1, 2, 3}, Capabilities{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseCapabilities(tt.payload)
+		if tt.ok != (err == nil) {
+			t.Errorf("%s: error %v", tt.name, err)
+		} else if tt.ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parsed %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCapabilitiesCommon(t *testing.T) {
+	sender := Capabilities{
+		Options:     HDR_COMPACT | HDR_COMPRESSED | HDR_SIZE,
+		Window:      true,
+		Compression: []string{"zstd", "lz4", "gzip"},
+		Checksums:   []string{"crc32q"},
+		MaxPayload:  8000,
+		Extended:    EXT_STREAMS | EXT_TOKEN,
+		Token:       42,
+	}
+	receiver := Capabilities{
+		Options:     HDR_COMPRESSED | HDR_SIZE | HDR_DRY_RUN,
+		Compression: []string{"gzip", "zstd"},
+		Encryption:  []string{"aes-gcm"},
+		Checksums:   []string{"crc32q"},
+		MaxPayload:  1400,
+		Extended:    EXT_TOKEN | EXT_PRIORITY,
+	}
+	want := Capabilities{
+		Options: HDR_COMPRESSED | HDR_SIZE,
+		// in the order the sender prefers
+		Compression: []string{"zstd", "gzip"},
+		Checksums:   []string{"crc32q"},
+		MaxPayload:  1400,
+		Extended:    EXT_TOKEN,
+		Token:       42,
+	}
+	if got := sender.Common(receiver); !reflect.DeepEqual(got, want) {
+		t.Errorf("common capabilities %v, want %v", got, want)
+	}
+
+	// an unknown max payload doesn't limit the other end's
+	for _, c := range []struct{ a, b, want int }{
+		{0, 1400, 1400}, {1400, 0, 1400}, {0, 0, 0}, {500, 1400, 500},
+	} {
+		got := Capabilities{MaxPayload: c.a}.Common(Capabilities{
+			MaxPayload: c.b}).MaxPayload
+		if got != c.want {
+			t.Errorf("max payload of %d and %d: %d, want %d", c.a, c.b,
+				got, c.want)
+		}
+	}
+}
+
+// the library receiver answers HELLO with its capabilities and takes the
+// FILENAME packet that follows.
+func TestReceiverHello(t *testing.T) {
+	opened, conn := startTestReceiver(t)
+	hello, _ := Capabilities{Compression: []string{"gzip"}}.MarshalBinary()
+	sendTestPacket(t, conn, HDR_HELLO, hello)
+
+	buf := make([]byte, MaxPacketLength)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr, payload, err := ParsePacket(buf[:n], false)
+	if err != nil || hdr.Flags != HDR_HELLO {
+		t.Fatalf("reply %v (%v), want HELLO", hdr, err)
+	}
+	caps, err := ParseCapabilities(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.Options != receiverOptions || !Supports(caps.Compression, "gzip") ||
+		caps.MaxPayload != MaxPacketLength-HeaderLength {
+		t.Errorf("receiver capabilities %v", caps)
+	}
+	if atomic.LoadInt32(opened) != 0 {
+		t.Errorf("HELLO opened a transfer")
+	}
+
+	sendTestPacket(t, conn, HDR_FILENAME, []byte("after-hello.bin"))
+	if flags, ok := readTestReply(t, conn); !ok || flags != 0 {
+		t.Errorf("FILENAME after HELLO answered with %v (%v)", flags, ok)
+	}
+}
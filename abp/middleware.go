@@ -0,0 +1,41 @@
+package abp
+
+// Direction tells a Middleware whether a packet is being sent or was
+// received.
+type Direction int
+
+const (
+	DIR_IN Direction = iota
+	DIR_OUT
+)
+
+func (d Direction) String() string {
+	if d == DIR_OUT {
+		return "out"
+	}
+	return "in"
+}
+
+// Middleware sees every packet of a Sender or Receiver, checksum and all,
+// and returns the packet to pass on. It may return a modified copy, e.g.
+// encrypted, or nil to drop the packet. An error drops the packet as well;
+// for outgoing packets of a Sender it also fails the transfer.
+type Middleware func(dir Direction, pkt []byte) ([]byte, error)
+
+// runs pkt through a chain of middleware. outgoing packets pass it in the
+// order the middleware was added, incoming ones in reverse, so a layer
+// added last sits closest to the network in both directions.
+func applyMiddleware(chain []Middleware, dir Direction, pkt []byte) ([]byte, error) {
+	for i := range chain {
+		mw := chain[i]
+		if dir == DIR_IN {
+			mw = chain[len(chain)-1-i]
+		}
+		var err error
+		pkt, err = mw(dir, pkt)
+		if err != nil || pkt == nil {
+			return nil, err
+		}
+	}
+	return pkt, nil
+}
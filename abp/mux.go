@@ -0,0 +1,231 @@
+package abp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// every datagram of a Mux starts with the session ID
+const MuxIDLength = 4
+
+// how many replies a Mux session buffers before dropping them
+const muxInBuffer = 16
+
+var ErrMuxClosed = errors.New("mux closed")
+
+// Mux runs several transfers over one connected UDP socket, so a batch of
+// files doesn't need a socket per file. Every datagram is prefixed with a
+// session ID, which the receiving Receiver has to expect (Multiplexed).
+//
+// The transfers share their RTT estimate. When several are ready to send,
+// the one with the highest priority goes first; transfers of the same
+// priority take turns.
+type Mux struct {
+	conn     *net.UDPConn
+	mu       sync.Mutex
+	wake     *sync.Cond
+	sessions map[uint32]*muxConn
+	nextID   uint32
+	queue    []*muxWrite
+	// counts the packets sent, for taking turns
+	served uint64
+	rtt    rttEstimator
+	closed chan struct{}
+	err    error
+}
+
+// a session of a Mux, as seen by its Sender.
+type muxConn struct {
+	mux      *Mux
+	id       uint32
+	priority int
+	// when the session last got to send
+	lastServed uint64
+	in         chan []byte
+	deadline   time.Time
+}
+
+type muxWrite struct {
+	conn *muxConn
+	pkt  []byte
+	done chan error
+}
+
+func NewMux(conn *net.UDPConn) *Mux {
+	m := &Mux{
+		conn:     conn,
+		sessions: make(map[uint32]*muxConn),
+		closed:   make(chan struct{}),
+	}
+	m.wake = sync.NewCond(&m.mu)
+	go m.readLoop()
+	go m.writeLoop()
+	return m
+}
+
+// Sender returns a Sender transferring name over the Mux. Higher
+// priorities are served first; the priority classes (PRIORITY_HIGH,
+// PRIORITY_NORMAL, PRIORITY_BULK) are announced to the receiver as well.
+func (m *Mux) Sender(name string, priority int) *Sender {
+	m.mu.Lock()
+	m.nextID++
+	c := &muxConn{
+		mux:      m,
+		id:       m.nextID,
+		priority: priority,
+		in:       make(chan []byte, muxInBuffer),
+	}
+	m.sessions[c.id] = c
+	m.mu.Unlock()
+	s := newSender(c, name, &m.rtt)
+	if priority >= PRIORITY_BULK && priority <= PRIORITY_HIGH {
+		s.Priority = priority
+	}
+	return s
+}
+
+// Close closes the socket; running transfers fail.
+func (m *Mux) Close() error {
+	m.fail(ErrMuxClosed)
+	return m.conn.Close()
+}
+
+func (m *Mux) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.closed:
+		return
+	default:
+	}
+	m.err = err
+	close(m.closed)
+	for _, w := range m.queue {
+		w.done <- err
+	}
+	m.queue = nil
+	m.wake.Broadcast()
+}
+
+// hands every reply to its session.
+func (m *Mux) readLoop() {
+	buf := make([]byte, MaxPacketLength)
+	for {
+		n, err := m.conn.Read(buf)
+		if err != nil {
+			if _, ok := err.(net.Error); ok && !errors.Is(err, net.ErrClosed) {
+				// e.g. nobody listening (yet)
+				select {
+				case <-m.closed:
+					return
+				case <-time.After(100 * time.Millisecond):
+				}
+				continue
+			}
+			m.fail(err)
+			return
+		}
+		if n < MuxIDLength {
+			continue
+		}
+		m.mu.Lock()
+		c := m.sessions[binary.BigEndian.Uint32(buf)]
+		m.mu.Unlock()
+		if c == nil {
+			continue
+		}
+		select {
+		case c.in <- append([]byte(nil), buf[MuxIDLength:n]...):
+		default:
+		}
+	}
+}
+
+// sends the queued packets, highest priority first and taking turns
+// otherwise.
+func (m *Mux) writeLoop() {
+	for {
+		m.mu.Lock()
+		for len(m.queue) == 0 && m.err == nil {
+			m.wake.Wait()
+		}
+		if m.err != nil {
+			m.mu.Unlock()
+			return
+		}
+		next := 0
+		for i, w := range m.queue {
+			best := m.queue[next].conn
+			if w.conn.priority > best.priority ||
+				w.conn.priority == best.priority &&
+					w.conn.lastServed < best.lastServed {
+				next = i
+			}
+		}
+		w := m.queue[next]
+		m.queue = append(m.queue[:next], m.queue[next+1:]...)
+		m.served++
+		w.conn.lastServed = m.served
+		m.mu.Unlock()
+
+		_, err := m.conn.Write(w.pkt)
+		w.done <- err
+	}
+}
+
+func (c *muxConn) Write(b []byte) (int, error) {
+	pkt := make([]byte, MuxIDLength+len(b))
+	binary.BigEndian.PutUint32(pkt, c.id)
+	copy(pkt[MuxIDLength:], b)
+	w := &muxWrite{c, pkt, make(chan error, 1)}
+
+	m := c.mux
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		return 0, m.err
+	}
+	m.queue = append(m.queue, w)
+	m.wake.Signal()
+	m.mu.Unlock()
+	if err := <-w.done; err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *muxConn) Read(b []byte) (int, error) {
+	var timeout <-chan time.Time
+	if !c.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(c.deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case pkt := <-c.in:
+		return copy(b, pkt), nil
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	case <-c.mux.closed:
+		return 0, c.mux.err
+	}
+}
+
+func (c *muxConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *muxConn) RemoteAddr() net.Addr {
+	return c.mux.conn.RemoteAddr()
+}
+
+func (c *muxConn) release() {
+	c.mux.mu.Lock()
+	delete(c.mux.sessions, c.id)
+	c.mux.mu.Unlock()
+}
@@ -0,0 +1,138 @@
+package abp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// transfers of every priority class share a Mux and arrive intact at a
+// Multiplexed Receiver, which passes their priorities on.
+func TestMuxTransfers(t *testing.T) {
+	recvConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recvConn.Close()
+	r := NewReceiver(recvConn, nil)
+	r.Multiplexed = true
+	go r.Serve()
+
+	conn, err := net.DialUDP("udp", nil, recvConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMux(conn)
+	defer m.Close()
+
+	priorities := []int{PRIORITY_HIGH, PRIORITY_NORMAL, PRIORITY_BULK,
+		PRIORITY_NORMAL}
+	errs := make(chan error, 2*len(priorities))
+	for i, priority := range priorities {
+		name := fmt.Sprintf("file%d", i)
+		data := testData(5000 + 1000*i)
+		s := m.Sender(name, priority)
+		s.Size = int64(len(data))
+		s.Retry = FixedRetry{Timeout: 20 * time.Millisecond, Retries: 50}
+		go func() {
+			_, err := s.ReadFrom(bytes.NewReader(data))
+			if err == nil {
+				err = s.Close()
+			}
+			errs <- err
+		}()
+	}
+
+	var mu sync.Mutex
+	received := make(map[string]int)
+	for range priorities {
+		in, err := r.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			defer in.Close()
+			got, err := ioutil.ReadAll(in)
+			var i int
+			fmt.Sscanf(in.Name, "file%d", &i)
+			if err == nil && !bytes.Equal(got, testData(5000+1000*i)) {
+				err = fmt.Errorf("%s: received %d bytes of wrong data",
+					in.Name, len(got))
+			}
+			mu.Lock()
+			received[in.Name] = in.Priority
+			mu.Unlock()
+			errs <- err
+		}()
+	}
+	for range 2 * len(priorities) {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("transfers didn't complete")
+		}
+	}
+	for i, priority := range priorities {
+		name := fmt.Sprintf("file%d", i)
+		if got, ok := received[name]; !ok || got != priority {
+			t.Errorf("%s received with priority %d (%v), want %d", name,
+				got, ok, priority)
+		}
+	}
+}
+
+// with packets of several sessions queued, the highest priority is sent
+// first and sessions of the same priority take turns.
+func TestMuxWriteOrder(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	conn, err := net.DialUDP("udp", nil, peer.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// no readLoop, and the writeLoop only starts once everything is queued
+	m := &Mux{conn: conn, sessions: make(map[uint32]*muxConn),
+		closed: make(chan struct{})}
+	m.wake = sync.NewCond(&m.mu)
+	high := &muxConn{mux: m, id: 1, priority: PRIORITY_HIGH}
+	a := &muxConn{mux: m, id: 2, priority: PRIORITY_NORMAL}
+	b := &muxConn{mux: m, id: 3, priority: PRIORITY_NORMAL}
+	bulk := &muxConn{mux: m, id: 4, priority: PRIORITY_BULK}
+	for _, w := range []struct {
+		conn *muxConn
+		pkt  string
+	}{
+		{bulk, "bulk1"}, {a, "a1"}, {a, "a2"}, {a, "a3"}, {b, "b1"},
+		{high, "high1"}, {b, "b2"}, {high, "high2"},
+	} {
+		m.queue = append(m.queue, &muxWrite{w.conn, []byte(w.pkt),
+			make(chan error, 1)})
+	}
+	go m.writeLoop()
+	defer m.fail(ErrMuxClosed)
+
+	want := []string{"high1", "high2", "a1", "b1", "a2", "b2", "a3", "bulk1"}
+	buf := make([]byte, MaxPacketLength)
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i, pkt := range want {
+		n, err := peer.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != pkt {
+			t.Fatalf("packet %d is %q, want %q", i, buf[:n], pkt)
+		}
+	}
+}
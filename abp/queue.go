@@ -0,0 +1,244 @@
+package abp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Queue is a spool of pending transfers on disk. Every entry is a small
+// JSON file in the spool directory, so entries survive restarts and can be
+// added by other processes while the queue runs. Failed transfers are
+// retried with exponential backoff.
+//
+// Only one process should Run a queue at a time.
+type Queue struct {
+	dir string
+	// how long to wait after the first failure of a transfer; doubles
+	// with every further failure up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// how often Run looks for new entries
+	PollInterval time.Duration
+	// sends an entry; the default uses a Sender with its defaults
+	Send func(e *QueueEntry) error
+}
+
+type QueueEntry struct {
+	ID string `json:"-"`
+	// the file to send, absolute
+	Path string `json:"path"`
+	// the receiver, host:port
+	Target      string    `json:"target"`
+	Added       time.Time `json:"added"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+const queueSuffix = ".json"
+
+// OpenQueue opens the spool in dir, creating it if needed.
+func OpenQueue(dir string) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("abp: opening queue: %w", err)
+	}
+	return &Queue{
+		dir:          dir,
+		MinBackoff:   time.Minute,
+		MaxBackoff:   time.Hour,
+		PollInterval: 5 * time.Second,
+	}, nil
+}
+
+// Add queues path to be sent to target.
+func (q *Queue) Add(path string, target string) (*QueueEntry, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("abp: queueing %s: %w", path, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("abp: queueing %s: %w", path, err)
+	}
+	now := time.Now()
+	e := &QueueEntry{
+		// sorts in the order entries were added
+		ID:          fmt.Sprintf("%020d", now.UnixNano()),
+		Path:        path,
+		Target:      target,
+		Added:       now,
+		NextAttempt: now,
+	}
+	return e, q.save(e)
+}
+
+// Entries returns the pending transfers, oldest first.
+func (q *Queue) Entries() ([]*QueueEntry, error) {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("abp: reading queue: %w", err)
+	}
+	var entries []*QueueEntry
+	for _, info := range files {
+		name := info.Name()
+		if !strings.HasSuffix(name, queueSuffix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(q.dir, name))
+		if err != nil {
+			// removed meanwhile
+			continue
+		}
+		e := &QueueEntry{ID: strings.TrimSuffix(name, queueSuffix)}
+		if err := json.Unmarshal(data, e); err != nil {
+			return nil, fmt.Errorf("abp: reading queue entry %s: %w",
+				name, err)
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+// Remove drops an entry without sending it.
+func (q *Queue) Remove(e *QueueEntry) error {
+	err := os.Remove(q.path(e))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("abp: removing queue entry: %w", err)
+	}
+	return nil
+}
+
+// Run sends the due entries one after the other until stop is closed.
+// Sent entries are removed, failed ones are tried again later.
+func (q *Queue) Run(stop <-chan struct{}) error {
+	for {
+		entries, err := q.Entries()
+		if err != nil {
+			return err
+		}
+		wait := q.PollInterval
+		for _, e := range entries {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+			if until := time.Until(e.NextAttempt); until > 0 {
+				if until < wait {
+					wait = until
+				}
+				continue
+			}
+			if err := q.send(e); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// sends an entry and records the outcome. only failing to update the
+// spool is an error.
+func (q *Queue) send(e *QueueEntry) error {
+	send := q.Send
+	if send == nil {
+		send = sendQueueEntry
+	}
+	err := send(e)
+	if err == nil {
+		return q.Remove(e)
+	}
+	backoff := q.MinBackoff
+	for i := 0; i < e.Attempts && backoff < q.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > q.MaxBackoff {
+		backoff = q.MaxBackoff
+	}
+	e.Attempts++
+	e.NextAttempt = time.Now().Add(backoff)
+	e.LastError = err.Error()
+	return q.save(e)
+}
+
+// writes an entry atomically and durably, so neither a crash nor a power
+// loss leaves half of it behind.
+func (q *Queue) save(e *QueueEntry) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(q.dir, "."+e.ID+".tmp")
+	if err := writeSynced(tmp, data); err != nil {
+		return fmt.Errorf("abp: saving queue entry: %w", err)
+	}
+	if err := os.Rename(tmp, q.path(e)); err != nil {
+		return fmt.Errorf("abp: saving queue entry: %w", err)
+	}
+	return nil
+}
+
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (q *Queue) path(e *QueueEntry) string {
+	return filepath.Join(q.dir, e.ID+queueSuffix)
+}
+
+// the default Queue.Send: the plain protocol with the defaults of
+// NewSender.
+func sendQueueEntry(e *QueueEntry) error {
+	f, err := os.Open(e.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	addr, err := net.ResolveUDPAddr("udp", e.Target)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s := NewSender(conn, filepath.Base(e.Path))
+	s.Size = info.Size()
+	if _, err := io.Copy(s, f); err != nil {
+		s.Close()
+		return err
+	}
+	return s.Close()
+}
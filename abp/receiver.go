@@ -0,0 +1,507 @@
+package abp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Receiver implements the receiving side of the protocol for use as a
+// library. Instead of writing files, it hands every incoming transfer to a
+// WriterFactory, so transfers can land in buffers, databases or object
+// stores directly.
+//
+// Besides the plain protocol it accepts compact headers, compressed
+// payloads, the announced file size, dry runs and reports the stored name;
+// FEC, delta transfers and sparse files are only supported by the receiver
+// command.
+type Receiver struct {
+	conn   datagramConn
+	create WriterFactory
+	// sessions without a packet for this long are aborted
+	Timeout time.Duration
+	// expect the session ID of a Mux before every packet. must be set
+	// before Serve.
+	Multiplexed bool
+	sessions    map[string]*session
+	// transfers waiting for Accept, if there is no WriterFactory
+	incoming   chan *Incoming
+	serveErr   error
+	middleware []Middleware
+}
+
+// WriterFactory opens the destination of an incoming transfer. The name is
+// passed on as sent, so it may contain slashes. An error refuses the
+// transfer with ERR_REFUSED.
+type WriterFactory func(name string) (io.WriteCloser, error)
+
+// the writer of a completed transfer is closed with Close. writers of
+// failed transfers are closed with CloseWithError (like io.PipeWriter) if
+// they implement it, so they can tell both apart.
+type closeWithError interface {
+	CloseWithError(err error) error
+}
+
+// the FILENAME options the library receiver accepts
+const receiverOptions = HDR_COMPACT | HDR_COMPRESSED | HDR_SIZE |
+	HDR_STORED_NAME | HDR_DRY_RUN
+
+// the capabilities the library receiver answers HELLO packets with. it
+// doesn't remember them, so it only takes gzip, the compression agreed on
+// without one.
+var receiverHello, _ = Capabilities{
+	Options:     receiverOptions,
+	Compression: []string{"gzip"},
+	Checksums:   []string{"crc32q"},
+	MaxPayload:  MaxPacketLength - HeaderLength,
+	Extended:    EXT_PRIORITY,
+}.MarshalBinary()
+
+// the most a compressed payload may inflate to; like the receiver command,
+// allow 8x the packet size.
+const maxReceiverPayload = 8 * MaxPacketLength
+
+type session struct {
+	addr *net.UDPAddr
+	// session ID of a multiplexed sender, nil otherwise
+	id       []byte
+	name     string
+	size     int64
+	priority int
+	options  uint16
+	writer   io.WriteCloser
+	// alternating bit of the next expected data packet
+	expect   uint16
+	received bool
+	closed   bool
+	lastAck  []byte
+	// repeated if the FILENAME packet is
+	filenameAck []byte
+	lastSeen    time.Time
+}
+
+// how many transfers may wait for Accept before new ones are refused
+const acceptBacklog = 16
+
+// how many packets of an accepted transfer are buffered before the
+// receiver stops acknowledging until the reader catches up
+const incomingBuffer = 64
+
+// what a Receiver needs of its socket; a *net.UDPConn or a StreamConn.
+type datagramConn interface {
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	SetReadDeadline(t time.Time) error
+}
+
+// NewReceiver returns a Receiver handing transfers to create. With a nil
+// create, transfers are read with Accept instead.
+func NewReceiver(conn *net.UDPConn, create WriterFactory) *Receiver {
+	return newReceiver(conn, create)
+}
+
+func newReceiver(conn datagramConn, create WriterFactory) *Receiver {
+	r := &Receiver{
+		conn:     conn,
+		create:   create,
+		Timeout:  10 * time.Second,
+		sessions: make(map[string]*session),
+	}
+	if create == nil {
+		r.incoming = make(chan *Incoming, acceptBacklog)
+	}
+	return r
+}
+
+// Serve receives transfers until reading from the connection fails, e.g.
+// because it was closed. Running transfers are aborted then.
+func (r *Receiver) Serve() error {
+	buf := make([]byte, MaxPacketLength)
+	for {
+		// wake up regularly to expire dead sessions
+		r.conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if err, ok := err.(net.Error); ok && err.Timeout() {
+				r.expire()
+				continue
+			}
+			err = fmt.Errorf("abp: receiving: %w", err)
+			for _, s := range r.sessions {
+				r.abort(s, err)
+			}
+			if r.incoming != nil {
+				r.serveErr = err
+				close(r.incoming)
+			}
+			return err
+		}
+		pkt := buf[:n]
+		var id []byte
+		if r.Multiplexed {
+			if n < MuxIDLength {
+				continue
+			}
+			id = append([]byte(nil), pkt[:MuxIDLength]...)
+			pkt = pkt[MuxIDLength:]
+		}
+		if pkt, _ := applyMiddleware(r.middleware, DIR_IN, pkt); pkt != nil {
+			r.handle(addr, id, pkt)
+		}
+		r.expire()
+	}
+}
+
+func (r *Receiver) expire() {
+	for _, s := range r.sessions {
+		if time.Since(s.lastSeen) > r.Timeout {
+			r.abort(s, &ProtocolError{STATE_RECEIVING, 0,
+				ErrTransferTimeout})
+		}
+	}
+}
+
+// drops a session; its writer is closed with err unless the transfer
+// already completed.
+func (r *Receiver) abort(s *session, err error) {
+	delete(r.sessions, sessionKey(s.addr, s.id))
+	if s.closed || s.writer == nil {
+		return
+	}
+	if w, ok := s.writer.(closeWithError); ok {
+		w.CloseWithError(err)
+	} else {
+		s.writer.Close()
+	}
+}
+
+// sessions are told apart by the sender's address and, if multiplexed,
+// the session ID.
+func sessionKey(addr *net.UDPAddr, id []byte) string {
+	if id == nil {
+		return addr.String()
+	}
+	return fmt.Sprintf("%v#%x", addr, id)
+}
+
+func (r *Receiver) handle(addr *net.UDPAddr, id []byte, buf []byte) {
+	s := r.sessions[sessionKey(addr, id)]
+
+	hdr, payload, err := ParsePacket(buf, s != nil && s.options&HDR_COMPACT != 0)
+	if err != nil {
+		return
+	}
+	if s != nil {
+		s.lastSeen = time.Now()
+	}
+
+	if hdr.Flags == HDR_ECHO {
+		// answered right away, this needs no session
+		echo := &session{addr: addr, id: id}
+		r.send(echo, r.packet(echo, HDR_ECHO, payload))
+		return
+	}
+	if hdr.Flags == HDR_HELLO {
+		hello := &session{addr: addr, id: id}
+		r.send(hello, r.packet(hello, HDR_HELLO, receiverHello))
+		return
+	}
+	if hdr.Flags == HDR_NAME || hdr.Flags == HDR_JOIN ||
+		hdr.Flags == HDR_STREAM {
+		// names longer than a packet, additional paths and parallel
+		// streams are left to the receiver command; without an answer,
+		// the sender gives up (or sends on the paths and streams it has).
+		// JOIN and STREAM are FILENAME packets by their flags, which
+		// would start a transfer named after their payload.
+		return
+	}
+	if hdr.Flags&HDR_FILENAME != 0 {
+		switch {
+		case s == nil || s.closed:
+			r.open(addr, id, hdr, payload)
+		case !s.received:
+			// our ACK got lost
+			r.send(s, s.lastAck)
+		default:
+			// our ACK got lost while the first data packet, sent
+			// right behind the FILENAME packet, arrived
+			r.send(s, s.filenameAck)
+		}
+		return
+	}
+	if s == nil {
+		return
+	}
+	if s.closed {
+		// the sender didn't get our FIN ACK
+		if hdr.Flags&HDR_FIN != 0 {
+			r.send(s, s.lastAck)
+		}
+		return
+	}
+	if hdr.Flags&^(HDR_ALTERNATING|HDR_FIN|HDR_COMPRESSED) != 0 {
+		// FEC, delta or skip packets we never agreed on
+		return
+	}
+	if hdr.Flags&HDR_ALTERNATING != s.expect {
+		// duplicate of the last packet
+		r.send(s, s.lastAck)
+		return
+	}
+
+	if hdr.Flags&HDR_COMPRESSED != 0 && len(payload) > 0 {
+		data, err := DecompressPayload("gzip", payload,
+			maxReceiverPayload)
+		if err != nil {
+			return
+		}
+		payload = data
+	}
+	if _, err := s.writer.Write(payload); err == errReaderBehind {
+		// not acknowledged, the sender tries again later
+		return
+	} else if err != nil {
+		r.fail(s, ERR_WRITE, err)
+		return
+	}
+	s.received = true
+	s.expect ^= HDR_ALTERNATING
+
+	ackFlags := hdr.Flags &^ HDR_COMPRESSED
+	var ackPayload []byte
+	if hdr.Flags&HDR_FIN != 0 {
+		if err := s.writer.Close(); err != nil {
+			s.writer = nil
+			r.fail(s, ERR_WRITE, err)
+			return
+		}
+		s.closed = true
+		if s.options&HDR_STORED_NAME != 0 {
+			ackPayload = []byte(s.name)
+		}
+	}
+	s.lastAck = r.packet(s, ackFlags, ackPayload)
+	r.send(s, s.lastAck)
+}
+
+// starts a new session for a FILENAME packet.
+func (r *Receiver) open(addr *net.UDPAddr, id []byte, hdr Header, payload []byte) {
+	s := &session{
+		addr:     addr,
+		id:       id,
+		expect:   HDR_ALTERNATING,
+		priority: PRIORITY_NORMAL,
+		lastSeen: time.Now(),
+	}
+	options := hdr.Flags & receiverOptions
+	if options&HDR_SIZE != 0 {
+		if len(payload) < 8 {
+			return
+		}
+		s.size = int64(binary.BigEndian.Uint64(payload))
+		payload = payload[8:]
+	}
+	if hdr.Flags&HDR_PRIORITY != 0 {
+		if len(payload) < 1 {
+			return
+		}
+		s.priority = int(payload[0])
+		payload = payload[1:]
+	}
+	s.name = string(payload)
+	if old := r.sessions[sessionKey(addr, id)]; old != nil {
+		r.abort(old, &ProtocolError{STATE_RECEIVING, 0,
+			ErrTransferAborted})
+	}
+	if options&HDR_DRY_RUN != 0 {
+		// nothing to check here; a lost ACK is answered again by
+		// the repeated FILENAME packet.
+		r.send(s, r.packet(s, options, nil))
+		return
+	}
+
+	var writer io.WriteCloser
+	var err error
+	if r.incoming != nil {
+		writer, err = r.queue(s)
+	} else {
+		writer, err = r.create(s.name)
+	}
+	if err != nil {
+		r.fail(s, ERR_REFUSED, err)
+		return
+	}
+	s.writer = writer
+	s.options = options
+	r.sessions[sessionKey(addr, id)] = s
+
+	// the ACK accepting compact headers is sent in full since the sender
+	// doesn't know about our answer yet.
+	s.lastAck = r.packet(s, s.options, nil)
+	s.filenameAck = s.lastAck
+	r.send(s, s.lastAck)
+}
+
+// aborts a session with an HDR_ERROR packet carrying one of the ERR_*
+// codes.
+func (r *Receiver) fail(s *session, code uint16, err error) {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, code)
+	r.send(s, r.packet(s, HDR_ERROR, payload))
+	r.abort(s, &ProtocolError{STATE_RECEIVING, code, err})
+}
+
+// assembles and checksums a packet for a session.
+func (r *Receiver) packet(s *session, flags uint16, payload []byte) []byte {
+	hdr := Header{Length: uint16(len(payload)), Flags: flags}
+	serialize := SerializeHeader
+	if s.options&HDR_COMPACT != 0 && flags&HDR_COMPACT == 0 {
+		serialize = SerializeCompactHeader
+	}
+	pkt := append(serialize(hdr), payload...)
+	SetChecksum(pkt)
+	return pkt
+}
+
+func (r *Receiver) send(s *session, pkt []byte) {
+	if pkt, _ := applyMiddleware(r.middleware, DIR_OUT, pkt); pkt != nil {
+		if s.id != nil {
+			pkt = append(append([]byte(nil), s.id...), pkt...)
+		}
+		r.conn.WriteToUDP(pkt, s.addr)
+	}
+}
+
+// Use adds middleware seeing every packet received and sent from now on.
+// It must not be called while Serve runs.
+func (r *Receiver) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Incoming is a transfer returned by Accept. It is an io.Reader and an
+// io.WriterTo, so io.Copy(dst, incoming) writes the data to dst as it
+// arrives. Read and WriteTo return the transfer's error if it failed.
+type Incoming struct {
+	Name string
+	// as announced by the sender, 0 if unknown
+	Size int64
+	// the priority class announced by the sender
+	Priority int
+
+	chunks    chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	rest      []byte
+	err       error
+}
+
+// the reader of an Incoming didn't keep up; Serve must not block on it.
+var errReaderBehind = errors.New("reader is behind")
+
+// Accept waits for the next transfer of a Receiver without a
+// WriterFactory. Serve has to run meanwhile; once it returned, Accept
+// returns its error.
+func (r *Receiver) Accept() (*Incoming, error) {
+	in, ok := <-r.incoming
+	if !ok {
+		return nil, r.serveErr
+	}
+	return in, nil
+}
+
+// used instead of a WriterFactory for Accept: queues the transfer unless
+// too many are waiting already.
+func (r *Receiver) queue(s *session) (io.WriteCloser, error) {
+	in := &Incoming{
+		Name:     s.name,
+		Size:     s.size,
+		Priority: s.priority,
+		chunks:   make(chan []byte, incomingBuffer),
+		done:     make(chan struct{}),
+	}
+	select {
+	case r.incoming <- in:
+		return &incomingWriter{in}, nil
+	default:
+		return nil, ErrAcceptBacklog
+	}
+}
+
+func (in *Incoming) Read(p []byte) (int, error) {
+	for len(in.rest) == 0 {
+		chunk, ok := <-in.chunks
+		if !ok {
+			if in.err != nil {
+				return 0, in.err
+			}
+			return 0, io.EOF
+		}
+		in.rest = chunk
+	}
+	n := copy(p, in.rest)
+	in.rest = in.rest[n:]
+	return n, nil
+}
+
+// WriteTo writes the rest of the transfer to w, one packet at a time.
+func (in *Incoming) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for {
+		if len(in.rest) > 0 {
+			n, err := w.Write(in.rest)
+			total += int64(n)
+			if err != nil {
+				in.rest = in.rest[n:]
+				return total, fmt.Errorf("abp: writing data: %w", err)
+			}
+			in.rest = nil
+		}
+		chunk, ok := <-in.chunks
+		if !ok {
+			return total, in.err
+		}
+		in.rest = chunk
+	}
+}
+
+// Close stops reading; a transfer that isn't complete yet is aborted.
+func (in *Incoming) Close() error {
+	in.closeOnce.Do(func() { close(in.done) })
+	return nil
+}
+
+// the receiving end of an Incoming, written by Serve.
+type incomingWriter struct {
+	in *Incoming
+}
+
+func (w *incomingWriter) Write(p []byte) (int, error) {
+	// the payload is only valid until the next packet
+	chunk := append([]byte(nil), p...)
+	select {
+	case <-w.in.done:
+		return 0, ErrIncomingClosed
+	default:
+	}
+	select {
+	case w.in.chunks <- chunk:
+		return len(p), nil
+	default:
+		return 0, errReaderBehind
+	}
+}
+
+func (w *incomingWriter) Close() error {
+	close(w.in.chunks)
+	return nil
+}
+
+func (w *incomingWriter) CloseWithError(err error) error {
+	w.in.err = err
+	close(w.in.chunks)
+	return nil
+}
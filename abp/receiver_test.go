@@ -0,0 +1,102 @@
+package abp
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a Receiver on loopback counting the transfers it opens, and a socket to
+// send it raw packets from.
+func startTestReceiver(t *testing.T) (*int32, *net.UDPConn) {
+	t.Helper()
+	recvConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { recvConn.Close() })
+	var opened int32
+	r := NewReceiver(recvConn, func(name string) (io.WriteCloser, error) {
+		atomic.AddInt32(&opened, 1)
+		return &testWriter{}, nil
+	})
+	go r.Serve()
+
+	conn, err := net.DialUDP("udp", nil, recvConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &opened, conn
+}
+
+func sendTestPacket(t *testing.T, conn *net.UDPConn, flags uint16, payload []byte) {
+	t.Helper()
+	pkt := append(SerializeHeader(Header{Length: uint16(len(payload)),
+		Flags: flags}), payload...)
+	SetChecksum(pkt)
+	if _, err := conn.Write(pkt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waits briefly for a reply, returning its flags or false if none came.
+func readTestReply(t *testing.T, conn *net.UDPConn) (uint16, bool) {
+	t.Helper()
+	buf := make([]byte, MaxPacketLength)
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	n, err := conn.Read(buf)
+	if err != nil {
+		return 0, false
+	}
+	hdr, _, err := ParsePacket(buf[:n], false)
+	if err != nil {
+		t.Fatalf("invalid reply: %v", err)
+	}
+	return hdr.Flags, true
+}
+
+// packets of the receiver command's extensions carry HDR_FILENAME among
+// their flags, but mustn't start a transfer of the library Receiver, nor
+// be acknowledged like a repeated FILENAME packet.
+func TestReceiverIgnoresExtensions(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   uint16
+		payload []byte
+	}{
+		{"join", HDR_JOIN, []byte("0123456789abcdef")},
+		// offset 4096 of the transfer
+		{"stream", HDR_STREAM, append([]byte{0, 0, 0, 0, 0, 0, 0x10, 0},
+			"0123456789abcdef"...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opened, conn := startTestReceiver(t)
+			sendTestPacket(t, conn, tt.flags, tt.payload)
+			if flags, ok := readTestReply(t, conn); ok {
+				t.Errorf("%v answered with %v", Flags(tt.flags), Flags(flags))
+			}
+			if n := atomic.LoadInt32(opened); n != 0 {
+				t.Errorf("%v opened %d transfers", Flags(tt.flags), n)
+			}
+
+			// nor is a transfer in progress from the same address
+			// answered
+			sendTestPacket(t, conn, HDR_FILENAME, []byte("test.bin"))
+			if flags, ok := readTestReply(t, conn); !ok || flags != 0 {
+				t.Fatalf("FILENAME packet not acknowledged")
+			}
+			sendTestPacket(t, conn, tt.flags, tt.payload)
+			if flags, ok := readTestReply(t, conn); ok {
+				t.Errorf("%v during a transfer answered with %v",
+					Flags(tt.flags), Flags(flags))
+			}
+			if n := atomic.LoadInt32(opened); n != 1 {
+				t.Errorf("%d transfers opened, want 1", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package abp
+
+import "time"
+
+// RetryPolicy decides how long a Sender waits for an ACK and when it gives
+// up on a packet, e.g. retransmitting quickly on a LAN or patiently over a
+// satellite link.
+type RetryPolicy interface {
+	// how long to wait for the ACK after the attempt'th transmission of
+	// a packet (0 for the first one). srtt is the smoothed round trip
+	// time measured so far, 0 before the first ACK.
+	NextTimeout(attempt int, srtt time.Duration) time.Duration
+	// whether to give up instead of retransmitting a packet for the
+	// attempt'th time
+	GiveUp(attempt int) bool
+}
+
+// FixedRetry waits Timeout for every ACK and gives up after Retries
+// retransmissions (0 = never), like the sender command does.
+type FixedRetry struct {
+	Timeout time.Duration
+	Retries int
+}
+
+func (p FixedRetry) NextTimeout(attempt int, srtt time.Duration) time.Duration {
+	return p.Timeout
+}
+
+func (p FixedRetry) GiveUp(attempt int) bool {
+	return p.Retries > 0 && attempt > p.Retries
+}
+
+// BackoffRetry waits twice the smoothed RTT for an ACK and doubles that
+// with every retransmission, kept between Min and Max. It starts at Max
+// until the first RTT is known and gives up after Retries retransmissions
+// (0 = never).
+type BackoffRetry struct {
+	Min, Max time.Duration
+	Retries  int
+}
+
+func (p BackoffRetry) NextTimeout(attempt int, srtt time.Duration) time.Duration {
+	if srtt == 0 {
+		return p.Max
+	}
+	timeout := 2 * srtt
+	for i := 0; i < attempt && timeout < p.Max; i++ {
+		timeout *= 2
+	}
+	if timeout < p.Min {
+		timeout = p.Min
+	}
+	if timeout > p.Max {
+		timeout = p.Max
+	}
+	return timeout
+}
+
+func (p BackoffRetry) GiveUp(attempt int) bool {
+	return p.Retries > 0 && attempt > p.Retries
+}
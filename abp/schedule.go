@@ -0,0 +1,129 @@
+package abp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a bandwidth limit depending on the time of day, e.g.
+// "limit 1MB/s 08:00-18:00, unlimited otherwise". The first rule whose
+// period contains the time applies.
+type Schedule []ScheduleRule
+
+// ScheduleRule limits the bandwidth to Rate bytes per second (0 =
+// unlimited) from Start to End, minutes after midnight in local time. A
+// period with End before Start lasts past midnight, one with both 0 all
+// day.
+type ScheduleRule struct {
+	Rate       float64
+	Start, End int
+}
+
+// ParseSchedule parses comma separated rules of the form "limit <rate>
+// [HH:MM-HH:MM]" or "unlimited [HH:MM-HH:MM]"; a rule without period, or
+// with "otherwise" instead, applies at any time.
+func ParseSchedule(spec string) (Schedule, error) {
+	var s Schedule
+	for _, part := range strings.Split(spec, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		var rule ScheduleRule
+		switch fields[0] {
+		case "limit":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("missing rate in %q", part)
+			}
+			rate, err := ParseRate(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			rule.Rate = rate
+			fields = fields[2:]
+		case "unlimited":
+			fields = fields[1:]
+		default:
+			return nil, fmt.Errorf("invalid rule %q, want e.g. "+
+				"\"limit 1MB/s 08:00-18:00\"", strings.TrimSpace(part))
+		}
+		if len(fields) > 1 {
+			return nil, fmt.Errorf("invalid rule %q", strings.TrimSpace(part))
+		}
+		if len(fields) == 1 && fields[0] != "otherwise" {
+			period := strings.SplitN(fields[0], "-", 2)
+			var err error
+			if len(period) == 2 {
+				rule.Start, err = parseClock(period[0])
+				if err == nil {
+					rule.End, err = parseClock(period[1])
+				}
+			}
+			if len(period) != 2 || err != nil {
+				return nil, fmt.Errorf("invalid period %q, want e.g. "+
+					"08:00-18:00", fields[0])
+			}
+		}
+		s = append(s, rule)
+	}
+	if len(s) == 0 {
+		return nil, fmt.Errorf("empty schedule")
+	}
+	return s, nil
+}
+
+func parseClock(spec string) (int, error) {
+	t, err := time.Parse("15:04", spec)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// RateAt returns the bytes per second allowed at t, fallback if no rule
+// applies.
+func (s Schedule) RateAt(t time.Time, fallback float64) float64 {
+	minute := t.Hour()*60 + t.Minute()
+	for _, rule := range s {
+		if rule.contains(minute) {
+			return rule.Rate
+		}
+	}
+	return fallback
+}
+
+func (r ScheduleRule) contains(minute int) bool {
+	if r.Start == r.End {
+		return r.Start == 0
+	}
+	if r.Start < r.End {
+		return minute >= r.Start && minute < r.End
+	}
+	return minute >= r.Start || minute < r.End
+}
+
+// ParseRate parses a rate like 2MB/s, 500KB/s or 1000 (bytes per second).
+// Units are powers of 1024.
+func ParseRate(spec string) (float64, error) {
+	s := strings.ToUpper(strings.TrimSuffix(spec, "/s"))
+	s = strings.TrimSuffix(s, "B")
+	unit := 1.0
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1024
+	case strings.HasSuffix(s, "M"):
+		unit = 1024 * 1024
+	case strings.HasSuffix(s, "G"):
+		unit = 1024 * 1024 * 1024
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid rate %q, want e.g. 2MB/s", spec)
+	}
+	return value * unit, nil
+}
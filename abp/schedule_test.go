@@ -0,0 +1,122 @@
+package abp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Schedule
+	}{
+		{"limit 1MB/s 08:00-18:00, unlimited otherwise",
+			Schedule{{1 << 20, 8 * 60, 18 * 60}, {0, 0, 0}}},
+		{"limit 500KB/s", Schedule{{500 << 10, 0, 0}}},
+		{"limit 100KB/s 22:00-06:30, limit 2MB/s",
+			Schedule{{100 << 10, 22 * 60, 6*60 + 30}, {2 << 20, 0, 0}}},
+		{" unlimited 12:00-13:00 ,, limit 1000 ",
+			Schedule{{0, 12 * 60, 13 * 60}, {1000, 0, 0}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: parsed %v, want %v", tt.spec, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: parsed %v, want %v", tt.spec, got, tt.want)
+				break
+			}
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		" , ",
+		"limit",
+		"limit fast",
+		"limit 0",
+		"throttle 1MB/s",
+		"limit 1MB/s 08:00",
+		"limit 1MB/s 8-18",
+		"limit 1MB/s 08:00-25:00",
+		"limit 1MB/s 08:00-18:00 weekdays",
+		"unlimited always",
+	} {
+		if s, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%q: parsed %v", spec, s)
+		}
+	}
+}
+
+func TestScheduleRateAt(t *testing.T) {
+	s, err := ParseSchedule("limit 1MB/s 08:00-18:00, " +
+		"limit 100KB/s 22:00-06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const fallback = 5
+	for _, tt := range []struct {
+		clock string
+		want  float64
+	}{
+		{"08:00", 1 << 20},
+		{"12:34", 1 << 20},
+		{"17:59", 1 << 20},
+		// periods end before their end
+		{"18:00", fallback},
+		{"21:59", fallback},
+		{"22:00", 100 << 10},
+		// past midnight
+		{"00:00", 100 << 10},
+		{"05:59", 100 << 10},
+		{"06:00", fallback},
+		{"07:59", fallback},
+	} {
+		at, _ := time.ParseInLocation("15:04", tt.clock, time.Local)
+		if got := s.RateAt(at, fallback); got != tt.want {
+			t.Errorf("%s: rate %v, want %v", tt.clock, got, tt.want)
+		}
+	}
+
+	// the first rule applies, and one without period always does
+	s, _ = ParseSchedule("unlimited 12:00-13:00, limit 1KB/s")
+	noon, _ := time.ParseInLocation("15:04", "12:30", time.Local)
+	if got := s.RateAt(noon, fallback); got != 0 {
+		t.Errorf("rate at noon %v, want unlimited", got)
+	}
+	if got := s.RateAt(noon.Add(time.Hour), fallback); got != 1024 {
+		t.Errorf("rate after noon %v, want 1024", got)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		want float64
+	}{
+		{"1000", 1000},
+		{"1000/s", 1000},
+		{"500KB/s", 500 << 10},
+		{"500kb/s", 500 << 10},
+		{"2M", 2 << 20},
+		{"1.5MB/s", 1.5 * (1 << 20)},
+		{"1GB/s", 1 << 30},
+	} {
+		if got, err := ParseRate(tt.spec); err != nil || got != tt.want {
+			t.Errorf("%q: %v (%v), want %v", tt.spec, got, err, tt.want)
+		}
+	}
+	for _, spec := range []string{"", "MB/s", "-1MB/s", "0", "fast",
+		"1TB/s"} {
+		if got, err := ParseRate(spec); err == nil {
+			t.Errorf("%q: parsed as %v", spec, got)
+		}
+	}
+}
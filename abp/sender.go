@@ -0,0 +1,362 @@
+package abp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Sender implements the sending side of the protocol for use as a library.
+// It sends one transfer under one name over a connected UDP socket: the
+// data written to it is collected into packets, Close sends the rest along
+// with the FIN. Sender is an io.ReaderFrom, so io.Copy(sender, src) reads
+// straight into the packet buffer.
+//
+// It only speaks the plain protocol; compact headers, compression, FEC,
+// delta transfers and sparse files need the sender command.
+type Sender struct {
+	conn packetConn
+	name string
+	// announced to the receiver so it can check its disk space, if known
+	Size int64
+	// the priority class announced to the receiver, PRIORITY_NORMAL by
+	// default; receivers that don't announce EXT_PRIORITY reject others
+	Priority int
+	// when to retransmit a packet and when to give up
+	Retry RetryPolicy
+	// bytes per packet incl. header, at most MaxPacketLength
+	PacketLength int
+	// the name the receiver stored the file under, once Close returned
+	StoredName string
+
+	opened bool
+	closed bool
+	// alternating bit of the next data packet
+	next uint16
+	// payload of the next data packet
+	buf []byte
+	// receives the ACKs, reused for every packet
+	in  []byte
+	err error
+	// the last acknowledged packet, sent again to keep a paused transfer
+	// alive
+	last []byte
+	// closed by Transfer.Cancel
+	cancel <-chan struct{}
+	// the Transfer receiving our events, if any
+	transfer   *Transfer
+	rtt        *rttEstimator
+	middleware []Middleware
+}
+
+// what a Sender needs of its socket; a connected *net.UDPConn or a session
+// of a Mux.
+type packetConn interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	SetReadDeadline(t time.Time) error
+	RemoteAddr() net.Addr
+}
+
+// smoothed round trip time like TCP calculates it (RFC 6298), 0 until the
+// first ACK. the senders of a Mux share one.
+type rttEstimator struct {
+	mu   sync.Mutex
+	srtt time.Duration
+}
+
+func (e *rttEstimator) get() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.srtt
+}
+
+func (e *rttEstimator) update(rtt time.Duration) {
+	e.mu.Lock()
+	if e.srtt == 0 {
+		e.srtt = rtt
+	} else {
+		e.srtt = (7*e.srtt + rtt) / 8
+	}
+	e.mu.Unlock()
+}
+
+func NewSender(conn *net.UDPConn, name string) *Sender {
+	return newSender(conn, name, &rttEstimator{})
+}
+
+func newSender(conn packetConn, name string, rtt *rttEstimator) *Sender {
+	return &Sender{
+		conn:         conn,
+		name:         name,
+		Retry:        FixedRetry{500 * time.Millisecond, 20},
+		PacketLength: DefaultPacketLength,
+		Priority:     PRIORITY_NORMAL,
+		next:         HDR_ALTERNATING,
+		rtt:          rtt,
+	}
+}
+
+// Write buffers p and sends every packet it fills.
+func (s *Sender) Write(p []byte) (int, error) {
+	if err := s.start(); err != nil {
+		return 0, err
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(s.buf[len(s.buf):cap(s.buf)], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+		if err := s.flushFull(); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// ReadFrom sends the data read from r until EOF. Like Write, it leaves
+// the last partial packet for Close.
+func (s *Sender) ReadFrom(r io.Reader) (int64, error) {
+	if err := s.start(); err != nil {
+		return 0, err
+	}
+	var total int64
+	for {
+		n, err := io.ReadFull(r, s.buf[len(s.buf):cap(s.buf)])
+		s.buf = s.buf[:len(s.buf)+n]
+		total += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, fmt.Errorf("abp: reading data: %w", err)
+		}
+		if err := s.flushFull(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// Close sends the buffered data with the FIN and waits for its ACK.
+func (s *Sender) Close() error {
+	defer s.release()
+	if s.closed {
+		return s.err
+	}
+	if err := s.start(); err != nil {
+		return err
+	}
+	s.closed = true
+	payload, err := s.exchange(s.next|HDR_FIN, s.buf)
+	if err != nil {
+		s.err = err
+		return err
+	}
+	s.StoredName = s.name
+	if len(payload) > 0 {
+		s.StoredName = string(payload)
+	}
+	return nil
+}
+
+// sends the FILENAME packet on the first write.
+func (s *Sender) start() error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.closed {
+		return ErrSenderClosed
+	}
+	if s.opened {
+		return nil
+	}
+	s.opened = true
+	flags := uint16(HDR_FILENAME | HDR_STORED_NAME)
+	var payload []byte
+	if s.Size > 0 {
+		flags |= HDR_SIZE
+		payload = make([]byte, 8)
+		binary.BigEndian.PutUint64(payload, uint64(s.Size))
+	}
+	if s.Priority != PRIORITY_NORMAL {
+		flags |= HDR_PRIORITY
+		payload = append(payload, byte(s.Priority))
+	}
+	payload = append(payload, s.name...)
+	if _, err := s.exchange(flags, payload); err != nil {
+		s.err = err
+		return err
+	}
+
+	length := s.PacketLength
+	if length <= HeaderLength || length > MaxPacketLength {
+		length = DefaultPacketLength
+	}
+	s.buf = make([]byte, 0, length-HeaderLength)
+	return nil
+}
+
+// sends the buffered data if it fills a packet.
+func (s *Sender) flushFull() error {
+	if len(s.buf) < cap(s.buf) {
+		return nil
+	}
+	if _, err := s.exchange(s.next, s.buf); err != nil {
+		s.err = err
+		return err
+	}
+	s.next ^= HDR_ALTERNATING
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// sends a packet until the matching ACK arrives and returns the ACK's
+// payload. the FILENAME ACK echoes the options the receiver accepted, data
+// ACKs echo the flags of the packet.
+func (s *Sender) exchange(flags uint16, payload []byte) ([]byte, error) {
+	pkt := s.packet(flags, payload)
+	state := STATE_WAIT_ACK
+	if flags&HDR_FILENAME != 0 {
+		state = STATE_WAIT_FILENAME_ACK
+	} else if flags&HDR_FIN != 0 {
+		state = STATE_WAIT_FIN_ACK
+	}
+	s.transfer.setState(state)
+	in := s.inBuffer()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && s.Retry.GiveUp(attempt) {
+			return nil, &ProtocolError{state, 0, ErrTransferTimeout}
+		}
+		select {
+		case <-s.cancel:
+			return nil, ErrTransferCanceled
+		default:
+		}
+		if attempt == 0 {
+			s.transfer.emit(PacketSent{flags, len(pkt)})
+		} else {
+			s.transfer.emit(Retransmit{flags, attempt})
+		}
+		sentAt := time.Now()
+		if err := s.write(pkt); err != nil {
+			return nil, err
+		}
+		deadline := sentAt.Add(s.Retry.NextTimeout(attempt, s.rtt.get()))
+		for {
+			s.conn.SetReadDeadline(deadline)
+			n, err := s.conn.Read(in)
+			if err != nil {
+				if err, ok := err.(net.Error); ok && err.Timeout() {
+					break
+				}
+				// e.g. nobody listening (yet); like a lost ACK
+				time.Sleep(time.Until(deadline))
+				break
+			}
+			ack, _ := applyMiddleware(s.middleware, DIR_IN, in[:n])
+			if ack == nil {
+				continue
+			}
+			hdr, reply, err := ParsePacket(ack, false)
+			if err != nil {
+				continue
+			}
+			switch {
+			case hdr.Flags == HDR_ERROR && len(reply) >= 2:
+				code := binary.BigEndian.Uint16(reply)
+				return nil, &PeerAbortError{code, ErrorMessage(code)}
+			case hdr.Flags == HDR_BUSY:
+				retryAfter := 5 * time.Second
+				if len(reply) >= 2 {
+					retryAfter = time.Duration(
+						binary.BigEndian.Uint16(reply)) * time.Second
+				}
+				time.Sleep(retryAfter)
+				attempt = -1
+			case flags&HDR_FILENAME != 0 && hdr.Flags&^flags == 0,
+				hdr.Flags == flags:
+				s.last = pkt
+				rtt := time.Since(sentAt)
+				if attempt == 0 {
+					// ACKs of retransmitted packets are ambiguous
+					s.rtt.update(rtt)
+				}
+				s.transfer.emit(AckReceived{hdr.Flags, rtt})
+				return append([]byte(nil), reply...), nil
+			default:
+				// a stale ACK
+				continue
+			}
+			break
+		}
+	}
+}
+
+// sends the last acknowledged packet again, which the receiver answers as
+// a duplicate, and drops the answer. this keeps the receiver from expiring
+// the transfer while it is paused.
+func (s *Sender) keepalive() {
+	if s.last == nil {
+		return
+	}
+	s.write(s.last)
+	s.transfer.emit(PacketSent{binary.BigEndian.Uint16(s.last[6:]),
+		len(s.last)})
+	in := s.inBuffer()
+	s.conn.SetReadDeadline(time.Now().Add(s.Retry.NextTimeout(0, s.rtt.get())))
+	for {
+		if _, err := s.conn.Read(in); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Sender) inBuffer() []byte {
+	if s.in == nil {
+		s.in = make([]byte, MaxPacketLength)
+	}
+	return s.in
+}
+
+// a Mux session is given up once its Sender is done.
+type releaser interface {
+	release()
+}
+
+func (s *Sender) release() {
+	if r, ok := s.conn.(releaser); ok {
+		r.release()
+	}
+}
+
+// Use adds middleware seeing every packet sent and received from now on.
+func (s *Sender) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+}
+
+// sends a packet through the middleware.
+func (s *Sender) write(pkt []byte) error {
+	pkt, err := applyMiddleware(s.middleware, DIR_OUT, pkt)
+	if err != nil {
+		return fmt.Errorf("abp: middleware: %w", err)
+	}
+	if pkt == nil {
+		return nil
+	}
+	if _, err := s.conn.Write(pkt); err != nil {
+		return fmt.Errorf("abp: sending to %v: %w", s.conn.RemoteAddr(), err)
+	}
+	return nil
+}
+
+// assembles and checksums a packet.
+func (s *Sender) packet(flags uint16, payload []byte) []byte {
+	hdr := Header{Length: uint16(len(payload)), Flags: flags}
+	pkt := append(SerializeHeader(hdr), payload...)
+	SetChecksum(pkt)
+	return pkt
+}
@@ -0,0 +1,149 @@
+package abp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// how many packets a StreamConn reads ahead
+const streamInBuffer = 64
+
+// StreamConn carries ABP packets over a byte stream, e.g. an SSH channel or
+// the stdin and stdout of a process on a jump host, for environments that
+// don't pass UDP. Every packet is framed with its length as a 16 bit big
+// endian number.
+//
+// The protocol runs unchanged on top: the stream doesn't lose packets, so
+// there are no retransmissions unless the other side is slow to answer.
+// Both ends of the stream need a StreamConn, see NewStreamSender and
+// NewStreamReceiver.
+type StreamConn struct {
+	rw io.ReadWriter
+	// serializes the frames of concurrent writers
+	writeMu  sync.Mutex
+	readOnce sync.Once
+	in       chan []byte
+	// why in was closed
+	readErr  error
+	deadline time.Time
+}
+
+// the address of the other end of a StreamConn, which has no other
+type streamAddr struct{}
+
+func (streamAddr) Network() string { return "stream" }
+func (streamAddr) String() string  { return "stream" }
+
+// the *net.UDPAddr a Receiver sees every packet of a StreamConn come from
+var streamUDPAddr = &net.UDPAddr{IP: net.IPv4zero}
+
+func NewStreamConn(rw io.ReadWriter) *StreamConn {
+	return &StreamConn{rw: rw, in: make(chan []byte, streamInBuffer)}
+}
+
+// NewStreamSender returns a Sender transferring name over rw, which has
+// to lead to a Receiver from NewStreamReceiver.
+func NewStreamSender(rw io.ReadWriter, name string) *Sender {
+	return newSender(NewStreamConn(rw), name, &rttEstimator{})
+}
+
+// NewStreamReceiver returns a Receiver for the transfers arriving over rw
+// from a Sender of NewStreamSender.
+func NewStreamReceiver(rw io.ReadWriter, create WriterFactory) *Receiver {
+	return newReceiver(NewStreamConn(rw), create)
+}
+
+// reads the frames in the background, so Read can time out.
+func (c *StreamConn) readLoop() {
+	var length [2]byte
+	for {
+		if _, err := io.ReadFull(c.rw, length[:]); err != nil {
+			c.readErr = err
+			close(c.in)
+			return
+		}
+		pkt := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(c.rw, pkt); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			c.readErr = err
+			close(c.in)
+			return
+		}
+		c.in <- pkt
+	}
+}
+
+// Read reads the next packet; like with UDP, the rest of a packet larger
+// than b is lost.
+func (c *StreamConn) Read(b []byte) (int, error) {
+	c.readOnce.Do(func() { go c.readLoop() })
+	var timeout <-chan time.Time
+	if !c.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(c.deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case pkt, ok := <-c.in:
+		if !ok {
+			return 0, c.readErr
+		}
+		return copy(b, pkt), nil
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+// Write writes b as one packet.
+func (c *StreamConn) Write(b []byte) (int, error) {
+	if len(b) > 0xffff {
+		return 0, fmt.Errorf("abp: %d byte packet too large for a stream",
+			len(b))
+	}
+	frame := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(frame, uint16(len(b)))
+	copy(frame[2:], b)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.rw.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFromUDP is Read for a Receiver; all packets come from the same
+// placeholder address.
+func (c *StreamConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	n, err := c.Read(b)
+	return n, streamUDPAddr, err
+}
+
+// WriteToUDP is Write for a Receiver; addr is ignored.
+func (c *StreamConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	return c.Write(b)
+}
+
+func (c *StreamConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *StreamConn) RemoteAddr() net.Addr {
+	return streamAddr{}
+}
+
+// Close closes the stream if it can be closed.
+func (c *StreamConn) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return errors.New("abp: stream can't be closed")
+}
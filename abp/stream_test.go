@@ -0,0 +1,107 @@
+package abp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// a transfer over a byte stream instead of UDP.
+func TestStreamTransfer(t *testing.T) {
+	sendEnd, recvEnd := net.Pipe()
+	defer sendEnd.Close()
+	defer recvEnd.Close()
+	out := &testWriter{}
+	r := NewStreamReceiver(recvEnd, func(name string) (io.WriteCloser, error) {
+		if name != "piped.bin" {
+			t.Errorf("transfer named %q", name)
+		}
+		return out, nil
+	})
+	go r.Serve()
+
+	data := testData(100000)
+	s := NewStreamSender(sendEnd, "piped.bin")
+	s.Size = int64(len(data))
+	_, err := s.ReadFrom(bytes.NewReader(data))
+	if err == nil {
+		err = s.Close()
+	}
+	checkDelivered(t, data, out, err)
+}
+
+func TestStreamConnFraming(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewStreamConn(&buf)
+	for _, pkt := range [][]byte{[]byte("first"), {}, make([]byte, 0xffff)} {
+		if n, err := c.Write(pkt); err != nil || n != len(pkt) {
+			t.Fatalf("writing %d bytes: %d (%v)", len(pkt), n, err)
+		}
+	}
+	if _, err := c.Write(make([]byte, 0x10000)); err == nil {
+		t.Errorf("wrote a packet too large for its length prefix")
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("\x00\x05first\x00\x00\xff\xff")) {
+		t.Errorf("frames start with %q", buf.Bytes()[:12])
+	}
+	// and a frame cut short
+	buf.Write([]byte{0, 10, 1, 2})
+
+	b := make([]byte, 0x10000)
+	for _, want := range []int{5, 0, 0xffff} {
+		if n, err := c.Read(b); err != nil || n != want {
+			t.Fatalf("read %d bytes (%v), want %d", n, err, want)
+		}
+	}
+	if _, err := c.Read(b); err != io.ErrUnexpectedEOF {
+		t.Errorf("read of a truncated frame: %v", err)
+	}
+	// the error sticks
+	if _, err := c.Read(b); err != io.ErrUnexpectedEOF {
+		t.Errorf("read after the truncated frame: %v", err)
+	}
+}
+
+func TestStreamConnEOF(t *testing.T) {
+	a, b := net.Pipe()
+	c := NewStreamConn(b)
+	go func() {
+		NewStreamConn(a).Write([]byte("last"))
+		a.Close()
+	}()
+	buf := make([]byte, 16)
+	if n, err := c.Read(buf); err != nil || string(buf[:n]) != "last" {
+		t.Fatalf("read %q (%v)", buf[:n], err)
+	}
+	if _, err := c.Read(buf); err != io.EOF {
+		t.Errorf("read after the end of the stream: %v", err)
+	}
+}
+
+func TestStreamConnDeadline(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	c := NewStreamConn(b)
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	if _, err := c.Read(make([]byte, 16)); !errors.Is(err,
+		os.ErrDeadlineExceeded) {
+		t.Fatalf("read without data: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("deadline passed after %v", time.Since(start))
+	}
+
+	// a packet arriving later is still read
+	go NewStreamConn(a).Write([]byte("late"))
+	c.SetReadDeadline(time.Time{})
+	buf := make([]byte, 16)
+	if n, err := c.Read(buf); err != nil || string(buf[:n]) != "late" {
+		t.Errorf("read %q (%v)", buf[:n], err)
+	}
+}
@@ -0,0 +1,246 @@
+package abp
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Transfer is a transfer running in the background, started by Send or
+// Receive. It can be paused, resumed and canceled from other goroutines.
+type Transfer struct {
+	mu     sync.Mutex
+	paused bool
+	// closed on Resume and Cancel to wake up a paused transfer
+	wake     chan struct{}
+	canceled chan struct{}
+	done     chan struct{}
+	// called regularly while paused
+	keepalive func()
+	err       error
+	state     State
+	events    chan Event
+}
+
+// a paused sender repeats its last packet this often so the receiver
+// doesn't give up on it
+const keepaliveInterval = time.Second
+
+// events not read in time are dropped once this many are queued
+const eventBuffer = 256
+
+func newTransfer() *Transfer {
+	return &Transfer{
+		wake:     make(chan struct{}),
+		canceled: make(chan struct{}),
+		done:     make(chan struct{}),
+		events:   make(chan Event, eventBuffer),
+	}
+}
+
+// Send sends r under name in the background, with the defaults of
+// NewSender.
+func Send(conn *net.UDPConn, name string, r io.Reader) *Transfer {
+	return NewSender(conn, name).Send(r)
+}
+
+// Send sends r in the background. The Sender must not be used otherwise.
+func (s *Sender) Send(r io.Reader) *Transfer {
+	t := newTransfer()
+	s.cancel = t.canceled
+	s.transfer = t
+	t.keepalive = s.keepalive
+	go func() {
+		_, err := s.ReadFrom(&pausedReader{t, r})
+		if err == nil {
+			err = t.wait()
+		}
+		if err == nil {
+			err = s.Close()
+		} else {
+			s.release()
+		}
+		t.finish(err)
+	}()
+	return t
+}
+
+// Receive copies an accepted transfer to w in the background. While it is
+// paused, the receiver stops acknowledging the transfer once its buffer is
+// full, so a long pause runs into the sender's retry limit.
+func Receive(in *Incoming, w io.Writer) *Transfer {
+	t := newTransfer()
+	t.setState(STATE_RECEIVING)
+	go func() {
+		_, err := in.WriteTo(&pausedWriter{t, w})
+		in.Close()
+		t.finish(err)
+	}()
+	return t
+}
+
+// Pause stops the transfer before the next packet.
+func (t *Transfer) Pause() {
+	t.mu.Lock()
+	t.paused = true
+	t.mu.Unlock()
+}
+
+func (t *Transfer) Resume() {
+	t.mu.Lock()
+	if t.paused {
+		t.paused = false
+		close(t.wake)
+		t.wake = make(chan struct{})
+	}
+	t.mu.Unlock()
+}
+
+// Cancel stops the transfer for good; Err returns ErrTransferCanceled
+// then.
+func (t *Transfer) Cancel() {
+	t.mu.Lock()
+	select {
+	case <-t.canceled:
+	default:
+		close(t.canceled)
+	}
+	t.mu.Unlock()
+}
+
+// Done is closed once the transfer completed, failed or was canceled.
+func (t *Transfer) Done() <-chan struct{} {
+	return t.done
+}
+
+// Events delivers what happens during the transfer, ending with Completed
+// before the channel is closed. The transfer never waits for the reader:
+// events are dropped if too many are queued, except for Completed.
+func (t *Transfer) Events() <-chan Event {
+	return t.events
+}
+
+// Err returns why the transfer failed, nil if it completed or is still
+// running.
+func (t *Transfer) Err() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+func (t *Transfer) finish(err error) {
+	select {
+	case <-t.canceled:
+		err = ErrTransferCanceled
+	default:
+	}
+	t.err = err
+	t.setState(STATE_DONE)
+	// make room for the last event if needed
+	for {
+		select {
+		case t.events <- Completed{err}:
+			close(t.events)
+			close(t.done)
+			return
+		default:
+		}
+		select {
+		case <-t.events:
+		default:
+		}
+	}
+}
+
+// nil-safe, so a Sender can report to a Transfer it may not have.
+func (t *Transfer) emit(e Event) {
+	if t == nil {
+		return
+	}
+	select {
+	case t.events <- e:
+	default:
+	}
+}
+
+func (t *Transfer) setState(state State) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	from := t.state
+	t.state = state
+	t.mu.Unlock()
+	if from != state {
+		t.emit(StateChanged{from, state})
+	}
+}
+
+// blocks while the transfer is paused. returns ErrTransferCanceled once it
+// was canceled.
+func (t *Transfer) wait() error {
+	// the state to return to once resumed
+	wasPaused := false
+	var resumed State
+	defer func() {
+		if wasPaused {
+			t.setState(resumed)
+		}
+	}()
+	for {
+		t.mu.Lock()
+		paused, wake := t.paused, t.wake
+		t.mu.Unlock()
+		select {
+		case <-t.canceled:
+			return ErrTransferCanceled
+		default:
+		}
+		if !paused {
+			return nil
+		}
+		if !wasPaused {
+			wasPaused = true
+			t.mu.Lock()
+			resumed = t.state
+			t.mu.Unlock()
+			t.setState(STATE_PAUSED)
+		}
+		select {
+		case <-wake:
+		case <-t.canceled:
+		case <-time.After(keepaliveInterval):
+			if t.keepalive != nil {
+				t.keepalive()
+			}
+		}
+	}
+}
+
+type pausedReader struct {
+	t *Transfer
+	r io.Reader
+}
+
+func (p *pausedReader) Read(b []byte) (int, error) {
+	if err := p.t.wait(); err != nil {
+		return 0, err
+	}
+	return p.r.Read(b)
+}
+
+type pausedWriter struct {
+	t *Transfer
+	w io.Writer
+}
+
+func (p *pausedWriter) Write(b []byte) (int, error) {
+	if err := p.t.wait(); err != nil {
+		return 0, err
+	}
+	return p.w.Write(b)
+}
@@ -0,0 +1,533 @@
+// The service of abp-grpcd, which runs ABP transfers on its host for
+// orchestration systems. Regenerate the Go code after changes with
+// protoc-gen-go and protoc-gen-go-grpc (paths=source_relative).
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: transfers.proto
+
+package abppb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type StartTransferRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Direction:
+	//
+	//	*StartTransferRequest_Send
+	//	*StartTransferRequest_Receive
+	Direction     isStartTransferRequest_Direction `protobuf_oneof:"direction"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StartTransferRequest) Reset() {
+	*x = StartTransferRequest{}
+	mi := &file_transfers_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StartTransferRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartTransferRequest) ProtoMessage() {}
+
+func (x *StartTransferRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_transfers_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartTransferRequest.ProtoReflect.Descriptor instead.
+func (*StartTransferRequest) Descriptor() ([]byte, []int) {
+	return file_transfers_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *StartTransferRequest) GetDirection() isStartTransferRequest_Direction {
+	if x != nil {
+		return x.Direction
+	}
+	return nil
+}
+
+func (x *StartTransferRequest) GetSend() *SendRequest {
+	if x != nil {
+		if x, ok := x.Direction.(*StartTransferRequest_Send); ok {
+			return x.Send
+		}
+	}
+	return nil
+}
+
+func (x *StartTransferRequest) GetReceive() *ReceiveRequest {
+	if x != nil {
+		if x, ok := x.Direction.(*StartTransferRequest_Receive); ok {
+			return x.Receive
+		}
+	}
+	return nil
+}
+
+type isStartTransferRequest_Direction interface {
+	isStartTransferRequest_Direction()
+}
+
+type StartTransferRequest_Send struct {
+	Send *SendRequest `protobuf:"bytes,1,opt,name=send,proto3,oneof"`
+}
+
+type StartTransferRequest_Receive struct {
+	Receive *ReceiveRequest `protobuf:"bytes,2,opt,name=receive,proto3,oneof"`
+}
+
+func (*StartTransferRequest_Send) isStartTransferRequest_Direction() {}
+
+func (*StartTransferRequest_Receive) isStartTransferRequest_Direction() {}
+
+// sends a file on the daemon's host to a receiver
+type SendRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// host:port of the receiver
+	Receiver string `protobuf:"bytes,1,opt,name=receiver,proto3" json:"receiver,omitempty"`
+	Path     string `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	// the name the receiver stores the file under; the base name of path
+	// if empty
+	Name          string `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendRequest) Reset() {
+	*x = SendRequest{}
+	mi := &file_transfers_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendRequest) ProtoMessage() {}
+
+func (x *SendRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_transfers_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendRequest.ProtoReflect.Descriptor instead.
+func (*SendRequest) Descriptor() ([]byte, []int) {
+	return file_transfers_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SendRequest) GetReceiver() string {
+	if x != nil {
+		return x.Receiver
+	}
+	return ""
+}
+
+func (x *SendRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *SendRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+// waits for one transfer on a UDP address and stores it in a directory
+type ReceiveRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// host:port to listen on
+	Listen        string `protobuf:"bytes,1,opt,name=listen,proto3" json:"listen,omitempty"`
+	Dir           string `protobuf:"bytes,2,opt,name=dir,proto3" json:"dir,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReceiveRequest) Reset() {
+	*x = ReceiveRequest{}
+	mi := &file_transfers_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReceiveRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReceiveRequest) ProtoMessage() {}
+
+func (x *ReceiveRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_transfers_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReceiveRequest.ProtoReflect.Descriptor instead.
+func (*ReceiveRequest) Descriptor() ([]byte, []int) {
+	return file_transfers_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ReceiveRequest) GetListen() string {
+	if x != nil {
+		return x.Listen
+	}
+	return ""
+}
+
+func (x *ReceiveRequest) GetDir() string {
+	if x != nil {
+		return x.Dir
+	}
+	return ""
+}
+
+type TransferID struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransferID) Reset() {
+	*x = TransferID{}
+	mi := &file_transfers_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransferID) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransferID) ProtoMessage() {}
+
+func (x *TransferID) ProtoReflect() protoreflect.Message {
+	mi := &file_transfers_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransferID.ProtoReflect.Descriptor instead.
+func (*TransferID) Descriptor() ([]byte, []int) {
+	return file_transfers_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *TransferID) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type WatchProgressRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	IntervalMs    uint32                 `protobuf:"varint,2,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchProgressRequest) Reset() {
+	*x = WatchProgressRequest{}
+	mi := &file_transfers_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchProgressRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchProgressRequest) ProtoMessage() {}
+
+func (x *WatchProgressRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_transfers_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchProgressRequest.ProtoReflect.Descriptor instead.
+func (*WatchProgressRequest) Descriptor() ([]byte, []int) {
+	return file_transfers_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *WatchProgressRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *WatchProgressRequest) GetIntervalMs() uint32 {
+	if x != nil {
+		return x.IntervalMs
+	}
+	return 0
+}
+
+type TransferStatus struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// the file name; empty while a receiving transfer waits for its sender
+	Name string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	// WAITING for a sender, or one of the states of abp.Transfer, e.g.
+	// WAIT_ACK, RECEIVING, DONE
+	State string `protobuf:"bytes,3,opt,name=state,proto3" json:"state,omitempty"`
+	// payload bytes read from the file or written to it so far
+	Bytes int64 `protobuf:"varint,4,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	// the size of the file being sent, 0 when receiving
+	Size        int64 `protobuf:"varint,5,opt,name=size,proto3" json:"size,omitempty"`
+	Retransmits int64 `protobuf:"varint,6,opt,name=retransmits,proto3" json:"retransmits,omitempty"`
+	Done        bool  `protobuf:"varint,7,opt,name=done,proto3" json:"done,omitempty"`
+	// why the transfer failed, empty if it didn't (yet)
+	Error         string `protobuf:"bytes,8,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransferStatus) Reset() {
+	*x = TransferStatus{}
+	mi := &file_transfers_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransferStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransferStatus) ProtoMessage() {}
+
+func (x *TransferStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_transfers_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransferStatus.ProtoReflect.Descriptor instead.
+func (*TransferStatus) Descriptor() ([]byte, []int) {
+	return file_transfers_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *TransferStatus) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *TransferStatus) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *TransferStatus) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *TransferStatus) GetBytes() int64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+func (x *TransferStatus) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *TransferStatus) GetRetransmits() int64 {
+	if x != nil {
+		return x.Retransmits
+	}
+	return 0
+}
+
+func (x *TransferStatus) GetDone() bool {
+	if x != nil {
+		return x.Done
+	}
+	return false
+}
+
+func (x *TransferStatus) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_transfers_proto protoreflect.FileDescriptor
+
+const file_transfers_proto_rawDesc = "" +
+	"\n" +
+	"\x0ftransfers.proto\x12\x03abp\"|\n" +
+	"\x14StartTransferRequest\x12&\n" +
+	"\x04send\x18\x01 \x01(\v2\x10.abp.SendRequestH\x00R\x04send\x12/\n" +
+	"\areceive\x18\x02 \x01(\v2\x13.abp.ReceiveRequestH\x00R\areceiveB\v\n" +
+	"\tdirection\"Q\n" +
+	"\vSendRequest\x12\x1a\n" +
+	"\breceiver\x18\x01 \x01(\tR\breceiver\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\x12\x12\n" +
+	"\x04name\x18\x03 \x01(\tR\x04name\":\n" +
+	"\x0eReceiveRequest\x12\x16\n" +
+	"\x06listen\x18\x01 \x01(\tR\x06listen\x12\x10\n" +
+	"\x03dir\x18\x02 \x01(\tR\x03dir\"\x1c\n" +
+	"\n" +
+	"TransferID\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"G\n" +
+	"\x14WatchProgressRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1f\n" +
+	"\vinterval_ms\x18\x02 \x01(\rR\n" +
+	"intervalMs\"\xc0\x01\n" +
+	"\x0eTransferStatus\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n" +
+	"\x05state\x18\x03 \x01(\tR\x05state\x12\x14\n" +
+	"\x05bytes\x18\x04 \x01(\x03R\x05bytes\x12\x12\n" +
+	"\x04size\x18\x05 \x01(\x03R\x04size\x12 \n" +
+	"\vretransmits\x18\x06 \x01(\x03R\vretransmits\x12\x12\n" +
+	"\x04done\x18\a \x01(\bR\x04done\x12\x14\n" +
+	"\x05error\x18\b \x01(\tR\x05error2\xf2\x01\n" +
+	"\tTransfers\x12?\n" +
+	"\rStartTransfer\x12\x19.abp.StartTransferRequest\x1a\x13.abp.TransferStatus\x121\n" +
+	"\tGetStatus\x12\x0f.abp.TransferID\x1a\x13.abp.TransferStatus\x12.\n" +
+	"\x06Cancel\x12\x0f.abp.TransferID\x1a\x13.abp.TransferStatus\x12A\n" +
+	"\rWatchProgress\x12\x19.abp.WatchProgressRequest\x1a\x13.abp.TransferStatus0\x01B-Z+github.com/v4lli/go-abp/cmd/abp-grpcd/abppbb\x06proto3"
+
+var (
+	file_transfers_proto_rawDescOnce sync.Once
+	file_transfers_proto_rawDescData []byte
+)
+
+func file_transfers_proto_rawDescGZIP() []byte {
+	file_transfers_proto_rawDescOnce.Do(func() {
+		file_transfers_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_transfers_proto_rawDesc), len(file_transfers_proto_rawDesc)))
+	})
+	return file_transfers_proto_rawDescData
+}
+
+var file_transfers_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_transfers_proto_goTypes = []any{
+	(*StartTransferRequest)(nil), // 0: abp.StartTransferRequest
+	(*SendRequest)(nil),          // 1: abp.SendRequest
+	(*ReceiveRequest)(nil),       // 2: abp.ReceiveRequest
+	(*TransferID)(nil),           // 3: abp.TransferID
+	(*WatchProgressRequest)(nil), // 4: abp.WatchProgressRequest
+	(*TransferStatus)(nil),       // 5: abp.TransferStatus
+}
+var file_transfers_proto_depIdxs = []int32{
+	1, // 0: abp.StartTransferRequest.send:type_name -> abp.SendRequest
+	2, // 1: abp.StartTransferRequest.receive:type_name -> abp.ReceiveRequest
+	0, // 2: abp.Transfers.StartTransfer:input_type -> abp.StartTransferRequest
+	3, // 3: abp.Transfers.GetStatus:input_type -> abp.TransferID
+	3, // 4: abp.Transfers.Cancel:input_type -> abp.TransferID
+	4, // 5: abp.Transfers.WatchProgress:input_type -> abp.WatchProgressRequest
+	5, // 6: abp.Transfers.StartTransfer:output_type -> abp.TransferStatus
+	5, // 7: abp.Transfers.GetStatus:output_type -> abp.TransferStatus
+	5, // 8: abp.Transfers.Cancel:output_type -> abp.TransferStatus
+	5, // 9: abp.Transfers.WatchProgress:output_type -> abp.TransferStatus
+	6, // [6:10] is the sub-list for method output_type
+	2, // [2:6] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_transfers_proto_init() }
+func file_transfers_proto_init() {
+	if File_transfers_proto != nil {
+		return
+	}
+	file_transfers_proto_msgTypes[0].OneofWrappers = []any{
+		(*StartTransferRequest_Send)(nil),
+		(*StartTransferRequest_Receive)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_transfers_proto_rawDesc), len(file_transfers_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_transfers_proto_goTypes,
+		DependencyIndexes: file_transfers_proto_depIdxs,
+		MessageInfos:      file_transfers_proto_msgTypes,
+	}.Build()
+	File_transfers_proto = out.File
+	file_transfers_proto_goTypes = nil
+	file_transfers_proto_depIdxs = nil
+}
@@ -0,0 +1,255 @@
+// The service of abp-grpcd, which runs ABP transfers on its host for
+// orchestration systems. Regenerate the Go code after changes with
+// protoc-gen-go and protoc-gen-go-grpc (paths=source_relative).
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: transfers.proto
+
+package abppb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Transfers_StartTransfer_FullMethodName = "/abp.Transfers/StartTransfer"
+	Transfers_GetStatus_FullMethodName     = "/abp.Transfers/GetStatus"
+	Transfers_Cancel_FullMethodName        = "/abp.Transfers/Cancel"
+	Transfers_WatchProgress_FullMethodName = "/abp.Transfers/WatchProgress"
+)
+
+// TransfersClient is the client API for Transfers service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TransfersClient interface {
+	// StartTransfer starts sending a file or waiting for one and returns
+	// right away; the transfer runs in the background.
+	StartTransfer(ctx context.Context, in *StartTransferRequest, opts ...grpc.CallOption) (*TransferStatus, error)
+	GetStatus(ctx context.Context, in *TransferID, opts ...grpc.CallOption) (*TransferStatus, error)
+	// Cancel stops a running transfer. Canceling a finished one does
+	// nothing.
+	Cancel(ctx context.Context, in *TransferID, opts ...grpc.CallOption) (*TransferStatus, error)
+	// WatchProgress sends the status whenever it changes, at most every
+	// interval_ms (default 500), and ends with the final one.
+	WatchProgress(ctx context.Context, in *WatchProgressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[TransferStatus], error)
+}
+
+type transfersClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTransfersClient(cc grpc.ClientConnInterface) TransfersClient {
+	return &transfersClient{cc}
+}
+
+func (c *transfersClient) StartTransfer(ctx context.Context, in *StartTransferRequest, opts ...grpc.CallOption) (*TransferStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransferStatus)
+	err := c.cc.Invoke(ctx, Transfers_StartTransfer_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *transfersClient) GetStatus(ctx context.Context, in *TransferID, opts ...grpc.CallOption) (*TransferStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransferStatus)
+	err := c.cc.Invoke(ctx, Transfers_GetStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *transfersClient) Cancel(ctx context.Context, in *TransferID, opts ...grpc.CallOption) (*TransferStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransferStatus)
+	err := c.cc.Invoke(ctx, Transfers_Cancel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *transfersClient) WatchProgress(ctx context.Context, in *WatchProgressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[TransferStatus], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Transfers_ServiceDesc.Streams[0], Transfers_WatchProgress_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchProgressRequest, TransferStatus]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Transfers_WatchProgressClient = grpc.ServerStreamingClient[TransferStatus]
+
+// TransfersServer is the server API for Transfers service.
+// All implementations must embed UnimplementedTransfersServer
+// for forward compatibility.
+type TransfersServer interface {
+	// StartTransfer starts sending a file or waiting for one and returns
+	// right away; the transfer runs in the background.
+	StartTransfer(context.Context, *StartTransferRequest) (*TransferStatus, error)
+	GetStatus(context.Context, *TransferID) (*TransferStatus, error)
+	// Cancel stops a running transfer. Canceling a finished one does
+	// nothing.
+	Cancel(context.Context, *TransferID) (*TransferStatus, error)
+	// WatchProgress sends the status whenever it changes, at most every
+	// interval_ms (default 500), and ends with the final one.
+	WatchProgress(*WatchProgressRequest, grpc.ServerStreamingServer[TransferStatus]) error
+	mustEmbedUnimplementedTransfersServer()
+}
+
+// UnimplementedTransfersServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTransfersServer struct{}
+
+func (UnimplementedTransfersServer) StartTransfer(context.Context, *StartTransferRequest) (*TransferStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method StartTransfer not implemented")
+}
+func (UnimplementedTransfersServer) GetStatus(context.Context, *TransferID) (*TransferStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedTransfersServer) Cancel(context.Context, *TransferID) (*TransferStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method Cancel not implemented")
+}
+func (UnimplementedTransfersServer) WatchProgress(*WatchProgressRequest, grpc.ServerStreamingServer[TransferStatus]) error {
+	return status.Error(codes.Unimplemented, "method WatchProgress not implemented")
+}
+func (UnimplementedTransfersServer) mustEmbedUnimplementedTransfersServer() {}
+func (UnimplementedTransfersServer) testEmbeddedByValue()                   {}
+
+// UnsafeTransfersServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TransfersServer will
+// result in compilation errors.
+type UnsafeTransfersServer interface {
+	mustEmbedUnimplementedTransfersServer()
+}
+
+func RegisterTransfersServer(s grpc.ServiceRegistrar, srv TransfersServer) {
+	// If the following call panics, it indicates UnimplementedTransfersServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Transfers_ServiceDesc, srv)
+}
+
+func _Transfers_StartTransfer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartTransferRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransfersServer).StartTransfer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Transfers_StartTransfer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransfersServer).StartTransfer(ctx, req.(*StartTransferRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Transfers_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransfersServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Transfers_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransfersServer).GetStatus(ctx, req.(*TransferID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Transfers_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransfersServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Transfers_Cancel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransfersServer).Cancel(ctx, req.(*TransferID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Transfers_WatchProgress_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchProgressRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TransfersServer).WatchProgress(m, &grpc.GenericServerStream[WatchProgressRequest, TransferStatus]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Transfers_WatchProgressServer = grpc.ServerStreamingServer[TransferStatus]
+
+// Transfers_ServiceDesc is the grpc.ServiceDesc for Transfers service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Transfers_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "abp.Transfers",
+	HandlerType: (*TransfersServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "StartTransfer",
+			Handler:    _Transfers_StartTransfer_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _Transfers_GetStatus_Handler,
+		},
+		{
+			MethodName: "Cancel",
+			Handler:    _Transfers_Cancel_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchProgress",
+			Handler:       _Transfers_WatchProgress_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "transfers.proto",
+}
@@ -0,0 +1,39 @@
+// abp-grpcd runs ABP transfers on its host on behalf of remote
+// orchestration systems, which start, watch and cancel them through the
+// gRPC service in abppb. It lives in a module of its own, which keeps gRPC
+// and protobuf out of the root module go-abp is imported as.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"google.golang.org/grpc"
+
+	"github.com/v4lli/go-abp/cmd/abp-grpcd/abppb"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:1237", "TCP address to "+
+		"serve the gRPC service on")
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [-listen addr]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Printf("Socket setup error: %v\n", err)
+		os.Exit(1)
+	}
+	server := grpc.NewServer()
+	abppb.RegisterTransfersServer(server, newService())
+	fmt.Printf("Serving gRPC on %s...\n", *listen)
+	if err := server.Serve(ln); err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/v4lli/go-abp/abp"
+	"github.com/v4lli/go-abp/cmd/abp-grpcd/abppb"
+)
+
+// how long finished transfers can still be looked up
+const KEEP_FINISHED = time.Hour
+
+// the default interval of WatchProgress
+const WATCH_INTERVAL = 500 * time.Millisecond
+
+// a transfer started by StartTransfer
+type transfer struct {
+	mu     sync.Mutex
+	status abppb.TransferStatus
+	// nil while a receiving transfer waits for its sender
+	t *abp.Transfer
+	// the socket of a receiving transfer, closed to cancel the wait
+	conn     *net.UDPConn
+	finished time.Time
+	// closed and replaced whenever the status changes
+	changed chan struct{}
+}
+
+type service struct {
+	abppb.UnimplementedTransfersServer
+	mu        sync.Mutex
+	transfers map[string]*transfer
+}
+
+func newService() *service {
+	return &service{transfers: make(map[string]*transfer)}
+}
+
+// a random ID like the receiver command gives its transfers
+func newTransferID() string {
+	var b [4]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// changes the status under the lock and wakes up the watchers.
+func (tr *transfer) update(f func(s *abppb.TransferStatus)) {
+	tr.mu.Lock()
+	f(&tr.status)
+	if tr.status.Done && tr.finished.IsZero() {
+		tr.finished = time.Now()
+	}
+	close(tr.changed)
+	tr.changed = make(chan struct{})
+	tr.mu.Unlock()
+}
+
+// a copy of the status, and the channel closed on its next change
+func (tr *transfer) snapshot() (*abppb.TransferStatus, <-chan struct{}) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	s := &abppb.TransferStatus{
+		Id:          tr.status.Id,
+		Name:        tr.status.Name,
+		State:       tr.status.State,
+		Bytes:       tr.status.Bytes,
+		Size:        tr.status.Size,
+		Retransmits: tr.status.Retransmits,
+		Done:        tr.status.Done,
+		Error:       tr.status.Error,
+	}
+	return s, tr.changed
+}
+
+func (s *service) StartTransfer(ctx context.Context,
+	req *abppb.StartTransferRequest) (*abppb.TransferStatus, error) {
+	tr := &transfer{changed: make(chan struct{})}
+	tr.status.Id = newTransferID()
+	var err error
+	switch dir := req.Direction.(type) {
+	case *abppb.StartTransferRequest_Send:
+		err = s.send(tr, dir.Send)
+	case *abppb.StartTransferRequest_Receive:
+		err = s.receive(tr, dir.Receive)
+	default:
+		err = status.Error(codes.InvalidArgument, "neither send nor receive")
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.forgetFinished()
+	s.transfers[tr.status.Id] = tr
+	s.mu.Unlock()
+	snapshot, _ := tr.snapshot()
+	return snapshot, nil
+}
+
+// starts sending a file.
+func (s *service) send(tr *transfer, req *abppb.SendRequest) error {
+	fh, err := os.Open(req.Path)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	info, err := fh.Stat()
+	if err != nil {
+		fh.Close()
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	addr, err := net.ResolveUDPAddr("udp", req.Receiver)
+	if err != nil {
+		fh.Close()
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		fh.Close()
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	name := req.Name
+	if name == "" {
+		name = filepath.Base(req.Path)
+	}
+	tr.status.Name = name
+	tr.status.Size = info.Size()
+	tr.status.State = abp.STATE_STARTING.String()
+	tr.t = abp.Send(conn, name, &countingReader{tr, fh})
+	go func() {
+		tr.follow()
+		fh.Close()
+		conn.Close()
+	}()
+	return nil
+}
+
+// starts waiting for a transfer on a UDP address.
+func (s *service) receive(tr *transfer, req *abppb.ReceiveRequest) error {
+	if info, err := os.Stat(req.Dir); err != nil || !info.IsDir() {
+		return status.Errorf(codes.InvalidArgument, "%s isn't a directory",
+			req.Dir)
+	}
+	addr, err := net.ResolveUDPAddr("udp", req.Listen)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	tr.conn = conn
+	tr.status.State = "WAITING"
+	r := abp.NewReceiver(conn, nil)
+	go r.Serve()
+	go func() {
+		defer conn.Close()
+		in, err := r.Accept()
+		if err != nil {
+			tr.fail(err)
+			return
+		}
+		fh, err := os.Create(filepath.Join(req.Dir, filepath.Base(in.Name)))
+		if err != nil {
+			in.Close()
+			tr.fail(err)
+			return
+		}
+		defer fh.Close()
+		tr.mu.Lock()
+		tr.status.Name = in.Name
+		tr.t = abp.Receive(in, &countingWriter{tr, fh})
+		tr.mu.Unlock()
+		tr.follow()
+	}()
+	return nil
+}
+
+// updates the status from the events of the transfer until it completed.
+func (tr *transfer) follow() {
+	for e := range tr.t.Events() {
+		switch e := e.(type) {
+		case abp.StateChanged:
+			tr.update(func(s *abppb.TransferStatus) {
+				s.State = e.To.String()
+			})
+		case abp.Retransmit:
+			tr.update(func(s *abppb.TransferStatus) {
+				s.Retransmits++
+			})
+		case abp.Completed:
+			if e.Err != nil {
+				tr.fail(e.Err)
+			} else {
+				tr.update(func(s *abppb.TransferStatus) {
+					s.State = abp.STATE_DONE.String()
+					s.Done = true
+				})
+			}
+		}
+	}
+}
+
+// marks the transfer as failed, unless it already finished.
+func (tr *transfer) fail(err error) {
+	tr.update(func(s *abppb.TransferStatus) {
+		if s.Done {
+			return
+		}
+		s.State = abp.STATE_DONE.String()
+		s.Done = true
+		s.Error = err.Error()
+	})
+}
+
+// drops the transfers that finished more than KEEP_FINISHED ago; s.mu is
+// held.
+func (s *service) forgetFinished() {
+	for id, tr := range s.transfers {
+		tr.mu.Lock()
+		old := !tr.finished.IsZero() &&
+			time.Since(tr.finished) > KEEP_FINISHED
+		tr.mu.Unlock()
+		if old {
+			delete(s.transfers, id)
+		}
+	}
+}
+
+func (s *service) lookup(id string) (*transfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tr, ok := s.transfers[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no transfer %q", id)
+	}
+	return tr, nil
+}
+
+func (s *service) GetStatus(ctx context.Context,
+	req *abppb.TransferID) (*abppb.TransferStatus, error) {
+	tr, err := s.lookup(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, _ := tr.snapshot()
+	return snapshot, nil
+}
+
+func (s *service) Cancel(ctx context.Context,
+	req *abppb.TransferID) (*abppb.TransferStatus, error) {
+	tr, err := s.lookup(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	tr.mu.Lock()
+	t, conn := tr.t, tr.conn
+	tr.mu.Unlock()
+	if t != nil {
+		t.Cancel()
+	} else if conn != nil {
+		// still waiting for the sender
+		tr.fail(abp.ErrTransferCanceled)
+		conn.Close()
+	}
+	// until the transfer noticed
+	for {
+		snapshot, changed := tr.snapshot()
+		if snapshot.Done {
+			return snapshot, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *service) WatchProgress(req *abppb.WatchProgressRequest,
+	stream abppb.Transfers_WatchProgressServer) error {
+	tr, err := s.lookup(req.Id)
+	if err != nil {
+		return err
+	}
+	interval := WATCH_INTERVAL
+	if req.IntervalMs > 0 {
+		interval = time.Duration(req.IntervalMs) * time.Millisecond
+	}
+	for {
+		snapshot, changed := tr.snapshot()
+		if err := stream.Send(snapshot); err != nil {
+			return err
+		}
+		if snapshot.Done {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+		select {
+		case <-time.After(interval):
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// counts the bytes read from the file being sent
+type countingReader struct {
+	tr *transfer
+	r  io.Reader
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.tr.update(func(s *abppb.TransferStatus) {
+			s.Bytes += int64(n)
+		})
+	}
+	return n, err
+}
+
+// counts the bytes written to the file being received
+type countingWriter struct {
+	tr *transfer
+	w  io.Writer
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.tr.update(func(s *abppb.TransferStatus) {
+		s.Bytes += int64(n)
+	})
+	return n, err
+}
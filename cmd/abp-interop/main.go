@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// name of the file sent in every combination
+const TEST_FILE = "interop.bin"
+
+// an implementation under test, run through sh -c with {host}, {port},
+// {addr} and {file} replaced
+type implementation struct {
+	name string
+	send string
+	recv string
+}
+
+// how one sender/receiver combination went
+type result struct {
+	sender, receiver string
+	err              error
+	elapsed          time.Duration
+	wire             *wireReport
+}
+
+// the address of a free UDP port on loopback
+func freePort() (int, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port, nil
+}
+
+func expand(template string, port int, file string) string {
+	host := "127.0.0.1"
+	return strings.NewReplacer("{host}", host, "{port}", strconv.Itoa(port),
+		"{addr}", net.JoinHostPort(host, strconv.Itoa(port)),
+		"{file}", file).Replace(template)
+}
+
+// starts a command in dir; exec lets the shell make way for it, so
+// killing the process stops the implementation itself.
+func start(command string, dir string, log *bytes.Buffer) (*exec.Cmd, error) {
+	cmd := exec.Command("sh", "-c", "exec "+command)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = log, log
+	return cmd, cmd.Start()
+}
+
+// runs sender against receiver through a relay checking every packet, and
+// looks for an intact copy of the file in the receiver's directory.
+func run(sender, receiver implementation, data []byte,
+	timeout time.Duration) result {
+	res := result{sender: sender.name, receiver: receiver.name}
+	srcDir, err := ioutil.TempDir("", "abp-interop-src")
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer os.RemoveAll(srcDir)
+	dstDir, err := ioutil.TempDir("", "abp-interop-dst")
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer os.RemoveAll(dstDir)
+	if err := ioutil.WriteFile(filepath.Join(srcDir, TEST_FILE), data,
+		0644); err != nil {
+		res.err = err
+		return res
+	}
+
+	port, err := freePort()
+	if err != nil {
+		res.err = err
+		return res
+	}
+	var recvLog, sendLog bytes.Buffer
+	recv, err := start(expand(receiver.recv, port, TEST_FILE), dstDir,
+		&recvLog)
+	if err != nil {
+		res.err = fmt.Errorf("starting the receiver: %v", err)
+		return res
+	}
+	defer func() {
+		recv.Process.Kill()
+		recv.Wait()
+	}()
+	// give the receiver time to open its socket
+	time.Sleep(500 * time.Millisecond)
+
+	relay, err := newRelay(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1),
+		Port: port})
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer relay.conn.Close()
+	go relay.run()
+	res.wire = relay.report
+
+	begin := time.Now()
+	send, err := start(expand(sender.send, relay.port(), TEST_FILE), srcDir,
+		&sendLog)
+	if err != nil {
+		res.err = fmt.Errorf("starting the sender: %v", err)
+		return res
+	}
+	done := make(chan error, 1)
+	go func() { done <- send.Wait() }()
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		send.Process.Kill()
+		<-done
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+	res.elapsed = time.Since(begin)
+	if err != nil {
+		res.err = fmt.Errorf("sender failed: %v\n%s", err,
+			indent(sendLog.String()))
+		return res
+	}
+
+	// receivers may store the file under another name
+	time.Sleep(200 * time.Millisecond)
+	files, _ := ioutil.ReadDir(dstDir)
+	for _, f := range files {
+		received, err := ioutil.ReadFile(filepath.Join(dstDir, f.Name()))
+		if err == nil && bytes.Equal(received, data) {
+			return res
+		}
+	}
+	res.err = fmt.Errorf("the sender succeeded, but no intact copy "+
+		"arrived (%d files received)\n%s", len(files),
+		indent(recvLog.String()))
+	return res
+}
+
+// indents the last lines of a command's output
+func indent(log string) string {
+	lines := strings.Split(strings.TrimRight(log, "\n"), "\n")
+	if len(lines) > 10 {
+		lines = lines[len(lines)-10:]
+	}
+	return "    | " + strings.Join(lines, "\n    | ")
+}
+
+func main() {
+	refSend := flag.String("ref-send", "", "command of the reference "+
+		"sender, e.g. \"./ref-send {host} {port} {file}\"")
+	refRecv := flag.String("ref-recv", "", "command of the reference "+
+		"receiver, which must store files in its working directory, e.g. "+
+		"\"./ref-recv -p {port}\"")
+	ourSend := flag.String("send", "abp-send {addr} {file}", "command "+
+		"of our sender")
+	ourRecv := flag.String("recv", "abp-recv -listen {addr}", "command "+
+		"of our receiver")
+	size := flag.Int("size", 100*1024, "bytes in the test file")
+	timeout := flag.Duration("timeout", time.Minute, "give up on a "+
+		"transfer after this long")
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s -ref-send <command> -ref-recv <command> "+
+			"[options]\n\n"+
+			"Commands are run with sh -c in the directory of the file "+
+			"(senders) or an\nempty one (receivers); {host}, {port}, "+
+			"{addr} and {file} are replaced.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *refSend == "" && *refRecv == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	ours := implementation{"ours", *ourSend, *ourRecv}
+	ref := implementation{"reference", *refSend, *refRecv}
+	var senders, receivers []implementation
+	senders = append(senders, ours)
+	receivers = append(receivers, ours)
+	if ref.send != "" {
+		senders = append(senders, ref)
+	}
+	if ref.recv != "" {
+		receivers = append(receivers, ref)
+	}
+
+	data := make([]byte, *size)
+	rand.Read(data)
+	failed := 0
+	for _, sender := range senders {
+		for _, receiver := range receivers {
+			res := run(sender, receiver, data, *timeout)
+			fmt.Printf("%s sender -> %s receiver: ", res.sender,
+				res.receiver)
+			if res.err != nil {
+				failed++
+				fmt.Printf("FAILED\n  %v\n", res.err)
+			} else {
+				fmt.Printf("OK (%v)\n", res.elapsed.Round(time.Millisecond))
+			}
+			if res.wire != nil {
+				res.wire.print()
+			}
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
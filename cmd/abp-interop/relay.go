@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// bytes of a bad packet shown in the report
+const SAMPLE_LENGTH = 32
+
+// what the relay found wrong with the packets of one direction
+type wireProblem struct {
+	count  int
+	sample []byte
+}
+
+// packets the relay couldn't parse, by direction and error
+type wireReport struct {
+	mu       sync.Mutex
+	packets  [2]int
+	problems [2]map[string]*wireProblem
+	order    [2][]string
+}
+
+var directionNames = [2]string{"sender", "receiver"}
+
+func (w *wireReport) add(from int, pkt []byte, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.packets[from]++
+	if err == nil {
+		return
+	}
+	if w.problems[from] == nil {
+		w.problems[from] = map[string]*wireProblem{}
+	}
+	msg := err.Error()
+	p := w.problems[from][msg]
+	if p == nil {
+		sample := pkt
+		if len(sample) > SAMPLE_LENGTH {
+			sample = sample[:SAMPLE_LENGTH]
+		}
+		p = &wireProblem{sample: append([]byte(nil), sample...)}
+		w.problems[from][msg] = p
+		w.order[from] = append(w.order[from], msg)
+	}
+	p.count++
+}
+
+func (w *wireReport) print() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for from := range w.problems {
+		for _, msg := range w.order[from] {
+			p := w.problems[from][msg]
+			fmt.Printf("  wire: %d of %d packets from the %s: %s, e.g. %s\n",
+				p.count, w.packets[from], directionNames[from], msg,
+				hex.EncodeToString(p.sample))
+		}
+	}
+}
+
+// forwards datagrams between a sender and a receiver and parses each of
+// them with our header and checksum code.
+type relay struct {
+	conn     *net.UDPConn
+	receiver *net.UDPAddr
+	sender   *net.UDPAddr
+	report   *wireReport
+}
+
+func newRelay(receiver *net.UDPAddr) (*relay, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	return &relay{conn: conn, receiver: receiver, report: &wireReport{}}, nil
+}
+
+func (r *relay) port() int {
+	return r.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func (r *relay) run() {
+	buf := make([]byte, abp.MaxPacketLength)
+	for {
+		n, addr, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		from, to := 0, r.receiver
+		if addr.String() == r.receiver.String() {
+			if r.sender == nil {
+				continue
+			}
+			from, to = 1, r.sender
+		} else {
+			r.sender = addr
+		}
+		// either encoding may be in use, the compact one is tried first
+		_, _, err = abp.ParsePacket(buf[:n], true)
+		r.report.add(from, buf[:n], err)
+		r.conn.WriteToUDP(buf[:n], to)
+	}
+}
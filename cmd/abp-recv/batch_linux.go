@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// datagrams read per recvmmsg(2) call at most
+const BATCH_SIZE = 32
+
+// struct mmsghdr
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+// reads batches of datagrams with one recvmmsg(2) call each, so windowed
+// senders don't make the receiver syscall-bound.
+type batchReader struct {
+	conn    *net.UDPConn
+	raw     syscall.RawConn
+	buffers [BATCH_SIZE][abp.MaxPacketLength]byte
+	names   [BATCH_SIZE]syscall.RawSockaddrAny
+	iovecs  [BATCH_SIZE]syscall.Iovec
+	msgs    [BATCH_SIZE]mmsghdr
+}
+
+func newBatchReader(conn *net.UDPConn) *batchReader {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		panic(err)
+	}
+	b := &batchReader{conn: conn, raw: raw}
+	for i := range b.msgs {
+		b.iovecs[i].Base = &b.buffers[i][0]
+		b.iovecs[i].SetLen(abp.MaxPacketLength)
+		b.msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+		b.msgs[i].hdr.Iov = &b.iovecs[i]
+		b.msgs[i].hdr.Iovlen = 1
+	}
+	return b
+}
+
+// blocks until at least one datagram arrived and returns all that are
+// waiting, up to BATCH_SIZE.
+func (b *batchReader) read() ([]datagram, error) {
+	for i := range b.msgs {
+		b.msgs[i].hdr.Namelen = syscall.SizeofSockaddrAny
+	}
+	var n int
+	var errno syscall.Errno
+	err := b.raw.Read(func(fd uintptr) bool {
+		r, _, e := syscall.Syscall6(syscall.SYS_RECVMMSG, fd,
+			uintptr(unsafe.Pointer(&b.msgs[0])), BATCH_SIZE, 0, 0, 0)
+		if e == syscall.EAGAIN || e == syscall.EINTR {
+			// wait until the socket is readable again
+			return false
+		}
+		n, errno = int(r), e
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if errno != 0 {
+		return nil, errno
+	}
+
+	dgrams := make([]datagram, 0, n)
+	for i := 0; i < n; i++ {
+		data := make([]byte, b.msgs[i].len)
+		copy(data, b.buffers[i][:])
+		dgrams = append(dgrams, datagram{sockaddrToUDP(&b.names[i]), data,
+			b.conn})
+	}
+	return dgrams, nil
+}
+
+func sockaddrToUDP(sa *syscall.RawSockaddrAny) *net.UDPAddr {
+	switch sa.Addr.Family {
+	case syscall.AF_INET:
+		sa4 := (*syscall.RawSockaddrInet4)(unsafe.Pointer(sa))
+		return &net.UDPAddr{
+			IP:   net.IP(append([]byte(nil), sa4.Addr[:]...)),
+			Port: networkPort(sa4.Port),
+		}
+	case syscall.AF_INET6:
+		sa6 := (*syscall.RawSockaddrInet6)(unsafe.Pointer(sa))
+		addr := &net.UDPAddr{
+			IP:   net.IP(append([]byte(nil), sa6.Addr[:]...)),
+			Port: networkPort(sa6.Port),
+		}
+		if sa6.Scope_id != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa6.Scope_id)); err == nil {
+				addr.Zone = ifi.Name
+			}
+		}
+		return addr
+	}
+	return &net.UDPAddr{}
+}
+
+// the port of a raw sockaddr is in network byte order
+func networkPort(port uint16) int {
+	p := (*[2]byte)(unsafe.Pointer(&port))
+	return int(p[0])<<8 | int(p[1])
+}
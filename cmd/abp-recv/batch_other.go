@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"net"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// without recvmmsg(2) every read returns a single datagram
+type batchReader struct {
+	conn   *net.UDPConn
+	buffer []byte
+}
+
+func newBatchReader(conn *net.UDPConn) *batchReader {
+	return &batchReader{conn, make([]byte, abp.MaxPacketLength)}
+}
+
+func (b *batchReader) read() ([]datagram, error) {
+	n, remoteAddr, err := b.conn.ReadFromUDP(b.buffer)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, n)
+	copy(data, b.buffer)
+	return []datagram{{remoteAddr, data, b.conn}}, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// keep received files in a content-addressed store as well, see -cas
+var casStore bool
+
+// the store: objects named after the SHA-256 of their content, links
+// being hashed and the index, one "<hash> <size> <time> <name>" line per
+// stored file
+const (
+	casObjects = ".abp-cas/objects"
+	casPending = ".abp-cas/pending"
+	casIndex   = ".abp-cas/index"
+)
+
+var casIndexLock sync.Mutex
+
+func openStore() error {
+	for _, dir := range []string{casObjects, casPending} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adds a completed file to the store. the file is hard linked into it
+// right away, so a transfer replacing it meanwhile doesn't change what
+// is hashed; hashing takes a while, so the rest happens in the
+// background. a file whose content is stored already is replaced by a
+// link to the object, so identical uploads take the space once.
+func storeFile(client *Client) {
+	path := "./" + client.filename
+	pending := filepath.Join(casPending, client.id)
+	if err := os.Link(path, pending); err != nil {
+		client.logf("CAS", "can't store %s: %v\n", client.filename, err)
+		return
+	}
+	goBackground(func() {
+		defer os.Remove(pending)
+		sum, err := hashFile(pending)
+		if err != nil {
+			client.logf("CAS", "can't hash %s: %v\n", client.filename, err)
+			return
+		}
+		object := filepath.Join(casObjects, hex.EncodeToString(sum))
+		info, err := os.Stat(pending)
+		if err != nil {
+			return
+		}
+		if _, err := os.Stat(object); err == nil {
+			if dedupe(path, pending, object) {
+				client.logf("CAS", "%s is a duplicate of %s\n",
+					client.filename, filepath.Base(object))
+			}
+		} else if err := os.Rename(pending, object); err != nil {
+			client.logf("CAS", "can't store %s: %v\n", client.filename, err)
+			return
+		}
+		appendIndex(fmt.Sprintf("%x %d %s %s\n", sum, info.Size(),
+			time.Now().UTC().Format(time.RFC3339), client.filename))
+		syncDir(casObjects)
+	})
+}
+
+// replaces the file at path by a link to object, unless it was replaced
+// by another transfer since it got hashed.
+func dedupe(path string, pending string, object string) bool {
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	hashed, err := os.Stat(pending)
+	if err != nil || !os.SameFile(current, hashed) {
+		return false
+	}
+	link := pending + ".link"
+	if err := os.Link(object, link); err != nil {
+		return false
+	}
+	if err := os.Rename(link, path); err != nil {
+		os.Remove(link)
+		return false
+	}
+	return true
+}
+
+func appendIndex(line string) {
+	casIndexLock.Lock()
+	defer casIndexLock.Unlock()
+	fh, err := os.OpenFile(casIndex, os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+		0644)
+	if err != nil {
+		fmt.Printf("[CAS] can't open %s: %v\n", casIndex, err)
+		return
+	}
+	defer fh.Close()
+	if _, err := fh.WriteString(line); err != nil {
+		fmt.Printf("[CAS] can't write %s: %v\n", casIndex, err)
+	}
+	if syncPolicy != SYNC_NONE {
+		fh.Sync()
+	}
+}
+
+// cas-verify: hashes every object of the store again and reports the
+// ones whose content doesn't match their name anymore. returns whether
+// all of them did.
+func verifyStore() bool {
+	infos, err := ioutil.ReadDir(casObjects)
+	if err != nil {
+		fmt.Printf("can't read the store: %v\n", err)
+		return false
+	}
+	bad := 0
+	for _, info := range infos {
+		sum, err := hashFile(filepath.Join(casObjects, info.Name()))
+		if err != nil || hex.EncodeToString(sum) != info.Name() {
+			fmt.Printf("CORRUPT %s\n", info.Name())
+			bad++
+		}
+	}
+	fmt.Printf("%d objects, %d corrupt\n", len(infos), bad)
+	return bad == 0
+}
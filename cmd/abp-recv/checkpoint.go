@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// running hash of the output file for checkpoints, covering its first
+// hashed bytes
+type checkpoint struct {
+	hash   hash.Hash
+	hashed int64
+}
+
+// answers a checkpoint request (HDR_HASH without payload during a transfer)
+// with the number of bytes written so far and the SHA-256 over them. the
+// data is read back from the file, so corruption on the way to the disk is
+// detected as well; only the part written since the last checkpoint is
+// read.
+func sendCheckpoint(client *Client) {
+	if client.fh == nil {
+		// nothing stored, e.g. a benchmark
+		return
+	}
+	flushData(client)
+	if client.checkpoint.hash == nil {
+		client.checkpoint.hash = sha256.New()
+	}
+	cp := &client.checkpoint
+	section := io.NewSectionReader(client.fh, cp.hashed,
+		client.sink.offset-cp.hashed)
+	n, err := io.Copy(cp.hash, section)
+	cp.hashed += n
+	if err != nil {
+		panic(err)
+	}
+	// a skipped hole at the end isn't part of the file yet
+	for cp.hashed < client.sink.offset {
+		zeros := make([]byte, 32*1024)
+		if rest := client.sink.offset - cp.hashed; rest < int64(len(zeros)) {
+			zeros = zeros[:rest]
+		}
+		cp.hash.Write(zeros)
+		cp.hashed += int64(len(zeros))
+	}
+
+	client.logf("HANDLER", "checkpoint of %s at %d bytes for %v\n",
+		client.filename, cp.hashed, client.remoteAddr)
+	payload := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(payload, uint64(cp.hashed))
+	sendPacket(client, abp.HDR_HASH, cp.hash.Sum(payload))
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// a command read from the control port, answered by the main loop so it
+// doesn't race with the datagrams
+type controlRequest struct {
+	command string
+	reply   chan string
+}
+
+// accepts control connections on addr; each line is a command whose
+// answer is written back, terminated by an empty line.
+func serveControl(addr string, requests chan<- controlRequest) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	listeners = append(listeners, ln)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil && atomic.LoadInt32(&listenersClosed) != 0 {
+				return
+			}
+			if err != nil {
+				panic(err)
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					req := controlRequest{scanner.Text(), make(chan string)}
+					requests <- req
+					fmt.Fprintf(conn, "%s\n", <-req.reply)
+				}
+			}()
+		}
+	}()
+	return nil
+}
+
+// runs a control command against the clients
+func handleControl(command string, clients map[string]*Client) string {
+	args := strings.Fields(command)
+	// names may contain spaces
+	switch {
+	case len(args) >= 1 && args[0] == "list":
+		return listFiles(strings.TrimSpace(strings.TrimPrefix(command,
+			"list")))
+	case len(args) >= 2 && args[0] == "delete":
+		return deleteFile(strings.TrimSpace(strings.TrimPrefix(command,
+			"delete")), clients)
+	}
+	switch {
+	case len(args) == 1 && args[0] == "status":
+		return transferStatus(clients)
+	case len(args) == 1 && args[0] == "drain":
+		return startDrain(clients)
+	case len(args) == 2 && args[0] == "cancel":
+		for _, client := range clients {
+			if client.id != args[1] || client.state == STATE_CLIENT_DEAD {
+				continue
+			}
+			if client.state == STATE_CLOSED0 ||
+				client.state == STATE_CLOSED1 {
+				return fmt.Sprintf("error: %s already completed\n",
+					client.id)
+			}
+			cancelClient(client, clients)
+			return fmt.Sprintf("canceled %s\n", client.id)
+		}
+		return fmt.Sprintf("error: no transfer %s\n", args[1])
+	}
+	return "error: unknown command, use status, cancel <id>, list " +
+		"[prefix], delete <name> or drain\n"
+}
+
+// lists the stored files whose names start with prefix, one per line
+// with their size
+func listFiles(prefix string) string {
+	infos, err := ioutil.ReadDir(".")
+	if err != nil {
+		return fmt.Sprintf("error: %v\n", err)
+	}
+	var lines []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() && strings.HasPrefix(info.Name(), prefix) {
+			lines = append(lines, fmt.Sprintf("%12d  %s", info.Size(),
+				info.Name()))
+		}
+	}
+	return fmt.Sprintf("%d file(s)\n", len(lines)) +
+		strings.Join(append(lines, ""), "\n")
+}
+
+// deletes a stored file, e.g. for a sync that mirrors deletions; files
+// of transfers in progress are left alone.
+func deleteFile(name string, clients map[string]*Client) string {
+	if name != sanitizeFilename(name) {
+		return fmt.Sprintf("error: invalid name %s\n", name)
+	}
+	for _, client := range clients {
+		if client.filename == name && (client.state == STATE_WAIT_DATA0 ||
+			client.state == STATE_WAIT_DATA1) {
+			return fmt.Sprintf("error: %s is being received\n", name)
+		}
+	}
+	info, err := os.Lstat("./" + name)
+	if err == nil && !info.Mode().IsRegular() {
+		return fmt.Sprintf("error: %s is no file\n", name)
+	}
+	if err == nil {
+		err = os.Remove("./" + name)
+	}
+	if err != nil {
+		return fmt.Sprintf("error: %v\n", err)
+	}
+	syncDir(".")
+	fmt.Printf("[CONTROL] deleted %s\n", name)
+	return fmt.Sprintf("deleted %s\n", name)
+}
+
+// lists the transfers in progress, one per line
+func transferStatus(clients map[string]*Client) string {
+	var lines []string
+	for _, client := range clients {
+		if client.state == STATE_CLIENT_DEAD || client.filename == "" {
+			continue
+		}
+		var received int64
+		if client.sink != nil {
+			received = client.sink.offset
+		}
+		size := "?"
+		if client.announcedSize > 0 {
+			size = fmt.Sprint(client.announcedSize)
+		}
+		lines = append(lines, fmt.Sprintf("%s %-21s %-13s %12d/%-12s %8s  %s",
+			client.id, client.remoteAddr, stateNames[client.state], received,
+			size, time.Since(client.started).Round(time.Second),
+			client.filename))
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("%d transfer(s)\n", len(lines)) +
+		strings.Join(append(lines, ""), "\n")
+}
+
+// aborts a transfer on request: the sender is told, the partial file
+// deleted. a transfer's streams are canceled along with it; a stream on
+// its own is only dropped, the file belongs to its transfer, whose
+// sender fails once the stream did.
+func cancelClient(client *Client, clients map[string]*Client) {
+	client.logf("HANDLER", "canceling transfer of %s from %v\n",
+		client.filename, client.remoteAddr)
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, abp.ERR_CANCELED)
+	sendPacket(client, abp.HDR_ERROR, payload)
+	if client.parent != nil {
+		dropStream(client)
+		return
+	}
+	for _, stream := range clients {
+		if stream.parent == client && stream.state != STATE_CLIENT_DEAD {
+			sendPacket(stream, abp.HDR_ERROR, payload)
+			dropStream(stream)
+		}
+	}
+	removeClient(client)
+	os.Remove(client.outPath)
+	notifyTransfer(client, "failed", abp.ErrorMessage(abp.ERR_CANCELED))
+}
+
+// sends a control command to a running receiver and prints the answer
+func runControlCommand(addr string, args []string) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		fmt.Printf("can't reach the control port: %v\n", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "%s\n", strings.Join(args, " "))
+	scanner := bufio.NewScanner(conn)
+	failed := false
+	for scanner.Scan() && scanner.Text() != "" {
+		if strings.HasPrefix(scanner.Text(), "error:") {
+			failed = true
+		}
+		fmt.Println(scanner.Text())
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// as many signatures as fit into a packet of default size
+const maxSignaturesPerPacket = (abp.DefaultPacketLength - abp.HeaderLength -
+	abp.SignatureHeaderLength) / abp.BlockSignatureLength
+
+// opens the existing version of client.filename as basis for a delta
+// transfer and calculates its block signatures. returns false if there is
+// no usable old version, in which case the file is transferred in full.
+func openDeltaBasis(client *Client) bool {
+	path := "./" + client.filename
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+		return false
+	}
+	basis, err := os.Open(path)
+	if err != nil {
+		client.logf("DELTA", "can't open basis %s: %v\n", path, err)
+		return false
+	}
+
+	blockSize := abp.DeltaBlockSize(info.Size())
+	sigs, err := abp.ComputeSignatures(basis, blockSize)
+	if err != nil {
+		client.logf("DELTA", "can't read basis %s: %v\n", path, err)
+		basis.Close()
+		return false
+	}
+	client.logf("DELTA", "%s: %d blocks of %d bytes\n", path, len(sigs),
+		blockSize)
+
+	client.basis = basis
+	client.blockSize = blockSize
+	client.signatures = sigs
+	// the new version is written next to the old one and replaces it
+	// once the transfer completed
+	client.outPath = "./." + client.filename + ".abp-delta"
+	return true
+}
+
+// answers a signature request with the signatures of the requested block
+// and as many following ones as fit into the reply.
+func sendSignatures(client *Client) {
+	if len(client.lastData) != 4 || client.basis == nil {
+		client.logf("DELTA", "invalid signature request from %v\n",
+			client.remoteAddr)
+		return
+	}
+	first := binary.BigEndian.Uint32(client.lastData)
+	total := uint32(len(client.signatures))
+	if first > total {
+		first = total
+	}
+	last := first + uint32(maxSignaturesPerPacket)
+	if last > total {
+		last = total
+	}
+
+	sigHdr := abp.SignatureHeader{
+		BlockSize:   uint32(client.blockSize),
+		TotalBlocks: total,
+		FirstBlock:  first,
+	}
+	sendPacket(client, abp.HDR_DELTA,
+		abp.SerializeSignatures(sigHdr, client.signatures[first:last]))
+	armTimeout(client, idleTimeout)
+	// This doesn't change FSM state
+}
+
+// replaces the old version of the file with the reconstructed (and
+// already closed) one.
+func finishDelta(client *Client) bool {
+	client.basis.Close()
+	client.basis = nil
+	client.delta = nil
+
+	err := os.Rename(client.outPath, "./"+client.filename)
+	if err != nil {
+		client.logf("DELTA", "can't replace %s: %v\n", client.filename, err)
+		return false
+	}
+	client.outPath = "./" + client.filename
+	client.logf("DELTA", "%s reconstructed\n", client.filename)
+	return true
+}
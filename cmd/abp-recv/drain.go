@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// how often a draining receiver checks whether its transfers completed
+const DRAIN_CHECK_INTERVAL = time.Second
+
+// how long a draining receiver waits for its transfers, see -drain-timeout
+var drainTimeout time.Duration
+
+// set while the receiver drains: new transfers go to a successor started
+// from the binary on disk (see handoff), and once the ones in progress
+// completed the receiver exits. where there can't be a successor, new
+// transfers are turned away with BUSY, so their senders retry later, and
+// the receiver restarts in place instead. started by SIGHUP or the drain
+// command of the control port.
+var draining bool
+var drainStarted time.Time
+var drainTick <-chan time.Time
+
+// work a transfer leaves behind, e.g. extracting an archive, hashing a
+// file into the store or posting to the webhook; a restart waits for it
+var background sync.WaitGroup
+
+// runs f in the background, tracked by background.
+func goBackground(f func()) {
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		f()
+	}()
+}
+
+func startDrain(clients map[string]*Client) string {
+	if draining {
+		return "already draining\n"
+	}
+	draining = true
+	drainStarted = time.Now()
+	drainTick = time.NewTicker(DRAIN_CHECK_INTERVAL).C
+	n := pendingTransfers(clients)
+	// the successor binds them again
+	closeListeners()
+	h, err := startSuccessor(drainSockets)
+	if err != nil {
+		fmt.Printf("[DRAIN] can't start a successor: %v\n", err)
+		fmt.Printf("[DRAIN] turning new transfers away, restarting once "+
+			"%d transfer(s) completed\n", n)
+		return fmt.Sprintf("draining, %d transfer(s) left\n", n)
+	}
+	successor = h
+	fmt.Printf("[DRAIN] handing new transfers to %d, exiting once %d "+
+		"transfer(s) completed\n", h.pid, n)
+	return fmt.Sprintf("draining into %d, %d transfer(s) left\n", h.pid, n)
+}
+
+// transfers still running, or completed but kept around to repeat a lost
+// FIN ACK; the new process wouldn't know them.
+func pendingTransfers(clients map[string]*Client) int {
+	pending := 0
+	for _, client := range clients {
+		switch client.state {
+		case STATE_WAIT_DATA0, STATE_WAIT_DATA1, STATE_CLOSED0,
+			STATE_CLOSED1:
+			pending++
+		}
+	}
+	return pending
+}
+
+// exits (or restarts) a draining receiver once its transfers completed,
+// or when -drain-timeout is up.
+func checkDrained(clients map[string]*Client) {
+	n := pendingTransfers(clients)
+	if n > 0 && time.Since(drainStarted) < drainTimeout {
+		return
+	}
+	if n > 0 {
+		fmt.Printf("[DRAIN] giving up on %d transfer(s) after %v\n", n,
+			drainTimeout)
+		// closes their files and aborts their uploads
+		for _, client := range clients {
+			if client.state != STATE_CLIENT_DEAD {
+				removeClient(client)
+			}
+		}
+	}
+	fmt.Printf("[DRAIN] waiting for background work\n")
+	background.Wait()
+	if successor != nil {
+		fmt.Printf("[DRAIN] exiting, %d takes over\n", successor.pid)
+		os.Exit(0)
+	}
+	fmt.Printf("[DRAIN] restarting\n")
+	err := restartSelf()
+	// still here, keep serving
+	fmt.Printf("[DRAIN] can't restart: %v\n", err)
+	draining = false
+	drainTick = nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// unpack received tar archives, see -extract
+var extractArchives bool
+
+// the suffixes of the archives unpacked with -extract, and whether they
+// are gzipped
+var archiveSuffixes = []struct {
+	suffix string
+	gzip   bool
+}{
+	{".tar", false},
+	{".tar.gz", true},
+	{".tgz", true},
+}
+
+// unpacks a completed transfer into a directory named after it (e.g.
+// photos for photos.tar) and deletes the archive, if it is one. extracting
+// a large archive takes a while, so it happens in the background; the
+// transfer is complete either way, a failure only leaves the archive.
+func extractArchive(client *Client) {
+	for _, a := range archiveSuffixes {
+		if !strings.HasSuffix(client.filename, a.suffix) ||
+			len(client.filename) == len(a.suffix) {
+			continue
+		}
+		archive := client.filename
+		dir := strings.TrimSuffix(archive, a.suffix)
+		compressed := a.gzip
+		goBackground(func() {
+			n, err := untar("./"+archive, "./"+dir, compressed)
+			if err != nil {
+				client.logf("EXTRACT", "can't extract %s: %v\n", archive,
+					err)
+				return
+			}
+			os.Remove("./" + archive)
+			syncDir(".")
+			client.logf("EXTRACT", "%s extracted into %s/ (%d entries)\n",
+				archive, dir, n)
+		})
+		return
+	}
+}
+
+// unpacks the tar archive at path into the new directory dir and returns
+// the number of entries. only directories and regular files are created,
+// entries leaving dir are refused.
+func untar(path string, dir string, compressed bool) (int, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+	var r io.Reader = fh
+	if compressed {
+		gz, err := gzip.NewReader(fh)
+		if err != nil {
+			return 0, err
+		}
+		r = gz
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	tr := tar.NewReader(r)
+	n := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." ||
+			strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return n, fmt.Errorf("entry %s outside the archive", hdr.Name)
+		}
+		target := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = writeEntry(target, tr, os.FileMode(hdr.Mode).Perm())
+		default:
+			fmt.Printf("[EXTRACT] skipping %s, neither file nor "+
+				"directory\n", hdr.Name)
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+func writeEntry(path string, r io.Reader, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fh, r); err != nil {
+		fh.Close()
+		return err
+	}
+	if syncPolicy != SYNC_NONE {
+		fh.Sync()
+	}
+	return fh.Close()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"github.com/v4lli/go-abp/abp"
+)
+
+// shards of the FEC group currently being collected for a client
+type fecGroup struct {
+	flags  uint16
+	hdr    abp.FecHeader
+	shards [][]byte
+	have   int
+}
+
+// the last FEC group that was decoded and handed to the FSM
+type fecDone struct {
+	valid      bool
+	flags      uint16
+	generation uint8
+}
+
+// collects one shard of a FEC group. returns true once the packet should be
+// handed to the FSM; client.lastHdr and client.lastData then describe the
+// decoded group as if it had been a single data packet.
+func collectFecShard(client *Client, hdr abp.Header, payload []byte) bool {
+	fecHdr, ok := abp.ParseFecHeader(payload)
+	if !ok {
+		client.logf("FEC", "invalid FEC header from %v, discarding "+
+			"packet...\n", client.remoteAddr)
+		return false
+	}
+	flags := hdr.Flags &^ abp.HDR_FEC
+	shard := payload[abp.FecHeaderLength:]
+
+	done := &client.fecDone
+	if done.valid && done.flags == flags {
+		if done.generation == fecHdr.Generation {
+			// late shard of a group we already decoded; acking
+			// every one of them would only confuse the sender.
+			return false
+		}
+		// the sender retransmits a group we already decoded, i.e.
+		// our ACK got lost. let the FSM resend it once per generation.
+		done.generation = fecHdr.Generation
+		client.lastHdr = abp.Header{Flags: flags}
+		client.lastData = nil
+		return true
+	}
+
+	group := &client.fecGroup
+	if group.shards == nil || group.flags != flags ||
+		group.hdr.K != fecHdr.K || group.hdr.M != fecHdr.M ||
+		group.hdr.Total != fecHdr.Total {
+		// shards of a retransmitted generation are kept, the
+		// content of a group never changes.
+		*group = fecGroup{
+			flags:  flags,
+			hdr:    fecHdr,
+			shards: make([][]byte, int(fecHdr.K)+int(fecHdr.M)),
+		}
+	}
+
+	shardLen := abp.FecShardLength(int(fecHdr.Total), int(fecHdr.K))
+	if len(shard) > shardLen || group.shards[fecHdr.Index] != nil {
+		return false
+	}
+	padded := make([]byte, shardLen)
+	copy(padded, shard)
+	group.shards[fecHdr.Index] = padded
+	group.have++
+
+	k := int(fecHdr.K)
+	if group.have < k {
+		return false
+	}
+
+	lost := 0
+	for i := 0; i < k; i++ {
+		if group.shards[i] == nil {
+			lost++
+		}
+	}
+	if err := abp.FecReconstruct(group.shards, k); err != nil {
+		client.logf("FEC", "%v\n", err)
+		*group = fecGroup{}
+		return false
+	}
+	if lost > 0 {
+		client.logf("FEC", "recovered %d lost packet(s) of %v\n", lost,
+			client.remoteAddr)
+	}
+
+	data := make([]byte, 0, k*shardLen)
+	for i := 0; i < k; i++ {
+		data = append(data, group.shards[i]...)
+	}
+
+	*done = fecDone{valid: true, flags: flags, generation: fecHdr.Generation}
+	*group = fecGroup{}
+	// Length is left at 0, a group may carry more than 64k; the FSM
+	// handlers only look at lastData.
+	client.lastHdr = abp.Header{Flags: flags}
+	client.lastData = data[:fecHdr.Total]
+	return true
+}
@@ -0,0 +1,13 @@
+package main
+
+import "syscall"
+
+// returns the number of bytes available to unprivileged users in the
+// filesystem containing dir, or -1 if unknown.
+func freeSpace(dir string) int64 {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return -1
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize)
+}
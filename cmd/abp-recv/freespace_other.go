@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// free space isn't checked on this platform
+func freeSpace(dir string) int64 {
+	return -1
+}
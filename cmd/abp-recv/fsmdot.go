@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+var stateNames = [...]string{"WAIT_FILENAME", "WAIT_DATA0", "WAIT_DATA1",
+	"CLOSED0", "CLOSED1", "CLIENT_DEAD"}
+
+var eventNames = [...]string{"FILENAME", "DATA0", "DATA1", "FIN0", "FIN1",
+	"TIMEOUT", "SIGREQ"}
+
+func handlerName(handler func(*Client)) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// the state a handler leaves the client in, if it succeeds. handlers not
+// listed here don't change the state.
+func fsmTarget(state int, event int, handler string) int {
+	switch handler {
+	case "saveFilename":
+		return STATE_WAIT_DATA1
+	case "receiveData":
+		if state == STATE_WAIT_DATA1 {
+			return STATE_WAIT_DATA0
+		}
+		return STATE_WAIT_DATA1
+	case "receiveLastData":
+		if event == EVENT_FIN1 {
+			return STATE_CLOSED1
+		}
+		return STATE_CLOSED0
+	case "removeClient", "removeClientAndDelete":
+		return STATE_CLIENT_DEAD
+	}
+	return state
+}
+
+// prints the receiver FSM as implemented by fsmTable in the Graphviz dot
+// language, to compare it with the diagram in dia/. events leading from
+// one state to the same target with the same handler share an edge.
+func printFsmDot() {
+	initFsm()
+	type edge struct {
+		from, to int
+		handler  string
+	}
+	events := map[edge][]string{}
+	var edges []edge
+	for state := range fsmTable {
+		for event, handler := range fsmTable[state] {
+			if handler == nil {
+				continue
+			}
+			name := handlerName(handler)
+			e := edge{state, fsmTarget(state, event, name), name}
+			if events[e] == nil {
+				edges = append(edges, e)
+			}
+			events[e] = append(events[e], eventNames[event])
+		}
+	}
+	sort.SliceStable(edges, func(i, j int) bool {
+		return edges[i].from < edges[j].from
+	})
+
+	fmt.Printf("digraph receiver {\n")
+	fmt.Printf("\trankdir=LR;\n")
+	fmt.Printf("\tnode [shape=circle];\n")
+	fmt.Printf("\t%s [shape=doublecircle];\n", stateNames[STATE_WAIT_FILENAME])
+	fmt.Printf("\t%s [shape=box];\n", stateNames[STATE_CLIENT_DEAD])
+	for _, e := range edges {
+		fmt.Printf("\t%s -> %s [label=\"%s / %s\"];\n", stateNames[e.from],
+			stateNames[e.to], strings.Join(events[e], ", "), e.handler)
+	}
+	fmt.Printf("}\n")
+}
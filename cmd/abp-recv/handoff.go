@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+	"github.com/v4lli/go-abp/impair"
+)
+
+// how long forwarding a datagram to the successor may take before it is
+// dropped, like the network could have
+const FORWARD_TIMEOUT = 100 * time.Millisecond
+
+// the environment variable telling a successor how many sockets it
+// inherited, see startSuccessor
+const HANDOFF_ENV = "ABP_HANDOFF"
+
+// ... and the address STUN discovered for its predecessor
+const REFLEXIVE_ENV = "ABP_REFLEXIVE"
+
+// a draining receiver hands new transfers to its successor, the receiver
+// binary on disk started alongside it with the same arguments. the
+// successor inherits the sockets, but only reads them once this process
+// exited: until then, datagrams that don't belong to a transfer in
+// progress here are forwarded to it over a socket pair, and it replies
+// through the inherited sockets. nil while there is none.
+var successor *handoff
+
+type handoff struct {
+	pid     int
+	conn    net.Conn
+	sockets []*net.UDPConn
+}
+
+// the receiver's sockets, handed to a successor
+var drainSockets []*net.UDPConn
+
+// listeners closed on hand-off, so the successor can bind their addresses
+var listeners []net.Listener
+var listenersClosed int32
+
+func closeListeners() {
+	atomic.StoreInt32(&listenersClosed, 1)
+	for _, ln := range listeners {
+		ln.Close()
+	}
+	listeners = nil
+}
+
+// the stdout the receiver was started with, before -log-file took it
+var consoleOut = os.Stdout
+
+// whether a datagram belongs to a transfer of this receiver rather than
+// to a new one, which is the successor's. new paths and streams of a
+// transfer in progress come from new addresses, but carry its ID.
+func ownsDatagram(dgram datagram, clients map[string]*Client) bool {
+	addr := dgram.remoteAddr.String()
+	if client, ok := clients[addr]; ok && client.state != STATE_CLIENT_DEAD {
+		return true
+	}
+	if joinedClient(addr) != nil {
+		return true
+	}
+	hdr, payload, err := abp.ParsePacket(dgram.data, false)
+	if err != nil {
+		return false
+	}
+	var id string
+	switch hdr.Flags {
+	case abp.HDR_JOIN:
+		id = string(payload)
+	case abp.HDR_STREAM:
+		if len(payload) < 8 {
+			return false
+		}
+		id = string(payload[8:])
+	default:
+		return false
+	}
+	for _, client := range clients {
+		if client.id == id && client.state != STATE_CLIENT_DEAD {
+			return true
+		}
+	}
+	return false
+}
+
+// forwards a datagram as the index of the socket it arrived on, the
+// sender's address (IPv6, port) and the datagram itself. a successor that
+// went away leaves the new transfers to this receiver again.
+func (h *handoff) forward(dgram datagram) {
+	index := -1
+	for i, conn := range h.sockets {
+		if conn == dgram.conn {
+			index = i
+		}
+	}
+	if index < 0 {
+		return
+	}
+	msg := make([]byte, 19, 19+len(dgram.data))
+	msg[0] = byte(index)
+	copy(msg[1:17], dgram.remoteAddr.IP.To16())
+	binary.BigEndian.PutUint16(msg[17:19], uint16(dgram.remoteAddr.Port))
+	msg = append(msg, dgram.data...)
+	h.conn.SetWriteDeadline(time.Now().Add(FORWARD_TIMEOUT))
+	_, err := h.conn.Write(msg)
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return
+	}
+	if err != nil {
+		fmt.Printf("[DRAIN] successor %d is gone (%v), turning new "+
+			"transfers away\n", h.pid, err)
+		h.conn.Close()
+		successor = nil
+	}
+}
+
+// reads the datagrams its predecessor forwards until it exited, then the
+// inherited sockets themselves.
+func readForwarded(conn net.Conn, sockets []*net.UDPConn,
+	datagrams chan<- datagram, impairment *impair.Impairment) {
+	buffer := make([]byte, 65536+19)
+	for {
+		n, err := conn.Read(buffer)
+		if err == io.EOF || err == nil && n == 0 {
+			break
+		}
+		if err != nil {
+			fmt.Printf("[DRAIN] can't read from the predecessor: %v\n", err)
+			break
+		}
+		if n < 19 || int(buffer[0]) >= len(sockets) {
+			continue
+		}
+		remoteAddr := &net.UDPAddr{
+			IP:   append(net.IP(nil), buffer[1:17]...),
+			Port: int(binary.BigEndian.Uint16(buffer[17:19])),
+		}
+		if ip4 := remoteAddr.IP.To4(); ip4 != nil {
+			remoteAddr.IP = ip4
+		}
+		data := append([]byte(nil), buffer[19:n]...)
+		datagrams <- datagram{remoteAddr, data, sockets[buffer[0]]}
+	}
+	conn.Close()
+	fmt.Printf("[DRAIN] the predecessor exited, taking over\n")
+	for _, ser := range sockets {
+		go readDatagrams(ser, datagrams, impairment)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// how long /healthz waits for the main loop before declaring it stuck
+const HEALTH_TIMEOUT = 2 * time.Second
+
+// readiness requires at least this much free disk space, see
+// -ready-min-free
+var readyMinFree int64
+
+type healthStatus struct {
+	Status      string   `json:"status"`
+	Listening   []string `json:"listening"`
+	Sessions    int      `json:"sessions"`
+	MaxSessions int      `json:"max_sessions,omitempty"`
+	FreeBytes   int64    `json:"free_bytes"`
+	Reason      string   `json:"reason,omitempty"`
+}
+
+// what the main loop tells the health endpoints
+type loopState struct {
+	sessions int
+	draining bool
+}
+
+// serves /healthz and /readyz on addr. both ask the main loop for the
+// number of running transfers through sessions, so a stuck main loop
+// fails them.
+func serveHealth(addr string, sockets []*net.UDPConn,
+	sessions chan<- chan loopState) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	listeners = append(listeners, ln)
+	var listening []string
+	for _, socket := range sockets {
+		listening = append(listening, socket.LocalAddr().String())
+	}
+
+	check := func(ready bool) (int, healthStatus) {
+		status := healthStatus{Status: "ok", Listening: listening,
+			MaxSessions: maxSessions, FreeBytes: freeSpace(".")}
+		reply := make(chan loopState, 1)
+		var state loopState
+		select {
+		case sessions <- reply:
+			state = <-reply
+			status.Sessions = state.sessions
+		case <-time.After(HEALTH_TIMEOUT):
+			status.Status, status.Reason = "failing", "the main loop "+
+				"doesn't respond"
+			return http.StatusServiceUnavailable, status
+		}
+		if !ready {
+			return http.StatusOK, status
+		}
+		switch {
+		case state.draining:
+			status.Status, status.Reason = "draining", "restarting once "+
+				"the transfers in progress completed"
+		case maxSessions > 0 && status.Sessions >= maxSessions:
+			status.Status, status.Reason = "busy", "-max-sessions reached"
+		case status.FreeBytes >= 0 && status.FreeBytes < readyMinFree:
+			status.Status, status.Reason = "busy", "less free disk space "+
+				"than -ready-min-free"
+		default:
+			return http.StatusOK, status
+		}
+		return http.StatusServiceUnavailable, status
+	}
+	handler := func(ready bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			code, status := check(ready)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(status)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", handler(false))
+	mux.HandleFunc("/readyz", handler(true))
+	go func() {
+		err := http.Serve(ln, mux)
+		if atomic.LoadInt32(&listenersClosed) == 0 {
+			panic(err)
+		}
+	}()
+	return nil
+}
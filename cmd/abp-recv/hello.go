@@ -0,0 +1,78 @@
+package main
+
+import (
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// answers a HELLO packet with the capabilities of this receiver, so the
+// sender can pick the options and packet size before its FILENAME packet.
+// like ECHO packets, HELLO packets aren't part of a transfer.
+func answerHello(client *Client) {
+	sender, err := abp.ParseCapabilities(client.lastData)
+	if err == nil {
+		client.logf("NET", "HELLO from %v: %v\n", client.remoteAddr, sender)
+	}
+	caps := abp.Capabilities{
+		Options:     filenameOptions,
+		Window:      true,
+		Compression: abp.Compressions,
+		Checksums:   []string{"crc32q"},
+		MaxPayload:  abp.MaxPacketLength - abp.HeaderLength,
+		Extended: abp.EXT_NAME_PARTS | abp.EXT_STREAMS | abp.EXT_PATHS |
+			abp.EXT_PRIORITY,
+	}
+	if sender.Token != 0 {
+		caps.Extended |= abp.EXT_TOKEN
+		caps.Token = sender.Token
+	}
+	// the sender compresses with the first algorithm of its list we
+	// support, like Common picks it
+	compression := "gzip"
+	if common := sender.Common(caps).Compression; len(common) > 0 {
+		compression = common[0]
+	}
+	rememberHello(client.remoteAddr.String(), sender.Token, compression)
+	if storage != nil {
+		// a storage takes the data in order only
+		caps.Options &^= abp.HDR_DELTA
+		caps.Extended &^= abp.EXT_STREAMS
+	}
+	payload, _ := caps.MarshalBinary()
+	sendPacket(client, abp.HDR_HELLO, payload)
+}
+
+// what a HELLO agreed on, for the client its sender starts next
+type helloState struct {
+	token       uint32
+	compression string
+	at          time.Time
+}
+
+// HELLOs by their address, see takeHello
+var hellos = make(map[string]helloState)
+
+// remembers a sender's session token and compression; the HELLO isn't
+// part of a transfer, the client its FILENAME starts picks them up. the
+// state of senders that never followed up expires with -idle-timeout.
+func rememberHello(addr string, token uint32, compression string) {
+	for a, h := range hellos {
+		if time.Since(h.at) > idleTimeout {
+			delete(hellos, a)
+		}
+	}
+	hellos[addr] = helloState{token, compression, time.Now()}
+}
+
+// the session token a new client from addr seals its replies with, 0 if
+// its sender didn't say HELLO, and the compression of its payloads, gzip
+// without a HELLO.
+func takeHello(addr string) (uint32, string) {
+	h, ok := hellos[addr]
+	if !ok || time.Since(h.at) > idleTimeout {
+		return 0, "gzip"
+	}
+	delete(hellos, addr)
+	return h.token, h.compression
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// the algorithm a HELLO agreed on decompresses the payloads of the
+// transfer its sender starts next; without a HELLO, it's gzip.
+func TestHelloCompression(t *testing.T) {
+	t.Chdir(t.TempDir())
+	defer func(idle time.Duration) { idleTimeout = idle }(idleTimeout)
+	idleTimeout = time.Minute
+	initFsm()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	addr := sink.LocalAddr().(*net.UDPAddr)
+
+	data := bytes.Repeat([]byte("compressible "), 100)
+	for _, algorithm := range append([]string{""}, abp.Compressions...) {
+		clients := make(map[string]*Client)
+		send := func(flags uint16, payload []byte) {
+			processDatagram(addr, testPacket(flags, payload), clients, conn)
+		}
+		name := "plain"
+		if algorithm != "" {
+			name = algorithm
+			hello, err := abp.Capabilities{
+				Compression: []string{algorithm},
+			}.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			send(abp.HDR_HELLO, hello)
+		}
+		compression := algorithm
+		if compression == "" {
+			compression = "gzip"
+		}
+		compressed, err := abp.CompressPayload(compression, data, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		send(abp.HDR_FILENAME|abp.HDR_COMPRESSED, []byte(name))
+		send(abp.HDR_ALTERNATING|abp.HDR_COMPRESSED, compressed)
+		send(abp.HDR_FIN, nil)
+
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: received %q", name, got)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// append-only log of all transfers as JSON lines, see -journal
+var journalPath string
+var journalLock sync.Mutex
+
+// appends an event to the journal.
+func writeJournal(event transferEvent) {
+	if journalPath == "" {
+		return
+	}
+	line, _ := json.Marshal(event)
+	line = append(line, '\n')
+
+	journalLock.Lock()
+	defer journalLock.Unlock()
+	fh, err := os.OpenFile(journalPath,
+		os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Printf("[JOURNAL] can't open %s: %v\n", journalPath, err)
+		return
+	}
+	defer fh.Close()
+	if _, err := fh.Write(line); err != nil {
+		fmt.Printf("[JOURNAL] can't write %s: %v\n", journalPath, err)
+	}
+}
+
+// prints the journal as a table, oldest transfer first.
+func printHistory(path string) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	fmt.Printf("%-20s %-8s %-7s %-21s %12s %9s  %s\n", "TIME", "ID",
+		"STATUS", "SENDER", "SIZE", "DURATION", "FILENAME")
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		var event transferEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			fmt.Printf("skipping invalid entry: %s\n", scanner.Text())
+			continue
+		}
+		filename := event.Filename
+		if event.Error != "" {
+			filename += " (" + event.Error + ")"
+		}
+		fmt.Printf("%-20s %-8s %-7s %-21s %12d %8.1fs  %s\n",
+			event.Time.Local().Format("2006-01-02 15:04:05"), event.ID,
+			event.Status, event.Sender, event.Size, event.Duration, filename)
+	}
+	return scanner.Err()
+}
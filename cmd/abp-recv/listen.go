@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/v4lli/go-abp/impair"
+)
+
+// parses a listen address whose port may be a range (host:5000-5010) into
+// one address per port. an empty host listens on all interfaces.
+func parseListen(spec string) ([]*net.UDPAddr, error) {
+	host, ports, err := net.SplitHostPort(spec)
+	if err != nil {
+		return nil, err
+	}
+	var ip net.IP
+	if host != "" {
+		if ip = net.ParseIP(host); ip == nil {
+			addr, err := net.ResolveIPAddr("ip", host)
+			if err != nil {
+				return nil, err
+			}
+			ip = addr.IP
+		}
+	}
+
+	first, last := ports, ports
+	if i := strings.Index(ports, "-"); i >= 0 {
+		first, last = ports[:i], ports[i+1:]
+	}
+	from, err := strconv.Atoi(first)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", first)
+	}
+	to, err := strconv.Atoi(last)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", last)
+	}
+	if from < 1 || to > 65535 || from > to {
+		return nil, fmt.Errorf("invalid port range %s", ports)
+	}
+
+	var addrs []*net.UDPAddr
+	for port := from; port <= to; port++ {
+		addrs = append(addrs, &net.UDPAddr{IP: ip, Port: port})
+	}
+	return addrs, nil
+}
+
+type datagram struct {
+	remoteAddr *net.UDPAddr
+	data       []byte
+	conn       *net.UDPConn
+}
+
+// reads datagrams from one of the sockets, in batches where supported, and
+// hands them to the main loop, impaired if so configured.
+func readDatagrams(conn *net.UDPConn, datagrams chan<- datagram,
+	impairment *impair.Impairment) {
+	batch := newBatchReader(conn)
+	for {
+		dgrams, err := batch.read()
+		if err != nil {
+			panic(err)
+		}
+		for _, dgram := range dgrams {
+			remoteAddr := dgram.remoteAddr
+			impairment.Apply(dgram.data, func(data []byte) {
+				datagrams <- datagram{remoteAddr, data, conn}
+			})
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a log file that is rotated once it grows beyond maxSize bytes or gets
+// older than maxAge: log becomes log.1, log.1 becomes log.2 and so on,
+// keeping at most maxFiles old logs.
+type rotatingLog struct {
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	maxFiles int
+
+	fh     *os.File
+	size   int64
+	opened time.Time
+}
+
+func openRotatingLog(path string, maxSize int64, maxAge time.Duration,
+	maxFiles int) (*rotatingLog, error) {
+	l := &rotatingLog{path: path, maxSize: maxSize, maxAge: maxAge,
+		maxFiles: maxFiles}
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fh.Stat()
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+	// an existing log counts as opened now, its age is unknown
+	l.fh, l.size, l.opened = fh, info.Size(), time.Now()
+	return l, nil
+}
+
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	if l.size > 0 && ((l.maxSize > 0 && l.size+int64(len(p)) > l.maxSize) ||
+		(l.maxAge > 0 && time.Since(l.opened) > l.maxAge)) {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.fh.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *rotatingLog) rotate() error {
+	l.fh.Close()
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxFiles))
+	for i := l.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i),
+			fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if l.maxFiles > 0 {
+		os.Rename(l.path, l.path+".1")
+	}
+	fh, err := os.OpenFile(l.path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	l.fh, l.size, l.opened = fh, 0, time.Now()
+	return nil
+}
+
+// sends everything printed to stdout to the log instead. lines are
+// written whole, so a rotation never splits one.
+func logToFile(l *rotatingLog) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	os.Stdout = w
+	go func() {
+		lines := bufio.NewReader(r)
+		for {
+			line, err := lines.ReadBytes('\n')
+			if len(line) > 0 {
+				if _, err := l.Write(line); err != nil {
+					fmt.Fprintf(os.Stderr, "[LOG] can't write %s: %v\n",
+						l.path, err)
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// parses a size like 100MB, 512KB or 1000 (bytes). units are powers of
+// 1024, like -limit-rate of the sender.
+func parseSize(spec string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToUpper(spec), "B")
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1024
+	case strings.HasSuffix(s, "M"):
+		unit = 1024 * 1024
+	case strings.HasSuffix(s, "G"):
+		unit = 1024 * 1024 * 1024
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q, want e.g. 100MB", spec)
+	}
+	return int64(value * float64(unit)), nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+	"github.com/v4lli/go-abp/statsd"
+)
+
+// -statsd: counters and timings of the transfers
+var metrics statsd.Client
+
+// how often the number of running transfers is sent to -statsd
+const METRICS_INTERVAL = 10 * time.Second
+
+// sends the metrics of a finished ("ok") or failed transfer.
+func reportTransferMetrics(client *Client, status string) {
+	if !metrics.Enabled() || client.filename == "" ||
+		client.requestedOptions&(abp.HDR_DRY_RUN|abp.HDR_BENCH) != 0 {
+		return
+	}
+	if status == "ok" {
+		metrics.Count("transfers.ok", 1)
+	} else {
+		metrics.Count("transfers.failed", 1)
+	}
+	if client.sink != nil {
+		metrics.Count("bytes", client.sink.offset)
+	}
+	metrics.Timing("transfer_time", time.Since(client.started))
+}
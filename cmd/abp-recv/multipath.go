@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/v4lli/go-abp/abp"
+)
+
+// source addresses that joined a transfer started from another address
+// (see HDR_JOIN), by address. the clients map only holds the address the
+// FILENAME packet came from, so every transfer is listed once.
+var joinedPaths = make(map[string]*Client)
+
+// looks up the transfer a joined path belongs to; paths of finished
+// transfers are forgotten.
+func joinedClient(addr string) *Client {
+	client, ok := joinedPaths[addr]
+	if !ok {
+		return nil
+	}
+	if client.state == STATE_CLIENT_DEAD {
+		delete(joinedPaths, addr)
+		return nil
+	}
+	return client
+}
+
+// adds the sender of a JOIN packet (a new client so far) to the transfer
+// whose ID it carries and confirms it. replies go to the address the
+// latest packet of a transfer came from, so its sender may switch paths
+// any time.
+func joinTransfer(newClient *Client, id []byte,
+	clients map[string]*Client) {
+	addr := newClient.remoteAddr.String()
+	markDead(newClient)
+	delete(clients, addr)
+	for _, client := range clients {
+		if client.id != string(id) || client.filename == "" ||
+			(client.state != STATE_WAIT_DATA0 &&
+				client.state != STATE_WAIT_DATA1) {
+			continue
+		}
+		client.logf("NET", "path %v joined the transfer from %v\n", addr,
+			client.remoteAddr)
+		joinedPaths[addr] = client
+		client.remoteAddr = newClient.remoteAddr
+		sendPacket(client, abp.HDR_JOIN, id)
+		return
+	}
+	newClient.logf("NET", "%v wants to join unknown transfer %q, "+
+		"ignoring...\n", addr, id)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/v4lli/go-abp/abp"
+	"golang.org/x/text/unicode/norm"
+)
+
+// stored names are cut to the bytes most filesystems allow per name
+const maxStoredName = 255
+
+// collects a part of a name too long for the FILENAME packet (see
+// HDR_NAME), which precedes the part the FILENAME packet carries. parts
+// arrive in order; a repeated one, whose ACK got lost, is acknowledged
+// again.
+func receiveNamePart(client *Client, payload []byte) {
+	if client.state != STATE_WAIT_FILENAME || len(payload) < 2 {
+		return
+	}
+	offset := int(binary.BigEndian.Uint16(payload))
+	part := payload[2:]
+	if offset == len(client.namePrefix) {
+		if len(client.namePrefix)+len(part) > abp.MaxNameLength {
+			abortClient(client, abp.ERR_REFUSED)
+			return
+		}
+		client.namePrefix = append(client.namePrefix, part...)
+	} else if offset > len(client.namePrefix) {
+		// a part got lost, the sender repeats it
+		return
+	}
+	ack := make([]byte, 2)
+	binary.BigEndian.PutUint16(ack, uint16(len(client.namePrefix)))
+	sendPacket(client, abp.HDR_NAME, ack)
+}
+
+// normalizes a received name into one that can be stored: bytes that
+// aren't UTF-8 become U+FFFD, control characters (which also includes
+// terminal escape sequences) are dropped, the name is converted to NFC (so
+// a decomposed name from e.g. macOS is the same file as a composed one)
+// and names too long for the filesystem keep their end, i.e. the base name
+// and extension of a path.
+func normalizeFilename(name string) string {
+	name = strings.ToValidUTF8(name, string(utf8.RuneError))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = norm.NFC.String(name)
+	for len(name) > maxStoredName {
+		_, size := utf8.DecodeRuneInString(name)
+		name = name[size:]
+	}
+	return name
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+)
+
+// logs where the time of a completed transfer went: writing and syncing
+// the file, sending ACKs, the rest of handling its packets (checksums,
+// decompression, FEC) and waiting for the next packet, to tell a slow disk
+// from a slow link.
+func logPhases(client *Client) {
+	total := time.Since(client.started)
+	if total <= 0 || client.sink == nil {
+		return
+	}
+	handled := client.handleTime + time.Since(client.handling)
+	disk := client.sink.elapsed + client.syncTime
+	other := handled - disk - client.ackTime
+	if other < 0 {
+		other = 0
+	}
+	waiting := total - handled
+	if waiting < 0 {
+		waiting = 0
+	}
+	share := func(d time.Duration) float64 {
+		return 100 * d.Seconds() / total.Seconds()
+	}
+	client.logf("HANDLER", "time: disk %v (%.0f%%), ACKs %v (%.0f%%), "+
+		"processing %v (%.0f%%), waiting for packets %v (%.0f%%)\n",
+		disk.Round(time.Millisecond), share(disk),
+		client.ackTime.Round(time.Millisecond), share(client.ackTime),
+		other.Round(time.Millisecond), share(other),
+		waiting.Round(time.Millisecond), share(waiting))
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reserves size bytes for fh so the filesystem can allocate contiguous
+// extents; falls back to just setting the file size where fallocate isn't
+// supported.
+func preallocate(fh *os.File, size int64) error {
+	err := syscall.Fallocate(int(fh.Fd()), 0, 0, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return fh.Truncate(size)
+	}
+	return err
+}
+
+// deallocates the given range of a preallocated file, see fallocate(2)
+func punchHole(fh *os.File, offset int64, length int64) error {
+	const FALLOC_FL_KEEP_SIZE = 0x1
+	const FALLOC_FL_PUNCH_HOLE = 0x2
+	return syscall.Fallocate(int(fh.Fd()),
+		FALLOC_FL_PUNCH_HOLE|FALLOC_FL_KEEP_SIZE, offset, length)
+}
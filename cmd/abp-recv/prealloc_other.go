@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "os"
+
+// there's no portable fallocate, so just set the file size
+func preallocate(fh *os.File, size int64) error {
+	return fh.Truncate(size)
+}
+
+// holes can't be punched on this platform; the preallocated file was only
+// extended with Truncate, so it is sparse already
+func punchHole(fh *os.File, offset int64, length int64) error {
+	return nil
+}
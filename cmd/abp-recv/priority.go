@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// the priority class of the transfer a datagram from addr belongs to; new
+// senders are handled like normal transfers.
+func datagramPriority(addr string, clients map[string]*Client) int {
+	client := clients[addr]
+	if client == nil {
+		client = joinedClient(addr)
+	}
+	if client == nil {
+		return abp.PRIORITY_NORMAL
+	}
+	return client.priority
+}
+
+// takes the datagrams that arrived along with first and orders them by the
+// priority class of their transfers, so ACKs of small high priority files
+// don't wait behind a burst of bulk packets. datagrams of the same class
+// keep their order.
+func prioritize(first datagram, datagrams <-chan datagram,
+	clients map[string]*Client) []datagram {
+	batch := []datagram{first}
+	waiting := true
+	for waiting && len(batch) < cap(datagrams) {
+		select {
+		case dgram := <-datagrams:
+			batch = append(batch, dgram)
+		default:
+			waiting = false
+		}
+	}
+	if len(batch) == 1 {
+		return batch
+	}
+	priorities := make(map[string]int)
+	for _, dgram := range batch {
+		addr := dgram.remoteAddr.String()
+		priorities[addr] = datagramPriority(addr, clients)
+	}
+	sort.SliceStable(batch, func(i, j int) bool {
+		return priorities[batch[i].remoteAddr.String()] >
+			priorities[batch[j].remoteAddr.String()]
+	})
+	return batch
+}
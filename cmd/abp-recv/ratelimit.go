@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// new clients a source IP may start per second, and how many at once; see
+// -handshake-rate and -handshake-burst
+var handshakeRate float64
+var handshakeBurst int
+
+// how often rejected handshakes are reported, and idle buckets dropped
+const HANDSHAKE_REPORT_INTERVAL = 10 * time.Second
+
+// a token bucket per source IP, limiting how fast new clients are set up.
+// each new client (a FILENAME, ECHO or hash request from an unknown
+// address) takes a token; without one, the datagram is dropped before any
+// state is allocated, so a flood can't make the receiver churn through
+// files and sessions. answering would only help reflection attacks.
+type handshakeLimiter struct {
+	buckets map[string]*tokenBucket
+	// rejected handshakes since the last report, per IP, and in total
+	rejected   map[string]int
+	total      int64
+	lastReport time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var handshakes = &handshakeLimiter{
+	buckets:  make(map[string]*tokenBucket),
+	rejected: make(map[string]int),
+}
+
+// takes a token for a new client from ip, returns false if there is none.
+func (l *handshakeLimiter) allow(ip net.IP) bool {
+	if handshakeRate <= 0 {
+		return true
+	}
+	now := time.Now()
+	l.report(now)
+
+	key := ip.String()
+	b := l.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: float64(handshakeBurst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * handshakeRate
+	if b.tokens > float64(handshakeBurst) {
+		b.tokens = float64(handshakeBurst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		l.rejected[key]++
+		l.total++
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prints the rejected handshakes once per interval and forgets the
+// buckets that filled up again, so spoofed addresses don't pile up.
+func (l *handshakeLimiter) report(now time.Time) {
+	if now.Sub(l.lastReport) < HANDSHAKE_REPORT_INTERVAL {
+		return
+	}
+	if len(l.rejected) > 0 {
+		count := 0
+		for _, n := range l.rejected {
+			count += n
+		}
+		fmt.Printf("[LIMIT] rejected %d handshakes from %d addresses in "+
+			"the last %v (%d in total)\n", count, len(l.rejected),
+			now.Sub(l.lastReport).Round(time.Second), l.total)
+		l.rejected = make(map[string]int)
+	}
+	full := time.Duration(float64(handshakeBurst) / handshakeRate *
+		float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastReport = now
+}
@@ -0,0 +1,1347 @@
+package main
+
+import (
+	"bufio"
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+	"github.com/v4lli/go-abp/completion"
+	"github.com/v4lli/go-abp/dscp"
+	"github.com/v4lli/go-abp/impair"
+)
+
+// receiver states
+const (
+	STATE_WAIT_FILENAME = iota
+	STATE_WAIT_DATA0
+	STATE_WAIT_DATA1
+	STATE_CLOSED0
+	STATE_CLOSED1
+	STATE_CLIENT_DEAD
+)
+
+// receiver events
+const (
+	EVENT_FILENAME = iota
+	EVENT_DATA0
+	EVENT_DATA1
+	EVENT_FIN0
+	EVENT_FIN1
+	EVENT_TIMEOUT
+	EVENT_SIGREQ
+)
+
+type Client struct {
+	// short random ID of the transfer, in every log line about it and in
+	// its journal entry and webhook event
+	id           string
+	activeTimer  *time.Timer
+	filename     string
+	state        int
+	lastData     []byte
+	lastHdr      abp.Header
+	remoteAddr   *net.UDPAddr
+	conn         *net.UDPConn
+	writer       *bufio.Writer
+	fh           *os.File
+	lastOutFlags int
+	lastOutData  []byte
+	// the last ACK advertised a window, see ackData
+	lastOutWindow bool
+	fecGroup      fecGroup
+	fecDone       fecDone
+	lastFec       bool
+	// options requested with the FILENAME packet
+	requestedOptions uint16
+	announcedSize    int64
+	compact          bool
+	// the priority class announced with HDR_PRIORITY; datagrams of
+	// higher classes are handled first
+	priority int
+	// the leading parts of a name longer than the FILENAME packet
+	namePrefix []byte
+	// tokens for -limit-rate and -schedule
+	throttle throttle
+	// path the data is written to, differs from filename in delta mode
+	outPath string
+	// the file being received into -storage instead, and whether it is
+	// being committed after the FIN
+	stored     StoredFile
+	committing bool
+	// the latest HDR_HASH request, see sendHash
+	hash *hashRequest
+	// the session token replies are sealed with and the algorithm of
+	// compressed payloads, see answerHello
+	token       uint32
+	compression string
+	// the output file is written with WriteAt, possibly preallocated
+	sink         *offsetWriter
+	preallocated bool
+	// number of bytes to skip instead of writing lastData (sparse files)
+	lastSkip int64
+	// last fsync of the output file, for SYNC_INTERVAL
+	lastSync time.Time
+	// when the FILENAME packet was accepted
+	started time.Time
+	// delta transfer against the existing version of the file
+	basis      *os.File
+	blockSize  int
+	signatures []abp.BlockSignature
+	delta      *abp.DeltaDecoder
+	// running hash for checkpoints, see -checkpoint of the sender
+	checkpoint checkpoint
+	// packets received again because our ACK got lost or was late
+	retransmits int
+	// the transfer a parallel stream writes a range of (see HDR_STREAM),
+	// and for a transfer, how far its streams got
+	parent    *Client
+	streamEnd int64
+	// time spent handling its packets (the current one since handling),
+	// syncing its file and sending ACKs
+	handling   time.Time
+	handleTime time.Duration
+	syncTime   time.Duration
+	ackTime    time.Duration
+	// counts the timeouts armed, so the main loop can tell the current
+	// one from one that fired just before it was stopped or re-armed
+	timeoutArmed uint64
+}
+
+// the options (HDR_COMPACT, HDR_COMPRESSED, HDR_DELTA, HDR_SIZE, HDR_SKIP,
+// HDR_STORED_NAME, HDR_DRY_RUN, HDR_BENCH) a sender may request with its
+// FILENAME packet; the receiver supports all of them.
+const filenameOptions = abp.HDR_COMPACT | abp.HDR_COMPRESSED | abp.HDR_DELTA |
+	abp.HDR_SIZE | abp.HDR_SKIP | abp.HDR_STORED_NAME | abp.HDR_DRY_RUN |
+	abp.HDR_BENCH
+
+// the most a compressed payload may inflate to; a FEC group may carry up
+// to 255 shards of packet size, compressed at most 8x.
+const maxDecompressedLength = 8 * 255 * abp.MaxPacketLength
+
+// limit of concurrent transfers (0 = unlimited) and the back-off suggested
+// to senders turned away because of it
+var maxSessions int
+var busyRetryAfter time.Duration
+
+// how long a client may stay silent before its transfer is aborted, and how
+// long a completed transfer is kept around to repeat a lost FIN ACK
+var idleTimeout time.Duration
+var closeLinger time.Duration
+
+// never overwrite existing files, see -no-clobber
+var noClobber bool
+
+// assembles a packet from flags and payload, checksums it and sends it to
+// the client.
+func sendPacket(client *Client, flags int, payload []byte) {
+	hdr := abp.Header{Length: uint16(len(payload)), Flags: uint16(flags)}
+	serialize := abp.SerializeHeader
+	if client.compact && flags&abp.HDR_COMPACT == 0 {
+		// the ACK accepting compact headers is always sent in full
+		// since the sender doesn't know about our answer yet.
+		serialize = abp.SerializeCompactHeader
+	}
+	pkt := append(serialize(hdr), payload...)
+	abp.SetChecksum(pkt)
+	if client.token != 0 {
+		abp.SealChecksum(pkt, client.token)
+	}
+
+	start := time.Now()
+	_, err := client.conn.WriteToUDP(pkt, client.remoteAddr)
+	if err != nil {
+		panic(err)
+	}
+	client.ackTime += time.Since(start)
+}
+
+// 4 random bytes are plenty to tell concurrent transfers apart
+func newTransferID() string {
+	id := make([]byte, 4)
+	if _, err := cryptorand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// prints a log line about a client, tagged like all log lines and
+// prefixed with the transfer ID
+func (client *Client) logf(tag string, format string, args ...interface{}) {
+	fmt.Printf("["+tag+"] "+client.id+" "+format, args...)
+}
+
+func replyWithData(client *Client, flags int, payload []byte) {
+	sendPacket(client, flags, payload)
+	client.logf("NET", "ACK with flags=%v sent to %v\n", abp.Flags(flags),
+		*client.remoteAddr)
+
+	// save last flags in case we need to resend an ACK later
+	client.lastOutFlags = flags
+	client.lastOutData = payload
+	client.lastOutWindow = false
+
+	// timeout which will mark the client as dead; a closed transfer
+	// only lingers to repeat the FIN ACK
+	if client.state == STATE_CLOSED0 || client.state == STATE_CLOSED1 {
+		armTimeout(client, closeLinger)
+	} else {
+		armTimeout(client, idleTimeout)
+	}
+}
+
+// a timeout of a client, handed to the main loop by the timer so the FSM
+// only runs there, see fireTimeout
+type clientTimeout struct {
+	client  *Client
+	armed   uint64
+	setTime string
+}
+
+var timeouts = make(chan clientTimeout)
+
+func armTimeout(client *Client, timeout time.Duration) {
+	if client.activeTimer != nil {
+		client.activeTimer.Stop()
+	}
+	client.timeoutArmed++
+	t := clientTimeout{client, client.timeoutArmed,
+		time.Now().Format(time.StampMilli)}
+	client.activeTimer = time.AfterFunc(timeout, func() {
+		timeouts <- t
+	})
+}
+
+// runs the FSM for a timeout, unless the timer was stopped or re-armed
+// while the timeout was on its way to the main loop.
+func fireTimeout(t clientTimeout) {
+	client := t.client
+	if client.activeTimer == nil || client.timeoutArmed != t.armed {
+		return
+	}
+	client.logf("TIMER", "Timeout hit for client %s (state=%d), set "+
+		"at %s!\n", client.remoteAddr, client.state, t.setTime)
+	client.activeTimer = nil
+	fsmLookup(client.state, EVENT_TIMEOUT)(client)
+}
+
+// sanitize filename to prevent directory traversal
+func sanitizeFilename(name string) string {
+	name = strings.Replace(name, "/", ".", -1)
+	name = normalizeFilename(strings.Replace(name, "\\", ".", -1))
+	if name == "" || name == "." || name == ".." {
+		// not a name of a file
+		return "unnamed"
+	}
+	return name
+}
+
+func saveFilename(client *Client) {
+	// with HDR_SIZE, the file size precedes the name
+	name := client.lastData[:client.lastHdr.Length]
+	if client.requestedOptions&abp.HDR_SIZE != 0 && len(name) >= 8 {
+		client.announcedSize = int64(binary.BigEndian.Uint64(name))
+		name = name[8:]
+	}
+	// followed by the priority class with HDR_PRIORITY
+	if client.lastHdr.Flags&abp.HDR_PRIORITY != 0 && len(name) >= 1 {
+		client.priority = int(name[0])
+		name = name[1:]
+	}
+	client.filename = string(client.namePrefix) + string(name)
+	client.started = time.Now()
+	if client.requestedOptions&(abp.HDR_DRY_RUN|abp.HDR_BENCH) == 0 {
+		metrics.Count("transfers.started", 1)
+	}
+	client.logf("HANDLER", "filename=%s (len=%d, size=%d, %s)\n",
+		client.filename, len(client.filename), client.announcedSize,
+		abp.PriorityString(client.priority))
+
+	client.filename = sanitizeFilename(client.filename)
+
+	// refuse the transfer right away if it can't fit on the disk
+	if free := freeSpace("."); storage == nil && free >= 0 &&
+		client.announcedSize > free {
+		client.logf("HANDLER", "%s needs %d bytes, only %d available\n",
+			client.filename, client.announcedSize, free)
+		abortClient(client, abp.ERR_NO_SPACE)
+		return
+	}
+
+	if client.requestedOptions&abp.HDR_DRY_RUN != 0 {
+		dryRun(client)
+		return
+	}
+	if client.requestedOptions&abp.HDR_BENCH != 0 {
+		// benchmark data is thrown away
+		client.requestedOptions &^= abp.HDR_DELTA | abp.HDR_SKIP
+		client.sink = &offsetWriter{w: discard{}}
+		client.writer = bufio.NewWriterSize(client.sink, writeBufferSize)
+		client.state = STATE_WAIT_DATA1
+		client.compact = client.requestedOptions&abp.HDR_COMPACT != 0
+		replyWithData(client, int(client.requestedOptions),
+			[]byte(client.id))
+		return
+	}
+
+	if storage != nil {
+		saveToStorage(client)
+		return
+	}
+
+	client.outPath = "./" + client.filename
+	if client.requestedOptions&abp.HDR_DELTA != 0 &&
+		(noClobber || !openDeltaBasis(client)) {
+		// nothing to diff against (or nothing we may replace), the
+		// sender falls back to sending the whole file
+		client.requestedOptions &^= abp.HDR_DELTA
+	}
+
+	var err error
+	if noClobber {
+		client.fh, err = createUnique(client)
+	} else {
+		// the file may be linked to an object of the store, which
+		// mustn't be truncated along with it
+		if casStore {
+			os.Remove(client.outPath)
+		}
+		client.fh, err = os.Create(client.outPath)
+	}
+	if err != nil {
+		panic(err)
+	}
+	// data is written to the offsets it belongs to, which allows to skip
+	// the holes of sparse files. with a known size, the whole file is
+	// allocated up front.
+	client.sink = &offsetWriter{w: client.fh}
+	client.writer = bufio.NewWriterSize(client.sink, writeBufferSize)
+	if client.announcedSize > 0 {
+		err = preallocate(client.fh, client.announcedSize)
+		if err == syscall.ENOSPC {
+			client.fh.Close()
+			os.Remove(client.outPath)
+			abortClient(client, abp.ERR_NO_SPACE)
+			return
+		} else if err != nil {
+			client.logf("HANDLER", "can't preallocate %s: %v\n",
+				client.outPath, err)
+		} else {
+			client.preallocated = true
+		}
+	}
+	if client.basis != nil {
+		client.delta = abp.NewDeltaDecoder(client.basis, client.blockSize,
+			client.writer)
+	}
+	client.state = STATE_WAIT_DATA1
+
+	// acknowledge the requested options along with the filename. the
+	// transfer ID lets the sender add paths to the transfer.
+	client.compact = client.requestedOptions&abp.HDR_COMPACT != 0
+	replyWithData(client, int(client.requestedOptions), []byte(client.id))
+}
+
+// answers a FILENAME packet asking for a dry run: the checks above passed,
+// so acknowledge the options a real transfer would get without creating
+// the file. a repeated FILENAME packet starts over with a new client.
+func dryRun(client *Client) {
+	if client.requestedOptions&abp.HDR_DELTA != 0 {
+		info, err := os.Stat("./" + client.filename)
+		if noClobber || err != nil || !info.Mode().IsRegular() ||
+			info.Size() == 0 {
+			client.requestedOptions &^= abp.HDR_DELTA
+		}
+	}
+	client.logf("HANDLER", "dry run for %s from %v\n", client.filename,
+		client.remoteAddr)
+	sendPacket(client, int(client.requestedOptions), nil)
+	markDead(client)
+}
+
+func removeClient(client *Client) {
+	client.logf("HANDLER", "file %s written; set client to DEAD: %v\n",
+		client.filename, client.remoteAddr)
+
+	if client.writer != nil {
+		client.writer.Flush()
+		client.writer = nil
+	}
+	if client.fh != nil {
+		syncFile(client)
+		client.fh.Close()
+		client.fh = nil
+	}
+	if client.stored != nil {
+		client.stored.Abort()
+		client.stored = nil
+	}
+	if client.basis != nil {
+		client.basis.Close()
+		client.basis = nil
+	}
+	if client.activeTimer != nil {
+		client.activeTimer.Stop()
+		client.activeTimer = nil
+	}
+	client.state = STATE_CLIENT_DEAD
+}
+
+// number of clients currently transferring a file
+func activeSessions(clients map[string]*Client) int {
+	active := 0
+	for _, client := range clients {
+		if client.parent != nil {
+			// part of another transfer
+			continue
+		}
+		if client.state == STATE_WAIT_DATA0 || client.state == STATE_WAIT_DATA1 {
+			active++
+		}
+	}
+	return active
+}
+
+// turns a new client away because too many transfers are running. the
+// BUSY reply carries the number of seconds after which the sender should
+// retry; the client is marked as DEAD so its retry starts afresh.
+func rejectBusy(client *Client) {
+	client.logf("HANDLER", "too many sessions, sending BUSY to %v\n",
+		client.remoteAddr)
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(busyRetryAfter/time.Second))
+	sendPacket(client, abp.HDR_BUSY, payload)
+	markDead(client)
+}
+
+// aborts the transfer of a client with an HDR_ERROR packet carrying one of
+// the abp.ERR_* codes.
+func abortClient(client *Client, code uint16) {
+	client.logf("HANDLER", "aborting transfer of %v: %s\n",
+		client.remoteAddr, abp.ErrorMessage(code))
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, code)
+	sendPacket(client, abp.HDR_ERROR, payload)
+	markDead(client)
+	notifyTransfer(client, "failed", abp.ErrorMessage(code))
+}
+
+func markDead(client *Client) {
+	if client.activeTimer != nil {
+		client.activeTimer.Stop()
+		client.activeTimer = nil
+	}
+	client.state = STATE_CLIENT_DEAD
+}
+
+func removeClientAndDelete(client *Client) {
+	if client.parent != nil {
+		dropStream(client)
+		return
+	}
+	removeClient(client)
+	client.logf("HANDLER", "deleted partially received file\n")
+	os.Remove(client.outPath)
+	notifyTransfer(client, "failed", "transfer aborted")
+}
+
+func ignorePacket(client *Client) {
+	// This doesn't change FSM state
+}
+
+func resendAck(client *Client) {
+	client.retransmits++
+	if client.lastOutWindow {
+		// the window may have changed since, e.g. for a sender probing
+		// a closed one
+		ackData(client, client.lastOutFlags)
+		return
+	}
+	replyWithData(client, client.lastOutFlags, client.lastOutData)
+	// This doesn't change FSM state
+}
+
+// answers a repeated FILENAME packet after data was received already. the
+// ACK of the data stays the one to repeat for duplicates.
+func resendFilenameAck(client *Client) {
+	sendPacket(client, int(client.requestedOptions), nil)
+	// This doesn't change FSM state
+}
+
+// writes sequentially to a file using WriteAt
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+	// time spent in WriteAt
+	elapsed time.Duration
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := w.w.WriteAt(p, w.offset)
+	w.elapsed += time.Since(start)
+	w.offset += int64(n)
+	return n, err
+}
+
+type discard struct{}
+
+func (discard) WriteAt(p []byte, off int64) (int, error) {
+	return len(p), nil
+}
+
+// skips over a hole of a sparse file. a preallocated file gets the hole
+// punched into it, otherwise seeking ahead leaves it unallocated anyway.
+func skipData(client *Client) {
+	flushData(client)
+	if client.preallocated {
+		err := punchHole(client.fh, client.sink.offset, client.lastSkip)
+		if err != nil {
+			client.logf("HANDLER", "can't punch hole into %s: %v\n",
+				client.outPath, err)
+		}
+	}
+	client.sink.offset += client.lastSkip
+	if client.stored != nil {
+		// the storage fills the hole with zeros once data follows it,
+		// so a hole at the end of the file needs its last byte
+		client.stored.WriteAt([]byte{0}, client.sink.offset-1)
+	}
+}
+
+// writes the data of the last packet; returns false if the transfer was
+// aborted because it was invalid.
+func writeData(client *Client) bool {
+	// a regular packet was accepted in between two FEC groups, so the
+	// next group may carry the same alternating bit as the last one.
+	if !client.lastFec {
+		client.fecDone = fecDone{}
+	}
+
+	if client.lastSkip > 0 {
+		skipData(client)
+		return true
+	}
+
+	var err error
+	if client.delta != nil {
+		_, err = client.delta.Write(client.lastData)
+		if err != nil {
+			// the delta comes from the peer, so it's its transfer that
+			// fails, not the receiver
+			client.logf("DELTA", "invalid delta from %v: %v\n",
+				client.remoteAddr, err)
+			abortClient(client, abp.ERR_WRITE)
+			removeClient(client)
+			os.Remove(client.outPath)
+			return false
+		}
+	} else {
+		_, err = client.writer.Write(client.lastData)
+	}
+	// err is set if nn != len(client.lastData)
+	if err != nil {
+		panic(err)
+	}
+	throttleData(client, len(client.lastData))
+	syncData(client)
+	return true
+}
+
+func receiveLastData(client *Client) {
+	if client.parent != nil {
+		finishStream(client)
+		return
+	}
+	if client.committing {
+		// a retransmitted FIN, acknowledged once the file is stored
+		armTimeout(client, idleTimeout)
+		return
+	}
+	if !writeData(client) {
+		return
+	}
+	if client.delta != nil && !client.delta.Complete() {
+		client.logf("DELTA", "delta stream of %v ended prematurely\n",
+			client.remoteAddr)
+		removeClientAndDelete(client)
+		return
+	}
+
+	// the file may have changed size since it was announced, or end
+	// with a skipped hole. parallel streams wrote the ranges behind ours.
+	flushData(client)
+	if client.fh != nil {
+		end := client.sink.offset
+		if client.streamEnd > end {
+			end = client.streamEnd
+		}
+		client.fh.Truncate(end)
+	}
+	closeOutput(client)
+	if client.stored != nil {
+		commitStored(client)
+		return
+	}
+	completeTransfer(client)
+}
+
+// acknowledges the FIN of a transfer whose file is complete.
+func completeTransfer(client *Client) {
+	if client.delta != nil && !finishDelta(client) {
+		removeClientAndDelete(client)
+		return
+	}
+	syncDir(".")
+	logPhases(client)
+	notifyTransfer(client, "ok", "")
+	if casStore && client.requestedOptions&abp.HDR_BENCH == 0 {
+		storeFile(client)
+	}
+	if extractArchives && client.requestedOptions&abp.HDR_BENCH == 0 {
+		extractArchive(client)
+	}
+
+	if (client.lastHdr.Flags & abp.HDR_ALTERNATING) != 0 {
+		client.state = STATE_CLOSED1
+	} else {
+		client.state = STATE_CLOSED0
+	}
+
+	// tell the sender where its file ended up, if it asked for it
+	var storedName []byte
+	if client.requestedOptions&abp.HDR_STORED_NAME != 0 {
+		storedName = []byte(client.filename)
+	}
+	replyWithData(client, int(client.lastHdr.Flags), storedName)
+}
+
+// creates the output file under a name that doesn't exist yet; an existing
+// name gets a counter appended (report.pdf.1, report.pdf.2, ...).
+func createUnique(client *Client) (*os.File, error) {
+	name := client.filename
+	for i := 1; ; i++ {
+		fh, err := os.OpenFile("./"+name, os.O_RDWR|os.O_CREATE|os.O_EXCL,
+			0666)
+		if err == nil {
+			if name != client.filename {
+				client.logf("HANDLER", "%s exists, storing as %s\n",
+					client.filename, name)
+			}
+			client.filename = name
+			client.outPath = "./" + name
+			return fh, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		name = fmt.Sprintf("%s.%d", client.filename, i)
+	}
+}
+
+func receiveData(client *Client) {
+	if !writeData(client) {
+		return
+	}
+	if client.state == STATE_WAIT_DATA1 {
+		// If this was ACK1 we're now expecting DATA0 next, other
+		// packets will trigger an ACK1 retransmit
+		client.state = STATE_WAIT_DATA0
+		ackData(client, abp.HDR_ALTERNATING)
+	} else {
+		client.state = STATE_WAIT_DATA1
+		ackData(client, 0)
+	}
+	client.logf("HANDLER", "got data, new state=%d\n", client.state)
+}
+
+var fsmTable [5][7](func(*Client))
+
+func initFsm() {
+	fsmTable[STATE_WAIT_FILENAME][EVENT_DATA0] = removeClientAndDelete
+	// early data whose FILENAME packet got lost; the sender repeats both
+	fsmTable[STATE_WAIT_FILENAME][EVENT_DATA1] = ignorePacket
+	fsmTable[STATE_WAIT_FILENAME][EVENT_FILENAME] = saveFilename
+	fsmTable[STATE_WAIT_FILENAME][EVENT_FIN0] = removeClientAndDelete
+	fsmTable[STATE_WAIT_FILENAME][EVENT_FIN1] = ignorePacket
+	fsmTable[STATE_WAIT_FILENAME][EVENT_TIMEOUT] = removeClientAndDelete
+	fsmTable[STATE_WAIT_FILENAME][EVENT_SIGREQ] = removeClientAndDelete
+
+	fsmTable[STATE_WAIT_DATA0][EVENT_DATA0] = receiveData
+	fsmTable[STATE_WAIT_DATA0][EVENT_DATA1] = resendAck
+	fsmTable[STATE_WAIT_DATA0][EVENT_TIMEOUT] = removeClientAndDelete
+	fsmTable[STATE_WAIT_DATA0][EVENT_FIN0] = receiveLastData
+	// can't happen because we would already be in CLOSED1 if we
+	// already got a FIN1 -> error:
+	fsmTable[STATE_WAIT_DATA0][EVENT_FIN1] = removeClientAndDelete
+	// the FILENAME ACK got lost while the early data right behind the
+	// FILENAME packet was received
+	fsmTable[STATE_WAIT_DATA0][EVENT_FILENAME] = resendFilenameAck
+	// a late duplicate of a signature request, ignore it
+	fsmTable[STATE_WAIT_DATA0][EVENT_SIGREQ] = ignorePacket
+
+	fsmTable[STATE_WAIT_DATA1][EVENT_DATA0] = resendAck
+	fsmTable[STATE_WAIT_DATA1][EVENT_DATA1] = receiveData
+	fsmTable[STATE_WAIT_DATA1][EVENT_FILENAME] = resendAck
+	// can't happen because we would already be in CLOSED0 if we
+	// already got a FIN0 -> error:
+	fsmTable[STATE_WAIT_DATA1][EVENT_FIN0] = removeClientAndDelete
+	fsmTable[STATE_WAIT_DATA1][EVENT_FIN1] = receiveLastData
+	fsmTable[STATE_WAIT_DATA1][EVENT_TIMEOUT] = removeClientAndDelete
+	// signatures are requested before the first data packet
+	fsmTable[STATE_WAIT_DATA1][EVENT_SIGREQ] = sendSignatures
+
+	fsmTable[STATE_CLOSED0][EVENT_DATA0] = removeClientAndDelete
+	fsmTable[STATE_CLOSED0][EVENT_DATA1] = removeClientAndDelete
+	fsmTable[STATE_CLOSED0][EVENT_FILENAME] = removeClientAndDelete
+	fsmTable[STATE_CLOSED0][EVENT_FIN0] = resendAck
+	fsmTable[STATE_CLOSED0][EVENT_FIN1] = removeClientAndDelete
+	fsmTable[STATE_CLOSED0][EVENT_TIMEOUT] = removeClient
+	fsmTable[STATE_CLOSED0][EVENT_SIGREQ] = ignorePacket
+
+	fsmTable[STATE_CLOSED1][EVENT_DATA0] = removeClientAndDelete
+	fsmTable[STATE_CLOSED1][EVENT_DATA1] = removeClientAndDelete
+	// the same for a file that fit into the early data
+	fsmTable[STATE_CLOSED1][EVENT_FILENAME] = resendFilenameAck
+	fsmTable[STATE_CLOSED1][EVENT_FIN0] = removeClientAndDelete
+	fsmTable[STATE_CLOSED1][EVENT_FIN1] = resendAck
+	fsmTable[STATE_CLOSED1][EVENT_TIMEOUT] = removeClient
+	fsmTable[STATE_CLOSED1][EVENT_SIGREQ] = ignorePacket
+}
+
+func fsmLookup(state int, event int) func(*Client) {
+	return fsmTable[state][event]
+}
+
+func processDatagram(remoteAddr *net.UDPAddr, buffer []byte, clients map[string]*Client, conn *net.UDPConn) {
+	// look if we've already got one from this remoteAddr
+	if _, ok := clients[remoteAddr.String()]; ok {
+		//fmt.Printf("[NET] Already seen client %s\n",
+		//     remoteAddr.String())
+
+		// remove possibly dead client & retry
+		if clients[remoteAddr.String()].state == STATE_CLIENT_DEAD {
+			fmt.Printf("[NET] client %s dead, removing\n",
+				remoteAddr.String())
+			delete(clients, remoteAddr.String())
+			processDatagram(remoteAddr, buffer, clients, conn)
+			return
+		}
+	} else if joinedClient(remoteAddr.String()) == nil {
+		if !handshakes.allow(remoteAddr.IP) {
+			return
+		}
+		client := &Client{
+			id:         newTransferID(),
+			state:      STATE_WAIT_FILENAME,
+			conn:       conn,
+			remoteAddr: remoteAddr,
+			priority:   abp.PRIORITY_NORMAL,
+		}
+		client.token, client.compression = takeHello(remoteAddr.String())
+		clients[remoteAddr.String()] = client
+		armTimeout(client, idleTimeout)
+		client.logf("NET", "NEW client %v\n", remoteAddr)
+	}
+	client := clients[remoteAddr.String()]
+	if client == nil {
+		client = joinedClient(remoteAddr.String())
+	}
+	client.handling = time.Now()
+	defer func() { client.handleTime += time.Since(client.handling) }()
+
+	// XXX clean up dead clients periodically
+
+	// parse packet; fill client struct with seperated header + payload.
+	hdr, payload, err := abp.ParsePacket(buffer, client.compact)
+	if err != nil {
+		client.logf("NET", "%v from %v, discarding packet...\n", err,
+			remoteAddr)
+		return
+	}
+	// a new client may just add a path to a running transfer
+	if hdr.Flags == abp.HDR_JOIN {
+		if client.filename == "" {
+			joinTransfer(client, payload, clients)
+		} else {
+			client.remoteAddr = remoteAddr
+			sendPacket(client, abp.HDR_JOIN, payload)
+		}
+		return
+	}
+	// a parallel stream joining a transfer, or repeating its request
+	// because our answer got lost
+	if hdr.Flags == abp.HDR_STREAM {
+		if client.filename == "" {
+			joinStream(client, payload, clients)
+		} else if client.parent != nil {
+			sendPacket(client, abp.HDR_STREAM, payload)
+		}
+		return
+	}
+	// the transfer of a stream was aborted meanwhile
+	if client.parent != nil && client.fh != nil && client.parent.fh == nil {
+		payload := make([]byte, 2)
+		binary.BigEndian.PutUint16(payload, abp.ERR_CANCELED)
+		sendPacket(client, abp.HDR_ERROR, payload)
+		dropStream(client)
+		return
+	}
+	// the transfer's own session stays silent while its streams send
+	if client.parent != nil && (client.parent.state == STATE_WAIT_DATA0 ||
+		client.parent.state == STATE_WAIT_DATA1) {
+		armTimeout(client.parent, idleTimeout)
+	}
+	client.lastHdr = hdr
+	client.lastData = payload
+	// replies go to wherever the latest packet came from, which differs
+	// from the address the transfer started from if the sender switched
+	// paths
+	client.remoteAddr = remoteAddr
+
+	// FEC shards are collected until their group can be decoded, which is
+	// then handed to the FSM like a single data packet.
+	client.lastFec = hdr.Flags&abp.HDR_FEC != 0
+	if client.lastFec {
+		if !collectFecShard(client, hdr, client.lastData) {
+			return
+		}
+		hdr = client.lastHdr
+	}
+
+	// compressed payloads are inflated before they reach the FSM, which
+	// also acks them without HDR_COMPRESSED. retransmitted FEC groups
+	// come without data.
+	if hdr.Flags&abp.HDR_COMPRESSED != 0 && hdr.Flags&abp.HDR_FILENAME == 0 {
+		if len(client.lastData) > 0 {
+			data, err := abp.DecompressPayload(client.compression,
+				client.lastData, maxDecompressedLength)
+			if err != nil {
+				client.logf("NET", "can't decompress payload from %v "+
+					"(%v), discarding packet...\n", remoteAddr, err)
+				return
+			}
+			client.lastData = data
+		}
+		hdr.Flags &^= abp.HDR_COMPRESSED
+		client.lastHdr = hdr
+	}
+
+	// holes of sparse files arrive as the number of bytes to skip
+	client.lastSkip = 0
+	if hdr.Flags&abp.HDR_SKIP != 0 && hdr.Flags&abp.HDR_FILENAME == 0 {
+		if len(client.lastData) != 8 {
+			client.logf("NET", "invalid skip packet from %v, "+
+				"discarding...\n", remoteAddr)
+			return
+		}
+		client.lastSkip = int64(binary.BigEndian.Uint64(client.lastData))
+		client.lastData = nil
+		hdr.Flags &^= abp.HDR_SKIP
+		client.lastHdr = hdr
+	}
+
+	// FINs (may still contain data!)
+	if hdr.Flags == abp.HDR_FIN {
+		client.logf("FSM", "%s (state=%d) -> GOT_FIN0\n",
+			remoteAddr.String(), client.state)
+		fsmLookup(client.state, EVENT_FIN0)(client)
+		return
+	}
+	if hdr.Flags == (abp.HDR_FIN | abp.HDR_ALTERNATING) {
+		client.logf("FSM", "%s (state=%d) -> GOT_FIN1\n",
+			remoteAddr.String(), client.state)
+		fsmLookup(client.state, EVENT_FIN1)(client)
+		return
+	}
+
+	// FILENAME flag set + no ACK, possibly requesting options
+	if hdr.Flags&^(filenameOptions|abp.HDR_PRIORITY) == abp.HDR_FILENAME {
+		if client.state == STATE_WAIT_FILENAME && (draining ||
+			maxSessions > 0 && activeSessions(clients) >= maxSessions) {
+			rejectBusy(client)
+			return
+		}
+		// a repeated FILENAME packet is answered with the options
+		// accepted the first time
+		if client.state == STATE_WAIT_FILENAME {
+			client.requestedOptions = hdr.Flags & filenameOptions
+		}
+		client.logf("FSM", "%s -> GOT_FILENAME\n", remoteAddr.String())
+		fsmLookup(client.state, EVENT_FILENAME)(client)
+		return
+	}
+
+	// ECHO packets are answered right away to measure the round trip
+	// time; like hash requests, they aren't part of a transfer.
+	if hdr.Flags == abp.HDR_ECHO {
+		sendPacket(client, abp.HDR_ECHO, client.lastData)
+		if client.state == STATE_WAIT_FILENAME {
+			markDead(client)
+		}
+		return
+	}
+
+	if hdr.Flags == abp.HDR_NAME {
+		receiveNamePart(client, client.lastData)
+		return
+	}
+
+	if hdr.Flags == abp.HDR_HELLO {
+		answerHello(client)
+		if client.state == STATE_WAIT_FILENAME {
+			markDead(client)
+		}
+		return
+	}
+
+	// hash of a stored file requested to verify it, only new clients
+	// may ask. during a transfer, the sender asks for a checkpoint.
+	if hdr.Flags == abp.HDR_HASH {
+		switch client.state {
+		case STATE_WAIT_FILENAME:
+			sendHash(client)
+		case STATE_WAIT_DATA0, STATE_WAIT_DATA1:
+			sendCheckpoint(client)
+		}
+		return
+	}
+
+	// block signatures requested for a delta transfer
+	if hdr.Flags == abp.HDR_DELTA {
+		client.logf("FSM", "%s (state=%d) -> EVENT_SIGREQ\n",
+			remoteAddr.String(), client.state)
+		fsmLookup(client.state, EVENT_SIGREQ)(client)
+		return
+	}
+
+	// ACKs + data
+	if hdr.Flags == abp.HDR_ALTERNATING {
+		client.logf("FSM", "%s (state=%d) -> EVENT_DATA1\n",
+			remoteAddr.String(), client.state)
+		fsmLookup(client.state, EVENT_DATA1)(client)
+		return
+	}
+	if hdr.Flags == 0 {
+		client.logf("FSM", "%s (state=%d) -> EVENT_DATA0\n",
+			remoteAddr.String(), client.state)
+		fsmLookup(client.state, EVENT_DATA0)(client)
+		return
+	}
+}
+
+// the modes other than receiving, given as the first argument. their
+// options may follow the name, e.g. status -control addr.
+var subcommands = []string{"cancel", "cas-verify", "completion", "delete",
+	"drain", "fsm", "history", "list", "status"}
+
+func isSubcommand(arg string) bool {
+	for _, name := range subcommands {
+		if arg == name {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:1234", "address to listen "+
+		"on; a port range (e.g. :5000-5010) opens one socket per port")
+	flag.IntVar(&maxSessions, "max-sessions", 0, "maximum number of "+
+		"concurrent transfers, further senders are told to retry later "+
+		"(0 = unlimited)")
+	flag.DurationVar(&busyRetryAfter, "busy-retry-after", 5*time.Second,
+		"back-off suggested to senders turned away by -max-sessions or "+
+			"while draining")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 10*time.Second,
+		"abort transfers whose sender stays silent this long")
+	flag.DurationVar(&closeLinger, "close-linger", 10*time.Second,
+		"keep completed transfers this long to answer repeated FINs")
+	syncFlag := flag.String("sync", "always", "when to fsync received "+
+		"files: always (every packet), interval, close or none")
+	flag.DurationVar(&syncInterval, "sync-interval", time.Second,
+		"fsync interval for -sync=interval")
+	flag.IntVar(&writeBufferSize, "write-buffer", 256*1024, "bytes of "+
+		"received data collected before writing them to the file, unless "+
+		"a sync needs them on disk earlier (0 = write every packet)")
+	flag.Int64Var(&maxWindow, "window", 0, "most bytes a sender may "+
+		"send per packet, advertised in every data ACK along with the "+
+		"free disk space (0 = only limited by the disk)")
+	storageSpec := flag.String("storage", "", "receive files into "+
+		"dir:<path> or an S3 compatible bucket, s3://<bucket>[/<prefix>]"+
+		"[?endpoint=<url>&region=<region>] (credentials from "+
+		"AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY) instead of the "+
+		"current directory")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 10*time.Minute, "how "+
+		"long to wait for running transfers after SIGHUP or the drain "+
+		"command before restarting anyway")
+	limitRate := flag.String("limit-rate", "", "bandwidth each sender "+
+		"may use, e.g. 2MB/s, enforced through the window")
+	schedule := flag.String("schedule", "", "per sender bandwidth limits "+
+		"by time of day, e.g. \"limit 1MB/s 08:00-18:00, unlimited "+
+		"otherwise\"; -limit-rate applies outside of them")
+	flag.StringVar(&webhookURL, "webhook", "", "URL POSTed a JSON "+
+		"summary of every finished or failed transfer")
+	flag.StringVar(&journalPath, "journal", "", "file to append a JSON "+
+		"line per transfer to")
+	flag.BoolVar(&writeReports, "report", false, "write a JSON report "+
+		"with hash, size, sender and retransmissions next to every "+
+		"received file as <name>.abp-report.json")
+	flag.BoolVar(&extractArchives, "extract", false, "unpack received "+
+		"tar archives (.tar, .tar.gz, .tgz, e.g. from -tar of the "+
+		"sender) into a directory of the same name and delete them")
+	flag.BoolVar(&casStore, "cas", false, "also keep received files in "+
+		"a content-addressed store in .abp-cas, replacing identical files "+
+		"by links to one copy")
+	flag.BoolVar(&noClobber, "no-clobber", false, "never overwrite "+
+		"existing files, store them under a name with a counter appended "+
+		"instead")
+	flag.Float64Var(&handshakeRate, "handshake-rate", 20, "new transfers "+
+		"a source IP may start per second (0 = unlimited)")
+	flag.IntVar(&handshakeBurst, "handshake-burst", 50, "new transfers a "+
+		"source IP may start at once before -handshake-rate applies")
+	var impairment impair.Impairment
+	impairment.Register(flag.CommandLine)
+	metrics.Register(flag.CommandLine, "abp.recv")
+	control := flag.String("control", "", "address of the TCP control "+
+		"port for the status and cancel commands (e.g. 127.0.0.1:1235)")
+	healthAddr := flag.String("http", "", "address to serve the /healthz "+
+		"and /readyz endpoints on (e.g. :8080)")
+	readyMinFreeFlag := flag.String("ready-min-free", "0", "/readyz "+
+		"fails once less disk space is left, e.g. 1GB")
+	rendezvousServer := flag.String("rendezvous", "", "register with "+
+		"this rendezvous server (see abp-rendezvous), so senders behind a "+
+		"NAT can reach a receiver behind a NAT")
+	rendezvousName := flag.String("rendezvous-name", "", "name senders "+
+		"ask the -rendezvous server for")
+	dscpSpec := flag.String("dscp", "", "mark outgoing packets with this "+
+		"DSCP for QoS, by name (e.g. CS1 for scavenger traffic) or number")
+	stunServers := flag.String("stun", "", "ask these STUN servers "+
+		"(comma separated, two tell the NAT type) for the public address "+
+		"of the first socket and pass it on to -rendezvous")
+	logFile := flag.String("log-file", "", "write the log to this file "+
+		"instead of stdout")
+	logMaxSize := flag.String("log-max-size", "100MB", "rotate -log-file "+
+		"once it grows beyond this size (0 = never)")
+	logMaxAge := flag.Duration("log-max-age", 0, "rotate -log-file once "+
+		"it is older than this, e.g. 24h (0 = never)")
+	logMaxFiles := flag.Int("log-max-files", 5, "number of rotated logs "+
+		"to keep as -log-file.1, -log-file.2 and so on")
+	tui := flag.Bool("tui", false, "show a live table of the running "+
+		"transfers above the latest log lines instead of the plain log")
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [options] [unreliable]\n"+
+			"       %s -control <addr> status|cancel <id>|list "+
+			"[prefix]|delete <name>|drain\n"+
+			"       %s -journal <file> history\n"+
+			"       %s cas-verify\n"+
+			"       %s fsm\n"+
+			"       %s completion bash|zsh|fish\n", os.Args[0], os.Args[0],
+			os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	mode := ""
+	if isSubcommand(flag.Arg(0)) {
+		mode = flag.Arg(0)
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+
+	if mode == "completion" {
+		commands := make(map[string][]string)
+		for _, name := range subcommands {
+			commands[name] = nil
+		}
+		commands["completion"] = []string{"bash", "zsh", "fish"}
+		script, err := completion.Script(flag.Arg(0),
+			filepath.Base(os.Args[0]), flag.CommandLine,
+			map[string][]string{
+				"sync": {"always", "interval", "close", "none"},
+			}, commands)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(script)
+		return
+	}
+	switch mode {
+	case "fsm":
+		printFsmDot()
+		return
+	case "cas-verify":
+		if !verifyStore() {
+			os.Exit(1)
+		}
+		return
+	case "history":
+		if journalPath == "" {
+			fmt.Printf("history needs -journal\n")
+			os.Exit(1)
+		}
+		if err := printHistory(journalPath); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "status", "cancel", "list", "delete", "drain":
+		if *control == "" {
+			fmt.Printf("%s needs -control\n", mode)
+			os.Exit(1)
+		}
+		runControlCommand(*control, append([]string{mode},
+			flag.Args()...))
+		return
+	}
+
+	var err error
+	syncPolicy, err = parseSyncPolicy(*syncFlag)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	if *limitRate != "" {
+		clientRate, err = abp.ParseRate(*limitRate)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *schedule != "" {
+		rateSchedule, err = abp.ParseSchedule(*schedule)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+	}
+	throttleRate(time.Now())
+	if *storageSpec != "" {
+		if casStore || extractArchives || noClobber || writeReports {
+			fmt.Printf("-storage can't be combined with -cas, -extract, " +
+				"-no-clobber and -report\n")
+			os.Exit(1)
+		}
+		storage, err = parseStorage(*storageSpec)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+	}
+	if casStore {
+		if err := openStore(); err != nil {
+			fmt.Printf("can't open the store: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *tui && *logFile != "" {
+		fmt.Printf("-tui and -log-file can't be combined\n")
+		os.Exit(1)
+	}
+	if *logFile != "" {
+		maxSize, err := parseSize(*logMaxSize)
+		if err != nil {
+			fmt.Printf("invalid -log-max-size: %v\n", err)
+			os.Exit(1)
+		}
+		l, err := openRotatingLog(*logFile, maxSize, *logMaxAge, *logMaxFiles)
+		if err == nil {
+			err = logToFile(l)
+		}
+		if err != nil {
+			fmt.Printf("can't log to %s: %v\n", *logFile, err)
+			os.Exit(1)
+		}
+	}
+
+	initFsm()
+	clients := make(map[string]*Client)
+
+	addrs, err := parseListen(*listen)
+	if err != nil {
+		fmt.Printf("invalid -listen %s: %v\n", *listen, err)
+		os.Exit(1)
+	}
+	codePoint := -1
+	if *dscpSpec != "" {
+		if codePoint, err = dscp.Parse(*dscpSpec); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+	}
+	// a successor inherits the sockets of its predecessor, see handoff
+	sockets, forwarded, err := inheritedSockets()
+	if err != nil {
+		fmt.Printf("can't take over the sockets: %v\n", err)
+		os.Exit(1)
+	}
+	for _, addr := range addrs {
+		if forwarded != nil {
+			break
+		}
+		ser, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			fmt.Printf("Socket setup error: %v\n", err)
+			return
+		}
+		if codePoint >= 0 {
+			if err := dscp.Set(ser, codePoint); err != nil {
+				fmt.Printf("-dscp: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		sockets = append(sockets, ser)
+	}
+	drainSockets = sockets
+
+	// For demonstration purposes: drop, duplicate and delay some
+	// datagrams and flip some bits in the payload. all of it should be
+	// detected and lead to re-transmits. any extra argument enables the
+	// classic mix, unless -simulate-* flags are given.
+	if flag.NArg() > 0 && !impairment.Enabled() {
+		impairment = impair.Impairment{Loss: 0.1, Dup: 0.05, Corrupt: 0.05}
+	}
+	if impairment.Enabled() {
+		fmt.Print("Enabling packet loss simulation!\n")
+	}
+
+	// the STUN servers are asked before the socket's reader starts. a
+	// successor can't ask while its predecessor reads the socket, it
+	// learns the answer from it instead.
+	if forwarded != nil {
+		reflexiveAddr, _ = net.ResolveUDPAddr("udp", os.Getenv(REFLEXIVE_ENV))
+	} else if *stunServers != "" {
+		discoverReflexive(sockets[0], *stunServers)
+	}
+
+	// every socket has its own reader, the datagrams of all of them are
+	// processed here one by one
+	datagrams := make(chan datagram, 64)
+	if forwarded != nil {
+		go readForwarded(forwarded, sockets, datagrams, &impairment)
+	} else {
+		for _, ser := range sockets {
+			go readDatagrams(ser, datagrams, &impairment)
+		}
+	}
+
+	controlRequests := make(chan controlRequest)
+	if *control != "" {
+		if err := serveControl(*control, controlRequests); err != nil {
+			fmt.Printf("Control port setup error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Control port on %s\n", *control)
+	}
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	sessionCounts := make(chan chan loopState)
+	if *healthAddr != "" {
+		readyMinFree, err = parseSize(*readyMinFreeFlag)
+		if err != nil {
+			fmt.Printf("invalid -ready-min-free: %v\n", err)
+			os.Exit(1)
+		}
+		err = serveHealth(*healthAddr, sockets, sessionCounts)
+		if err != nil {
+			fmt.Printf("Health endpoint setup error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Health endpoints on http://%s/healthz and /readyz\n",
+			*healthAddr)
+	}
+
+	// a nil channel never fires
+	var metricsTick <-chan time.Time
+	if metrics.Enabled() {
+		metricsTick = time.NewTicker(METRICS_INTERVAL).C
+	}
+
+	var dash *dashboard
+	var tuiTick <-chan time.Time
+	if *tui {
+		if dash, err = startDashboard(); err != nil {
+			fmt.Printf("can't start the dashboard: %v\n", err)
+			os.Exit(1)
+		}
+		tuiTick = time.NewTicker(TUI_INTERVAL).C
+	}
+
+	// the first socket registers with the rendezvous server, whose
+	// datagrams are handled apart from those of the senders
+	var rendezvousAddr *net.UDPAddr
+	var rendezvousTick <-chan time.Time
+	if *rendezvousServer != "" {
+		if *rendezvousName == "" {
+			fmt.Printf("-rendezvous needs -rendezvous-name\n")
+			os.Exit(1)
+		}
+		rendezvousAddr, err = net.ResolveUDPAddr("udp", *rendezvousServer)
+		if err != nil {
+			fmt.Printf("invalid -rendezvous %s: %v\n", *rendezvousServer,
+				err)
+			os.Exit(1)
+		}
+		registerRendezvous(sockets[0], rendezvousAddr, *rendezvousName)
+		rendezvousTick = time.NewTicker(RENDEZVOUS_INTERVAL).C
+		fmt.Printf("Registered as %s with %v\n", *rendezvousName,
+			rendezvousAddr)
+	}
+
+	fmt.Printf("Waiting for clients on %s...\n", *listen)
+	for {
+		// blockingly wait for new datagrams or control commands
+		select {
+		case dgram := <-datagrams:
+			for _, dgram := range prioritize(dgram, datagrams, clients) {
+				if rendezvousAddr != nil &&
+					dgram.remoteAddr.IP.Equal(rendezvousAddr.IP) &&
+					dgram.remoteAddr.Port == rendezvousAddr.Port {
+					handleRendezvous(dgram.conn, dgram.data)
+					continue
+				}
+				if successor != nil && !ownsDatagram(dgram, clients) {
+					successor.forward(dgram)
+					continue
+				}
+				fmt.Printf("[NET] new message from %v\n",
+					dgram.remoteAddr)
+				processDatagram(dgram.remoteAddr, dgram.data, clients,
+					dgram.conn)
+			}
+		case t := <-timeouts:
+			fireTimeout(t)
+		case c := <-storedCommits:
+			finishStored(c)
+		case r := <-hashResults:
+			finishHash(r)
+		case req := <-controlRequests:
+			req.reply <- handleControl(req.command, clients)
+		case <-hangups:
+			startDrain(clients)
+		case <-drainTick:
+			checkDrained(clients)
+		case reply := <-sessionCounts:
+			reply <- loopState{activeSessions(clients), draining}
+		case <-metricsTick:
+			metrics.Gauge("transfers.active",
+				int64(activeSessions(clients)))
+		case <-tuiTick:
+			dash.draw(clients)
+		case <-rendezvousTick:
+			registerRendezvous(sockets[0], rendezvousAddr, *rendezvousName)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/v4lli/go-abp/rendezvous"
+	"github.com/v4lli/go-abp/stun"
+)
+
+// how often the receiver renews its registration with the rendezvous
+// server, which also keeps the mapping of its NAT alive
+const RENDEZVOUS_INTERVAL = 15 * time.Second
+
+// empty datagrams sent to a sender announced by the rendezvous server
+const RENDEZVOUS_PUNCHES = 3
+
+// the address of the first socket as seen by the -stun server, nil without
+var reflexiveAddr *net.UDPAddr
+
+// asks the STUN servers for the reflexive address of conn, which must not
+// be read from by anyone else yet, and prints it along with the type of
+// NAT in front of it.
+func discoverReflexive(conn *net.UDPConn, servers string) {
+	addrs, err := stun.ResolveServers(servers)
+	if err != nil {
+		fmt.Printf("[STUN] %v\n", err)
+		return
+	}
+	addr, nat, err := stun.Discover(conn, addrs)
+	if err != nil {
+		fmt.Printf("[STUN] %v\n", err)
+		return
+	}
+	reflexiveAddr = addr
+	fmt.Printf("[STUN] reachable at %v, %s\n", addr, nat)
+}
+
+// registers conn with the rendezvous server under name.
+func registerRendezvous(conn *net.UDPConn, server *net.UDPAddr,
+	name string) {
+	_, err := conn.WriteToUDP(rendezvous.Register(name, rendezvous.RoleRecv,
+		reflexiveAddr), server)
+	if err != nil {
+		fmt.Printf("[RENDEZVOUS] registering with %v failed: %v\n", server,
+			err)
+	}
+}
+
+// handles a datagram of the rendezvous server: a sender wants to connect,
+// so a few empty datagrams are sent to it, which lets its packets pass
+// our NAT. it discards them. if the sender's STUN server saw another
+// address, that one is tried as well.
+func handleRendezvous(conn *net.UDPConn, data []byte) {
+	peer, reflexive, err := rendezvous.ParsePeer(data)
+	if err != nil {
+		fmt.Printf("[RENDEZVOUS] %v\n", err)
+		return
+	}
+	fmt.Printf("[RENDEZVOUS] sender %v is coming, punching a hole\n", peer)
+	targets := []*net.UDPAddr{peer}
+	if rendezvous.Symmetric(peer, reflexive) {
+		fmt.Printf("[RENDEZVOUS] the sender is behind a symmetric NAT (STUN "+
+			"saw %v), its packets probably won't get through\n", reflexive)
+		targets = append(targets, reflexive)
+	}
+	for i := 0; i < RENDEZVOUS_PUNCHES; i++ {
+		for _, target := range targets {
+			conn.WriteToUDP(nil, target)
+		}
+	}
+}
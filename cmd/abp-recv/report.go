@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// write <name>.abp-report.json next to every received file, see -report
+var writeReports bool
+
+// the journal entry of a completed transfer plus the details a consumer
+// of the file may want to check its provenance with
+type transferReport struct {
+	transferEvent
+	AnnouncedSize int64 `json:"announced_size,omitempty"`
+	Retransmits   int   `json:"retransmits"`
+	Checkpoints   int64 `json:"checkpoint_bytes,omitempty"`
+	// "ok" if the size matches the one announced by the sender,
+	// "size mismatch" if it doesn't and "none" if none was announced
+	Verification string `json:"verification"`
+}
+
+// collects the details of a transfer; the hash is added by write.
+func newTransferReport(client *Client) *transferReport {
+	if !writeReports {
+		return nil
+	}
+	r := &transferReport{AnnouncedSize: client.announcedSize,
+		Retransmits: client.retransmits,
+		Checkpoints: client.checkpoint.hashed, Verification: "none"}
+	if client.requestedOptions&abp.HDR_SIZE != 0 && client.sink != nil {
+		r.Verification = "ok"
+		if client.sink.offset != client.announcedSize {
+			r.Verification = "size mismatch"
+		}
+	}
+	return r
+}
+
+// writes the report of the file received at path.
+func (r *transferReport) write(path string, event transferEvent) {
+	if r == nil {
+		return
+	}
+	r.transferEvent = event
+	data, _ := json.MarshalIndent(r, "", "  ")
+	data = append(data, '\n')
+	reportPath := path + ".abp-report.json"
+	if err := ioutil.WriteFile(reportPath, data, 0644); err != nil {
+		fmt.Printf("[REPORT] can't write %s: %v\n", reportPath, err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// replaces the process with the receiver binary on disk, started with the
+// same arguments. the sockets are closed on exec and bound again by the
+// new binary.
+func restartSelf() error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(self, os.Args, os.Environ())
+}
+
+// starts the receiver binary on disk with the same arguments as the
+// successor taking new transfers. it inherits the sockets as fd 3 on,
+// followed by its end of the socket pair datagrams are forwarded over.
+func startSuccessor(sockets []*net.UDPConn) (*handoff, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	pair, err := syscall.Socketpair(syscall.AF_UNIX,
+		syscall.SOCK_SEQPACKET|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	ours := os.NewFile(uintptr(pair[0]), "handoff")
+	theirs := os.NewFile(uintptr(pair[1]), "handoff")
+	defer theirs.Close()
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, ser := range sockets {
+		f, err := ser.File()
+		if err != nil {
+			ours.Close()
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	cmd := exec.Command(self, os.Args[1:]...)
+	cmd.Env = append(os.Environ(),
+		HANDOFF_ENV+"="+strconv.Itoa(len(sockets)))
+	if reflexiveAddr != nil {
+		cmd.Env = append(cmd.Env, REFLEXIVE_ENV+"="+reflexiveAddr.String())
+	}
+	cmd.Stdout = consoleOut
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, theirs)
+	if err := cmd.Start(); err != nil {
+		ours.Close()
+		return nil, err
+	}
+	// reaped if it exits before this process does
+	go cmd.Wait()
+
+	conn, err := net.FileConn(ours)
+	ours.Close()
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+	return &handoff{cmd.Process.Pid, conn, sockets}, nil
+}
+
+// the sockets inherited from a predecessor and the connection it forwards
+// datagrams over, nil if the receiver wasn't started by one.
+func inheritedSockets() ([]*net.UDPConn, net.Conn, error) {
+	n, err := strconv.Atoi(os.Getenv(HANDOFF_ENV))
+	if err != nil {
+		return nil, nil, nil
+	}
+	// the successor's own successor gets its sockets afresh
+	os.Unsetenv(HANDOFF_ENV)
+	defer os.Unsetenv(REFLEXIVE_ENV)
+	var sockets []*net.UDPConn
+	for i := 0; i <= n; i++ {
+		f := os.NewFile(uintptr(3+i), "inherited")
+		if i == n {
+			conn, err := net.FileConn(f)
+			f.Close()
+			return sockets, conn, err
+		}
+		conn, err := net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		ser, ok := conn.(*net.UDPConn)
+		if !ok {
+			return nil, nil, fmt.Errorf("inherited fd %d isn't a UDP "+
+				"socket", 3+i)
+		}
+		sockets = append(sockets, ser)
+	}
+	return sockets, nil, nil
+}
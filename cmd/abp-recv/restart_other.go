@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// restarting in place isn't supported on this platform
+func restartSelf() error {
+	return errors.New("restarting isn't supported on this platform")
+}
+
+// neither is handing off to a successor
+func startSuccessor(sockets []*net.UDPConn) (*handoff, error) {
+	return nil, errors.New("handing off isn't supported on this platform")
+}
+
+func inheritedSockets() ([]*net.UDPConn, net.Conn, error) {
+	return nil, nil, nil
+}
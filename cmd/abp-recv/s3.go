@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// files are uploaded to S3 in parts of this size; a file up to this size
+// is uploaded with a single PUT once it is complete
+const S3_PART_SIZE = 8 << 20
+
+// parts of a file waiting for their upload before its sender is told to
+// pause, and before a sender that doesn't pause fails the file
+const (
+	S3_PENDING_PARTS = 2
+	S3_QUEUED_PARTS  = 8
+)
+
+var (
+	errOutOfOrder = errors.New("data written out of order")
+	errBacklog    = errors.New("upload too far behind the sender")
+	errAborted    = errors.New("upload aborted")
+)
+
+// an S3 compatible object store, see -storage s3://. files are stored as
+// <prefix>/<name> and uploaded while they are received, so there is no
+// intermediate file; larger ones are uploaded in parts in the background.
+// requests are signed with AWS signature version 4, with the credentials
+// from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+// buckets are addressed by path (https://<endpoint>/<bucket>/<key>), which
+// S3 compatible stores support as well.
+type s3Storage struct {
+	endpoint  string
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	token     string
+	client    *http.Client
+}
+
+func newS3Storage(bucket string, prefix string, endpoint string,
+	region string) (*s3Storage, error) {
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	s := &s3Storage{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		region:    region,
+		bucket:    bucket,
+		prefix:    prefix,
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		client:    &http.Client{Timeout: time.Minute},
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("S3 storage needs AWS_ACCESS_KEY_ID and " +
+			"AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+// sends a signed request for key of the bucket and returns the body of
+// the response, an error if it isn't a success.
+func (s *s3Storage) request(method string, key string, query url.Values,
+	body []byte) ([]byte, error) {
+	path := "/" + awsEscape(s.bucket, true) + "/" + awsEscape(key, false)
+	rawQuery := canonicalQuery(query)
+	target := s.endpoint + path
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+	sum := sha256.Sum256(body)
+	signV4(req, path, rawQuery, hex.EncodeToString(sum[:]), s.region,
+		s.accessKey, s.secretKey, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	reply, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var s3err struct {
+			Code    string
+			Message string
+		}
+		xml.Unmarshal(reply, &s3err)
+		return nil, fmt.Errorf("%s %s: %s (%s %s)", method, key,
+			resp.Status, s3err.Code, s3err.Message)
+	}
+	if method == http.MethodPut {
+		// the ETag of an uploaded part, needed to complete the upload
+		return []byte(resp.Header.Get("ETag")), nil
+	}
+	return reply, nil
+}
+
+// signs req with AWS signature version 4: an HMAC of the canonical form of
+// the request (with all headers set so far) under a key derived from the
+// secret, the date, the region and the service.
+func signV4(req *http.Request, path string, rawQuery string,
+	payloadHash string, region string, accessKey string, secretKey string,
+	now time.Time) {
+	date := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(
+			strings.Join(values, ","))
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	fmt.Fprintf(&canonical, "%s\n%s\n%s\n", req.Method, path, rawQuery)
+	for _, name := range names {
+		fmt.Fprintf(&canonical, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+	fmt.Fprintf(&canonical, "\n%s\n%s", signedHeaders, payloadHash)
+
+	scope := date[:8] + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" +
+		hex.EncodeToString(hash[:])
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date[:8], region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 "+
+		"Credential=%s/%s, SignedHeaders=%s, Signature=%x", accessKey,
+		scope, signedHeaders, hmacSHA256(key, toSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapes everything but unreserved characters (and, in keys, the slashes
+// between their parts), as the canonical request demands.
+func awsEscape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' ||
+			'0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 ||
+			c == '/' && !escapeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, awsEscape(key, true)+"="+
+				awsEscape(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// a file being uploaded. data is collected until a part is full, which is
+// handed to a goroutine doing all the requests of the file, so the
+// receiver never waits for S3: while parts pile up, Backlogged closes the
+// window of this sender only. upload errors are reported by Commit.
+type s3File struct {
+	s   *s3Storage
+	key string
+	buf []byte
+	// bytes written so far
+	end int64
+	err error
+	// parts handed to the uploader, counted until they are uploaded;
+	// nil ends the file, see finish
+	parts   chan []byte
+	pending int32
+	// whether the upload is thrown away once the parts are through
+	aborted int32
+	done    chan error
+}
+
+func (s *s3Storage) Create(name string) (StoredFile, error) {
+	key := name
+	if s.prefix != "" {
+		key = s.prefix + "/" + name
+	}
+	f := &s3File{s: s, key: key,
+		parts: make(chan []byte, S3_QUEUED_PARTS),
+		done:  make(chan error, 1)}
+	goBackground(f.upload)
+	return f, nil
+}
+
+func (f *s3File) WriteAt(p []byte, off int64) (int, error) {
+	if f.err != nil {
+		// reported by Commit
+		return len(p), nil
+	}
+	if off < f.end {
+		f.err = errOutOfOrder
+		return len(p), nil
+	}
+	// a skipped hole
+	for off > f.end {
+		gap := off - f.end
+		if gap > S3_PART_SIZE {
+			gap = S3_PART_SIZE
+		}
+		f.write(make([]byte, gap))
+	}
+	f.write(p)
+	return len(p), nil
+}
+
+func (f *s3File) write(p []byte) {
+	f.buf = append(f.buf, p...)
+	f.end += int64(len(p))
+	for f.err == nil && len(f.buf) >= S3_PART_SIZE {
+		part := f.buf[:S3_PART_SIZE]
+		f.buf = append([]byte(nil), f.buf[S3_PART_SIZE:]...)
+		f.queuePart(part)
+	}
+}
+
+// hands a part to the uploader without waiting: a sender ignoring the
+// closed window fails the file once the queue is full.
+func (f *s3File) queuePart(part []byte) {
+	atomic.AddInt32(&f.pending, 1)
+	select {
+	case f.parts <- part:
+	default:
+		atomic.AddInt32(&f.pending, -1)
+		f.err = errBacklog
+	}
+}
+
+// whether the uploader is behind, so the sender should pause.
+func (f *s3File) Backlogged() bool {
+	return atomic.LoadInt32(&f.pending) >= S3_PENDING_PARTS
+}
+
+// uploads the parts of the file until finish. the first full part starts
+// a multipart upload; a file ending before that is uploaded with a single
+// PUT of its last part.
+func (f *s3File) upload() {
+	var uploadID string
+	var etags []string
+	var err error
+	multipart := false
+	for part := range f.parts {
+		last := part == nil
+		if err == nil && atomic.LoadInt32(&f.aborted) == 0 {
+			if last && !multipart {
+				_, err = f.s.request(http.MethodPut, f.key, nil, f.buf)
+			} else if !last || len(f.buf) > 0 {
+				if last {
+					part = f.buf
+				}
+				if !multipart {
+					uploadID, err = f.startUpload()
+					multipart = err == nil
+				}
+				if err == nil {
+					var etag []byte
+					etag, err = f.s.request(http.MethodPut, f.key,
+						url.Values{
+							"partNumber": {fmt.Sprint(len(etags) + 1)},
+							"uploadId":   {uploadID}}, part)
+					etags = append(etags, string(etag))
+				}
+			}
+		}
+		if !last {
+			atomic.AddInt32(&f.pending, -1)
+			continue
+		}
+		if err == nil && atomic.LoadInt32(&f.aborted) != 0 {
+			err = errAborted
+		}
+		if err == nil && multipart {
+			err = f.completeUpload(uploadID, etags)
+		}
+		if err != nil && multipart {
+			f.s.request(http.MethodDelete, f.key,
+				url.Values{"uploadId": {uploadID}}, nil)
+		}
+		f.done <- err
+		return
+	}
+}
+
+func (f *s3File) startUpload() (string, error) {
+	reply, err := f.s.request(http.MethodPost, f.key,
+		url.Values{"uploads": {""}}, nil)
+	var started struct{ UploadId string }
+	if err == nil {
+		err = xml.Unmarshal(reply, &started)
+	}
+	return started.UploadId, err
+}
+
+func (f *s3File) completeUpload(uploadID string, etags []string) error {
+	var complete strings.Builder
+	complete.WriteString("<CompleteMultipartUpload>")
+	for i, etag := range etags {
+		fmt.Fprintf(&complete, "<Part><PartNumber>%d</PartNumber>"+
+			"<ETag>%s</ETag></Part>", i+1, etag)
+	}
+	complete.WriteString("</CompleteMultipartUpload>")
+	reply, err := f.s.request(http.MethodPost, f.key,
+		url.Values{"uploadId": {uploadID}}, []byte(complete.String()))
+	if err == nil && bytes.Contains(reply, []byte("<Error>")) {
+		// completing can fail after the 200 OK has been sent
+		err = fmt.Errorf("completing %s failed: %s", f.key, reply)
+	}
+	return err
+}
+
+// tells the uploader the file is complete. the remainder in buf is only
+// read by the uploader from now on.
+func (f *s3File) finish() {
+	if f.parts != nil {
+		// the queue may be full, but the uploader is draining it
+		go func(parts chan []byte) { parts <- nil }(f.parts)
+		f.parts = nil
+	}
+}
+
+// waits for the upload, so the receiver calls it in the background, see
+// commitStored.
+func (f *s3File) Commit() error {
+	if f.err != nil {
+		f.Abort()
+		return f.err
+	}
+	f.finish()
+	return <-f.done
+}
+
+// throws the upload away in the background.
+func (f *s3File) Abort() error {
+	atomic.StoreInt32(&f.aborted, 1)
+	f.finish()
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// Storage is where received files end up if it isn't the current
+// directory, see -storage. It only needs to take the data of a file in
+// order, so transfers into a Storage can't be delta transfers or use
+// parallel streams.
+type Storage interface {
+	// Create starts receiving the file name, which only shows up in the
+	// storage (replacing an older one) once it is committed.
+	Create(name string) (StoredFile, error)
+}
+
+// StoredFile is a file being received into a Storage. Errors of WriteAt
+// may as well be reported by Commit only.
+type StoredFile interface {
+	// WriteAt writes p at off. Data is written in order, but skipped
+	// holes (see HDR_SKIP) leave gaps, which read as zeros.
+	WriteAt(p []byte, off int64) (int, error)
+	// Commit stores the complete file under its name.
+	Commit() error
+	// Abort throws the file away.
+	Abort() error
+}
+
+// the storage files are received into, nil for the current directory
+var storage Storage
+
+// parses -storage: dir:<path> or s3://<bucket>[/<prefix>][?endpoint=<url>
+// &region=<region>].
+func parseStorage(spec string) (Storage, error) {
+	if strings.HasPrefix(spec, "dir:") {
+		dir := strings.TrimPrefix(spec, "dir:")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		return diskStorage(dir), nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid storage %q, want dir:<path> or "+
+			"s3://<bucket>[/<prefix>]", spec)
+	}
+	s3, err := newS3Storage(u.Host, strings.Trim(u.Path, "/"),
+		u.Query().Get("endpoint"), u.Query().Get("region"))
+	if err != nil {
+		return nil, err
+	}
+	return s3, nil
+}
+
+// starts receiving a file into the storage instead of creating it in the
+// current directory.
+func saveToStorage(client *Client) {
+	// there is no older version to diff against
+	client.requestedOptions &^= abp.HDR_DELTA
+	file, err := storage.Create(client.filename)
+	if err != nil {
+		client.logf("STORAGE", "can't create %s: %v\n", client.filename, err)
+		abortClient(client, abp.ERR_WRITE)
+		return
+	}
+	client.stored = file
+	client.sink = &offsetWriter{w: file}
+	client.writer = bufio.NewWriterSize(client.sink, writeBufferSize)
+	client.state = STATE_WAIT_DATA1
+	client.compact = client.requestedOptions&abp.HDR_COMPACT != 0
+	replyWithData(client, int(client.requestedOptions), []byte(client.id))
+}
+
+// a StoredFile whose writes are queued can fall behind the sender, which
+// then gets a closed window until it caught up (see advertisedWindow)
+type backlogger interface {
+	Backlogged() bool
+}
+
+// the result of committing a file in the background, handled by the main
+// loop with finishStored
+type storedCommit struct {
+	client *Client
+	err    error
+}
+
+var storedCommits = make(chan storedCommit)
+
+// commits the received file to the storage in the background, as it may
+// have to wait for an upload. the FIN is acknowledged once it's done.
+func commitStored(client *Client) {
+	file := client.stored
+	client.stored = nil
+	client.committing = true
+	go func() {
+		storedCommits <- storedCommit{client, file.Commit()}
+	}()
+}
+
+// completes the transfer of a committed file.
+func finishStored(c storedCommit) {
+	client := c.client
+	client.committing = false
+	if client.state == STATE_CLIENT_DEAD {
+		if c.err == nil {
+			client.logf("STORAGE", "stored %s, but its sender gave up "+
+				"meanwhile\n", client.filename)
+		}
+		return
+	}
+	if c.err != nil {
+		client.logf("STORAGE", "can't store %s: %v\n", client.filename,
+			c.err)
+		abortClient(client, abp.ERR_WRITE)
+		return
+	}
+	completeTransfer(client)
+}
+
+// a directory, e.g. on another file system. files are received into
+// temporary files next to their final name and renamed once complete, so
+// a partially received file never shows up under its name.
+type diskStorage string
+
+type diskFile struct {
+	*os.File
+	path string
+}
+
+func (d diskStorage) Create(name string) (StoredFile, error) {
+	fh, err := ioutil.TempFile(string(d), ".abp-*")
+	if err != nil {
+		return nil, err
+	}
+	// like the files created in the current directory, not private
+	fh.Chmod(0644)
+	return &diskFile{fh, filepath.Join(string(d), name)}, nil
+}
+
+func (f *diskFile) Commit() error {
+	if syncPolicy != SYNC_NONE {
+		if err := f.Sync(); err != nil {
+			f.Abort()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	syncDir(filepath.Dir(f.path))
+	return nil
+}
+
+func (f *diskFile) Abort() error {
+	f.Close()
+	return os.Remove(f.Name())
+}
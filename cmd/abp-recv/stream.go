@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// adds the sender of a STREAM packet (a new client so far) as a parallel
+// stream to the transfer whose ID it carries, see -streams of the sender.
+// the stream writes its range of the file from the offset in the packet
+// on, with an alternating bit of its own, until its FIN. the transfer's
+// own FIN, sent once all streams are done, completes the file.
+func joinStream(client *Client, payload []byte, clients map[string]*Client) {
+	if len(payload) < 8 {
+		markDead(client)
+		return
+	}
+	offset := int64(binary.BigEndian.Uint64(payload))
+	id := string(payload[8:])
+	for _, parent := range clients {
+		if parent.id != id || parent.fh == nil || parent.parent != nil ||
+			(parent.state != STATE_WAIT_DATA0 &&
+				parent.state != STATE_WAIT_DATA1) {
+			continue
+		}
+		client.parent = parent
+		client.filename = parent.filename
+		client.outPath = parent.outPath
+		client.fh = parent.fh
+		client.sink = &offsetWriter{w: parent.fh, offset: offset}
+		client.writer = bufio.NewWriterSize(client.sink, writeBufferSize)
+		client.started = parent.started
+		client.state = STATE_WAIT_DATA1
+		parent.logf("NET", "stream from %v joined at offset %d\n",
+			client.remoteAddr, offset)
+		replyWithData(client, abp.HDR_STREAM, payload)
+		return
+	}
+	client.logf("NET", "%v wants to stream into unknown transfer %q, "+
+		"ignoring...\n", client.remoteAddr, id)
+	markDead(client)
+}
+
+// completes a stream with its FIN: its range is written and synced like a
+// whole file would be, the file itself stays open for the other streams.
+func finishStream(client *Client) {
+	if !writeData(client) {
+		return
+	}
+	flushData(client)
+	if syncPolicy != SYNC_NONE {
+		syncFile(client)
+	}
+	if client.sink.offset > client.parent.streamEnd {
+		client.parent.streamEnd = client.sink.offset
+	}
+	client.writer = nil
+	client.fh = nil
+	client.logf("HANDLER", "stream of %s complete at offset %d\n",
+		client.filename, client.sink.offset)
+
+	if (client.lastHdr.Flags & abp.HDR_ALTERNATING) != 0 {
+		client.state = STATE_CLOSED1
+	} else {
+		client.state = STATE_CLOSED0
+	}
+	replyWithData(client, int(client.lastHdr.Flags), nil)
+}
+
+// gives up a stream whose transfer is gone, or which timed out; the file
+// belongs to the transfer.
+func dropStream(client *Client) {
+	client.logf("HANDLER", "dropping stream of %s from %v\n",
+		client.filename, client.remoteAddr)
+	client.writer = nil
+	client.fh = nil
+	markDead(client)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// fsync policies for received files, see -sync
+const (
+	SYNC_ALWAYS   = iota // after every packet
+	SYNC_INTERVAL        // at most once per syncInterval
+	SYNC_CLOSE           // once the file is complete
+	SYNC_NONE            // leave it to the OS
+)
+
+var syncPolicy = SYNC_ALWAYS
+var syncInterval time.Duration
+
+// size of the write-behind buffer of received files, see -write-buffer
+var writeBufferSize int
+
+func parseSyncPolicy(policy string) (int, error) {
+	switch policy {
+	case "always":
+		return SYNC_ALWAYS, nil
+	case "interval":
+		return SYNC_INTERVAL, nil
+	case "close":
+		return SYNC_CLOSE, nil
+	case "none":
+		return SYNC_NONE, nil
+	}
+	return 0, fmt.Errorf("invalid sync policy %q, want "+
+		"always|interval|close|none", policy)
+}
+
+// called after a packet has been written to the buffer, before it is
+// acknowledged. the buffer is flushed before every sync, so with
+// SYNC_ALWAYS every acknowledged packet is on disk. otherwise acknowledged
+// data may still be buffered, which is only lost along with the receiver
+// process and with it the transfer, whose FIN is never acknowledged then.
+func syncData(client *Client) {
+	if writeBufferSize <= 0 {
+		flushData(client)
+	}
+	if client.fh == nil {
+		return
+	}
+	switch syncPolicy {
+	case SYNC_ALWAYS:
+		flushData(client)
+		syncFile(client)
+	case SYNC_INTERVAL:
+		if time.Since(client.lastSync) >= syncInterval {
+			flushData(client)
+			syncFile(client)
+			client.lastSync = time.Now()
+		}
+	}
+}
+
+// writes the buffered data to the output file.
+func flushData(client *Client) {
+	if err := client.writer.Flush(); err != nil {
+		panic(err)
+	}
+}
+
+// flushes and closes the output file once it is complete; unless the
+// policy is SYNC_NONE, it is synced one last time.
+func closeOutput(client *Client) {
+	flushData(client)
+	client.writer = nil
+	if client.fh == nil {
+		return
+	}
+	if syncPolicy != SYNC_NONE {
+		syncFile(client)
+	}
+	client.fh.Close()
+	client.fh = nil
+}
+
+// syncs the output file to disk.
+func syncFile(client *Client) {
+	start := time.Now()
+	client.fh.Sync()
+	client.syncTime += time.Since(start)
+}
+
+// syncs a directory so a new or renamed entry survives a crash.
+func syncDir(dir string) {
+	if syncPolicy == SYNC_NONE {
+		return
+	}
+	fh, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	fh.Sync()
+	fh.Close()
+}
@@ -2,5 +2,5 @@
 set -ex
 
 go build
-./receiver unrealiable
+./abp-recv unrealiable
 sha256sum blob.bin
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// the bytes per second a client may send (0 = unlimited), and limits by
+// time of day overriding it; see -limit-rate and -schedule
+var clientRate float64
+var rateSchedule abp.Schedule
+
+// the rate in force, to report when the schedule changes it
+var currentRate float64 = -1
+
+// a token bucket per client, enforced through the window: a client out of
+// tokens is told to pause (see advertisedWindow) until they refilled.
+// senders ignoring windows aren't throttled.
+type throttle struct {
+	tokens float64
+	last   time.Time
+}
+
+func throttleRate(now time.Time) float64 {
+	rate := clientRate
+	if rateSchedule != nil {
+		rate = rateSchedule.RateAt(now, clientRate)
+	}
+	if rate != currentRate {
+		if currentRate >= 0 || rate > 0 {
+			fmt.Printf("[LIMIT] per client: %s\n", rateString(rate))
+		}
+		currentRate = rate
+	}
+	return rate
+}
+
+func rateString(rate float64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("limit %.0f KB/s", rate/1024)
+}
+
+// the payload bytes the client may send now, -1 if unlimited. a client
+// isn't offered less than a packet of the size it sends, so throttling
+// doesn't shrink its packets, it pauses it.
+func throttleWindow(client *Client) int64 {
+	now := time.Now()
+	rate := throttleRate(now)
+	t := &client.throttle
+	if rate <= 0 {
+		t.last = time.Time{}
+		return -1
+	}
+	packet := float64(len(client.lastData))
+	if packet < 512 {
+		packet = 512
+	}
+	// the sender probes a closed window every 200ms, so allow a quarter
+	// of a second's worth to be sent at once to reach the rate, and at
+	// least a packet, or a low rate would never open the window again
+	burst := rate / 4
+	if burst < packet {
+		burst = packet
+	}
+	if t.last.IsZero() {
+		t.tokens = burst
+	} else {
+		t.tokens += now.Sub(t.last).Seconds() * rate
+	}
+	if t.tokens > burst {
+		t.tokens = burst
+	}
+	t.last = now
+	if t.tokens < packet {
+		return 0
+	}
+	return int64(t.tokens)
+}
+
+// takes the tokens for n payload bytes received.
+func throttleData(client *Client, n int) {
+	if !client.throttle.last.IsZero() {
+		client.throttle.tokens -= float64(n)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// the per client throttle follows the schedule, and -limit-rate outside
+// of its periods.
+func TestThrottleSchedule(t *testing.T) {
+	defer func(rate float64, schedule abp.Schedule) {
+		clientRate, rateSchedule, currentRate = rate, schedule, -1
+	}(clientRate, rateSchedule)
+	var err error
+	rateSchedule, err = abp.ParseSchedule("limit 1MB/s 08:00-18:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientRate = 4096
+
+	for _, tt := range []struct {
+		clock string
+		want  float64
+	}{
+		{"12:00", 1 << 20},
+		{"20:00", 4096},
+	} {
+		at, _ := time.ParseInLocation("15:04", tt.clock, time.Local)
+		if got := throttleRate(at); got != tt.want {
+			t.Errorf("%s: rate %v, want %v", tt.clock, got, tt.want)
+		}
+	}
+
+	// a schedule that's in force all day closes the window of a client
+	// that sent its quarter of a second's worth
+	rateSchedule, _ = abp.ParseSchedule("limit 8KB/s")
+	clientRate = 0
+	client := &Client{lastData: make([]byte, 1024)}
+	window := throttleWindow(client)
+	if window != 2048 {
+		t.Fatalf("window %d, want the 2048 byte burst", window)
+	}
+	throttleData(client, int(window))
+	if window := throttleWindow(client); window != 0 {
+		t.Errorf("window %d after the burst, want 0", window)
+	}
+
+	rateSchedule, _ = abp.ParseSchedule("unlimited")
+	clientRate = 4096
+	if window := throttleWindow(client); window != -1 {
+		t.Errorf("window %d with an unlimited schedule", window)
+	}
+}
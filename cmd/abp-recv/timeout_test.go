@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+func testPacket(flags uint16, payload []byte) []byte {
+	pkt := append(abp.SerializeHeader(abp.Header{
+		Length: uint16(len(payload)), Flags: flags}), payload...)
+	abp.SetChecksum(pkt)
+	return pkt
+}
+
+// timeouts fire while the datagrams of other transfers, and late ones of
+// the timed out transfers, keep arriving, and the control port cancels
+// transfers. the FSM runs in the loop handling all of them like main does,
+// so go test -race finds any timer touching a client on its own.
+func TestTimeoutWhileReceiving(t *testing.T) {
+	t.Chdir(t.TempDir())
+	defer func(idle, linger time.Duration) {
+		idleTimeout, closeLinger = idle, linger
+	}(idleTimeout, closeLinger)
+	idleTimeout, closeLinger = 5*time.Millisecond, 5*time.Millisecond
+	initFsm()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the senders' sockets only take the ACKs
+	var senders []*net.UDPAddr
+	for range 8 {
+		sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sink.Close()
+		senders = append(senders, sink.LocalAddr().(*net.UDPAddr))
+	}
+
+	datagrams := make(chan datagram)
+	done := make(chan struct{})
+	for i, addr := range senders {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			rnd := rand.New(rand.NewSource(int64(i)))
+			packets := [][]byte{
+				testPacket(abp.HDR_FILENAME, []byte(fmt.Sprintf("f%d", i))),
+			}
+			for n := range 20 {
+				packets = append(packets, testPacket(
+					uint16(n%2)*abp.HDR_ALTERNATING, []byte{byte(n)}))
+			}
+			packets = append(packets, testPacket(abp.HDR_FIN, nil))
+			for _, pkt := range packets {
+				datagrams <- datagram{remoteAddr: addr, data: pkt, conn: conn}
+				// now and then long enough for the transfer to time out
+				time.Sleep(time.Duration(rnd.Intn(8)) * time.Millisecond)
+			}
+		}()
+	}
+
+	clients := make(map[string]*Client)
+	var timedOut int
+	control := time.NewTicker(3 * time.Millisecond)
+	defer control.Stop()
+	for running := len(senders); running > 0; {
+		select {
+		case dgram := <-datagrams:
+			processDatagram(dgram.remoteAddr, dgram.data, clients, dgram.conn)
+		case to := <-timeouts:
+			fireTimeout(to)
+			timedOut++
+		case <-control.C:
+			handleControl("status", clients)
+			for _, client := range clients {
+				handleControl("cancel "+client.id, clients)
+				break
+			}
+		case <-done:
+			running--
+		}
+	}
+	// and the last transfers time out or linger until they are gone
+	deadline := time.After(5 * time.Second)
+	for activeSessions(clients) > 0 || timedOut == 0 {
+		select {
+		case to := <-timeouts:
+			fireTimeout(to)
+			timedOut++
+		case <-deadline:
+			t.Fatalf("%d transfers still active", activeSessions(clients))
+		}
+	}
+}
+
+// a draining receiver turns new senders away while the transfers it still
+// has time out, which the drain waits for.
+func TestTimeoutWhileDraining(t *testing.T) {
+	t.Chdir(t.TempDir())
+	defer func(idle, linger time.Duration) {
+		idleTimeout, closeLinger, draining = idle, linger, false
+	}(idleTimeout, closeLinger)
+	idleTimeout, closeLinger = 20*time.Millisecond, 20*time.Millisecond
+	initFsm()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the running transfers' senders and the one knocking while draining
+	var senders []*net.UDPAddr
+	for range 5 {
+		sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sink.Close()
+		senders = append(senders, sink.LocalAddr().(*net.UDPAddr))
+	}
+	late := senders[4]
+
+	clients := make(map[string]*Client)
+	for i, addr := range senders[:4] {
+		processDatagram(addr, testPacket(abp.HDR_FILENAME,
+			[]byte(fmt.Sprintf("f%d", i))), clients, conn)
+	}
+	if n := pendingTransfers(clients); n != 4 {
+		t.Fatalf("%d pending transfers, want 4", n)
+	}
+	draining = true
+
+	// the new sender keeps retrying until the last transfer timed out
+	tick := time.NewTicker(2 * time.Millisecond)
+	defer tick.Stop()
+	deadline := time.After(5 * time.Second)
+	for pendingTransfers(clients) > 0 {
+		select {
+		case to := <-timeouts:
+			fireTimeout(to)
+		case <-tick.C:
+			processDatagram(late, testPacket(abp.HDR_FILENAME,
+				[]byte("late")), clients, conn)
+			if clients[late.String()].state != STATE_CLIENT_DEAD {
+				t.Fatalf("draining receiver accepted a transfer")
+			}
+		case <-deadline:
+			t.Fatalf("%d transfers still pending",
+				pendingTransfers(clients))
+		}
+	}
+}
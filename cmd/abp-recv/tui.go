@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how often -tui redraws the screen, and how many log lines it shows
+const TUI_INTERVAL = 500 * time.Millisecond
+const TUI_EVENT_LINES = 15
+
+// -tui: a live table of the running transfers above the latest log lines,
+// redrawn with ANSI escape codes
+type dashboard struct {
+	terminal *os.File
+	width    int
+
+	mu     sync.Mutex
+	events []string
+
+	// bytes received per transfer ID at the last redraw, for the rates
+	received map[string]int64
+	lastDraw time.Time
+}
+
+// takes over the terminal: from now on everything printed to stdout ends
+// up in the event pane.
+func startDashboard() (*dashboard, error) {
+	d := &dashboard{terminal: os.Stdout, width: 100,
+		received: make(map[string]int64), lastDraw: time.Now()}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil &&
+		columns > 40 {
+		d.width = columns
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	os.Stdout = w
+	go func() {
+		lines := bufio.NewScanner(r)
+		for lines.Scan() {
+			d.mu.Lock()
+			d.events = append(d.events, lines.Text())
+			if len(d.events) > TUI_EVENT_LINES {
+				d.events = d.events[len(d.events)-TUI_EVENT_LINES:]
+			}
+			d.mu.Unlock()
+		}
+	}()
+	return d, nil
+}
+
+func (d *dashboard) draw(clients map[string]*Client) {
+	elapsed := time.Since(d.lastDraw).Seconds()
+	d.lastDraw = time.Now()
+
+	var rows []*Client
+	for _, client := range clients {
+		if client.state != STATE_CLIENT_DEAD && client.filename != "" {
+			rows = append(rows, client)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].started.Before(rows[j].started)
+	})
+
+	// clear the screen, then print from the top left corner
+	screen := "\033[H\033[2J"
+	screen += fmt.Sprintf("abp-recv: %d transfer(s)  %s\n\n", len(rows),
+		time.Now().Format("15:04:05"))
+	screen += d.line(fmt.Sprintf("%-8s %-21s %6s %12s %8s  %s", "ID",
+		"PEER", "%", "RATE", "RETRANS", "FILE"))
+	received := make(map[string]int64)
+	for _, client := range rows {
+		var offset int64
+		if client.sink != nil {
+			offset = client.sink.offset
+		}
+		received[client.id] = offset
+		percent := "?"
+		if client.announcedSize > 0 {
+			percent = fmt.Sprintf("%.1f", 100*float64(offset)/
+				float64(client.announcedSize))
+		}
+		rate := float64(offset-d.received[client.id]) / elapsed
+		screen += d.line(fmt.Sprintf("%-8s %-21s %6s %9.1fKB/s %8d  %s",
+			client.id, client.remoteAddr, percent, rate/1024,
+			client.retransmits, client.filename))
+	}
+	d.received = received
+
+	screen += "\n" + d.line("-- log --")
+	d.mu.Lock()
+	for _, event := range d.events {
+		screen += d.line(event)
+	}
+	d.mu.Unlock()
+	d.terminal.WriteString(screen)
+}
+
+// cuts s to the width of the terminal and ends the line
+func (d *dashboard) line(s string) string {
+	if len(s) > d.width {
+		s = s[:d.width]
+	}
+	return s + "\n"
+}
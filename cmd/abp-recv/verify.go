@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// a hash request of a client: the file is hashed once, retries of the
+// request are answered from it
+type hashRequest struct {
+	name    string
+	done    bool
+	flags   int
+	payload []byte
+}
+
+// a hash computed in the background, handed to the main loop
+type hashResult struct {
+	client *Client
+	req    *hashRequest
+	sum    []byte
+	err    error
+}
+
+var hashResults = make(chan hashResult)
+
+// answers a hash request (HDR_HASH, payload: file name) with the SHA-256
+// of the stored file, or ERR_NOT_FOUND. hashing a large file takes a
+// while, so it happens in the background, see finishHash; the client
+// stays around while its sender repeats the request, and may ask for the
+// hash of another file next.
+func sendHash(client *Client) {
+	name := sanitizeFilename(string(client.lastData))
+	if req := client.hash; req != nil && req.name == name {
+		if req.done {
+			sendPacket(client, req.flags, req.payload)
+		} else {
+			armTimeout(client, idleTimeout)
+		}
+		return
+	}
+	client.logf("HANDLER", "%v asks for the hash of %s\n",
+		client.remoteAddr, name)
+	req := &hashRequest{name: name}
+	client.hash = req
+	armTimeout(client, idleTimeout)
+	go func() {
+		sum, err := hashFile("./" + name)
+		hashResults <- hashResult{client, req, sum, err}
+	}()
+}
+
+// answers a hash request once the hash is computed, unless the client
+// went away or asked for another file meanwhile.
+func finishHash(r hashResult) {
+	client := r.client
+	if client.hash != r.req || client.state != STATE_WAIT_FILENAME {
+		return
+	}
+	r.req.done = true
+	if r.err != nil {
+		client.logf("HANDLER", "can't hash %s: %v\n", r.req.name, r.err)
+		r.req.flags = abp.HDR_ERROR
+		r.req.payload = make([]byte, 2)
+		binary.BigEndian.PutUint16(r.req.payload, abp.ERR_NOT_FOUND)
+	} else {
+		r.req.flags = abp.HDR_HASH
+		r.req.payload = r.sum
+	}
+	sendPacket(client, r.req.flags, r.req.payload)
+}
+
+// returns the SHA-256 of a file.
+func hashFile(path string) ([]byte, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fh); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
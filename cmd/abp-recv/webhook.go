@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// URL POSTed a transferEvent whenever a transfer finishes or fails, see
+// -webhook
+var webhookURL string
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+type transferEvent struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Filename string    `json:"filename"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256,omitempty"`
+	Sender   string    `json:"sender"`
+	Duration float64   `json:"duration"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// records the outcome of a transfer in the journal and the -report file
+// and reports it to the webhook and -statsd. status is "ok" or "failed",
+// reason explains the latter. all of it happens in the background so it
+// can't stall other transfers.
+func notifyTransfer(client *Client, status string, reason string) {
+	reportTransferMetrics(client, status)
+	if (webhookURL == "" && journalPath == "" && !writeReports) ||
+		client.filename == "" ||
+		client.requestedOptions&(abp.HDR_DRY_RUN|abp.HDR_BENCH) != 0 {
+		return
+	}
+	event := transferEvent{
+		ID:       client.id,
+		Time:     time.Now(),
+		Filename: client.filename,
+		Sender:   client.remoteAddr.String(),
+		Duration: time.Since(client.started).Seconds(),
+		Status:   status,
+		Error:    reason,
+	}
+	if client.sink != nil {
+		event.Size = client.sink.offset
+	}
+	path := client.outPath
+	report := newTransferReport(client)
+
+	goBackground(func() {
+		if status == "ok" {
+			if sum, err := hashFile(path); err == nil {
+				event.SHA256 = hex.EncodeToString(sum)
+			}
+			report.write(path, event)
+		}
+		writeJournal(event)
+		if webhookURL == "" {
+			return
+		}
+
+		body, _ := json.Marshal(event)
+		resp, err := webhookClient.Post(webhookURL, "application/json",
+			bytes.NewReader(body))
+		if err != nil {
+			fmt.Printf("[WEBHOOK] can't notify %s: %v\n", webhookURL, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			fmt.Printf("[WEBHOOK] %s answered %s\n", webhookURL, resp.Status)
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// the most payload bytes advertised to senders per ACK, see -window
+// (0 = only limited by the disk)
+var maxWindow int64
+
+// how many payload bytes the client may send next: what still fits on the
+// disk once the buffered data is written, capped by -window and by what
+// -limit-rate leaves, and closed while a -storage upload catches up. a
+// sender told 0 pauses and probes with empty packets until there is room
+// again.
+func advertisedWindow(client *Client) uint32 {
+	window := int64(math.MaxUint32)
+	if maxWindow > 0 {
+		window = maxWindow
+	}
+	if client.fh != nil {
+		if free := freeSpace("."); free >= 0 {
+			if client.writer != nil {
+				free -= int64(client.writer.Buffered())
+			}
+			if free < window {
+				window = free
+			}
+		}
+	}
+	if b, ok := client.stored.(backlogger); ok && b.Backlogged() {
+		window = 0
+	}
+	if allowed := throttleWindow(client); allowed >= 0 && allowed < window {
+		window = allowed
+	}
+	if window < 0 {
+		window = 0
+	}
+	return uint32(window)
+}
+
+func windowPayload(client *Client) []byte {
+	payload := make([]byte, abp.WindowLength)
+	binary.BigEndian.PutUint32(payload, advertisedWindow(client))
+	return payload
+}
+
+// acknowledges a data packet, advertising the current window. senders
+// that don't know about windows ignore the payload.
+func ackData(client *Client, flags int) {
+	replyWithData(client, flags, windowPayload(client))
+	client.lastOutWindow = true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/v4lli/go-abp/rendezvous"
+)
+
+// registrations are forgotten if they aren't renewed within this time;
+// receivers renew theirs every 15s
+const REGISTRATION_TTL = time.Minute
+
+// where a peer registered from, and when
+type registration struct {
+	addr *net.UDPAddr
+	// as learned from a STUN server, if the peer asked one
+	reflexive *net.UDPAddr
+	at        time.Time
+}
+
+// pairs the sender and receiver registered under the same name by telling
+// each the other's address. a receiver stays registered for further
+// senders, a sender is forgotten once it got its receiver.
+func main() {
+	listen := flag.String("listen", ":1236", "address to listen on")
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [-listen addr]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	addr, err := net.ResolveUDPAddr("udp", *listen)
+	if err != nil {
+		fmt.Printf("invalid -listen %s: %v\n", *listen, err)
+		os.Exit(1)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		fmt.Printf("Socket setup error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Waiting for peers on %s...\n", *listen)
+
+	registrations := map[string]map[string]registration{
+		rendezvous.RoleSend: {},
+		rendezvous.RoleRecv: {},
+	}
+	buf := make([]byte, 512)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			panic(err)
+		}
+		name, role, reflexive, err := rendezvous.ParseRegister(buf[:n])
+		if err != nil {
+			fmt.Printf("[NET] %v: %v\n", from, err)
+			continue
+		}
+		now := time.Now()
+		// forget peers that went away
+		for _, byName := range registrations {
+			for old, reg := range byName {
+				if now.Sub(reg.at) > REGISTRATION_TTL {
+					delete(byName, old)
+				}
+			}
+		}
+		registrations[role][name] = registration{from, reflexive, now}
+		if rendezvous.Symmetric(from, reflexive) {
+			fmt.Printf("[REG] %s %s at %v is behind a symmetric NAT (STUN "+
+				"saw %v)\n", name, role, from, reflexive)
+		}
+
+		other := rendezvous.RoleRecv
+		if role == rendezvous.RoleRecv {
+			other = rendezvous.RoleSend
+		}
+		peer, ok := registrations[other][name]
+		if !ok {
+			fmt.Printf("[REG] %s %s registered from %v\n", name, role, from)
+			continue
+		}
+		fmt.Printf("[REG] %s: pairing %v and %v\n", name, from, peer.addr)
+		conn.WriteToUDP(rendezvous.Peer(peer.addr, peer.reflexive), from)
+		conn.WriteToUDP(rendezvous.Peer(from, reflexive), peer.addr)
+		delete(registrations[rendezvous.RoleSend], name)
+	}
+}
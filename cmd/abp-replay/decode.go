@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// payload bytes shown by decode
+const PREVIEW_LENGTH = 64
+
+// reads a packet given as hex on the command line or as a file holding
+// either the raw bytes or hex.
+func readPacket(arg string) ([]byte, error) {
+	data, err := ioutil.ReadFile(arg)
+	if os.IsNotExist(err) {
+		data, err = []byte(arg), nil
+	} else if err != nil {
+		return nil, err
+	}
+	// hex may be split by whitespace or colons and prefixed with 0x
+	text := strings.NewReplacer(" ", "", "\n", "", "\r", "", "\t", "",
+		":", "").Replace(strings.TrimSpace(string(data)))
+	text = strings.TrimPrefix(text, "0x")
+	if pkt, err := hex.DecodeString(text); err == nil {
+		return pkt, nil
+	}
+	if string(data) == arg {
+		return nil, fmt.Errorf("%q is neither a file nor hex", arg)
+	}
+	return data, nil
+}
+
+// prints the fields of a single packet. the regular header encoding is
+// assumed unless only the compact one yields a valid checksum.
+func decode(arg string) bool {
+	pkt, err := readPacket(arg)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	fmt.Printf("%d bytes\n", len(pkt))
+	if len(pkt) < abp.HeaderLength && !abp.VerifyCompactChecksum(pkt) {
+		fmt.Printf("too short for a header (%d bytes)\n", abp.HeaderLength)
+		return false
+	}
+
+	encoding := "regular"
+	hdrLen := abp.HeaderLength
+	var hdr abp.Header
+	if len(pkt) >= abp.HeaderLength {
+		hdr = abp.Header{
+			Checksum: binary.BigEndian.Uint32(pkt),
+			Length:   binary.BigEndian.Uint16(pkt[4:]),
+			Flags:    binary.BigEndian.Uint16(pkt[6:]),
+		}
+	}
+	// abp.VerifyChecksum would log the mismatch
+	end := hdrLen + int(hdr.Length)
+	regular := len(pkt) >= end &&
+		abp.Checksum(pkt[:hdrLen], pkt[hdrLen:end]) == hdr.Checksum
+	if !regular && abp.VerifyCompactChecksum(pkt) {
+		encoding = "compact"
+		hdr, hdrLen, _ = abp.ParseCompactHeader(pkt)
+	}
+	fmt.Printf("encoding: %s header, %d bytes\n", encoding, hdrLen)
+	fmt.Printf("flags:    0x%04x %s\n", hdr.Flags, abp.FlagString(hdr.Flags))
+	if err := hdr.Validate(); err != nil {
+		fmt.Printf("          %v\n", err)
+	}
+	fmt.Printf("length:   %d\n", hdr.Length)
+
+	end = hdrLen + int(hdr.Length)
+	if end > len(pkt) {
+		fmt.Printf("checksum: 0x%08x, can't verify: the packet is %d "+
+			"bytes short\n", hdr.Checksum, end-len(pkt))
+		return false
+	}
+	payload := pkt[hdrLen:end]
+	computed := abp.Checksum(pkt[:hdrLen], payload)
+	valid := computed == hdr.Checksum
+	if valid {
+		fmt.Printf("checksum: 0x%08x OK\n", hdr.Checksum)
+	} else {
+		fmt.Printf("checksum: 0x%08x INVALID, computed 0x%08x\n",
+			hdr.Checksum, computed)
+	}
+	if extra := len(pkt) - end; extra > 0 {
+		fmt.Printf("          %d bytes follow the payload\n", extra)
+	}
+
+	switch {
+	case hdr.Flags == abp.HDR_ERROR && len(payload) >= 2:
+		fmt.Printf("error:    %s\n",
+			abp.ErrorMessage(binary.BigEndian.Uint16(payload)))
+	case hdr.Flags == abp.HDR_BUSY && len(payload) >= 2:
+		fmt.Printf("busy:     retry after %ds\n",
+			binary.BigEndian.Uint16(payload))
+	case hdr.Flags&(abp.HDR_SKIP|abp.HDR_FILENAME) == abp.HDR_SKIP &&
+		len(payload) == 8:
+		fmt.Printf("skip:     %d bytes\n", binary.BigEndian.Uint64(payload))
+	}
+	if len(payload) > 0 {
+		fmt.Printf("payload:\n%s", hex.Dump(payload[:min(len(payload),
+			PREVIEW_LENGTH)]))
+		if len(payload) > PREVIEW_LENGTH {
+			fmt.Printf("... %d more bytes\n", len(payload)-PREVIEW_LENGTH)
+		}
+	}
+	return valid
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// receiver states, as in abp-recv
+const (
+	STATE_WAIT_FILENAME = iota
+	STATE_WAIT_DATA0
+	STATE_WAIT_DATA1
+	STATE_CLOSED0
+	STATE_CLOSED1
+	STATE_CLIENT_DEAD
+)
+
+var stateNames = []string{"WAIT_FILENAME", "WAIT_DATA0", "WAIT_DATA1",
+	"CLOSED0", "CLOSED1", "CLIENT_DEAD"}
+
+// names of the FILENAME options
+var optionNames = []struct {
+	flag uint16
+	name string
+}{
+	{abp.HDR_COMPACT, "compact"},
+	{abp.HDR_COMPRESSED, "compressed"},
+	{abp.HDR_DELTA, "delta"},
+	{abp.HDR_SIZE, "size"},
+	{abp.HDR_SKIP, "skip"},
+	{abp.HDR_STORED_NAME, "stored-name"},
+	{abp.HDR_DRY_RUN, "dry-run"},
+	{abp.HDR_BENCH, "bench"},
+}
+
+// one sender talking to the receiver, keyed by the sender's address
+type session struct {
+	sender string
+	state  int
+	// set once the FILENAME ACK accepted compact headers
+	compact bool
+	// the last packet of each direction, to spot repeated ones
+	lastSent  []byte
+	lastReply []byte
+	// whether the last packet of the sender has been answered
+	answered bool
+	// whether the FILENAME has been answered
+	filenameAcked bool
+
+	packets       int
+	retransmits   int
+	duplicates    int
+	checksumFails int
+}
+
+// the state the receiver's FSM moves to on a data packet or FIN; repeated
+// packets leave it where it is, everything else kills the client.
+func nextState(state int, bit bool, fin bool) (int, bool) {
+	want := STATE_WAIT_DATA0
+	if bit {
+		want = STATE_WAIT_DATA1
+	}
+	switch {
+	case state == want && fin && bit:
+		return STATE_CLOSED1, true
+	case state == want && fin:
+		return STATE_CLOSED0, true
+	case state == want && bit:
+		return STATE_WAIT_DATA0, true
+	case state == want:
+		return STATE_WAIT_DATA1, true
+	case state == STATE_WAIT_FILENAME && bit:
+		// early data that overtook the FILENAME
+		return state, false
+	case (state == STATE_WAIT_DATA0 || state == STATE_WAIT_DATA1) && !fin:
+		// the ACK got lost, it is sent again
+		return state, false
+	case state == STATE_CLOSED0 && fin && !bit,
+		state == STATE_CLOSED1 && fin && bit:
+		return state, false
+	}
+	return STATE_CLIENT_DEAD, false
+}
+
+func options(flags uint16) string {
+	var names []string
+	for _, o := range optionNames {
+		if flags&o.flag != 0 {
+			names = append(names, o.name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(names, ",") + "]"
+}
+
+func bitOf(flags uint16) int {
+	if flags&abp.HDR_ALTERNATING != 0 {
+		return 1
+	}
+	return 0
+}
+
+// describes a packet of the sender and runs it through the receiver FSM
+func (s *session) fromSender(hdr abp.Header, payload []byte) string {
+	f := hdr.Flags
+	switch {
+	case f == abp.HDR_NAME && len(payload) >= 2:
+		return fmt.Sprintf("NAME offset=%d len=%d",
+			binary.BigEndian.Uint16(payload), len(payload)-2)
+	case f&abp.HDR_FILENAME != 0:
+		desc := fmt.Sprintf("FILENAME len=%d%s", len(payload), options(f))
+		if s.state == STATE_WAIT_FILENAME {
+			s.state = STATE_WAIT_DATA1
+			return desc + "  WAIT_FILENAME -> WAIT_DATA1"
+		}
+		return desc
+	case f == abp.HDR_ECHO:
+		return fmt.Sprintf("ECHO len=%d", len(payload))
+	case f == abp.HDR_HELLO:
+		return fmt.Sprintf("HELLO len=%d", len(payload))
+	case f == abp.HDR_HASH:
+		if s.state == STATE_WAIT_FILENAME {
+			return fmt.Sprintf("HASH request len=%d", len(payload))
+		}
+		return "CHECKPOINT request"
+	case f == abp.HDR_DELTA:
+		return "SIGREQ"
+	}
+
+	kind := "DATA"
+	if f&abp.HDR_FIN != 0 {
+		kind = "FIN"
+	}
+	desc := fmt.Sprintf("%s%d len=%d", kind, bitOf(f), len(payload))
+	if f&abp.HDR_COMPRESSED != 0 {
+		desc += " compressed"
+	}
+	if f&abp.HDR_SKIP != 0 && len(payload) == 8 {
+		desc += fmt.Sprintf(" skip=%d", binary.BigEndian.Uint64(payload))
+	}
+	// FEC shards only reach the FSM once their group is decoded, which
+	// the ACK tells
+	if f&abp.HDR_FEC != 0 {
+		return desc + " FEC shard"
+	}
+	from := s.state
+	to, accepted := nextState(from, f&abp.HDR_ALTERNATING != 0,
+		f&abp.HDR_FIN != 0)
+	s.state = to
+	if !accepted && to == from {
+		return desc + "  (" + stateNames[from] + ", ignored)"
+	}
+	return desc + "  " + stateNames[from] + " -> " + stateNames[to]
+}
+
+// describes a reply of the receiver
+func (s *session) fromReceiver(hdr abp.Header, payload []byte) string {
+	f := hdr.Flags
+	switch {
+	case f == abp.HDR_BUSY && len(payload) >= 2:
+		s.state = STATE_CLIENT_DEAD
+		return fmt.Sprintf("BUSY retry after %ds",
+			binary.BigEndian.Uint16(payload))
+	case f == abp.HDR_ERROR && len(payload) >= 2:
+		s.state = STATE_CLIENT_DEAD
+		return "ERROR " + abp.ErrorMessage(binary.BigEndian.Uint16(payload))
+	case f == abp.HDR_ECHO:
+		return fmt.Sprintf("ECHO reply len=%d", len(payload))
+	case f == abp.HDR_HELLO:
+		return fmt.Sprintf("HELLO reply len=%d", len(payload))
+	case f == abp.HDR_HASH:
+		return fmt.Sprintf("HASH reply len=%d", len(payload))
+	case f == abp.HDR_DELTA:
+		return fmt.Sprintf("SIGNATURES len=%d", len(payload))
+	case f == abp.HDR_NAME && len(payload) >= 2:
+		return fmt.Sprintf("NAME ACK received=%d",
+			binary.BigEndian.Uint16(payload))
+	case f&(abp.HDR_ALTERNATING|abp.HDR_FIN) == 0 &&
+		(f != 0 || !s.filenameAcked):
+		// the FILENAME ACK echoes the accepted options, data ACKs carry
+		// none of them
+		s.filenameAcked = true
+		s.compact = f&abp.HDR_COMPACT != 0
+		return "FILENAME ACK" + options(f)
+	case f&abp.HDR_FIN != 0:
+		return fmt.Sprintf("ACK FIN%d", bitOf(f))
+	}
+	return fmt.Sprintf("ACK%d", bitOf(f))
+}
+
+func main() {
+	port := flag.Int("port", 1234, "UDP port of the receiver in the capture")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"usage: %s [options] capture.pcap\n"+
+				"       %s decode <hex|file>\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 2 && flag.Arg(0) == "decode" {
+		if !decode(flag.Arg(1)) {
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	fh, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer fh.Close()
+	capture, err := newPcapReader(fh)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	sessions := map[string]*session{}
+	var order []*session
+	var start time.Time
+	for {
+		dgram, err := capture.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		var peer, arrow string
+		toReceiver := dgram.dst.Port == *port
+		if toReceiver {
+			peer, arrow = dgram.src.String(), "->"
+		} else if dgram.src.Port == *port {
+			peer, arrow = dgram.dst.String(), "<-"
+		} else {
+			continue
+		}
+		if start.IsZero() {
+			start = dgram.time
+		}
+		s := sessions[peer]
+		if s == nil || (toReceiver && s.state == STATE_CLIENT_DEAD) {
+			s = &session{sender: peer, state: STATE_WAIT_FILENAME}
+			sessions[peer] = s
+			order = append(order, s)
+		}
+		s.packets++
+
+		var note string
+		last := &s.lastReply
+		if toReceiver {
+			last = &s.lastSent
+		}
+		if bytes.Equal(*last, dgram.payload) {
+			switch {
+			case !toReceiver:
+				note = "  [repeated]"
+			case s.answered:
+				note = "  [duplicate]"
+				s.duplicates++
+			default:
+				note = "  [retransmission]"
+				s.retransmits++
+			}
+		}
+		*last = append((*last)[:0], dgram.payload...)
+
+		var desc string
+		hdr, payload, err := abp.ParsePacket(dgram.payload, s.compact)
+		// a corrupted compact packet falls through to the regular
+		// encoding, which fails in other ways
+		if err != nil && s.compact &&
+			!abp.VerifyCompactChecksum(dgram.payload) {
+			err = abp.ErrChecksum
+		}
+		switch {
+		case err == abp.ErrChecksum:
+			desc = "CHECKSUM FAILURE, discarded"
+			s.checksumFails++
+		case err != nil:
+			desc = fmt.Sprintf("INVALID (%v), discarded", err)
+		case note != "":
+			// repeated packets don't move the FSM, describe them only
+			desc = s.describeRepeat(hdr, payload, toReceiver)
+		case toReceiver:
+			desc = s.fromSender(hdr, payload)
+			s.answered = false
+		default:
+			desc = s.fromReceiver(hdr, payload)
+			s.answered = true
+		}
+		fmt.Printf("%11.6f %s %s recv  %s%s\n",
+			dgram.time.Sub(start).Seconds(), peer, arrow, desc, note)
+	}
+
+	fmt.Printf("\n%d sessions\n", len(order))
+	for _, s := range order {
+		fmt.Printf("%s: %d packets, %d retransmissions, %d duplicates, "+
+			"%d checksum failures, final state %s\n", s.sender, s.packets,
+			s.retransmits, s.duplicates, s.checksumFails,
+			stateNames[s.state])
+	}
+}
+
+// describes a repeated packet without touching the session state
+func (s *session) describeRepeat(hdr abp.Header, payload []byte,
+	toReceiver bool) string {
+	shadow := *s
+	if toReceiver {
+		desc := shadow.fromSender(hdr, payload)
+		if i := strings.Index(desc, "  "); i >= 0 {
+			desc = desc[:i]
+		}
+		return desc
+	}
+	return shadow.fromReceiver(hdr, payload)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// link types of the captures we can decode
+const (
+	LINKTYPE_NULL      = 0
+	LINKTYPE_ETHERNET  = 1
+	LINKTYPE_RAW       = 101
+	LINKTYPE_LOOP      = 108
+	LINKTYPE_LINUX_SLL = 113
+	LINKTYPE_IPV4      = 228
+	LINKTYPE_IPV6      = 229
+)
+
+// a UDP datagram taken from a capture
+type capturedDatagram struct {
+	time    time.Time
+	src     *net.UDPAddr
+	dst     *net.UDPAddr
+	payload []byte
+}
+
+// reads the classic libpcap file format (not pcapng), in either byte order
+// and with micro- or nanosecond timestamps.
+type pcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nanos    bool
+	linkType uint32
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("reading pcap header: %w", err)
+	}
+	p := &pcapReader{r: r}
+	switch binary.LittleEndian.Uint32(hdr) {
+	case 0xa1b2c3d4:
+		p.order = binary.LittleEndian
+	case 0xa1b23c4d:
+		p.order, p.nanos = binary.LittleEndian, true
+	case 0xd4c3b2a1:
+		p.order = binary.BigEndian
+	case 0x4d3cb2a1:
+		p.order, p.nanos = binary.BigEndian, true
+	default:
+		return nil, errors.New("not a pcap file (pcapng isn't supported)")
+	}
+	p.linkType = p.order.Uint32(hdr[20:]) & 0xffff
+	return p, nil
+}
+
+// returns the next UDP datagram of the capture, skipping everything else.
+// io.EOF marks the end of the capture.
+func (p *pcapReader) next() (*capturedDatagram, error) {
+	rec := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(p.r, rec); err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated capture")
+		} else if err != nil {
+			return nil, err
+		}
+		sec := int64(p.order.Uint32(rec))
+		frac := int64(p.order.Uint32(rec[4:]))
+		if !p.nanos {
+			frac *= 1000
+		}
+		frame := make([]byte, p.order.Uint32(rec[8:]))
+		if _, err := io.ReadFull(p.r, frame); err != nil {
+			return nil, fmt.Errorf("truncated capture")
+		}
+		if d := p.decode(frame); d != nil {
+			d.time = time.Unix(sec, frac)
+			return d, nil
+		}
+	}
+}
+
+// strips the link layer header and decodes the IP packet.
+func (p *pcapReader) decode(frame []byte) *capturedDatagram {
+	switch p.linkType {
+	case LINKTYPE_ETHERNET:
+		if len(frame) < 14 {
+			return nil
+		}
+		etherType := binary.BigEndian.Uint16(frame[12:])
+		frame = frame[14:]
+		// a VLAN tag
+		if etherType == 0x8100 && len(frame) >= 4 {
+			frame = frame[4:]
+		}
+	case LINKTYPE_LINUX_SLL:
+		if len(frame) < 16 {
+			return nil
+		}
+		frame = frame[16:]
+	case LINKTYPE_NULL, LINKTYPE_LOOP:
+		if len(frame) < 4 {
+			return nil
+		}
+		frame = frame[4:]
+	case LINKTYPE_RAW, LINKTYPE_IPV4, LINKTYPE_IPV6:
+	default:
+		return nil
+	}
+	return decodeIP(frame)
+}
+
+// decodes an IPv4 or IPv6 packet carrying UDP. fragments and extension
+// headers aren't handled.
+func decodeIP(pkt []byte) *capturedDatagram {
+	if len(pkt) < 1 {
+		return nil
+	}
+	var src, dst net.IP
+	var udp []byte
+	switch pkt[0] >> 4 {
+	case 4:
+		ihl := int(pkt[0]&0xf) * 4
+		if len(pkt) < 20 || ihl < 20 || len(pkt) < ihl || pkt[9] != 17 {
+			return nil
+		}
+		// more fragments or a fragment offset
+		if binary.BigEndian.Uint16(pkt[6:])&0x3fff != 0 {
+			return nil
+		}
+		src, dst = net.IP(pkt[12:16]), net.IP(pkt[16:20])
+		udp = pkt[ihl:]
+	case 6:
+		if len(pkt) < 40 || pkt[6] != 17 {
+			return nil
+		}
+		src, dst = net.IP(pkt[8:24]), net.IP(pkt[24:40])
+		udp = pkt[40:]
+	default:
+		return nil
+	}
+	if len(udp) < 8 {
+		return nil
+	}
+	length := int(binary.BigEndian.Uint16(udp[4:]))
+	if length < 8 || length > len(udp) {
+		// truncated by the snap length
+		return nil
+	}
+	return &capturedDatagram{
+		src:     &net.UDPAddr{IP: src, Port: int(binary.BigEndian.Uint16(udp))},
+		dst:     &net.UDPAddr{IP: dst, Port: int(binary.BigEndian.Uint16(udp[2:]))},
+		payload: udp[8:length],
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// transmissions between two adjustments of the payload size
+const ADAPT_WINDOW = 32
+
+// loss rates (retransmissions per transmission) below which the payload
+// grows and above which it shrinks
+const ADAPT_GROW_LOSS = 0.02
+const ADAPT_SHRINK_LOSS = 0.1
+
+// sizes data packets by the observed loss. some paths silently drop UDP
+// datagrams close to the MTU, so it starts at the conservative default
+// and only grows toward the maximum while packets get through, and backs
+// off again when retransmissions spike.
+type payloadSizer struct {
+	min  int
+	max  int
+	size int
+	// transmissions and retransmissions in the current window
+	packets     int
+	retransmits int
+}
+
+func newPayloadSizer(max int) *payloadSizer {
+	min := abp.DefaultPacketLength - abp.HeaderLength
+	if min > max {
+		min = max
+	}
+	return &payloadSizer{min: min, max: max, size: min}
+}
+
+// counts a (re)transmission of a data packet and adjusts the size once a
+// window is complete.
+func (p *payloadSizer) sent(attempt int) {
+	p.packets++
+	if attempt > 0 {
+		p.retransmits++
+	}
+	if p.packets < ADAPT_WINDOW {
+		return
+	}
+
+	loss := float64(p.retransmits) / float64(p.packets)
+	size := p.size
+	if loss < ADAPT_GROW_LOSS {
+		// additive increase, reaching the maximum in 8 windows
+		size += (p.max - p.min + 7) / 8
+	} else if loss > ADAPT_SHRINK_LOSS {
+		// multiplicative decrease
+		size /= 2
+	}
+	if size > p.max {
+		size = p.max
+	}
+	if size < p.min {
+		size = p.min
+	}
+	if size != p.size {
+		fmt.Printf("\n[ADAPT] payload %d -> %d bytes (%d of %d packets "+
+			"retransmitted)\n", p.size, size, p.retransmits, p.packets)
+		p.size = size
+	}
+	p.packets, p.retransmits = 0, 0
+}
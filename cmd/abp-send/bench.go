@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// transmission statistics of the data phase, reported by bench
+type transferStats struct {
+	packets     int64
+	retransmits int64
+	rttSamples  int64
+	rttSum      time.Duration
+	rttMin      time.Duration
+	rttMax      time.Duration
+}
+
+var stats transferStats
+
+// counts a (re)transmission of a data packet or FEC group.
+func (s *transferStats) sent(attempt int) {
+	s.packets++
+	if attempt > 0 {
+		s.retransmits++
+	}
+}
+
+// records the round trip time of an acknowledged packet; like TCP, only
+// packets that weren't retransmitted are sampled (Karn's algorithm).
+func (s *transferStats) acked(attempt int, rtt time.Duration) {
+	if attempt > 0 {
+		return
+	}
+	if s.rttSamples == 0 || rtt < s.rttMin {
+		s.rttMin = rtt
+	}
+	if rtt > s.rttMax {
+		s.rttMax = rtt
+	}
+	s.rttSum += rtt
+	s.rttSamples++
+}
+
+// generates incompressible data for the given duration, starting with the
+// first read.
+type benchReader struct {
+	duration time.Duration
+	deadline time.Time
+	data     []byte
+	pos      int
+}
+
+func newBenchReader(duration time.Duration) *benchReader {
+	data := make([]byte, 1024*1024)
+	rand.Read(data)
+	return &benchReader{duration: duration, data: data}
+}
+
+func (r *benchReader) Read(p []byte) (int, error) {
+	if r.deadline.IsZero() {
+		r.deadline = time.Now().Add(r.duration)
+	}
+	if time.Now().After(r.deadline) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[r.pos:])
+	r.pos = (r.pos + n) % len(r.data)
+	return n, nil
+}
+
+func printBenchReport(bytesSent int64, elapsed time.Duration) {
+	fmt.Printf("\nBenchmark: %d bytes in %v\n", bytesSent,
+		elapsed.Round(time.Millisecond))
+	fmt.Printf("  goodput:  %.2f KB/s\n",
+		float64(bytesSent)/elapsed.Seconds()/1024)
+	if stats.packets > 0 {
+		fmt.Printf("  loss:     %d of %d transmissions (%.1f%%)\n",
+			stats.retransmits, stats.packets,
+			100*float64(stats.retransmits)/float64(stats.packets))
+	}
+	if stats.rttSamples > 0 {
+		fmt.Printf("  rtt:      min %v, avg %v, max %v\n", stats.rttMin,
+			stats.rttSum/time.Duration(stats.rttSamples), stats.rttMax)
+	}
+}
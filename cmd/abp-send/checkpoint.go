@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// how often a checkpoint request goes unanswered before checkpoints are
+// given up, the receiver probably doesn't support them
+const CHECKPOINT_ATTEMPTS = 3
+
+// compares the receiver's copy with the local file every few megabytes
+// during the transfer, see -checkpoint.
+type checkpointer struct {
+	fh       *os.File
+	interval int64
+	// data bytes sent when the next checkpoint is due
+	next int64
+	// running hash of the local file, covering its first hashed bytes
+	hash   hash.Hash
+	hashed int64
+	// the last offset both sides agreed on
+	good     int64
+	disabled bool
+}
+
+func newCheckpointer(fh *os.File, megabytes int) *checkpointer {
+	interval := int64(megabytes) * 1024 * 1024
+	return &checkpointer{fh: fh, interval: interval, next: interval,
+		hash: sha256.New()}
+}
+
+// called once a packet was acknowledged and sent bytes of data went out
+// in total; asks for a checkpoint if one is due and exits on a mismatch.
+func (c *checkpointer) check(conn *net.UDPConn, sent int64) {
+	if c.disabled || sent < c.next {
+		return
+	}
+	c.next = sent + c.interval
+
+	request := finalizePkg(abp.Header{Flags: abp.HDR_HASH}, nil)
+	for attempt := 0; attempt < CHECKPOINT_ATTEMPTS; attempt++ {
+		limiter.wait(len(request))
+		if _, err := writePacket(conn, request); err != nil {
+			panic(err)
+		}
+		// reading back the data takes the receiver a moment
+		for {
+			reply, payload, ok := readPacket(handshakeTimeout)
+			if !ok {
+				break
+			}
+			if reply.Flags == abp.HDR_ERROR && len(payload) >= 2 {
+				exitWith(EXIT_ABORTED, "\nReceiver aborted the transfer: %s",
+					abp.ErrorMessage(binary.BigEndian.Uint16(payload)))
+			}
+			if reply.Flags != abp.HDR_HASH || len(payload) != 8+sha256.Size {
+				// e.g. a late duplicate ACK
+				continue
+			}
+			c.compare(int64(binary.BigEndian.Uint64(payload)), payload[8:])
+			return
+		}
+	}
+	fmt.Printf("\nReceiver doesn't answer checkpoints, disabling them.\n")
+	c.disabled = true
+}
+
+// compares the receiver's hash over its first offset bytes with the local
+// file.
+func (c *checkpointer) compare(offset int64, remote []byte) {
+	if offset < c.hashed {
+		c.hash.Reset()
+		c.hashed = 0
+	}
+	section := io.NewSectionReader(c.fh, c.hashed, offset-c.hashed)
+	n, err := io.Copy(c.hash, section)
+	c.hashed += n
+	if err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	if c.hashed < offset || !bytes.Equal(c.hash.Sum(nil), remote) {
+		exitWith(EXIT_VERIFY_FAILED, "\nCheckpoint MISMATCH at %d bytes, "+
+			"the receiver's copy is corrupt; last good checkpoint at %d "+
+			"bytes", offset, c.good)
+	}
+	fmt.Printf("\nCheckpoint at %d bytes ok.\n", offset)
+	c.good = offset
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// the most input we try to squeeze into one packet, as a multiple of its
+// capacity
+const maxCompressScale = 8
+
+// reads the input file and compresses it chunk by chunk. since the
+// capacity of a packet is fixed, it tries to fit more and more input into
+// one packet as long as the data compresses well, and falls back to
+// sending raw chunks for data that doesn't shrink.
+type compressor struct {
+	reader    *bufio.Reader
+	algorithm string
+	level     int
+	scale     int
+	pending   []byte
+	eof       bool
+	hole      bool
+}
+
+func newCompressor(reader *bufio.Reader, algorithm string,
+	level int) *compressor {
+	return &compressor{reader: reader, algorithm: algorithm, level: level,
+		scale: 1}
+}
+
+// makes sure at least n bytes are pending, unless the input hits EOF.
+func (c *compressor) fill(n int) error {
+	if c.eof || c.hole || len(c.pending) >= n {
+		return nil
+	}
+	buf := make([]byte, n-len(c.pending))
+	count, err := io.ReadFull(c.reader, buf)
+	c.pending = append(c.pending, buf[:count]...)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		c.eof = true
+		return nil
+	}
+	if err == errHole {
+		// stop at the hole until the caller skipped it
+		c.hole = true
+		return nil
+	}
+	return err
+}
+
+// returns the next chunk of at most capacity bytes, whether it is
+// compressed, how many bytes of input it covers and io.EOF once the input
+// is exhausted (with the last chunk, like a short read). errHole is
+// returned without data when a hole of a sparse file was reached.
+func (c *compressor) next(capacity int) ([]byte, bool, int, error) {
+	if err := c.fill(capacity * c.scale); err != nil {
+		return nil, false, 0, err
+	}
+	if err := c.holeErr(); err != nil {
+		return nil, false, 0, err
+	}
+
+	for scale := c.scale; scale >= 1; scale /= 2 {
+		in := c.pending
+		if len(in) > capacity*scale {
+			in = in[:capacity*scale]
+		}
+		out, err := abp.CompressPayload(c.algorithm, in, c.level)
+		if err != nil {
+			return nil, false, 0, err
+		}
+		if len(out) <= capacity && len(out) < len(in) {
+			c.pending = c.pending[len(in):]
+			if scale == c.scale && c.scale < maxCompressScale {
+				c.scale *= 2
+			} else {
+				c.scale = scale
+			}
+			return out, true, len(in), c.readErr()
+		}
+	}
+
+	// incompressible; send it as is
+	c.scale = 1
+	in := c.pending
+	if len(in) > capacity {
+		in = in[:capacity]
+	}
+	c.pending = c.pending[len(in):]
+	return in, false, len(in), c.readErr()
+}
+
+func (c *compressor) readErr() error {
+	if c.eof && len(c.pending) == 0 {
+		return io.EOF
+	}
+	return nil
+}
+
+// returns errHole once all data before a hole has been consumed.
+func (c *compressor) holeErr() error {
+	if c.hole && len(c.pending) == 0 {
+		c.hole = false
+		return errHole
+	}
+	return nil
+}
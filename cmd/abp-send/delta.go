@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// fetches the block signatures of the receiver's old version of the file,
+// one reply's worth at a time. every request is resent until the matching
+// reply arrived.
+func fetchSignatures(conn *net.UDPConn) ([]abp.BlockSignature, int) {
+	var sigs []abp.BlockSignature
+	for attempt := 0; ; attempt++ {
+		checkRetries(attempt, EXIT_TIMEOUT)
+		request := make([]byte, 4)
+		binary.BigEndian.PutUint32(request, uint32(len(sigs)))
+		hdr := abp.Header{Length: uint16(len(request)), Flags: abp.HDR_DELTA}
+		pkt := finalizePkg(hdr, request)
+		limiter.wait(len(pkt))
+		_, err := writePacket(conn, pkt)
+		if err != nil {
+			panic(err)
+		}
+
+		replyHdr, payload, ok := readPacket(ackTimeout)
+		if !ok || replyHdr.Flags != abp.HDR_DELTA {
+			continue
+		}
+		sigHdr, replySigs, ok := abp.ParseSignatures(payload)
+		if !ok || sigHdr.FirstBlock != uint32(len(sigs)) {
+			continue
+		}
+		sigs = append(sigs, replySigs...)
+		attempt = -1
+		if len(sigs) >= int(sigHdr.TotalBlocks) || len(replySigs) == 0 {
+			return sigs, int(sigHdr.BlockSize)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// delay between starting the probes of two addresses of a receiver, as
+// recommended by RFC 8305 (happy eyeballs)
+const DIAL_STAGGER = 250 * time.Millisecond
+
+// ECHO packets sent to an address before it is given up
+const DIAL_PROBES = 3
+
+// names a receiver looked up by SRV record, e.g. srv:_abp._udp.example.com
+const SRV_PREFIX = "srv:"
+
+// resolves the receiver's address. if the host has several, all of them
+// are probed with ECHO packets, a new one every DIAL_STAGGER, and the
+// first that answers is used. if none does, e.g. because the receiver
+// predates ECHO packets, the first address is used as before.
+func resolveReceiver(hostPort string) (*net.UDPAddr, error) {
+	if strings.HasPrefix(hostPort, SRV_PREFIX) {
+		return resolveSRV(strings.TrimPrefix(hostPort, SRV_PREFIX))
+	}
+	host, portName, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return net.ResolveUDPAddr("udp", hostPort)
+	}
+	port, err := net.LookupPort("udp", portName)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := lookupAddrs(host, port)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 1 {
+		return addrs[0], nil
+	}
+	fmt.Printf("%s has %d addresses, probing them...\n", host, len(addrs))
+	if addr := probeAddrs(addrs); addr != nil {
+		return addr, nil
+	}
+	fmt.Printf("No address of %s answered, using %v.\n", host, addrs[0])
+	return addrs[0], nil
+}
+
+// resolves the SRV records of name. the targets are tried in the order of
+// their priority, those of equal priority in a random order weighted as
+// the records ask for, until the addresses of one answer. if none does,
+// the first target is used.
+func resolveSRV(name string) (*net.UDPAddr, error) {
+	_, srvs, err := net.LookupSRV("", "", name)
+	if err != nil {
+		return nil, err
+	}
+	var first *net.UDPAddr
+	for _, srv := range srvs {
+		addrs, err := lookupAddrs(strings.TrimSuffix(srv.Target, "."),
+			int(srv.Port))
+		if err != nil {
+			fmt.Printf("[NET] skipping %s: %v\n", srv.Target, err)
+			continue
+		}
+		if first == nil {
+			first = addrs[0]
+		}
+		if len(srvs) == 1 && len(addrs) == 1 {
+			return first, nil
+		}
+		fmt.Printf("Trying %s port %d (priority %d, weight %d)...\n",
+			srv.Target, srv.Port, srv.Priority, srv.Weight)
+		if addr := probeAddrs(addrs); addr != nil {
+			return addr, nil
+		}
+	}
+	if first == nil {
+		return nil, fmt.Errorf("no SRV target of %s resolves", name)
+	}
+	fmt.Printf("No SRV target of %s answered, using %v.\n", name, first)
+	return first, nil
+}
+
+// looks up the addresses of host, with IPv6 and IPv4 taking turns.
+func lookupAddrs(host string, port int) ([]*net.UDPAddr, error) {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []*net.UDPAddr
+	for _, ip := range interleaveFamilies(ips) {
+		addrs = append(addrs, &net.UDPAddr{IP: ip, Port: port})
+	}
+	return addrs, nil
+}
+
+// probes addrs, a new one every DIAL_STAGGER, and returns the first that
+// answers, nil if none does.
+func probeAddrs(addrs []*net.UDPAddr) *net.UDPAddr {
+	answered := make(chan *net.UDPAddr, len(addrs))
+	done := make(chan struct{})
+	defer close(done)
+	for i, addr := range addrs {
+		go probeAddr(addr, time.Duration(i)*DIAL_STAGGER, answered, done)
+	}
+	for range addrs {
+		if addr := <-answered; addr != nil {
+			fmt.Printf("%v answered first.\n", addr)
+			return addr
+		}
+	}
+	return nil
+}
+
+// sends ECHO packets to addr after waiting for delay, on a socket of its
+// own, and passes addr on to answered if the receiver replies, nil
+// otherwise. gives up early once done is closed.
+func probeAddr(addr *net.UDPAddr, delay time.Duration,
+	answered chan<- *net.UDPAddr, done <-chan struct{}) {
+	select {
+	case <-time.After(delay):
+	case <-done:
+		answered <- nil
+		return
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		fmt.Printf("[NET] probing %v failed: %v\n", addr, err)
+		answered <- nil
+		return
+	}
+	defer conn.Close()
+
+	buf := make([]byte, abp.MaxPacketLength)
+	for seq := uint32(0); seq < DIAL_PROBES; seq++ {
+		select {
+		case <-done:
+			answered <- nil
+			return
+		default:
+		}
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, seq)
+		pkt := finalizePkg(abp.Header{Length: uint16(len(payload)),
+			Flags: abp.HDR_ECHO}, payload)
+		tracePacket("->", pkt)
+		if _, err := conn.Write(pkt); err != nil {
+			// most likely an ICMP error for an earlier probe
+			continue
+		}
+		conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break
+			}
+			tracePacket("<-", buf[:n])
+			hdr, data, err := abp.ParsePacket(buf[:n], false)
+			if err == nil && hdr.Flags == abp.HDR_ECHO && len(data) == 4 &&
+				binary.BigEndian.Uint32(data) == seq {
+				answered <- addr
+				return
+			}
+		}
+	}
+	answered <- nil
+}
+
+// orders addresses so that IPv6 and IPv4 take turns, starting with the
+// family of the first one, which keeps the resolver's preference while
+// making sure a broken family doesn't delay the other one for long.
+func interleaveFamilies(ips []net.IP) []net.IP {
+	var first, second []net.IP
+	for _, ip := range ips {
+		if (ip.To4() == nil) == (ips[0].To4() == nil) {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	ordered := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// queue watch: files dropped into a directory are moved into the spool
+// and queued, so a crash or reboot neither loses nor forgets them. they
+// wait in a directory of their own below <spool>/files, named like their
+// queue entry, and are moved to the sent directory once they arrived.
+type dropDir struct {
+	q       *abp.Queue
+	dir     string
+	files   string
+	sentDir string
+	target  string
+}
+
+func newDropDir(q *abp.Queue, spool string, dir string, sentDir string,
+	target string) *dropDir {
+	if sentDir == "" {
+		sentDir = filepath.Join(filepath.Dir(filepath.Clean(dir)), "sent")
+	}
+	// queue entries have absolute paths
+	files, err := filepath.Abs(filepath.Join(spool, "files"))
+	if err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	d := &dropDir{q, dir, files, sentDir, target}
+	for _, path := range []string{d.files, d.sentDir} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			exitWith(EXIT_USAGE, "%v", err)
+		}
+	}
+	d.recover()
+	return d
+}
+
+// picks up where a crash left the spool: files moved in but not queued
+// yet are queued, entries whose file was already moved on are done.
+func (d *dropDir) recover() {
+	entries, err := d.q.Entries()
+	if err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	queued := make(map[string]bool)
+	for _, e := range entries {
+		if !d.owns(e) {
+			continue
+		}
+		if _, err := os.Stat(e.Path); os.IsNotExist(err) {
+			d.q.Remove(e)
+			continue
+		}
+		queued[e.Path] = true
+	}
+	files, _ := filepath.Glob(filepath.Join(d.files, "*", "*"))
+	for _, path := range files {
+		if queued[path] {
+			continue
+		}
+		fmt.Printf("[QUEUE] queueing %s again\n", path)
+		if _, err := d.q.Add(path, d.target); err != nil {
+			fmt.Printf("[QUEUE] %v\n", err)
+		}
+	}
+}
+
+// whether an entry was spooled from the drop directory
+func (d *dropDir) owns(e *abp.QueueEntry) bool {
+	return strings.HasPrefix(e.Path, d.files+string(os.PathSeparator))
+}
+
+// spools every file in the drop directory once its size and modification
+// time stayed the same for one scan, like watch sends them.
+func (d *dropDir) watch() {
+	seen := make(map[string]os.FileInfo)
+	failed := make(map[string]time.Time)
+	fmt.Printf("Watching %s, queueing new files for %s\n", d.dir, d.target)
+	for {
+		entries, err := ioutil.ReadDir(d.dir)
+		if err != nil {
+			exitWith(EXIT_USAGE, "%v", err)
+		}
+		current := make(map[string]os.FileInfo)
+		for _, info := range entries {
+			if !info.Mode().IsRegular() {
+				continue
+			}
+			name := info.Name()
+			current[name] = info
+			last, ok := seen[name]
+			if !ok || last.Size() != info.Size() ||
+				!last.ModTime().Equal(info.ModTime()) ||
+				time.Since(failed[name]) < watchRetryDelay {
+				continue
+			}
+			if err := d.spool(name); err != nil {
+				fmt.Printf("[QUEUE] can't spool %s: %v\n", name, err)
+				failed[name] = time.Now()
+				continue
+			}
+			delete(failed, name)
+			delete(current, name)
+		}
+		seen = current
+		time.Sleep(watchInterval)
+	}
+}
+
+// moves a dropped file into the spool and queues it. the rename is
+// atomic, so a file is either still dropped or spooled; the spool has to
+// be on the same filesystem as the drop directory.
+func (d *dropDir) spool(name string) error {
+	dir := filepath.Join(d.files, fmt.Sprintf("%020d", time.Now().UnixNano()))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name)
+	if err := os.Rename(filepath.Join(d.dir, name), path); err != nil {
+		os.Remove(dir)
+		return err
+	}
+	// a crash right here is taken care of by recover
+	if _, err := d.q.Add(path, d.target); err != nil {
+		return err
+	}
+	fmt.Printf("[QUEUE] queued %s\n", name)
+	return nil
+}
+
+// moves a file that arrived to the sent directory.
+func (d *dropDir) done(e *abp.QueueEntry) {
+	if !d.owns(e) {
+		return
+	}
+	sent := filepath.Join(d.sentDir, filepath.Base(e.Path))
+	if err := os.Rename(e.Path, sent); err != nil {
+		fmt.Printf("[QUEUE] can't move %s to %s: %v\n", e.Path, d.sentDir,
+			err)
+		return
+	}
+	os.Remove(filepath.Dir(e.Path))
+}
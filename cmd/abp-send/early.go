@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// the first data packet, sent right behind the FILENAME packet instead of
+// after its ACK. this saves a round trip, the whole transfer of a file
+// fitting into one packet takes a single one.
+type earlyData struct {
+	chunk   []byte
+	count   int
+	readErr error
+	// flags of the packet and its ACK
+	flags  uint16
+	packet []byte
+	sentAt time.Time
+	acked  bool
+}
+
+// reads the first chunk of the file and frames it as the first data
+// packet. the options of the FILENAME packet aren't accepted yet, so it
+// is neither compressed nor FEC protected nor skips a hole.
+func readEarlyData(r io.Reader, size int) *earlyData {
+	chunk := make([]byte, size)
+	count, err := io.ReadFull(r, chunk)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	} else if err != nil && err != io.EOF {
+		panic(err)
+	}
+	e := &earlyData{chunk: chunk[:count], count: count, readErr: err,
+		flags: abp.HDR_ALTERNATING}
+	if err == io.EOF {
+		e.flags |= abp.HDR_FIN
+	}
+	e.packet = finalizePkg(abp.Header{Length: uint16(count), Flags: e.flags},
+		e.chunk)
+	return e
+}
+
+// sends the packet along with (another copy of) the FILENAME packet.
+func (e *earlyData) send(conn *net.UDPConn) {
+	limiter.wait(len(e.packet))
+	e.sentAt = time.Now()
+	if _, err := writePacket(conn, e.packet); err != nil {
+		// e.g. connection refused, reported for the FILENAME packet
+		// sent just before because nobody is listening (yet)
+		fmt.Printf("[NET] sending early data failed: %v\n", err)
+	}
+}
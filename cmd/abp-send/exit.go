@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// exit codes of the sender
+const (
+	EXIT_OK               = 0
+	EXIT_USAGE            = 1 // invalid arguments or unreadable input file
+	EXIT_HANDSHAKE_FAILED = 2 // the FILENAME packet was never acknowledged
+	EXIT_TIMEOUT          = 3 // a data packet was never acknowledged
+	EXIT_VERIFY_FAILED    = 4 // verify: the receiver's copy differs
+	EXIT_ABORTED          = 5 // the receiver aborted the transfer
+)
+
+// give up after this many retransmissions of one packet (0 = never), see
+// -max-retries
+var maxRetries int
+
+// exits with one of the EXIT_* codes.
+func exitWith(code int, format string, args ...interface{}) {
+	fmt.Printf(format+"\n", args...)
+	finishTransferMetrics(code, 0)
+	os.Exit(code)
+}
+
+// called before every (re)transmission of a packet; gives up with code
+// once it was retransmitted -max-retries times.
+func checkRetries(attempt int, code int) {
+	if maxRetries > 0 && attempt > maxRetries {
+		if code == EXIT_HANDSHAKE_FAILED {
+			exitWith(code, "\nGiving up, no reply from the receiver after "+
+				"%d retries.", maxRetries)
+		}
+		exitWith(code, "\nGiving up, packet not acknowledged after %d "+
+			"retries.", maxRetries)
+	}
+}
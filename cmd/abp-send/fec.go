@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// splits a group of file data into k data shards of equal (padded) length
+// followed by m parity shards.
+func fecShards(data []byte, k int, m int) [][]byte {
+	shardLen := abp.FecShardLength(len(data), k)
+	shards := make([][]byte, k+m)
+	for i := range shards {
+		shards[i] = make([]byte, shardLen)
+		if i < k && i*shardLen < len(data) {
+			copy(shards[i], data[i*shardLen:])
+		}
+	}
+	abp.FecEncode(shards, k)
+	return shards
+}
+
+// sends one FEC group (the data of up to k regular packets) and blocks
+// until the receiver acknowledged the whole group. The group is resent
+// with an increased generation on every timeout so the receiver can tell
+// retransmissions apart from late shards of a group it already decoded.
+func sendFecGroup(conn *net.UDPConn, data []byte, offset int64,
+	maxShard int, m int, flags uint16) {
+	// the last group may need fewer data shards; always send at least one
+	// so that an empty FIN group still reaches the receiver.
+	k := (len(data) + maxShard - 1) / maxShard
+	if k == 0 {
+		k = 1
+	}
+	shards := fecShards(data, k, m)
+	shardLen := abp.FecShardLength(len(data), k)
+
+	for generation := 0; ; generation++ {
+		checkRetries(generation, EXIT_TIMEOUT)
+		sentAt := time.Now()
+		stats.sent(generation)
+		timeline.sent(offset, len(data), generation)
+		for idx := range shards {
+			// data shards are sent without their padding, the
+			// receiver restores it from Total.
+			payloadLen := shardLen
+			if idx < k {
+				payloadLen = len(data) - idx*shardLen
+				if payloadLen > shardLen {
+					payloadLen = shardLen
+				} else if payloadLen < 0 {
+					payloadLen = 0
+				}
+			}
+			fecHdr := abp.FecHeader{
+				Index:      uint8(idx),
+				K:          uint8(k),
+				M:          uint8(m),
+				Generation: uint8(generation),
+				Total:      uint32(len(data)),
+			}
+			payload := append(abp.SerializeFecHeader(fecHdr),
+				shards[idx][:payloadLen]...)
+			hdr := abp.Header{Length: uint16(len(payload)), Flags: flags}
+
+			// FSM event: sendData
+			pkt := finalizePkg(hdr, payload)
+			limiter.wait(len(pkt))
+			writeStart := time.Now()
+			_, err := writePacket(conn, pkt)
+			phases.send += time.Since(writeStart)
+			if err != nil {
+				panic(err)
+			}
+		}
+		printProgress()
+
+		// the receiver acknowledges a decoded group just like a single
+		// (uncompressed) data packet, i.e. without the FEC flag.
+		if waitForAck(int(flags &^ (abp.HDR_FEC | abp.HDR_COMPRESSED))) {
+			stats.acked(generation, time.Since(sentAt))
+			return
+		}
+	}
+}
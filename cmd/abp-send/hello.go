@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// HELLO packets sent before a receiver that doesn't answer them is
+// assumed to predate them
+const HELLO_ATTEMPTS = 3
+
+// the FILENAME options this sender may request
+const senderOptions = abp.HDR_COMPACT | abp.HDR_COMPRESSED | abp.HDR_DELTA |
+	abp.HDR_SIZE | abp.HDR_SKIP | abp.HDR_STORED_NAME | abp.HDR_DRY_RUN |
+	abp.HDR_BENCH
+
+// the extended flags of this sender
+const senderExtended = abp.EXT_NAME_PARTS | abp.EXT_STREAMS | abp.EXT_PATHS |
+	abp.EXT_TOKEN | abp.EXT_PRIORITY
+
+// -hello: exchanges capabilities with the receiver and returns the ones
+// both ends support, or nil if the receiver doesn't answer, in which case
+// the options are negotiated by the FILENAME packet alone as before. with
+// token (-token), it offers a session token. with -compress, only that
+// algorithm is offered, the receiver can't pick another one.
+func sayHello(conn *net.UDPConn, maxPayload int, token bool,
+	compress string) *abp.Capabilities {
+	local := abp.Capabilities{
+		Options:     senderOptions,
+		Window:      true,
+		Compression: abp.Compressions,
+		Checksums:   []string{"crc32q"},
+		MaxPayload:  maxPayload,
+		Extended:    senderExtended &^ abp.EXT_TOKEN,
+	}
+	if compress != "" {
+		local.Compression = []string{compress}
+	}
+	if token {
+		local.Extended |= abp.EXT_TOKEN
+		local.Token = newSessionToken()
+	}
+	payload, err := local.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	pkt := finalizePkg(abp.Header{Flags: abp.HDR_HELLO,
+		Length: uint16(len(payload))}, payload)
+	for attempt := 0; attempt < HELLO_ATTEMPTS; attempt++ {
+		if _, err := writePacket(conn, pkt); err != nil {
+			panic(err)
+		}
+		deadline := time.Now().Add(handshakeTimeout)
+		for {
+			reply, data, ok := readPacket(time.Until(deadline))
+			if !ok {
+				break
+			}
+			if reply.Flags != abp.HDR_HELLO {
+				continue
+			}
+			remote, err := abp.ParseCapabilities(data)
+			if err != nil {
+				fmt.Printf("[HELLO] invalid reply: %v\n", err)
+				continue
+			}
+			if remote.Extended&abp.EXT_TOKEN != 0 &&
+				remote.Token != local.Token {
+				fmt.Printf("[HELLO] reply with a foreign token, ignoring\n")
+				continue
+			}
+			common := local.Common(remote)
+			if len(common.Checksums) == 0 {
+				exitWith(EXIT_HANDSHAKE_FAILED, "Receiver supports none of "+
+					"our checksums (%v).", local.Checksums)
+			}
+			fmt.Printf("[HELLO] agreed on %v\n", common)
+			if common.Extended&abp.EXT_TOKEN != 0 {
+				atomic.StoreUint32(&sessionToken, local.Token)
+			}
+			return &common
+		}
+	}
+	fmt.Printf("Receiver doesn't answer HELLO packets, using the plain " +
+		"handshake.\n")
+	return nil
+}
+
+// the token the receiver seals its replies with once HELLO agreed on it,
+// see abp.SealChecksum; 0 before
+var sessionToken uint32
+
+func newSessionToken() uint32 {
+	var token [4]byte
+	for binary.BigEndian.Uint32(token[:]) == 0 {
+		if _, err := rand.Read(token[:]); err != nil {
+			panic(err)
+		}
+	}
+	return binary.BigEndian.Uint32(token[:])
+}
+
+// drops the options of a FILENAME packet the receiver doesn't support
+// according to its HELLO; it would refuse them anyway. the file size is
+// part of the packet either way, and the priority is agreed on as an
+// extended flag. without a HELLO, compression can only be gzip.
+func dropUnsupported(flags uint16, caps *abp.Capabilities,
+	compress string) uint16 {
+	if caps == nil {
+		if flags&abp.HDR_COMPRESSED != 0 && compress != "gzip" {
+			fmt.Printf("Receiver can't agree on %s compression without "+
+				"HELLO, not requesting it\n", compress)
+			flags &^= abp.HDR_COMPRESSED
+		}
+		return flags
+	}
+	unsupported := flags &^ (caps.Options | abp.HDR_FILENAME | abp.HDR_SIZE |
+		abp.HDR_PRIORITY)
+	if flags&abp.HDR_COMPRESSED != 0 && !abp.Supports(caps.Compression,
+		compress) {
+		unsupported |= abp.HDR_COMPRESSED
+	}
+	if unsupported != 0 {
+		fmt.Printf("[HELLO] receiver doesn't support %s, not requesting it\n",
+			abp.FlagString(unsupported))
+	}
+	return flags &^ unsupported
+}
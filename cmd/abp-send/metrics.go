@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+
+	"github.com/v4lli/go-abp/statsd"
+)
+
+// -statsd: counters and timings of the transfer
+var metrics statsd.Client
+
+// when the FILENAME packet was first sent, zero before
+var transferStarted time.Time
+
+// metric names of the failures, by exit code
+var failureMetrics = map[int]string{
+	EXIT_HANDSHAKE_FAILED: "handshake_failed",
+	EXIT_TIMEOUT:          "timeout",
+	EXIT_VERIFY_FAILED:    "verify_failed",
+	EXIT_ABORTED:          "aborted",
+}
+
+func startTransferMetrics() {
+	transferStarted = time.Now()
+	metrics.Count("transfers.started", 1)
+}
+
+// sends the metrics of a transfer ending with one of the EXIT_* codes.
+// bytes is only known on success.
+func finishTransferMetrics(code int, bytes int64) {
+	if transferStarted.IsZero() {
+		return
+	}
+	if code == EXIT_OK {
+		metrics.Count("transfers.ok", 1)
+		metrics.Count("bytes", bytes)
+	} else if name, ok := failureMetrics[code]; ok {
+		metrics.Count("transfers.failed", 1)
+		metrics.Count("transfers.failed."+name, 1)
+	}
+	metrics.Count("packets", stats.packets)
+	metrics.Count("retransmits", stats.retransmits)
+	if stats.rttSamples > 0 {
+		metrics.Timing("rtt", stats.rttSum/time.Duration(stats.rttSamples))
+	}
+	metrics.Timing("transfer_time", time.Since(transferStarted))
+	transferStarted = time.Time{}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/v4lli/go-abp/impair"
+)
+
+// sync <dir> <host:port>: mirrors the files of a directory tree to the
+// receiver. every file whose copy on the receiver is missing or has a
+// different hash (see verify) is sent as a delta transfer in a child
+// sender with the options given, unchanged ones aren't. the receiver
+// stores the files flat, photos/2024/a.jpg becomes photos.2024.a.jpg, so
+// paths that would end up under the same name are refused. the names are
+// recorded in a manifest per receiver and directory; with -delete, the
+// receiver's files recorded there without a local counterpart anymore are
+// deleted through its control port.
+func runSync(args []string, deleteRemote bool, control string,
+	manifests string) {
+	if len(args) != 2 {
+		exitWith(EXIT_USAGE, "usage: sync <dir> <host:port>")
+	}
+	if deleteRemote && control == "" {
+		exitWith(EXIT_USAGE, "-delete needs the receiver's -control port")
+	}
+	dir, hostPort := filepath.Clean(args[0]), args[1]
+	base := filepath.Base(dir)
+	var names []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo,
+		err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		names = append(names, base+"/"+filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	keep := make(map[string]string)
+	for _, name := range names {
+		stored := receiverName(name)
+		if other, ok := keep[stored]; ok {
+			exitWith(EXIT_USAGE, "[SYNC] %s and %s would both be stored "+
+				"as %s", other, name, stored)
+		}
+		keep[stored] = name
+	}
+	manifestPath := filepath.Join(manifests, hostPort+"-"+base)
+	synced, err := readManifest(manifestPath)
+	if err != nil {
+		exitWith(EXIT_USAGE, "[SYNC] %v", err)
+	}
+
+	udpAddr, err := resolveReceiver(hostPort)
+	if err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	defer conn.Close()
+	go readReplies(conn, &impair.Impairment{})
+	self, err := os.Executable()
+	if err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	options := append(childArgs("delete", "control", "manifests"), "-delta")
+
+	sent, unchanged, deleted := 0, 0, 0
+	code := EXIT_OK
+	for _, name := range names {
+		fh, err := os.Open(filepath.Join(filepath.Dir(dir), name))
+		if err != nil {
+			exitWith(EXIT_USAGE, "%v", err)
+		}
+		local, err := hashLocal(fh)
+		fh.Close()
+		if err != nil {
+			exitWith(EXIT_USAGE, "%v", err)
+		}
+		if bytes.Equal(remoteHash(conn, []byte(name)), local) {
+			unchanged++
+			continue
+		}
+		fmt.Printf("[SYNC] sending %s\n", name)
+		// the receiver gets the name relative to the directory's parent
+		cmd := exec.Command(self, append(options, hostPort, name)...)
+		cmd.Dir = filepath.Dir(dir)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Printf("[SYNC] sending %s failed: %v\n", name, err)
+			if exitErr, ok := err.(*exec.ExitError); ok && code == EXIT_OK {
+				code = exitErr.ExitCode()
+			}
+			continue
+		}
+		sent++
+	}
+
+	// the files of this sync on the receiver: the local ones, sent now or
+	// before, and the ones gone locally until they are deleted
+	for stored := range keep {
+		synced[stored] = true
+	}
+	if deleteRemote {
+		remote, err := controlCommand(control, "list "+base+".")
+		if err != nil {
+			exitWith(EXIT_USAGE, "[SYNC] can't list the receiver's "+
+				"files: %v", err)
+		}
+		onReceiver := make(map[string]bool)
+		for _, line := range remote[1:] {
+			// size and name
+			fields := strings.SplitN(strings.TrimSpace(line), "  ", 2)
+			if len(fields) == 2 {
+				onReceiver[fields[1]] = true
+			}
+		}
+		for stored := range synced {
+			if _, ok := keep[stored]; ok {
+				continue
+			}
+			if !onReceiver[stored] {
+				// deleted by someone else
+				delete(synced, stored)
+				continue
+			}
+			if _, err := controlCommand(control,
+				"delete "+stored); err != nil {
+				fmt.Printf("[SYNC] can't delete %s: %v\n", stored, err)
+				continue
+			}
+			fmt.Printf("[SYNC] deleted %s\n", stored)
+			delete(synced, stored)
+			deleted++
+		}
+	}
+	if err := writeManifest(manifestPath, synced); err != nil {
+		fmt.Printf("[SYNC] can't write %s: %v\n", manifestPath, err)
+		if code == EXIT_OK {
+			code = EXIT_USAGE
+		}
+	}
+	exitWith(code, "[SYNC] %d sent, %d unchanged, %d deleted", sent,
+		unchanged, deleted)
+}
+
+// reads the receiver names a sync recorded, one per line; there are none
+// before the first sync.
+func readManifest(path string) (map[string]bool, error) {
+	synced := make(map[string]bool)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return synced, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(string(data), "\n") {
+		if name != "" {
+			synced[name] = true
+		}
+	}
+	return synced, nil
+}
+
+// replaces the manifest at path, so a crash leaves the old one.
+func writeManifest(path string, synced map[string]bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	var names []string
+	for name := range synced {
+		names = append(names, name+"\n")
+	}
+	sort.Strings(names)
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(strings.Join(names, "")), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// the name the receiver stores a file sent as name under
+func receiverName(name string) string {
+	return strings.NewReplacer("/", ".", "\\", ".").Replace(name)
+}
+
+// runs a command on the receiver's control port (see -control of the
+// receiver) and returns the lines of the answer.
+func controlCommand(addr string, command string) ([]string, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "%s\n", command)
+	var lines []string
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() && scanner.Text() != "" {
+		if strings.HasPrefix(scanner.Text(), "error: ") {
+			return nil, fmt.Errorf("%s",
+				strings.TrimPrefix(scanner.Text(), "error: "))
+		}
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no answer")
+	}
+	return lines, scanner.Err()
+}
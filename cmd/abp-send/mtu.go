@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"syscall"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// set by -df: all packets carry the Don't Fragment bit, not just the
+// probes, so routers drop those too large for the next link instead of
+// fragmenting them
+var dontFragment bool
+
+// set while discoverPacketLength probes, which expects packets to be too
+// large
+var probingMTU bool
+
+// path MTUs tried in this order: the IPv6 minimum, Ethernet and jumbo
+// frames. discovery stops at the first one that doesn't get through.
+var probeMTUs = []int{1280, 1500, 9000}
+
+// bytes of IP and UDP headers in front of every packet
+func ipOverhead(conn *net.UDPConn) int {
+	if conn.RemoteAddr().(*net.UDPAddr).IP.To4() != nil {
+		return 20 + 8
+	}
+	return 40 + 8
+}
+
+// converts a MTU into the length of our packets incl. header.
+func packetLength(conn *net.UDPConn, mtu int) int {
+	length := mtu - ipOverhead(conn)
+	if length > abp.MaxPacketLength {
+		length = abp.MaxPacketLength
+	}
+	return length
+}
+
+// finds the largest packet length that reaches the receiver and comes back
+// with ECHO packets sent with the Don't Fragment bit set. this also covers
+// receivers which can't take packets larger than the default (they don't
+// answer, or with a truncated echo).
+func discoverPacketLength(conn *net.UDPConn) int {
+	setDontFragment(conn, true)
+	probingMTU = true
+	defer func() {
+		setDontFragment(conn, dontFragment)
+		probingMTU = false
+	}()
+
+	best := abp.DefaultPacketLength
+	for _, mtu := range probeMTUs {
+		length := packetLength(conn, mtu)
+		if length <= best {
+			continue
+		}
+		if !probe(conn, length) {
+			break
+		}
+		best = length
+	}
+	return best
+}
+
+// sends an ECHO packet of the given length (twice, if needed) and reports
+// whether it came back intact.
+func probe(conn *net.UDPConn, length int) bool {
+	payload := make([]byte, length-abp.HeaderLength)
+	rand.Read(payload)
+	hdr := abp.Header{Length: uint16(len(payload)), Flags: abp.HDR_ECHO}
+	pkt := finalizePkg(hdr, payload)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		limiter.wait(len(pkt))
+		if _, err := writePacket(conn, pkt); err != nil {
+			// EMSGSIZE: larger than the MTU of the local interface
+			// or a known path MTU
+			fmt.Printf("[MTU] %d byte packets: %v%s\n", length, err,
+				describePathMTU(conn))
+			return false
+		}
+		reply, data, ok := readPacket(handshakeTimeout)
+		if ok && reply.Flags == abp.HDR_ECHO && bytes.Equal(data, payload) {
+			return true
+		}
+	}
+	fmt.Printf("[MTU] %d byte packets don't get through\n", length)
+	return false
+}
+
+// tells whether err is the kernel refusing a packet larger than the path
+// MTU, right away or because of an earlier ICMP fragmentation needed.
+func tooBig(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE)
+}
+
+// the path MTU as part of a message, if known
+func describePathMTU(conn *net.UDPConn) string {
+	if mtu := pathMTU(conn); mtu > 0 {
+		return fmt.Sprintf(" (path MTU %d)", mtu)
+	}
+	return ""
+}
+
+// gives up on a transfer whose packets of length bytes don't get through
+// the path and tells which -mtu would.
+func fragmentationNeeded(conn *net.UDPConn, length int) {
+	hint := "a smaller -mtu"
+	if mtu := pathMTU(conn); mtu > 0 {
+		hint = fmt.Sprintf("-mtu %d", mtu)
+	}
+	exitWith(EXIT_USAGE, "\n%d byte packets don't fit through the path%s "+
+		"and the Don't Fragment bit is set; try %s.",
+		length+ipOverhead(conn), describePathMTU(conn), hint)
+}
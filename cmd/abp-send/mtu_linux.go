@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// sets the Don't Fragment bit on all packets (IP_PMTUDISC_DO), or goes back
+// to the default of setting it only as long as the path MTU allows.
+func setDontFragment(conn *net.UDPConn, on bool) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return
+	}
+	v4, v6 := syscall.IP_PMTUDISC_WANT, syscall.IPV6_PMTUDISC_WANT
+	if on {
+		v4, v6 = syscall.IP_PMTUDISC_DO, syscall.IPV6_PMTUDISC_DO
+	}
+	raw.Control(func(fd uintptr) {
+		if conn.RemoteAddr().(*net.UDPAddr).IP.To4() != nil {
+			syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP,
+				syscall.IP_MTU_DISCOVER, v4)
+		} else {
+			syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6,
+				syscall.IPV6_MTU_DISCOVER, v6)
+		}
+	})
+}
+
+// the path MTU the kernel knows for conn, lowered by ICMP fragmentation
+// needed messages; 0 if unknown.
+func pathMTU(conn *net.UDPConn) int {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	mtu := 0
+	raw.Control(func(fd uintptr) {
+		if conn.RemoteAddr().(*net.UDPAddr).IP.To4() != nil {
+			mtu, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP,
+				syscall.IP_MTU)
+		} else {
+			mtu, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IPV6,
+				syscall.IPV6_MTU)
+		}
+	})
+	if err != nil {
+		return 0
+	}
+	return mtu
+}
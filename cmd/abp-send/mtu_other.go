@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"net"
+)
+
+// not supported on this platform; probes may get fragmented, which still
+// tells whether the receiver takes larger packets.
+func setDontFragment(conn *net.UDPConn, on bool) {
+}
+
+// unknown on this platform.
+func pathMTU(conn *net.UDPConn) int {
+	return 0
+}
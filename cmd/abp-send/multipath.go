@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// consecutive timeouts after which failover mode gives up on a path
+const FAILOVER_TIMEOUTS = 3
+
+// JOIN packets sent on a path before it is given up
+const JOIN_ATTEMPTS = 10
+
+// a socket bound to one local interface or address, see -paths
+type path struct {
+	name string
+	conn *net.UDPConn
+	// set by readReplies once the receiver confirmed the path
+	joined int32
+}
+
+// the paths of a transfer. the first one carries the handshake, the others
+// join the transfer with HDR_JOIN once the FILENAME ACK told its ID. in
+// failover mode packets go out on one path until it stops getting replies,
+// in stripe mode the joined paths take turns.
+type multipath struct {
+	stripe   bool
+	paths    []*path
+	current  int
+	timeouts int
+}
+
+// nil without -paths
+var paths *multipath
+
+// opens a socket to raddr on every interface (or local address) in the
+// comma separated spec.
+func openPaths(spec string, mode string, raddr *net.UDPAddr) (*multipath,
+	error) {
+	if mode != "failover" && mode != "stripe" {
+		return nil, fmt.Errorf("unknown -paths-mode %q, want failover or "+
+			"stripe", mode)
+	}
+	m := &multipath{stripe: mode == "stripe"}
+	for _, name := range strings.Split(spec, ",") {
+		ip, err := localAddr(name, raddr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.DialUDP("udp", &net.UDPAddr{IP: ip}, raddr)
+		if err != nil {
+			return nil, fmt.Errorf("path %s: %v", name, err)
+		}
+		m.paths = append(m.paths, &path{name: name, conn: conn})
+	}
+	m.paths[0].joined = 1
+	return m, nil
+}
+
+// the address of the interface name in the address family of raddr, or
+// name itself if it is an address.
+func localAddr(name string, raddr *net.UDPAddr) (net.IP, error) {
+	if ip := net.ParseIP(name); ip != nil {
+		return ip, nil
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("path %s: %v", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("path %s: %v", name, err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() != nil) != (raddr.IP.To4() != nil) ||
+			ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return ipNet.IP, nil
+	}
+	return nil, fmt.Errorf("path %s: no usable address for %v", name,
+		raddr.IP)
+}
+
+// the socket the next packet is sent on
+func (m *multipath) conn() *net.UDPConn {
+	if m.stripe {
+		for i := 1; i <= len(m.paths); i++ {
+			p := m.paths[(m.current+i)%len(m.paths)]
+			if atomic.LoadInt32(&p.joined) != 0 {
+				m.current = (m.current + i) % len(m.paths)
+				break
+			}
+		}
+	}
+	return m.paths[m.current].conn
+}
+
+// called whenever no reply arrived in time. in failover mode, the next
+// joined path takes over after a few timeouts in a row.
+func (m *multipath) timeout() {
+	m.timeouts++
+	if m.stripe || m.timeouts < FAILOVER_TIMEOUTS {
+		return
+	}
+	for i := 1; i < len(m.paths); i++ {
+		p := m.paths[(m.current+i)%len(m.paths)]
+		if atomic.LoadInt32(&p.joined) != 0 {
+			fmt.Printf("\n[PATH] no replies on %s, switching to %s\n",
+				m.paths[m.current].name, p.name)
+			m.current = (m.current + i) % len(m.paths)
+			m.timeouts = 0
+			return
+		}
+	}
+}
+
+func (m *multipath) replied() {
+	m.timeouts = 0
+}
+
+// adds the other paths to the transfer with the ID from the FILENAME ACK.
+// JOIN packets are repeated in the background until the receiver
+// confirms them.
+func (m *multipath) join(id []byte) {
+	// the receiver takes the path for a new client, which never uses
+	// compact headers
+	pkt := append(abp.SerializeHeader(abp.Header{Length: uint16(len(id)),
+		Flags: abp.HDR_JOIN}), id...)
+	abp.SetChecksum(pkt)
+	for _, p := range m.paths[1:] {
+		go func(p *path) {
+			for attempt := 0; attempt < JOIN_ATTEMPTS; attempt++ {
+				if atomic.LoadInt32(&p.joined) != 0 {
+					return
+				}
+				tracePacket("->", pkt)
+				if _, err := p.conn.Write(pkt); err != nil {
+					fmt.Printf("[PATH] sending on %s failed: %v\n", p.name,
+						err)
+				}
+				time.Sleep(handshakeTimeout)
+			}
+			fmt.Printf("[PATH] %s couldn't join the transfer\n", p.name)
+		}(p)
+	}
+}
+
+// marks the path of conn as joined once the receiver confirmed it.
+func (m *multipath) joined(conn *net.UDPConn) {
+	for _, p := range m.paths {
+		if p.conn == conn && atomic.CompareAndSwapInt32(&p.joined, 0, 1) {
+			fmt.Printf("[PATH] %s joined the transfer\n", p.name)
+		}
+	}
+}
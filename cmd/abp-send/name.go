@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// sends the leading part of a name too long for the FILENAME packet in
+// HDR_NAME packets of up to maxPayload bytes, each once the one before was
+// acknowledged. receivers predating them don't answer, the transfer fails
+// then like a FILENAME packet without reply.
+func sendNameParts(conn *net.UDPConn, prefix []byte, maxPayload int) {
+	for offset := 0; offset < len(prefix); {
+		part := prefix[offset:]
+		if len(part) > maxPayload-2 {
+			part = part[:maxPayload-2]
+		}
+		payload := make([]byte, 2, 2+len(part))
+		binary.BigEndian.PutUint16(payload, uint16(offset))
+		payload = append(payload, part...)
+		pkt := finalizePkg(abp.Header{Flags: abp.HDR_NAME,
+			Length: uint16(len(payload))}, payload)
+		next := offset + len(part)
+		for attempt := 0; offset < next; attempt++ {
+			checkRetries(attempt, EXIT_HANDSHAKE_FAILED)
+			if _, err := writePacket(conn, pkt); err != nil {
+				panic(err)
+			}
+			deadline := time.Now().Add(handshakeTimeout)
+			for offset < next {
+				reply, data, ok := readPacket(time.Until(deadline))
+				if !ok {
+					break
+				}
+				if reply.Flags == abp.HDR_ERROR && len(data) >= 2 {
+					exitWith(EXIT_ABORTED, "Receiver refused the name: %s",
+						abp.ErrorMessage(binary.BigEndian.Uint16(data)))
+				}
+				if reply.Flags == abp.HDR_NAME && len(data) == 2 &&
+					int(binary.BigEndian.Uint16(data)) >= next {
+					offset = next
+				}
+			}
+		}
+	}
+	fmt.Printf("Sent the first %d bytes of the name in advance.\n",
+		len(prefix))
+}
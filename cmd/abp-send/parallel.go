@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how often the combined progress of -parallel is printed
+const PARALLEL_PROGRESS_INTERVAL = time.Second
+
+// print "PROGRESS <bytes>" lines instead of dots, for the parent sender of
+// -parallel (see -progress-lines)
+var progressLines bool
+
+// a file sent by runParallel
+type parallelFile struct {
+	name    string
+	size    int64
+	acked   int64
+	code    int
+	started bool
+	done    bool
+}
+
+// sends several files, up to n at once, every one in a child sender with
+// a session (and socket) of its own like watch runs them. the children
+// report their progress, which is printed combined; their other output is
+// prefixed with the file name. exits with EXIT_OK if all files arrived,
+// otherwise with the exit code of the first file (in the order given)
+// that didn't.
+func runParallel(hostPort string, names []string, n int) {
+	if n < 1 {
+		exitWith(EXIT_USAGE, "-parallel must be at least 1")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	args := append(childArgs("parallel"), "-progress-lines")
+
+	files := make([]*parallelFile, len(names))
+	var total int64
+	for i, name := range names {
+		files[i] = &parallelFile{name: name}
+		if info, err := os.Stat(name); err == nil && info.Mode().IsRegular() {
+			files[i].size = info.Size()
+			total += info.Size()
+		}
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, n)
+	start := time.Now()
+	for _, f := range files {
+		wg.Add(1)
+		go func(f *parallelFile) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			lock.Lock()
+			f.started = true
+			lock.Unlock()
+			// a slice of its own, the goroutines would share the array
+			// behind args otherwise
+			argv := append(append([]string(nil), args...), hostPort,
+				f.name)
+			code := runParallelChild(self, argv, f, &lock)
+			lock.Lock()
+			f.code, f.done = code, true
+			lock.Unlock()
+			if code == EXIT_OK {
+				fmt.Printf("[PARALLEL] %s sent\n", f.name)
+			} else {
+				fmt.Printf("[PARALLEL] sending %s failed (exit code %d)\n",
+					f.name, code)
+			}
+		}(f)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	ticker := time.NewTicker(PARALLEL_PROGRESS_INTERVAL)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-ticker.C:
+		case <-finished:
+			running = false
+		}
+		lock.Lock()
+		done, failed, active := 0, 0, 0
+		var acked int64
+		for _, f := range files {
+			acked += f.acked
+			switch {
+			case f.done && f.code != EXIT_OK:
+				failed++
+			case f.done:
+				done++
+			case f.started:
+				active++
+			}
+		}
+		lock.Unlock()
+		elapsed := time.Since(start).Seconds()
+		fmt.Printf("[PARALLEL] %d/%d files sent, %d failed, %d running, "+
+			"%d of %d bytes, ~%.2f KB/s\n", done, len(files), failed, active,
+			acked, total, float64(acked)/elapsed/1024)
+	}
+
+	code := EXIT_OK
+	failed := 0
+	for _, f := range files {
+		if f.code != EXIT_OK {
+			if code == EXIT_OK {
+				code = f.code
+			}
+			failed++
+		}
+	}
+	exitWith(code, "[PARALLEL] %d of %d files sent, %d failed",
+		len(files)-failed, len(files), failed)
+}
+
+// runs the child sending f and returns its exit code.
+func runParallelChild(self string, args []string, f *parallelFile,
+	lock *sync.Mutex) int {
+	cmd := exec.Command(self, args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		fmt.Printf("[PARALLEL] can't start %s: %v\n", f.name, err)
+		return EXIT_USAGE
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Printf("[PARALLEL] can't start %s: %v\n", f.name, err)
+		return EXIT_USAGE
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if bytes, ok := parseProgressLine(line); ok {
+			lock.Lock()
+			f.acked = bytes
+			lock.Unlock()
+			continue
+		}
+		if line != "" {
+			fmt.Printf("[%s] %s\n", f.name, line)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+			return exitErr.ExitCode()
+		}
+		return EXIT_ABORTED
+	}
+	lock.Lock()
+	f.acked = f.size
+	lock.Unlock()
+	return EXIT_OK
+}
+
+func parseProgressLine(line string) (int64, bool) {
+	if !strings.HasPrefix(line, "PROGRESS ") {
+		return 0, false
+	}
+	bytes, err := strconv.ParseInt(strings.TrimPrefix(line, "PROGRESS "),
+		10, 64)
+	return bytes, err == nil
+}
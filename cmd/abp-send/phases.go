@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// where the time of the data phase went: reading (and compressing) the
+// file, handing packets to the network, sleeping for -limit-rate and
+// waiting for ACKs. printed once the transfer completed, to tell a slow
+// disk from a slow link.
+type phaseTimes struct {
+	read     time.Duration
+	send     time.Duration
+	throttle time.Duration
+	wait     time.Duration
+}
+
+var phases phaseTimes
+
+func (p *phaseTimes) print(total time.Duration) {
+	if total <= 0 {
+		return
+	}
+	share := func(d time.Duration) string {
+		return fmt.Sprintf("%v (%.0f%%)", d.Round(time.Millisecond),
+			100*d.Seconds()/total.Seconds())
+	}
+	fmt.Printf("Time: reading %s, sending %s, rate limit %s, waiting for "+
+		"ACKs %s\n", share(p.read), share(p.send), share(p.throttle),
+		share(p.wait))
+	switch {
+	case p.read > p.wait && p.read > p.throttle:
+		fmt.Printf("Reading the file took longest: the disk (or " +
+			"compression) is the bottleneck.\n")
+	case p.wait > p.read && p.wait > p.throttle:
+		fmt.Printf("Waiting for ACKs took longest: the link (or the " +
+			"receiver) is the bottleneck.\n")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// sends count ECHO packets (0 = until interrupted) one second apart and
+// prints the round trip time of each, then a summary. exits with
+// EXIT_HANDSHAKE_FAILED if none was answered.
+func ping(conn *net.UDPConn, count int) {
+	var received int
+	var rttSum, rttMin, rttMax time.Duration
+	seq := uint32(0)
+	for ; count == 0 || int(seq) < count; seq++ {
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, seq)
+		hdr := abp.Header{Length: uint16(len(payload)), Flags: abp.HDR_ECHO}
+		pkt := finalizePkg(hdr, payload)
+		limiter.wait(len(pkt))
+		sentAt := time.Now()
+		if _, err := writePacket(conn, pkt); err != nil {
+			panic(err)
+		}
+
+		// skip late replies to earlier requests
+		answered := false
+		for !answered && time.Since(sentAt) < ackTimeout {
+			reply, data, ok := readPacket(ackTimeout - time.Since(sentAt))
+			answered = ok && reply.Flags == abp.HDR_ECHO && len(data) == 4 &&
+				binary.BigEndian.Uint32(data) == seq
+		}
+		rtt := time.Since(sentAt)
+		if !answered {
+			fmt.Printf("seq=%d timeout\n", seq)
+		} else {
+			fmt.Printf("seq=%d time=%v\n", seq, rtt)
+			if received == 0 || rtt < rttMin {
+				rttMin = rtt
+			}
+			if rtt > rttMax {
+				rttMax = rtt
+			}
+			rttSum += rtt
+			received++
+		}
+		if count == 0 || int(seq)+1 < count {
+			time.Sleep(time.Second - time.Since(sentAt))
+		}
+	}
+
+	fmt.Printf("%d sent, %d received, %.0f%% loss\n", seq, received,
+		100*float64(int(seq)-received)/float64(seq))
+	if received == 0 {
+		exitWith(EXIT_HANDSHAKE_FAILED, "No reply from the receiver.")
+	}
+	fmt.Printf("rtt min %v, avg %v, max %v\n", rttMin,
+		rttSum/time.Duration(received), rttMax)
+	os.Exit(EXIT_OK)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// queue add <host:port> <file>...: spools files to be sent later.
+// queue list: prints the pending transfers.
+// queue run: sends the spooled files, retrying failed ones with backoff,
+// until interrupted.
+// queue watch <host:port> <dir>: queue run, which also spools every file
+// dropped into dir, see dropDir.watch.
+// every transfer runs in a child process with the options given to queue
+// run or watch, like watch does.
+func runQueue(spool string, backoff time.Duration, sentDir string,
+	args []string) {
+	if len(args) == 0 {
+		exitWith(EXIT_USAGE, "queue needs add, list, run or watch")
+	}
+	q, err := abp.OpenQueue(spool)
+	if err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	q.MinBackoff = backoff
+	if q.MaxBackoff < backoff {
+		q.MaxBackoff = backoff
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) < 3 {
+			exitWith(EXIT_USAGE, "usage: queue add <host:port> <file>...")
+		}
+		for _, path := range args[2:] {
+			if _, err := q.Add(path, args[1]); err != nil {
+				exitWith(EXIT_USAGE, "%v", err)
+			}
+			fmt.Printf("Queued %s for %s.\n", path, args[1])
+		}
+	case "list":
+		entries, err := q.Entries()
+		if err != nil {
+			exitWith(EXIT_USAGE, "%v", err)
+		}
+		for _, e := range entries {
+			fmt.Printf("%s -> %s", e.Path, e.Target)
+			if e.Attempts > 0 {
+				fmt.Printf(" (%d failed, next try %s: %s)", e.Attempts,
+					e.NextAttempt.Format(time.RFC3339), e.LastError)
+			}
+			fmt.Printf("\n")
+		}
+	case "run", "watch":
+		self, err := os.Executable()
+		if err != nil {
+			exitWith(EXIT_USAGE, "%v", err)
+		}
+		var dropped *dropDir
+		if args[0] == "watch" {
+			if len(args) != 3 {
+				exitWith(EXIT_USAGE, "usage: queue watch <host:port> <dir>")
+			}
+			dropped = newDropDir(q, spool, args[2], sentDir, args[1])
+			go dropped.watch()
+		}
+		options := childArgs("spool", "queue-backoff", "sent-dir")
+		q.Send = func(e *abp.QueueEntry) error {
+			fmt.Printf("[QUEUE] sending %s to %s\n", e.Path, e.Target)
+			cmd := exec.Command(self, append(options, e.Target,
+				filepath.Base(e.Path))...)
+			cmd.Dir = filepath.Dir(e.Path)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				fmt.Printf("[QUEUE] sending %s failed: %v\n", e.Path, err)
+				return err
+			}
+			fmt.Printf("[QUEUE] %s sent\n", e.Path)
+			if dropped != nil {
+				dropped.done(e)
+			}
+			return nil
+		}
+		if err := q.Run(nil); err != nil {
+			exitWith(EXIT_USAGE, "%v", err)
+		}
+	default:
+		exitWith(EXIT_USAGE, "unknown queue command %q", args[0])
+	}
+	os.Exit(EXIT_OK)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// token bucket limiting the bytes per second put on the wire, see
+// -limit-rate and -schedule. a nil limiter doesn't limit anything.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// with a schedule, the rate is looked up for every packet; -limit-rate
+	// applies when none of its rules does (0 = unlimited)
+	schedule abp.Schedule
+	fallback float64
+}
+
+var limiter *rateLimiter
+
+func newRateLimiter(rate float64, schedule abp.Schedule) *rateLimiter {
+	l := &rateLimiter{schedule: schedule, fallback: rate, last: time.Now()}
+	l.setRate(rate)
+	return l
+}
+
+func (l *rateLimiter) setRate(rate float64) {
+	// allow bursts of a few packets, or 50ms worth of traffic on fast
+	// links, so we don't sleep for every single packet
+	burst := rate / 20
+	if burst < 4*512 {
+		burst = 4 * 512
+	}
+	l.rate, l.burst, l.tokens = rate, burst, burst
+}
+
+// blocks until n bytes may be sent.
+func (l *rateLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	now := time.Now()
+	if l.schedule != nil {
+		if rate := l.schedule.RateAt(now, l.fallback); rate != l.rate {
+			fmt.Printf("\n[LIMIT] schedule: %s\n", rateString(rate))
+			l.setRate(rate)
+		}
+	}
+	if l.rate <= 0 {
+		l.last = now
+		return
+	}
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+		time.Sleep(delay)
+		phases.throttle += delay
+	}
+}
+
+func rateString(rate float64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("limit %.0f KB/s", rate/1024)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// the rate limiter takes its rate from the schedule, -limit-rate only
+// applies where the schedule has no rule.
+func TestRateLimiterSchedule(t *testing.T) {
+	allDay, _ := abp.ParseSchedule("limit 40KB/s")
+	l := newRateLimiter(0, allDay)
+	start := time.Now()
+	// the burst, then another 8KB at 40KB/s
+	for range 10 {
+		l.wait(1024)
+	}
+	if l.rate != 40<<10 {
+		t.Fatalf("rate %v, want the schedule's", l.rate)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond ||
+		elapsed > 2*time.Second {
+		t.Errorf("10KB took %v, want about 200ms", elapsed)
+	}
+
+	// a period two hours from now
+	now := time.Now()
+	later := (now.Hour()*60 + now.Minute() + 120) % (24 * 60)
+	l = newRateLimiter(1, abp.Schedule{{Rate: 1024, Start: later,
+		End: later + 1}})
+	l.wait(1)
+	if l.rate != 1 {
+		t.Errorf("rate %v outside of the schedule, want -limit-rate",
+			l.rate)
+	}
+
+	unlimited, _ := abp.ParseSchedule("unlimited")
+	l = newRateLimiter(1, unlimited)
+	start = time.Now()
+	for range 100 {
+		l.wait(1024)
+	}
+	if l.rate != 0 || time.Since(start) > time.Second {
+		t.Errorf("unlimited schedule: rate %v, took %v", l.rate,
+			time.Since(start))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/v4lli/go-abp/rendezvous"
+	"github.com/v4lli/go-abp/stun"
+)
+
+// how long the sender waits for the rendezvous server to name the receiver
+const RENDEZVOUS_TIMEOUT = 30 * time.Second
+
+// asks the STUN servers (comma separated) for the reflexive address of
+// conn and prints it along with the type of NAT in front of it. returns
+// nil if there's no answer.
+func discoverReflexive(conn *net.UDPConn, servers string) *net.UDPAddr {
+	addrs, err := stun.ResolveServers(servers)
+	if err != nil {
+		fmt.Printf("[STUN] %v\n", err)
+		return nil
+	}
+	addr, nat, err := stun.Discover(conn, addrs)
+	if err != nil {
+		fmt.Printf("[STUN] %v\n", err)
+		return nil
+	}
+	fmt.Printf("[STUN] reachable at %v, %s\n", addr, nat)
+	return addr
+}
+
+// asks the rendezvous server for the receiver registered under name and
+// connects to it from the socket the server saw, so the receiver's NAT,
+// which the receiver opened for exactly that address, lets our packets
+// through. the FILENAME packets open our NAT for its replies in turn.
+// with stunServers, the socket's reflexive address is passed on as well.
+func meetReceiver(server string, name string, stunServers string) (
+	*net.UDPConn, error) {
+	serverAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	var reflexive *net.UDPAddr
+	if stunServers != "" {
+		reflexive = discoverReflexive(conn, stunServers)
+	}
+	fmt.Printf("Asking %v for %s...\n", serverAddr, name)
+
+	var peer *net.UDPAddr
+	buf := make([]byte, 512)
+	deadline := time.Now().Add(RENDEZVOUS_TIMEOUT)
+	for peer == nil && time.Now().Before(deadline) {
+		_, err := conn.WriteToUDP(rendezvous.Register(name,
+			rendezvous.RoleSend, reflexive), serverAddr)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				break
+			}
+			if !from.IP.Equal(serverAddr.IP) || from.Port != serverAddr.Port {
+				continue
+			}
+			var peerReflexive *net.UDPAddr
+			peer, peerReflexive, err = rendezvous.ParsePeer(buf[:n])
+			if err != nil {
+				fmt.Printf("[RENDEZVOUS] %v\n", err)
+				continue
+			}
+			if rendezvous.Symmetric(peer, peerReflexive) {
+				fmt.Printf("[RENDEZVOUS] the receiver is behind a "+
+					"symmetric NAT (STUN saw %v), our packets probably "+
+					"won't get through\n", peerReflexive)
+			}
+			break
+		}
+	}
+	local := conn.LocalAddr().(*net.UDPAddr)
+	conn.Close()
+	if peer == nil {
+		return nil, fmt.Errorf("no receiver registered as %s with %v", name,
+			serverAddr)
+	}
+	fmt.Printf("Receiver %s is at %v.\n", name, peer)
+	return net.DialUDP("udp", local, peer)
+}
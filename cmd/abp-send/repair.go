@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// rounds of -repair before the receiver's copy is given up on
+const REPAIR_ATTEMPTS = 2
+
+// -repair: once the transfer completed, compares the receiver's copy with
+// the file like verify, and if it differs (e.g. corrupted on a disk or by
+// a checksum collision), lets a delta transfer send only the blocks that
+// do, see -delta. both run as child senders of their own, like watch
+// runs them, since the receiver is done with this session.
+func repairTransfer(hostPort string, filename string) {
+	self, err := os.Executable()
+	if err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	args := childArgs("repair", "streams", "delta", "checkpoint")
+	run := func(mode ...string) int {
+		cmd := exec.Command(self, append(append(args, mode...), hostPort,
+			filename)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				return exitErr.ExitCode()
+			}
+			exitWith(EXIT_USAGE, "can't start sender: %v", err)
+		}
+		return EXIT_OK
+	}
+	for attempt := 0; ; attempt++ {
+		code := run("verify")
+		if code == EXIT_OK {
+			return
+		}
+		if code != EXIT_VERIFY_FAILED {
+			exitWith(code, "[REPAIR] can't verify the receiver's copy.")
+		}
+		if attempt == REPAIR_ATTEMPTS {
+			exitWith(EXIT_VERIFY_FAILED, "[REPAIR] receiver's copy still "+
+				"differs after %d repairs.", REPAIR_ATTEMPTS)
+		}
+		fmt.Printf("[REPAIR] sending the blocks that differ\n")
+		if code := run("-delta", "-repairing"); code != EXIT_OK {
+			exitWith(code, "[REPAIR] repair failed.")
+		}
+	}
+}
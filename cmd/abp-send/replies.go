@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+	"github.com/v4lli/go-abp/impair"
+)
+
+// a packet from the receiver, as decoded by readReplies
+type reply struct {
+	hdr     abp.Header
+	payload []byte
+}
+
+// replies that arrived but weren't picked up by readPacket yet. this many
+// are buffered, later ones are dropped like lost packets.
+const replyBuffer = 64
+
+var replies = make(chan reply, replyBuffer)
+
+// set once the first reply arrived; until then, an ICMP port unreachable
+// for our socket means nobody listens on the receiver's port.
+var heardFromReceiver int32
+
+// the first such error, picked up by readPacket
+var refusals = make(chan error, 1)
+
+// passes err on to readPacket if it tells the receiver isn't listening and
+// we haven't heard from it yet. later on, a refused packet is just a lost
+// one: the receiver may be restarting, and it is retransmitted anyway.
+func noteRefused(err error) bool {
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return false
+	}
+	if atomic.LoadInt32(&heardFromReceiver) == 0 {
+		select {
+		case refusals <- err:
+		default:
+		}
+	}
+	return true
+}
+
+// reads and decodes the receiver's replies in the background, so that
+// none goes missing while the sender reads the file or frames the next
+// packet. runs until conn is closed.
+func readReplies(conn *net.UDPConn, impairment *impair.Impairment) {
+	buf := make([]byte, abp.MaxPacketLength)
+	for {
+		n, err := conn.Read(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if noteRefused(err) {
+			continue
+		} else if tooBig(err) {
+			// the next packet that large fails to send
+			fmt.Printf("[NET] ICMP fragmentation needed%s\n",
+				describePathMTU(conn))
+			continue
+		} else if err != nil {
+			// the reply is missing either way
+			fmt.Printf("[NET] reading reply failed: %v\n", err)
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		// the socket is connected, so the kernel only passes on
+		// datagrams from the receiver's address. whether they are from
+		// the receiver tells the session token, see queueReply.
+		// a receiver behind a NAT sends empty datagrams to open it for
+		// us, see -rendezvous
+		if n == 0 {
+			continue
+		}
+		impairment.Apply(append([]byte(nil), buf[:n]...), func(data []byte) {
+			queueReply(conn, data)
+		})
+	}
+}
+
+// decodes a reply received on conn and queues it for readPacket. once
+// HELLO agreed on a session token, replies not sealed with it fail the
+// checksum.
+func queueReply(conn *net.UDPConn, data []byte) {
+	if token := atomic.LoadUint32(&sessionToken); token != 0 {
+		abp.SealChecksum(data, token)
+	}
+	tracePacket("<-", data)
+	// compact headers may be switched on by the main goroutine any
+	// time, so try them first; a regular packet practically never passes
+	// the compact checksum.
+	hdr, payload, err := abp.ParsePacket(data, true)
+	if err != nil {
+		fmt.Printf("[NET] discarding reply (%d bytes): %v\n", len(data), err)
+		return
+	}
+	atomic.StoreInt32(&heardFromReceiver, 1)
+	if hdr.Flags == abp.HDR_JOIN {
+		if paths != nil {
+			paths.joined(conn)
+		}
+		return
+	}
+	select {
+	case replies <- reply{hdr, payload}:
+	default:
+		fmt.Printf("[NET] too many replies queued, dropping one\n")
+	}
+}
+
+// reused by readPacket, which runs for every packet sent
+var replyTimer = time.NewTimer(0)
+
+// waits for the next reply. returns false if none arrived in time. gives
+// up with EXIT_HANDSHAKE_FAILED right away if the receiver's host reports
+// that nothing listens on its port, instead of waiting for the retries to
+// run out.
+func readPacket(timeout time.Duration) (abp.Header, []byte, bool) {
+	if !replyTimer.Stop() {
+		select {
+		case <-replyTimer.C:
+		default:
+		}
+	}
+	replyTimer.Reset(timeout)
+	select {
+	case r := <-replies:
+		if paths != nil {
+			paths.replied()
+		}
+		return r.hdr, r.payload, true
+	case err := <-refusals:
+		exitWith(EXIT_HANDSHAKE_FAILED, "Connection refused: no receiver "+
+			"is listening (%v).", err)
+		return abp.Header{}, nil, false
+	case <-replyTimer.C:
+		fmt.Printf("[NET] no reply within %v\n", timeout)
+		if paths != nil {
+			paths.timeout()
+		}
+		return abp.Header{}, nil, false
+	}
+}
@@ -0,0 +1,1035 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/v4lli/go-abp/abp"
+	"github.com/v4lli/go-abp/completion"
+	"github.com/v4lli/go-abp/dscp"
+	"github.com/v4lli/go-abp/impair"
+)
+
+// set once the receiver accepted compact headers in its FILENAME ACK; all
+// later packets in both directions use the compact encoding.
+var compactHeaders bool
+
+// the name the receiver stored the file under, as reported in its FIN ACK
+var storedName string
+
+// how long to wait for the FILENAME ACK and for any later reply before
+// sending the packet again
+var handshakeTimeout time.Duration
+var ackTimeout time.Duration
+
+// takes a header structure and a variable-length data byte array, assembles
+// them into one big bytearray and calculates+inserts the crc32 checksum into
+// the resulting thing. the header is serialized once with a zero checksum,
+// which is patched in afterwards.
+func finalizePkg(hdr abp.Header, data []byte) []byte {
+	serialize := abp.SerializeHeader
+	if compactHeaders {
+		serialize = abp.SerializeCompactHeader
+	}
+	hdr.Checksum = 0
+	serializedHeader := serialize(hdr)
+	payload := data[:hdr.Length]
+
+	ret := make([]byte, len(serializedHeader)+len(payload))
+	copy(ret, serializedHeader)
+	copy(ret[len(serializedHeader):], payload)
+	binary.BigEndian.PutUint32(ret, abp.Checksum(serializedHeader, payload))
+	return ret
+}
+
+// blockingly waits for an ACK reply, returns true if the reply's flags
+// are equal to the flags supplied in wantFlags. stale or duplicated ACKs
+// are skipped; gives up after the ACK timeout.
+func waitForAck(wantFlags int) bool {
+	start := time.Now()
+	defer func() { phases.wait += time.Since(start) }()
+	deadline := start.Add(ackTimeout)
+	for {
+		replyHdr, payload, ok := readPacket(time.Until(deadline))
+		if !ok {
+			// no ack received, equivalent to bad/wrong ACK
+			return false
+		}
+
+		if int(replyHdr.Flags) == wantFlags {
+			// the FIN ACK may tell under which name the file was stored
+			if replyHdr.Flags&abp.HDR_FIN != 0 {
+				if len(payload) > 0 {
+					storedName = string(payload)
+				}
+			} else {
+				peerWindow = abp.ParseWindow(payload)
+			}
+			return true
+		} else if replyHdr.Flags == abp.HDR_ERROR && len(payload) >= 2 {
+			exitWith(EXIT_ABORTED, "\nReceiver aborted the transfer: %s",
+				abp.ErrorMessage(binary.BigEndian.Uint16(payload)))
+			return false
+		}
+		fmt.Printf("[NET] invalid reply; got Flags=%v, want Flags=%v...\n",
+			abp.Flags(replyHdr.Flags), abp.Flags(wantFlags))
+	}
+}
+
+// backs off after the receiver turned us away because it is busy. the
+// BUSY reply suggests a retry delay in seconds; some jitter keeps rejected
+// senders from coming back all at once.
+func waitWhileBusy(payload []byte) {
+	retryAfter := 5 * time.Second
+	if len(payload) >= 2 {
+		retryAfter = time.Duration(binary.BigEndian.Uint16(payload)) * time.Second
+	}
+	retryAfter += time.Duration(rand.Int63n(int64(retryAfter)/5 + 1))
+	fmt.Printf("Receiver is busy, retrying in %v...\n", retryAfter)
+	time.Sleep(retryAfter)
+}
+
+// prints what the receiver agreed to in a dry run and exits.
+func reportDryRun(accepted uint16, requested uint16) {
+	if accepted&abp.HDR_DRY_RUN == 0 {
+		// it started a real transfer, which times out on its side
+		exitWith(EXIT_USAGE, "Receiver doesn't support dry runs.")
+	}
+	fmt.Printf("Dry run: the receiver would accept the file.\n")
+	options := []struct {
+		flag uint16
+		name string
+	}{
+		{abp.HDR_COMPACT, "compact headers"},
+		{abp.HDR_COMPRESSED, "compression"},
+		{abp.HDR_DELTA, "delta transfer"},
+		{abp.HDR_SKIP, "sparse files"},
+	}
+	for _, option := range options {
+		if requested&option.flag == 0 {
+			continue
+		}
+		answer := "no"
+		if accepted&option.flag != 0 {
+			answer = "yes"
+		}
+		fmt.Printf("  %-16s %s\n", option.name+":", answer)
+	}
+	os.Exit(EXIT_OK)
+}
+
+// parses the argument of -fec, "k:m" with k data and m parity packets
+// per group.
+func parseFecSpec(spec string) (int, int, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid FEC spec %q, want k:m", spec)
+	}
+	k, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if k < 1 || m < 0 || k+m > 255 {
+		return 0, 0, fmt.Errorf("invalid FEC spec %q, need k >= 1 and k+m <= 255", spec)
+	}
+	return k, m, nil
+}
+
+// a receiver listening on a port range (host:5000-5010) is sent to a random
+// port of the range, which spreads the senders across its sockets. SRV
+// records name the port themselves.
+func pickPort(hostPort string) (string, error) {
+	if strings.HasPrefix(hostPort, SRV_PREFIX) {
+		return hostPort, nil
+	}
+	host, ports, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return "", err
+	}
+	i := strings.Index(ports, "-")
+	if i < 0 {
+		return hostPort, nil
+	}
+	from, err := strconv.Atoi(ports[:i])
+	if err != nil {
+		return "", err
+	}
+	to, err := strconv.Atoi(ports[i+1:])
+	if err != nil {
+		return "", err
+	}
+	if from > to {
+		return "", fmt.Errorf("invalid port range %s", ports)
+	}
+	port := from + rand.Intn(to-from+1)
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// the modes other than sending files, given as the first argument. their
+// options may follow the name, e.g. queue -spool dir list.
+var subcommands = []string{"bench", "completion", "ping", "queue", "sync",
+	"verify", "watch"}
+
+func isSubcommand(arg string) bool {
+	for _, name := range subcommands {
+		if arg == name {
+			return true
+		}
+	}
+	return false
+}
+
+func parseFlags(args []string) {
+	if err := flag.CommandLine.Parse(args); err == flag.ErrHelp {
+		os.Exit(EXIT_OK)
+	} else if err != nil {
+		os.Exit(EXIT_USAGE)
+	}
+}
+
+func main() {
+	// command line argument handling
+	fecSpec := flag.String("fec", "", "enable forward error correction "+
+		"with k data and m parity packets per group (k:m, e.g. 8:2)")
+	compact := flag.Bool("compact", false, "request the compact varint "+
+		"header encoding, saves a few bytes per packet on slow links")
+	compress := flag.String("compress", "", "compress payloads if the "+
+		"receiver supports it (gzip, zstd or lz4; zstd and lz4 imply -hello)")
+	compressLevel := flag.Int("compress-level", flate.DefaultCompression,
+		"gzip compression level, 1 (fastest) to 9 (best), or -1 for the "+
+			"default (6)")
+	delta := flag.Bool("delta", false, "only send the blocks that changed "+
+		"if the receiver has an older version of the file")
+	flag.DurationVar(&handshakeTimeout, "handshake-timeout", 500*time.Millisecond,
+		"resend the FILENAME packet if it isn't acknowledged in time")
+	flag.DurationVar(&ackTimeout, "ack-timeout", 500*time.Millisecond,
+		"resend a data packet if it isn't acknowledged in time")
+	flag.IntVar(&maxRetries, "max-retries", 20, "give up after "+
+		"retransmitting a packet this often (0 = never)")
+	dryRun := flag.Bool("dry-run", false, "only negotiate the transfer "+
+		"(incl. the receiver's disk space check), don't send any data")
+	streamCount := flag.Int("streams", 1, "split the file into this many "+
+		"ranges and send them in parallel sessions, for paths whose RTT "+
+		"limits a single one")
+	streamSpec := flag.String("stream", "", "send only the range "+
+		"id:offset:length of a -streams transfer (set by its sender)")
+	tarDir := flag.Bool("tar", false, "send the directory given instead "+
+		"of a file as one tar archive, packed on the fly")
+	tarGzip := flag.Bool("tar-gzip", false, "gzip the archive of -tar")
+	benchDuration := flag.Duration("duration", 10*time.Second, "bench: "+
+		"how long to stream generated data")
+	pingCount := flag.Int("count", 4, "number of ECHO packets sent by "+
+		"ping (0 = until interrupted)")
+	mtu := flag.Int("mtu", 0, "path MTU to size packets for; 0 discovers "+
+		"it, -1 sticks to 512 byte packets")
+	flag.BoolVar(&dontFragment, "df", false, "set the Don't Fragment bit "+
+		"on all packets and fail with the path MTU if they are too large, "+
+		"instead of having them fragmented")
+	checkpointMB := flag.Int("checkpoint", 0, "compare a hash of the "+
+		"receiver's copy with the local file every N megabytes (0 = never)")
+	var impairment impair.Impairment
+	impairment.Register(flag.CommandLine)
+	metrics.Register(flag.CommandLine, "abp.send")
+	earlyDataFlag := flag.Bool("early-data", true, "send the first data "+
+		"packet right behind the FILENAME packet without waiting for its "+
+		"ACK")
+	adaptive := flag.Bool("adaptive", true, "start with small packets and "+
+		"grow them up to the -mtu size while there is little loss")
+	limitRate := flag.String("limit-rate", "", "limit the bandwidth used, "+
+		"e.g. 2MB/s or 500KB/s")
+	schedule := flag.String("schedule", "", "bandwidth limits by time "+
+		"of day, e.g. \"limit 1MB/s 08:00-18:00, unlimited otherwise\"; "+
+		"-limit-rate applies outside of them")
+	sentDir := flag.String("sent-dir", "", "where watch moves files "+
+		"that were sent (default: sent next to the watched directory)")
+	spool := flag.String("spool", ".abp-spool", "spool directory of the "+
+		"queue commands")
+	queueBackoff := flag.Duration("queue-backoff", time.Minute, "queue "+
+		"run: wait this long before retrying a failed transfer, doubling "+
+		"with every failure up to an hour")
+	pathSpec := flag.String("paths", "", "send over several local "+
+		"interfaces or addresses, e.g. eth0,wwan0")
+	pathMode := flag.String("paths-mode", "failover", "how -paths are "+
+		"used: failover (switch when one stops working) or stripe "+
+		"(take turns)")
+	rendezvousServer := flag.String("rendezvous", "", "find the receiver "+
+		"through this rendezvous server (see abp-rendezvous); <host:port> "+
+		"is the name the receiver registered with then")
+	dscpSpec := flag.String("dscp", "", "mark outgoing packets with this "+
+		"DSCP for QoS, by name (e.g. CS1 for scavenger traffic) or number")
+	priorityFlag := flag.String("priority", "normal", "priority class "+
+		"announced to the receiver: high, normal or bulk; it handles "+
+		"packets of higher classes first (implies -hello unless normal)")
+	repair := flag.Bool("repair", false, "compare the receiver's copy "+
+		"with the file once it is sent, and send the blocks that differ "+
+		"if it doesn't match")
+	repairing := flag.Bool("repairing", false, "only send the file as "+
+		"a delta against the receiver's copy (set by -repair)")
+	syncDelete := flag.Bool("delete", false, "sync: delete the "+
+		"receiver's files that are gone locally (needs -control)")
+	control := flag.String("control", "", "address of the receiver's "+
+		"control port, for sync -delete")
+	manifests := flag.String("manifests", ".abp-sync", "sync: directory "+
+		"remembering which files each sync sent, so -delete only "+
+		"deletes those")
+	hello := flag.Bool("hello", false, "exchange capabilities with the "+
+		"receiver first and only request what it supports (implied by "+
+		"-token and -priority)")
+	token := flag.Bool("token", true, "agree on a random session token "+
+		"with the receiver, which seals its replies with it so forged "+
+		"ones are dropped (implies -hello)")
+	stunServers := flag.String("stun", "", "ask these STUN servers "+
+		"(comma separated, two tell the NAT type) for our public address "+
+		"and pass it on to -rendezvous")
+	flag.BoolVar(&tracePackets, "trace-packets", false, "print every "+
+		"packet sent or received as a decoded header instead of the "+
+		"progress dots")
+	timelineOut := flag.String("timeline", "", "record which packets had "+
+		"to be retransmitted and when; write them to this CSV file or, "+
+		"with \"chart\", print a chart at the end")
+	parallelFiles := flag.Int("parallel", 1, "send the files given, up to "+
+		"this many at once, each in a session of its own")
+	flag.BoolVar(&progressLines, "progress-lines", false, "print the "+
+		"bytes acknowledged as PROGRESS lines instead of dots (set by "+
+		"-parallel)")
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [options] <host:port> <filename>\n"+
+			"       %s [-parallel n] [options] <host:port> <file>...\n"+
+			"       %s verify <host:port> <filename>\n"+
+			"       %s [options] bench [-duration d] <host:port>\n"+
+			"       %s ping [-count n] <host:port>\n"+
+			"       %s completion bash|zsh|fish\n"+
+			"       %s [options] watch <host:port> <dir>\n"+
+			"       %s [-spool dir] queue add <host:port> <file>...\n"+
+			"       %s [-spool dir] [options] queue list|run\n"+
+			"       %s [-spool dir] [options] queue watch <host:port> "+
+			"<dir>\n"+
+			"       %s [-delete -control addr] [options] sync <dir> "+
+			"<host:port>\n"+
+			"<host:port> may be srv:<name> to look up the receiver's "+
+			"SRV records.\n",
+			os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0],
+			os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0],
+			os.Args[0])
+		flag.PrintDefaults()
+	}
+	// the flag package would exit with 2 on errors, which is taken by
+	// EXIT_HANDSHAKE_FAILED
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	parseFlags(os.Args[1:])
+	mode := ""
+	if isSubcommand(flag.Arg(0)) {
+		mode = flag.Arg(0)
+		parseFlags(flag.Args()[1:])
+	}
+	verify := mode == "verify"
+	bench := mode == "bench"
+	pingMode := mode == "ping"
+	if mode == "completion" {
+		if flag.NArg() != 1 {
+			flag.Usage()
+			os.Exit(EXIT_USAGE)
+		}
+		commands := make(map[string][]string)
+		for _, name := range subcommands {
+			commands[name] = nil
+		}
+		commands["completion"] = []string{"bash", "zsh", "fish"}
+		commands["queue"] = []string{"add", "list", "run", "watch"}
+		script, err := completion.Script(flag.Arg(0),
+			filepath.Base(os.Args[0]), flag.CommandLine,
+			map[string][]string{
+				"compress": abp.Compressions,
+				"priority": {"high", "normal", "bulk"},
+			}, commands)
+		if err != nil {
+			exitWith(EXIT_USAGE, "%v", err)
+		}
+		fmt.Print(script)
+		os.Exit(EXIT_OK)
+	}
+	if mode == "sync" {
+		runSync(flag.Args(), *syncDelete, *control, *manifests)
+	}
+	if mode == "queue" {
+		runQueue(*spool, *queueBackoff, *sentDir, flag.Args())
+	}
+	wantArgs := 2
+	if bench || pingMode {
+		wantArgs = 1
+	}
+	if mode == "" && (flag.NArg() > 2 || *parallelFiles > 1) {
+		runParallel(flag.Arg(0), flag.Args()[1:], *parallelFiles)
+	}
+	if flag.NArg() != wantArgs {
+		flag.Usage()
+		os.Exit(EXIT_USAGE)
+	}
+	if mode == "watch" {
+		dir := flag.Arg(1)
+		if *sentDir == "" {
+			*sentDir = filepath.Join(filepath.Dir(filepath.Clean(dir)),
+				"sent")
+		}
+		watchDir(dir, *sentDir, flag.Arg(0))
+	}
+	host_port := flag.Arg(0)
+	filename := []byte(flag.Arg(1))
+	if bench {
+		filename = []byte("abp-bench")
+	}
+
+	fecK, fecM := 0, 0
+	if *fecSpec != "" {
+		var err error
+		fecK, fecM, err = parseFecSpec(*fecSpec)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(EXIT_USAGE)
+		}
+	}
+
+	codePoint := -1
+	if *dscpSpec != "" {
+		var err error
+		codePoint, err = dscp.Parse(*dscpSpec)
+		if err != nil {
+			exitWith(EXIT_USAGE, "%v", err)
+		}
+	}
+
+	priority, priorityErr := abp.ParsePriority(*priorityFlag)
+	if priorityErr != nil {
+		exitWith(EXIT_USAGE, "%v", priorityErr)
+	}
+
+	var stream *streamRange
+	if *streamSpec != "" {
+		var err error
+		if stream, err = parseStreamRange(*streamSpec); err != nil {
+			exitWith(EXIT_USAGE, "%v", err)
+		}
+	}
+	if *streamCount < 1 {
+		exitWith(EXIT_USAGE, "invalid -streams %d", *streamCount)
+	}
+	if *streamCount > 1 && (bench || *tarDir || pingMode ||
+		verify || *delta || *rendezvousServer != "" || *pathSpec != "") {
+		exitWith(EXIT_USAGE, "-streams needs a file, and can't be "+
+			"combined with verify, -delta, -rendezvous or -paths")
+	}
+
+	if *repair && (bench || *tarDir || *dryRun || stream != nil) {
+		exitWith(EXIT_USAGE, "-repair needs a file, and can't be "+
+			"combined with -dry-run")
+	}
+
+	if *mtu > 0 && *mtu < 128 {
+		exitWith(EXIT_USAGE, "invalid MTU %d, need at least 128", *mtu)
+	}
+
+	if *limitRate != "" || *schedule != "" {
+		var rate float64
+		var rules abp.Schedule
+		var err error
+		if *limitRate != "" {
+			rate, err = abp.ParseRate(*limitRate)
+		}
+		if err == nil && *schedule != "" {
+			rules, err = abp.ParseSchedule(*schedule)
+		}
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(EXIT_USAGE)
+		}
+		limiter = newRateLimiter(rate, rules)
+	}
+
+	if *compress != "" && !abp.Supports(abp.Compressions, *compress) {
+		fmt.Printf("unknown compression %q\n", *compress)
+		os.Exit(EXIT_USAGE)
+	}
+	// flate's other levels (0 for none, -2 for Huffman only) aren't
+	// worth setting HDR_COMPRESSED for
+	if *compressLevel != flate.DefaultCompression &&
+		(*compressLevel < flate.BestSpeed ||
+			*compressLevel > flate.BestCompression) {
+		fmt.Printf("invalid compression level %d\n", *compressLevel)
+		os.Exit(EXIT_USAGE)
+	}
+
+	// open input file for reading
+	var fh *os.File
+	var fhReader *bufio.Reader
+	var size int64
+	var err error
+	if bench {
+		fhReader = bufio.NewReader(newBenchReader(*benchDuration))
+	} else if *tarDir {
+		if verify {
+			exitWith(EXIT_USAGE, "verify can't be combined with -tar")
+		}
+		archive, name := tarDirectory(string(filename), *tarGzip)
+		fhReader = bufio.NewReader(archive)
+		filename = []byte(name)
+	} else if !pingMode {
+		fh, err = os.Open(string(filename))
+		if err != nil {
+			exitWith(EXIT_USAGE, "%v", err)
+		}
+		info, err := fh.Stat()
+		if err != nil {
+			exitWith(EXIT_USAGE, "%v", err)
+		}
+		size = info.Size()
+		fhReader = bufio.NewReader(fh)
+		if stream != nil {
+			fhReader = bufio.NewReader(io.NewSectionReader(fh,
+				stream.offset, stream.length))
+		}
+	}
+
+	// with -rendezvous, the receiver is known by name only
+	var conn *net.UDPConn
+	if *rendezvousServer != "" {
+		if *pathSpec != "" {
+			exitWith(EXIT_USAGE, "-paths can't be combined with -rendezvous")
+		}
+		conn, err = meetReceiver(*rendezvousServer, host_port, *stunServers)
+		if err != nil {
+			exitWith(EXIT_HANDSHAKE_FAILED, "%v", err)
+		}
+	} else {
+		if *stunServers != "" {
+			// the transfer's socket is connected to the receiver, so
+			// the STUN servers get one of their own
+			if probe, err := net.ListenUDP("udp", nil); err == nil {
+				discoverReflexive(probe, *stunServers)
+				probe.Close()
+			}
+		}
+		host_port, err = pickPort(host_port)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(EXIT_USAGE)
+		}
+		udpAddr, err := resolveReceiver(host_port)
+		if err != nil {
+			exitWith(EXIT_USAGE, "%v", err)
+		}
+		if *pathSpec != "" {
+			paths, err = openPaths(*pathSpec, *pathMode, udpAddr)
+			if err != nil {
+				exitWith(EXIT_USAGE, "%v", err)
+			}
+			conn = paths.paths[0].conn
+			for _, p := range paths.paths[1:] {
+				go readReplies(p.conn, &impairment)
+			}
+		} else {
+			conn, err = net.DialUDP("udp", nil, udpAddr)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+	conns := []*net.UDPConn{conn}
+	if paths != nil {
+		conns = nil
+		for _, p := range paths.paths {
+			conns = append(conns, p.conn)
+		}
+	}
+	for _, c := range conns {
+		if codePoint >= 0 {
+			if err := dscp.Set(c, codePoint); err != nil {
+				exitWith(EXIT_USAGE, "-dscp: %v", err)
+			}
+		}
+		if dontFragment {
+			setDontFragment(c, true)
+		}
+	}
+	fmt.Printf("Connected to %s! - ", host_port)
+	go readReplies(conn, &impairment)
+
+	if verify {
+		verifyFile(conn, fh, filename)
+	}
+	if pingMode {
+		fmt.Printf("\n")
+		ping(conn, *pingCount)
+	}
+
+	var outHdr abp.Header
+	// payload size incl. header is set to <= 512 because of minimum MTU of 576
+	// minus udp header minus IP header minus some IP header options (not
+	// all 60 bytes though...), unless the path allows larger packets.
+	pktLength := abp.DefaultPacketLength
+	if *mtu > 0 {
+		pktLength = packetLength(conn, *mtu)
+	} else if *mtu == 0 {
+		pktLength = discoverPacketLength(conn)
+	}
+	maxPayload := pktLength - abp.HeaderLength
+	// receivers that don't know priorities reject the FILENAME packet
+	// announcing one, so only those saying HELLO with EXT_PRIORITY get it.
+	// the session token is agreed on with HELLO as well, and so is any
+	// compression but gzip.
+	var caps *abp.Capabilities
+	if *hello || *token || priority != abp.PRIORITY_NORMAL ||
+		(*compress != "" && *compress != "gzip") {
+		caps = sayHello(conn, maxPayload, *token, *compress)
+	}
+	if priority != abp.PRIORITY_NORMAL &&
+		(caps == nil || caps.Extended&abp.EXT_PRIORITY == 0) {
+		fmt.Printf("[HELLO] receiver doesn't support priorities\n")
+		priority = abp.PRIORITY_NORMAL
+	}
+	if caps != nil {
+		if caps.MaxPayload > 0 && caps.MaxPayload < maxPayload {
+			maxPayload = caps.MaxPayload
+		}
+		if caps.Extended&abp.EXT_STREAMS == 0 && *streamCount > 1 {
+			fmt.Printf("[HELLO] receiver doesn't support streams\n")
+			*streamCount = 1
+		}
+	}
+	fmt.Printf("hdrLen=%d, max payload len=%d\n", abp.HeaderLength, maxPayload)
+
+	// FSM event: StartProgramm
+
+	// first send the file name, preceded by the file size so the receiver
+	// can check whether it fits
+	out := make([]byte, maxPayload)
+	binary.BigEndian.PutUint64(out, uint64(size))
+	fnLen := 8
+	if priority != abp.PRIORITY_NORMAL {
+		out[fnLen] = byte(priority)
+		fnLen++
+	}
+	// the start of a name too long for the packet goes ahead of it
+	if len(filename) > abp.MaxNameLength {
+		exitWith(EXIT_USAGE, "name longer than %d bytes",
+			abp.MaxNameLength)
+	}
+	if !utf8.Valid(filename) {
+		fmt.Printf("%q isn't valid UTF-8, the receiver replaces the "+
+			"invalid bytes\n", filename)
+	}
+	var namePrefix []byte
+	if room := maxPayload - fnLen; len(filename) > room && stream == nil {
+		if caps != nil && caps.Extended&abp.EXT_NAME_PARTS == 0 {
+			exitWith(EXIT_USAGE, "Receiver doesn't take names longer "+
+				"than %d bytes.", room)
+		}
+		namePrefix = filename[:len(filename)-room]
+		sendNameParts(conn, namePrefix, maxPayload)
+	}
+	fnLen += copy(out[fnLen:], filename[len(namePrefix):])
+
+	// cast is ok here because maxPayload will always be < UINT16_MAX
+	outHdr.Length = uint16(fnLen)
+	outHdr.Flags = abp.HDR_FILENAME | abp.HDR_SIZE
+	if *compact {
+		outHdr.Flags |= abp.HDR_COMPACT
+	}
+	if *compress != "" {
+		outHdr.Flags |= abp.HDR_COMPRESSED
+	}
+	if *delta && !bench {
+		outHdr.Flags |= abp.HDR_DELTA
+	}
+	// holes of sparse files are skipped if the receiver supports it, and
+	// we'd like to know if it renamed the file. a benchmark asks the
+	// receiver to discard the data instead.
+	if bench {
+		outHdr.Flags |= abp.HDR_BENCH
+	} else {
+		outHdr.Flags |= abp.HDR_STORED_NAME
+		// an archive of -tar has no holes to look for, and streams
+		// send ranges of the file
+		if fh != nil && *streamCount == 1 {
+			outHdr.Flags |= abp.HDR_SKIP
+		}
+	}
+	if *dryRun {
+		outHdr.Flags |= abp.HDR_DRY_RUN
+	}
+	if priority != abp.PRIORITY_NORMAL {
+		outHdr.Flags |= abp.HDR_PRIORITY
+	}
+	outHdr.Flags = dropUnsupported(outHdr.Flags, caps, *compress)
+	// the options receivers may echo; the priority isn't one
+	options := outHdr.Flags &^ (abp.HDR_FILENAME | abp.HDR_PRIORITY)
+
+	// FEC groups and the FILENAME packet always use the full size
+	sizer := newPayloadSizer(maxPayload)
+	if !*adaptive {
+		sizer.size = maxPayload
+	}
+
+	// a delta transfer has to fetch the signatures before sending data
+	var early *earlyData
+	if *earlyDataFlag && !*dryRun && outHdr.Flags&abp.HDR_DELTA == 0 &&
+		*streamCount == 1 && stream == nil {
+		early = readEarlyData(fhReader, sizer.size)
+	}
+
+	// send out filename pkgs as long as we've got no ACK
+	if !*dryRun && stream == nil {
+		startTransferMetrics()
+	}
+	sendbuffer := finalizePkg(outHdr, out)
+	var accepted uint16
+	var transferID []byte
+	// a stream of a -streams transfer joins it instead
+	if stream != nil {
+		joinStream(conn, stream)
+	} else {
+		for attempt := 0; ; attempt++ {
+			checkRetries(attempt, EXIT_HANDSHAKE_FAILED)
+			// FSM event: sendFilename
+			limiter.wait(len(sendbuffer))
+			_, err := writePacket(conn, sendbuffer)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("Sent FILENAME packet with %d bytes (Flags=%v).\n",
+				len(sendbuffer), abp.Flags(outHdr.Flags))
+			if early != nil {
+				early.send(conn)
+				stats.sent(attempt)
+				timeline.sent(0, early.count, attempt)
+			}
+
+			// FSM state transition: WAIT_FILENAME_ACK
+			// the receiver echoes the options it accepted in the ACK,
+			// older ones just reply with Flags=0.
+			if ack, payload, ok := readPacket(handshakeTimeout); ok {
+				if ack.Flags == abp.HDR_BUSY {
+					waitWhileBusy(payload)
+					// a receiver busy with others forgot the name
+					if namePrefix != nil {
+						sendNameParts(conn, namePrefix, maxPayload)
+					}
+					attempt = -1
+					continue
+				}
+				if ack.Flags == abp.HDR_ERROR && len(payload) >= 2 {
+					exitWith(EXIT_ABORTED, "Receiver aborted the transfer: %s",
+						abp.ErrorMessage(binary.BigEndian.Uint16(payload)))
+				}
+				if early != nil && ack.Flags == early.flags {
+					// the receiver only takes data once it accepted the
+					// FILENAME packet, whose ACK got lost then. the
+					// accepted options are unknown, so none are used.
+					early.acked = true
+					if ack.Flags&abp.HDR_FIN != 0 && len(payload) > 0 {
+						storedName = string(payload)
+					}
+					break
+				}
+				if ack.Flags&^options == 0 {
+					accepted = ack.Flags
+					// newer receivers tell the ID of the transfer
+					transferID = payload
+					break
+				}
+				fmt.Printf("[NET] invalid reply; got Flags=%v, want Flags=%v...\n",
+					abp.Flags(ack.Flags), abp.Flags(options))
+			}
+		}
+	}
+
+	if *dryRun {
+		reportDryRun(accepted, options)
+	}
+
+	if paths != nil {
+		if len(transferID) == 0 {
+			fmt.Printf("Receiver doesn't support multiple paths, using %s "+
+				"only.\n", paths.paths[0].name)
+		} else {
+			paths.join(transferID)
+		}
+	}
+
+	if bench && accepted&abp.HDR_BENCH == 0 {
+		fmt.Printf("Receiver doesn't support benchmarks, it stores the "+
+			"data as %s.\n", filename)
+	}
+
+	compactHeaders = accepted&abp.HDR_COMPACT != 0
+	if compactHeaders {
+		fmt.Printf("Receiver accepted compact headers.\n")
+	}
+
+	// with -streams, we send the first range and the streams the rest;
+	// they need the ID of the transfer to join it
+	var parallel *streams
+	if *streamCount > 1 && !*dryRun {
+		ranges := splitRanges(string(transferID), size, *streamCount)
+		if len(transferID) == 0 {
+			fmt.Printf("Receiver doesn't support streams, sending the " +
+				"file in one.\n")
+		} else if len(ranges) > 1 {
+			fhReader = bufio.NewReader(io.NewSectionReader(fh, 0,
+				ranges[0].length))
+			parallel = startStreams(ranges[1:], host_port, string(filename))
+		}
+	}
+
+	// in delta mode, the data packets carry the delta stream instead of
+	// the plain file
+	var deltaReader *abp.DeltaReader
+	if accepted&abp.HDR_DELTA != 0 {
+		sigs, blockSize := fetchSignatures(conn)
+		fmt.Printf("Receiver has an old version (%d blocks of %d bytes), "+
+			"sending delta.\n", len(sigs), blockSize)
+		deltaReader = abp.NewDeltaReader(fhReader, blockSize, sigs)
+		fhReader = bufio.NewReader(deltaReader)
+	} else if *repairing {
+		exitWith(EXIT_VERIFY_FAILED, "Receiver can't repair its copy "+
+			"in place.")
+	} else if *delta {
+		fmt.Printf("Receiver has no old version, sending whole file.\n")
+	}
+
+	var sparse *sparseReader
+	if accepted&abp.HDR_SKIP != 0 && deltaReader == nil {
+		sparse = newSparseReader(fh, size)
+		if early != nil {
+			sparse.pos = int64(early.count)
+		}
+		fhReader = bufio.NewReader(sparse)
+	}
+
+	var comp *compressor
+	if accepted&abp.HDR_COMPRESSED != 0 {
+		comp = newCompressor(fhReader, *compress, *compressLevel)
+	}
+	if comp != nil {
+		fmt.Printf("Receiver accepted %s compression.\n", *compress)
+	} else if *compress != "" {
+		fmt.Printf("Receiver doesn't support compression.\n")
+	}
+
+	// start calculating goodput from here on
+	startTime := time.Now().UnixNano()
+	lastTimeCalculation := startTime
+	phases = phaseTimes{}
+	var bytesSent int64
+	bytesSent = 0
+
+	// in FEC mode every alternating bit covers a whole group of shards
+	maxShard := maxPayload - abp.FecHeaderLength
+	var group []byte
+	if fecK > 0 {
+		group = make([]byte, fecK*maxShard)
+		fmt.Printf("FEC enabled: %d data + %d parity packets per group\n",
+			fecK, fecM)
+	}
+
+	// benchmarks have no file to compare with
+	var checkpoints *checkpointer
+	if *checkpointMB > 0 && fh != nil {
+		checkpoints = newCheckpointer(fh, *checkpointMB)
+	}
+
+	// this is our alternating-bit-indicator
+	lastState := false
+	// we can now start sending actual data
+	for {
+		var chunk []byte
+		var count int
+		var readErr error
+		compressed := false
+		// the receiver's window is closed; an empty packet asks again
+		probe := early == nil && peerWindow == 0
+		if probe {
+			waitForWindow()
+		}
+		readStart := time.Now()
+		if early != nil {
+			// the first chunk went out with the FILENAME packet
+			chunk, count, readErr = early.chunk, early.count, early.readErr
+		} else if probe {
+			chunk = out[:0]
+		} else if comp != nil {
+			capacity := windowedSize(sizer.size)
+			if fecK > 0 {
+				capacity = len(group)
+			}
+			chunk, compressed, count, readErr = comp.next(capacity)
+		} else if fecK > 0 {
+			// fill a whole group; a short group means we hit EOF.
+			count, readErr = io.ReadFull(fhReader, group)
+			if readErr == io.ErrUnexpectedEOF {
+				readErr = io.EOF
+			}
+			chunk = group[:count]
+		} else {
+			// reads up to the current payload size. may also be 0!
+			count, readErr = fhReader.Read(out[:windowedSize(sizer.size)])
+			chunk = out[:count]
+		}
+		phases.read += time.Since(readStart)
+
+		outHdr.Flags = 0
+
+		// reached a hole of a sparse file: send the data before it
+		// first, then a packet telling the receiver to skip it.
+		skip := false
+		if readErr == errHole {
+			readErr = nil
+			if len(chunk) == 0 {
+				skip = true
+				chunk = make([]byte, 8)
+				binary.BigEndian.PutUint64(chunk, uint64(sparse.holeLength()))
+				outHdr.Flags |= abp.HDR_SKIP
+				if sparse.skipHole() {
+					readErr = io.EOF
+				}
+			}
+		} else if readErr != nil && readErr != io.EOF {
+			panic(readErr)
+		}
+
+		outHdr.Length = uint16(len(chunk))
+
+		if !lastState {
+			outHdr.Flags |= abp.HDR_ALTERNATING
+		}
+
+		// if this was a short read (i.e. err == io.EOF) we
+		// send all data (may also be 0), possibly the ACK
+		// bit AND the FIN flag.
+		if readErr == io.EOF {
+			outHdr.Flags |= abp.HDR_FIN
+			// the FIN completes the file, so the streams go first
+			if parallel != nil {
+				bytesSent += parallel.wait()
+				parallel = nil
+			}
+		}
+
+		if compressed {
+			outHdr.Flags |= abp.HDR_COMPRESSED
+		}
+
+		if fecK > 0 && !skip && !probe && early == nil {
+			outHdr.Flags |= abp.HDR_FEC
+			sendFecGroup(conn, chunk, bytesSent, maxShard, fecM,
+				outHdr.Flags)
+			lastState = !lastState
+		} else {
+			sendbuffer = finalizePkg(outHdr, chunk)
+			// the early data packet is in flight already, possibly
+			// even acknowledged
+			inFlight := early != nil
+			acked := early != nil && early.acked
+			var sentAt time.Time
+			if inFlight {
+				sentAt = early.sentAt
+			}
+			early = nil
+			// actually try sending out this chunk of data.
+			for attempt := 0; !acked; attempt++ {
+				checkRetries(attempt, EXIT_TIMEOUT)
+				if !inFlight {
+					// FSM event: sendData
+					limiter.wait(len(sendbuffer))
+					sentAt = time.Now()
+					_, err := writePacket(conn, sendbuffer)
+					phases.send += time.Since(sentAt)
+					stats.sent(attempt)
+					timeline.sent(bytesSent, count, attempt)
+					if *adaptive {
+						sizer.sent(attempt)
+					}
+
+					if err != nil {
+						panic(err)
+					}
+					printProgress()
+				}
+				inFlight = false
+
+				// nb: if we sent Flags=ACK1|FIN, we're also expecting
+				// an ACK1|FIN reply. if we sent ACK0|FIN, we're
+				// expecting only FIN.
+				// FSM state transition: WAIT_ACK_1 || WAIT_ACK_0
+				//                       || WAIT_FIN_ACK1
+				//                       || WAIT_FIN_ACK0
+				// the receiver acks decompressed data, so
+				// HDR_COMPRESSED isn't part of the reply.
+				// the same goes for HDR_SKIP.
+				if waitForAck(int(outHdr.Flags &^ (abp.HDR_COMPRESSED | abp.HDR_SKIP))) {
+					stats.acked(attempt, time.Since(sentAt))
+					acked = true
+				}
+			}
+			lastState = !lastState
+		}
+
+		bytesSent += int64(count)
+		if checkpoints != nil && readErr != io.EOF {
+			checkpoints.check(conn, bytesSent)
+		}
+		now := time.Now().UnixNano()
+		if lastTimeCalculation < (now - int64(time.Second)) {
+			lastTimeCalculation = now
+			if progressLines {
+				fmt.Printf("\nPROGRESS %d\n", bytesSent)
+			} else {
+				fmt.Printf("\nGoodput: ~%.2f KB/s\n",
+					float64(bytesSent/((now-startTime)/int64(time.Second)))/1024)
+			}
+		}
+
+		if readErr == io.EOF {
+			fmt.Print("\nFIN sent/FINACK received, terminating client.\n")
+			if deltaReader != nil {
+				fmt.Printf("Delta: %d bytes sent literally, %d bytes "+
+					"reused from the receiver's copy.\n",
+					deltaReader.LiteralBytes, deltaReader.CopiedBytes)
+			}
+			if storedName != "" && storedName != string(filename) {
+				fmt.Printf("Receiver stored the file as %s.\n", storedName)
+			}
+			if bench {
+				printBenchReport(bytesSent,
+					time.Duration(time.Now().UnixNano()-startTime))
+			}
+			phases.print(time.Duration(time.Now().UnixNano() - startTime))
+			if *timelineOut != "" {
+				timeline.dump(*timelineOut)
+			}
+			finishTransferMetrics(EXIT_OK, bytesSent)
+			break
+		}
+	}
+
+	conn.Close()
+	if *repair {
+		repairTransfer(host_port, string(filename))
+	}
+	// FSM state transition: PROGRAM_TERMINATED
+}
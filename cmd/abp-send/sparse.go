@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// returned by sparseReader when the read position reached a hole
+var errHole = errors.New("hole in sparse file")
+
+// reads a file but stops at its holes (see dataSegment), which are sent
+// as "skip N bytes" packets instead of streams of zeros.
+type sparseReader struct {
+	fh        *os.File
+	size      int64
+	pos       int64
+	dataStart int64
+	dataEnd   int64
+}
+
+func newSparseReader(fh *os.File, size int64) *sparseReader {
+	r := &sparseReader{fh: fh, size: size}
+	r.dataStart, r.dataEnd = dataSegment(fh, 0, size)
+	return r
+}
+
+func (r *sparseReader) Read(p []byte) (int, error) {
+	if r.pos >= r.dataEnd {
+		r.dataStart, r.dataEnd = dataSegment(r.fh, r.pos, r.size)
+	}
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.pos < r.dataStart {
+		return 0, errHole
+	}
+	if int64(len(p)) > r.dataEnd-r.pos {
+		p = p[:r.dataEnd-r.pos]
+	}
+	n, err := r.fh.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// the length of the hole at the current position
+func (r *sparseReader) holeLength() int64 {
+	return r.dataStart - r.pos
+}
+
+// moves past the hole at the current position; returns true if the hole
+// extends to the end of the file.
+func (r *sparseReader) skipHole() bool {
+	r.pos = r.dataStart
+	return r.pos >= r.size
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lseek whence values to find data and holes, see lseek(2)
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// returns the start and end of the next data segment at or after pos. if
+// there is no more data, both are size.
+func dataSegment(fh *os.File, pos int64, size int64) (int64, int64) {
+	start, err := fh.Seek(pos, seekData)
+	if err != nil {
+		// ENXIO: only a hole left until the end of the file. other
+		// errors mean holes aren't supported, treat it all as data.
+		if pos < size && !errors.Is(err, syscall.ENXIO) {
+			return pos, size
+		}
+		return size, size
+	}
+	end, err := fh.Seek(start, seekHole)
+	if err != nil || end > size {
+		end = size
+	}
+	return start, end
+}
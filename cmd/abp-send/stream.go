@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// the range of the file a stream of a -streams transfer sends
+type streamRange struct {
+	// ID of the transfer, from the FILENAME ACK
+	id     string
+	offset int64
+	length int64
+}
+
+// parses the argument of -stream, "id:offset:length".
+func parseStreamRange(spec string) (*streamRange, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid stream %q, want id:offset:length",
+			spec)
+	}
+	offset, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &streamRange{parts[0], offset, length}, nil
+}
+
+// splits a file of size bytes into n contiguous ranges; the first one is
+// sent by the transfer itself, the others by streams.
+func splitRanges(id string, size int64, n int) []*streamRange {
+	length := (size + int64(n) - 1) / int64(n)
+	var ranges []*streamRange
+	for offset := int64(0); offset < size; offset += length {
+		if offset+length > size {
+			length = size - offset
+		}
+		ranges = append(ranges, &streamRange{id, offset, length})
+	}
+	return ranges
+}
+
+// -streams: the child senders transferring the ranges behind the first one
+// in parallel, every one in a session of its own like watch runs them.
+type streams struct {
+	children []*exec.Cmd
+	ranges   []*streamRange
+}
+
+// starts a child sender for every range.
+func startStreams(ranges []*streamRange, hostPort string,
+	filename string) *streams {
+	self, err := os.Executable()
+	if err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	args := childArgs("streams")
+	s := &streams{ranges: ranges}
+	for _, r := range ranges {
+		spec := fmt.Sprintf("%s:%d:%d", r.id, r.offset, r.length)
+		cmd := exec.Command(self, append(args, "-stream="+spec, hostPort,
+			filename)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			exitWith(EXIT_USAGE, "can't start stream: %v", err)
+		}
+		s.children = append(s.children, cmd)
+	}
+	return s
+}
+
+// waits for all streams to be acknowledged and returns the number of
+// bytes they sent; the transfer fails with the first one that didn't make
+// it.
+func (s *streams) wait() int64 {
+	failed := -1
+	code := EXIT_OK
+	for i, cmd := range s.children {
+		if err := cmd.Wait(); err != nil && failed < 0 {
+			failed = i
+			code = EXIT_ABORTED
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				code = exitErr.ExitCode()
+			}
+		}
+	}
+	if failed >= 0 {
+		r := s.ranges[failed]
+		exitWith(code, "\nStream of bytes %d-%d failed.", r.offset,
+			r.offset+r.length-1)
+	}
+	fmt.Printf("\n[STREAMS] all %d streams complete\n", len(s.children))
+	var bytes int64
+	for _, r := range s.ranges {
+		bytes += r.length
+	}
+	return bytes
+}
+
+// joins the transfer a stream belongs to with a STREAM packet, which the
+// receiver echoes once it set up the stream.
+func joinStream(conn *net.UDPConn, r *streamRange) {
+	payload := make([]byte, 8, 8+len(r.id))
+	binary.BigEndian.PutUint64(payload, uint64(r.offset))
+	payload = append(payload, r.id...)
+	pkt := finalizePkg(abp.Header{Flags: abp.HDR_STREAM,
+		Length: uint16(len(payload))}, payload)
+	for attempt := 0; ; attempt++ {
+		checkRetries(attempt, EXIT_HANDSHAKE_FAILED)
+		if _, err := writePacket(conn, pkt); err != nil {
+			panic(err)
+		}
+		deadline := time.Now().Add(handshakeTimeout)
+		for {
+			reply, payload, ok := readPacket(time.Until(deadline))
+			if !ok {
+				break
+			}
+			if reply.Flags == abp.HDR_STREAM {
+				fmt.Printf("Stream of bytes %d-%d joined transfer %s.\n",
+					r.offset, r.offset+r.length-1, r.id)
+				return
+			}
+			if reply.Flags == abp.HDR_ERROR && len(payload) >= 2 {
+				exitWith(EXIT_ABORTED, "Receiver aborted the stream: %s",
+					abp.ErrorMessage(binary.BigEndian.Uint16(payload)))
+			}
+		}
+	}
+}
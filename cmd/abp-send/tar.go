@@ -0,0 +1,94 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// -tar: packs dir into a tar archive (gzipped with -tar-gzip) while it is
+// being sent, and returns the archive as a stream along with the name to
+// send it under, e.g. photos.tar. the entries are relative to dir, so a
+// receiver extracting the archive recreates its content in a directory of
+// the archive's name. the size isn't known in advance.
+func tarDirectory(dir string, compress bool) (io.Reader, string) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	if !info.IsDir() {
+		exitWith(EXIT_USAGE, "-tar needs a directory, %s isn't one", dir)
+	}
+	name := filepath.Base(filepath.Clean(dir)) + ".tar"
+	if compress {
+		name += ".gz"
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(writeTar(w, dir, compress))
+	}()
+	return r, name
+}
+
+func writeTar(w io.Writer, dir string, compress bool) error {
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo,
+		err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			// sockets and the like
+			fmt.Printf("[TAR] skipping %s: %v\n", path, err)
+			return nil
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		fh, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		// a file growing meanwhile is cut off at its size in the header
+		_, err = io.CopyN(tw, fh, info.Size())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
@@ -3,4 +3,4 @@ set -ex
 
 go build
 openssl sha256 blob.bin
-./sender 127.0.0.1:1234 blob.bin
+./abp-send 127.0.0.1:1234 blob.bin
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rows of the -timeline chart
+const TIMELINE_ROWS = 20
+
+// a retransmitted data packet (or FEC group)
+type retransmission struct {
+	at      time.Duration
+	offset  int64
+	length  int
+	attempt int
+}
+
+// records when which bytes of the file had to be retransmitted, see
+// -timeline. consecutive packets that all needed a retransmission form a
+// burst.
+type lossTimeline struct {
+	start  time.Time
+	events []retransmission
+	// packets sent so far, and whether the current one was resent
+	packets int
+	lost    bool
+	burst   int
+	longest int
+}
+
+var timeline lossTimeline
+
+// counts a (re)transmission of the packet carrying length bytes from
+// offset on; attempt 0 is its first transmission.
+func (t *lossTimeline) sent(offset int64, length int, attempt int) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	if attempt == 0 {
+		t.finishPacket()
+		t.packets++
+		return
+	}
+	t.lost = true
+	t.events = append(t.events, retransmission{time.Since(t.start), offset,
+		length, attempt})
+}
+
+func (t *lossTimeline) finishPacket() {
+	if !t.lost {
+		t.burst = 0
+		return
+	}
+	t.lost = false
+	t.burst++
+	if t.burst > t.longest {
+		t.longest = t.burst
+	}
+}
+
+// writes the timeline as CSV to path, or as a chart to stdout if path is
+// "chart".
+func (t *lossTimeline) dump(path string) {
+	t.finishPacket()
+	if path == "chart" {
+		t.printChart()
+		return
+	}
+	fh, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("can't write the timeline: %v\n", err)
+		return
+	}
+	defer fh.Close()
+	w := csv.NewWriter(fh)
+	w.Write([]string{"time_ms", "offset", "length", "attempt"})
+	for _, e := range t.events {
+		w.Write([]string{
+			strconv.FormatFloat(e.at.Seconds()*1000, 'f', 3, 64),
+			strconv.FormatInt(e.offset, 10), strconv.Itoa(e.length),
+			strconv.Itoa(e.attempt)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fmt.Printf("can't write the timeline: %v\n", err)
+		return
+	}
+	fmt.Printf("Wrote %d retransmissions to %s.\n", len(t.events), path)
+}
+
+// prints the retransmissions per slice of the transfer time as bars.
+// uniform loss shows up as bars of similar length, bursty loss as a few
+// long ones.
+func (t *lossTimeline) printChart() {
+	elapsed := time.Since(t.start)
+	fmt.Printf("\nRetransmissions: %d of %d packets resent %d times, "+
+		"longest burst %d packets\n", t.lostPackets(), t.packets,
+		len(t.events), t.longest)
+	if len(t.events) == 0 || elapsed <= 0 {
+		return
+	}
+	var rows [TIMELINE_ROWS]int
+	most := 0
+	for _, e := range t.events {
+		row := int(int64(e.at) * TIMELINE_ROWS / int64(elapsed))
+		if row >= TIMELINE_ROWS {
+			row = TIMELINE_ROWS - 1
+		}
+		rows[row]++
+		if rows[row] > most {
+			most = rows[row]
+		}
+	}
+	step := elapsed / TIMELINE_ROWS
+	for i, n := range rows {
+		fmt.Printf("%9v %5d %s\n", (step * time.Duration(i)).Round(
+			time.Millisecond), n, strings.Repeat("#", (n*50+most-1)/most))
+	}
+}
+
+// number of distinct packets that were retransmitted
+func (t *lossTimeline) lostPackets() int {
+	n := 0
+	for _, e := range t.events {
+		if e.attempt == 1 {
+			n++
+		}
+	}
+	return n
+}
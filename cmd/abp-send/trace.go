@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// -trace-packets: print every packet sent or received as a line instead
+// of the progress dots
+var tracePackets bool
+
+// replies are traced by readReplies' goroutine
+var traceLock sync.Mutex
+var traceLast time.Time
+
+// prints the decoded header of a packet sent (->) or received (<-), along
+// with the time since the previous one.
+func tracePacket(dir string, pkt []byte) {
+	if !tracePackets {
+		return
+	}
+	traceLock.Lock()
+	defer traceLock.Unlock()
+	now := time.Now()
+	delta := time.Duration(0)
+	if !traceLast.IsZero() {
+		delta = now.Sub(traceLast)
+	}
+	traceLast = now
+
+	crc := "ok"
+	hdr, payload, err := abp.ParsePacket(pkt, true)
+	if err != nil {
+		crc = "bad"
+		if len(pkt) >= abp.HeaderLength {
+			hdr.Length = binary.BigEndian.Uint16(pkt[4:])
+			hdr.Flags = binary.BigEndian.Uint16(pkt[6:])
+		}
+	}
+	bit := 0
+	if hdr.Flags&abp.HDR_ALTERNATING != 0 {
+		bit = 1
+	}
+	line := fmt.Sprintf("[TRACE] %+10.3fms %s %-24s len=%-5d bit=%d crc=%s",
+		delta.Seconds()*1000, dir, abp.FlagString(hdr.Flags), hdr.Length,
+		bit, crc)
+	if hdr.Flags&abp.HDR_FEC != 0 && err == nil {
+		if fec, ok := abp.ParseFecHeader(payload); ok {
+			line += fmt.Sprintf(" shard=%d/%d+%d gen=%d", fec.Index, fec.K,
+				fec.M, fec.Generation)
+		}
+	}
+	if err != nil && err != abp.ErrChecksum {
+		line += fmt.Sprintf(" (%v)", err)
+	}
+	fmt.Println(line)
+}
+
+// sends a packet to the receiver, tracing it first. with -paths, it goes
+// out on the path picked for it instead of conn. a refused packet counts
+// as lost, see noteRefused; one that doesn't fit through the path ends
+// the transfer.
+func writePacket(conn *net.UDPConn, pkt []byte) (int, error) {
+	tracePacket("->", pkt)
+	if paths != nil {
+		conn = paths.conn()
+	}
+	// the kernel reports an ICMP error for an earlier packet on whichever
+	// call comes first, this packet is lost then
+	n, err := conn.Write(pkt)
+	if noteRefused(err) {
+		return n, nil
+	}
+	if tooBig(err) && !probingMTU {
+		fragmentationNeeded(conn, len(pkt))
+	}
+	return n, err
+}
+
+// shows the progress of the transfer, unless every packet is traced
+// anyway or it is reported in PROGRESS lines.
+func printProgress() {
+	if !tracePackets && !progressLines {
+		fmt.Print(".")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net"
+	"os"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// asks the receiver for the SHA-256 of its copy of the file and compares
+// it with the local one, then exits.
+func verifyFile(conn *net.UDPConn, fh *os.File, filename []byte) {
+	local, err := hashLocal(fh)
+	if err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	remote := remoteHash(conn, filename)
+	if remote == nil {
+		exitWith(EXIT_VERIFY_FAILED, "Verification failed: %s",
+			abp.ErrorMessage(abp.ERR_NOT_FOUND))
+	}
+	if !bytes.Equal(remote, local) {
+		exitWith(EXIT_VERIFY_FAILED, "MISMATCH: receiver has "+
+			"%x, local file is %x", remote, local)
+	}
+	exitWith(EXIT_OK, "Match: %x", local)
+}
+
+// returns the SHA-256 of a file.
+func hashLocal(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// sends a HDR_HASH packet whose payload is the file name and returns the
+// SHA-256 of the receiver's copy, or nil if it has none.
+func remoteHash(conn *net.UDPConn, filename []byte) []byte {
+	hdr := abp.Header{Length: uint16(len(filename)), Flags: abp.HDR_HASH}
+	request := finalizePkg(hdr, filename)
+	// a late answer to an earlier request would be taken for ours
+	for len(replies) > 0 {
+		<-replies
+	}
+	for attempt := 0; ; attempt++ {
+		checkRetries(attempt, EXIT_HANDSHAKE_FAILED)
+		limiter.wait(len(request))
+		if _, err := writePacket(conn, request); err != nil {
+			panic(err)
+		}
+
+		// hashing takes the receiver a while for large files
+		reply, payload, ok := readPacket(handshakeTimeout)
+		if !ok {
+			continue
+		}
+		if reply.Flags == abp.HDR_ERROR && len(payload) >= 2 {
+			return nil
+		}
+		if reply.Flags == abp.HDR_HASH && len(payload) == sha256.Size {
+			return payload
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// how often the watched directory is scanned, and how long to wait before
+// trying a file again whose transfer failed
+const watchInterval = time.Second
+const watchRetryDelay = 30 * time.Second
+
+// watch: sends every file that appears in (or is changed in) dir to
+// hostPort and moves it to sentDir once it arrived. a file is only sent
+// once its size and modification time stayed the same for one scan, so
+// files that are still being written are left alone. every transfer runs
+// in a child process with the same options, so one failing transfer
+// can't take the others down.
+func watchDir(dir string, sentDir string, hostPort string) {
+	if err := os.MkdirAll(sentDir, 0755); err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		exitWith(EXIT_USAGE, "%v", err)
+	}
+	args := childArgs("sent-dir")
+
+	seen := make(map[string]os.FileInfo)
+	failed := make(map[string]time.Time)
+	fmt.Printf("Watching %s, sending new files to %s\n", dir, hostPort)
+	for {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			exitWith(EXIT_USAGE, "%v", err)
+		}
+		current := make(map[string]os.FileInfo)
+		for _, info := range entries {
+			if !info.Mode().IsRegular() {
+				continue
+			}
+			name := info.Name()
+			current[name] = info
+			last, ok := seen[name]
+			if !ok || last.Size() != info.Size() ||
+				!last.ModTime().Equal(info.ModTime()) {
+				// new or still changing
+				continue
+			}
+			if time.Since(failed[name]) < watchRetryDelay {
+				continue
+			}
+
+			// the receiver gets the bare name, so run the child in
+			// the watched directory
+			fmt.Printf("[WATCH] sending %s\n", name)
+			cmd := exec.Command(self, append(args, hostPort, name)...)
+			cmd.Dir = dir
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				fmt.Printf("[WATCH] sending %s failed (%v), retrying in "+
+					"%v\n", name, err, watchRetryDelay)
+				failed[name] = time.Now()
+				continue
+			}
+			delete(failed, name)
+			err := os.Rename(filepath.Join(dir, name),
+				filepath.Join(sentDir, name))
+			if err != nil {
+				fmt.Printf("[WATCH] can't move %s to %s: %v\n", name,
+					sentDir, err)
+				// don't send it over and over again
+				failed[name] = time.Now()
+				continue
+			}
+			delete(current, name)
+			fmt.Printf("[WATCH] %s sent\n", name)
+		}
+		seen = current
+		time.Sleep(watchInterval)
+	}
+}
+
+// the options to pass on to a child sender: all that were set, except the
+// ones named in skip.
+func childArgs(skip ...string) []string {
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range skip {
+			if f.Name == name {
+				return
+			}
+		}
+		args = append(args, "-"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
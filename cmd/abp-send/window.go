@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// how long the sender waits before probing a closed window
+const WINDOW_PROBE_INTERVAL = 200 * time.Millisecond
+
+// the payload bytes the receiver is willing to accept with the next data
+// packet, from the ACK of the last one; -1 if it advertised none
+var peerWindow int64 = -1
+
+// caps the payload size of the next data packet at the receiver's window.
+func windowedSize(size int) int {
+	if peerWindow >= 0 && peerWindow < int64(size) {
+		return int(peerWindow)
+	}
+	return size
+}
+
+// waits before an empty data packet asks the receiver, whose window is
+// closed, whether it has room again.
+func waitForWindow() {
+	fmt.Printf("\n[WINDOW] receiver can't take more data, probing in %v\n",
+		WINDOW_PROBE_INTERVAL)
+	time.Sleep(WINDOW_PROBE_INTERVAL)
+}
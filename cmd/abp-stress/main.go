@@ -0,0 +1,240 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// what one simulated sender went through
+type clientResult struct {
+	id          int
+	err         error
+	duration    time.Duration
+	packets     int
+	retransmits int
+}
+
+// the resource usage of the receiver at one point in time
+type usageSnapshot struct {
+	at    time.Time
+	cpu   time.Duration
+	rss   int64
+	files int
+}
+
+// parses a size like 10MB, 512KB or 1000 (bytes). units are powers of
+// 1024, like -limit-rate of the sender.
+func parseSize(spec string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToUpper(spec), "B")
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1024
+	case strings.HasSuffix(s, "M"):
+		unit = 1024 * 1024
+	case strings.HasSuffix(s, "G"):
+		unit = 1024 * 1024 * 1024
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid size %q, want e.g. 10MB", spec)
+	}
+	return int64(value * float64(unit)), nil
+}
+
+// the contents of a generated file, reproducible from its seed
+func generated(seed int64, size int64) io.Reader {
+	return io.LimitReader(rand.New(rand.NewSource(seed)), size)
+}
+
+// sends size bytes generated from seed under name and counts the packets
+// it took.
+func runClient(addr *net.UDPAddr, id int, name string, seed int64,
+	size int64, retry abp.RetryPolicy) clientResult {
+	result := clientResult{id: id}
+	start := time.Now()
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer conn.Close()
+
+	s := abp.NewSender(conn, name)
+	s.Size = size
+	s.Retry = retry
+	t := s.Send(generated(seed, size))
+	for e := range t.Events() {
+		switch e.(type) {
+		case abp.PacketSent:
+			result.packets++
+		case abp.Retransmit:
+			result.retransmits++
+		}
+	}
+	result.err = t.Err()
+	result.duration = time.Since(start)
+	return result
+}
+
+func main() {
+	clients := flag.Int("clients", 200, "number of concurrent senders")
+	fileSize := flag.String("file-size", "10MB", "bytes sent by each "+
+		"sender, e.g. 10MB or 512KB")
+	ramp := flag.Duration("ramp", time.Second, "spread the start of the "+
+		"senders over this long instead of starting all at once")
+	ackTimeout := flag.Duration("ack-timeout", 500*time.Millisecond,
+		"resend a packet if it isn't acknowledged in time")
+	maxRetries := flag.Int("max-retries", 20, "give up after "+
+		"retransmitting a packet this often (0 = never)")
+	recvPid := flag.Int("recv-pid", 0, "process ID of a local receiver "+
+		"whose CPU time, memory and open files to report")
+	soakDuration := flag.Duration("soak", 0, "keep sending files of "+
+		"random sizes up to -file-size for this long, e.g. 8h")
+	soakInterval := flag.Duration("soak-interval", time.Minute, "how "+
+		"often -soak reports its progress and the receiver's usage")
+	recvDir := flag.String("recv-dir", "", "directory a local receiver "+
+		"stores files in; -soak compares them with what was sent and "+
+		"deletes them")
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [options] <host:port>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *clients < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	size, err := parseSize(*fileSize)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	addr, err := net.ResolveUDPAddr("udp", flag.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	retry := abp.FixedRetry{Timeout: *ackTimeout, Retries: *maxRetries}
+
+	var usage *usageSampler
+	if *recvPid > 0 {
+		if usage, err = newUsageSampler(*recvPid); err != nil {
+			fmt.Printf("can't watch the receiver: %v\n", err)
+			os.Exit(1)
+		}
+		go usage.run(500 * time.Millisecond)
+	}
+
+	if *soakDuration > 0 {
+		if !soak(addr, *clients, size, retry, *recvDir, *soakDuration,
+			*soakInterval, usage) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Printf("Starting %d senders of %d bytes each against %v...\n",
+		*clients, size, addr)
+	results := make([]clientResult, *clients)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < *clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = runClient(addr, i, fmt.Sprintf("stress-%d.bin", i),
+				int64(i), size, retry)
+		}(i)
+		time.Sleep(*ramp / time.Duration(*clients))
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report(results, size, elapsed)
+	if usage != nil {
+		usage.report(elapsed)
+	}
+	for _, r := range results {
+		if r.err != nil {
+			os.Exit(1)
+		}
+	}
+}
+
+// prints the aggregate throughput and the spread over the senders
+func report(results []clientResult, size int64, elapsed time.Duration) {
+	var failed []clientResult
+	var durations []time.Duration
+	var rates []float64
+	var packets, retransmits int
+	for _, r := range results {
+		packets += r.packets
+		retransmits += r.retransmits
+		if r.err != nil {
+			failed = append(failed, r)
+			continue
+		}
+		durations = append(durations, r.duration)
+		if r.packets > 0 {
+			rates = append(rates, float64(r.retransmits)/
+				float64(r.packets))
+		}
+	}
+	ok := len(results) - len(failed)
+
+	fmt.Printf("\n%d of %d senders completed in %v\n", ok, len(results),
+		elapsed.Round(time.Millisecond))
+	fmt.Printf("Aggregate goodput: %.2f MB/s (%d bytes)\n",
+		float64(int64(ok)*size)/elapsed.Seconds()/1024/1024,
+		int64(ok)*size)
+	if packets > 0 {
+		fmt.Printf("Retransmissions: %d of %d packets (%.2f%%)\n",
+			retransmits, packets, 100*float64(retransmits)/float64(packets))
+	}
+	if len(durations) > 0 {
+		sort.Slice(durations, func(i, j int) bool {
+			return durations[i] < durations[j]
+		})
+		fmt.Printf("Transfer time: min %v, median %v, max %v\n",
+			durations[0].Round(time.Millisecond),
+			durations[len(durations)/2].Round(time.Millisecond),
+			durations[len(durations)-1].Round(time.Millisecond))
+	}
+	if len(rates) > 0 {
+		sort.Float64s(rates)
+		fmt.Printf("Retransmission rate per sender: min %.2f%%, median "+
+			"%.2f%%, max %.2f%%\n", 100*rates[0], 100*rates[len(rates)/2],
+			100*rates[len(rates)-1])
+	}
+	// failures are grouped by their error, many senders tend to fail
+	// the same way
+	var errs []string
+	byErr := map[string][]int{}
+	for _, r := range failed {
+		msg := r.err.Error()
+		if byErr[msg] == nil {
+			errs = append(errs, msg)
+		}
+		byErr[msg] = append(byErr[msg], r.id)
+	}
+	for _, msg := range errs {
+		ids := byErr[msg]
+		fmt.Printf("%d senders failed, e.g. sender %d: %s\n", len(ids),
+			ids[0], msg)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/v4lli/go-abp/abp"
+)
+
+// counters of a soak run
+type soakStats struct {
+	mu        sync.Mutex
+	ok        int
+	failed    int
+	corrupted int
+	missing   int
+	bytes     int64
+}
+
+// compares a received file with the data it was generated from
+func sameContents(path string, want io.Reader) (bool, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer fh.Close()
+	got := bufio.NewReader(fh)
+	a, b := make([]byte, 64*1024), make([]byte, 64*1024)
+	for {
+		n, errA := io.ReadFull(want, a)
+		m, errB := io.ReadFull(got, b)
+		if n != m || !bytes.Equal(a[:n], b[:m]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, nil
+		}
+	}
+}
+
+// sends generated files of up to maxSize bytes one after the other until
+// the deadline. with recvDir, each file is compared with what was sent
+// and deleted afterwards.
+func soakClient(addr *net.UDPAddr, id int, prefix string, maxSize int64,
+	retry abp.RetryPolicy, recvDir string, deadline time.Time,
+	stats *soakStats) {
+	rnd := rand.New(rand.NewSource(int64(id)))
+	for n := 0; time.Now().Before(deadline); n++ {
+		seed := rnd.Int63()
+		size := 1 + rnd.Int63n(maxSize)
+		name := fmt.Sprintf("%s-%d-%d.bin", prefix, id, n)
+		result := runClient(addr, id, name, seed, size, retry)
+		if result.err != nil {
+			fmt.Printf("[SOAK] %s failed: %v\n", name, result.err)
+			stats.mu.Lock()
+			stats.failed++
+			stats.mu.Unlock()
+			continue
+		}
+
+		var same bool
+		var err error
+		if recvDir != "" {
+			path := filepath.Join(recvDir, name)
+			same, err = sameContents(path, generated(seed, size))
+			if err == nil {
+				os.Remove(path)
+			}
+		}
+		stats.mu.Lock()
+		switch {
+		case recvDir == "" || same:
+			stats.ok++
+			stats.bytes += size
+		case err != nil:
+			fmt.Printf("[SOAK] %s not found on the receiver: %v\n", name,
+				err)
+			stats.missing++
+		default:
+			fmt.Printf("[SOAK] %s CORRUPTED, seed %d, %d bytes\n", name,
+				seed, size)
+			stats.corrupted++
+		}
+		stats.mu.Unlock()
+	}
+}
+
+// runs soak clients for duration, reporting every interval, and returns
+// whether all transfers arrived intact.
+func soak(addr *net.UDPAddr, clients int, maxSize int64,
+	retry abp.RetryPolicy, recvDir string, duration time.Duration,
+	interval time.Duration, usage *usageSampler) bool {
+	if recvDir == "" {
+		fmt.Printf("Without -recv-dir, received files are neither " +
+			"verified nor deleted.\n")
+	}
+	fmt.Printf("Soaking %v with %d senders of up to %d bytes per file...\n",
+		duration, clients, maxSize)
+	start := time.Now()
+	deadline := start.Add(duration)
+	prefix := fmt.Sprintf("soak-%d", start.Unix())
+	stats := &soakStats{}
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			soakClient(addr, i, prefix, maxSize, retry, recvDir, deadline,
+				stats)
+		}(i)
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var baseline *usageSnapshot
+	for {
+		select {
+		case <-ticker.C:
+		case <-finished:
+		}
+		elapsed := time.Since(start)
+		stats.mu.Lock()
+		fmt.Printf("[SOAK] %v: %d ok (%.2f MB/s), %d failed, %d missing, "+
+			"%d corrupted\n", elapsed.Round(time.Second), stats.ok,
+			float64(stats.bytes)/elapsed.Seconds()/1024/1024, stats.failed,
+			stats.missing, stats.corrupted)
+		intact := stats.corrupted == 0 && stats.missing == 0
+		stats.mu.Unlock()
+
+		// growth of the receiver's memory and open files since the first
+		// report hints at leaks
+		if usage != nil {
+			now := usage.snapshot()
+			if baseline == nil {
+				baseline = &now
+			}
+			cpu := 0.0
+			if d := now.at.Sub(baseline.at); d > 0 {
+				cpu = 100 * (now.cpu - baseline.cpu).Seconds() / d.Seconds()
+			}
+			fmt.Printf("[SOAK] receiver: RSS %.1f MB (%+.1f), %d open "+
+				"files (%+d), %.0f%% CPU\n", float64(now.rss)/1024/1024,
+				float64(now.rss-baseline.rss)/1024/1024, now.files,
+				now.files-baseline.files, cpu)
+		}
+
+		select {
+		case <-finished:
+			return intact
+		default:
+		}
+	}
+}
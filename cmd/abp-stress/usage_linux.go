@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clock ticks per second of the CPU times in /proc/<pid>/stat; 100 on
+// every Linux architecture Go runs on
+const CLOCK_TICKS = 100
+
+// samples the resource usage of a receiver from /proc while the senders
+// run.
+type usageSampler struct {
+	pid       int
+	mu        sync.Mutex
+	startCPU  time.Duration
+	cpu       time.Duration
+	peakRSS   int64
+	peakFiles int
+	last      usageSnapshot
+}
+
+func newUsageSampler(pid int) (*usageSampler, error) {
+	u := &usageSampler{pid: pid}
+	cpu, err := u.cpuTime()
+	if err != nil {
+		return nil, err
+	}
+	u.startCPU, u.cpu = cpu, cpu
+	u.sample()
+	return u, nil
+}
+
+func (u *usageSampler) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		u.sample()
+	}
+}
+
+func (u *usageSampler) sample() {
+	cpu, err := u.cpuTime()
+	if err != nil {
+		// the receiver is gone, keep what we have
+		return
+	}
+	rss := u.rss()
+	files, _ := ioutil.ReadDir(fmt.Sprintf("/proc/%d/fd", u.pid))
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.cpu = cpu
+	u.last = usageSnapshot{time.Now(), cpu, rss, len(files)}
+	if rss > u.peakRSS {
+		u.peakRSS = rss
+	}
+	if len(files) > u.peakFiles {
+		u.peakFiles = len(files)
+	}
+}
+
+// user and system time used so far
+func (u *usageSampler) cpuTime() (time.Duration, error) {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", u.pid))
+	if err != nil {
+		return 0, err
+	}
+	// the command name in parentheses may contain spaces
+	fields := strings.Fields(string(stat[strings.LastIndexByte(
+		string(stat), ')')+1:]))
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("unexpected format of /proc/%d/stat", u.pid)
+	}
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	return time.Duration(utime+stime) * time.Second / CLOCK_TICKS, nil
+}
+
+// resident memory in bytes
+func (u *usageSampler) rss() int64 {
+	status, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", u.pid))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if strings.HasPrefix(line, "VmRSS:") {
+			kb, _ := strconv.ParseInt(strings.Fields(line)[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+// the usage as of now
+func (u *usageSampler) snapshot() usageSnapshot {
+	u.sample()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.last
+}
+
+func (u *usageSampler) report(elapsed time.Duration) {
+	u.sample()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	used := u.cpu - u.startCPU
+	fmt.Printf("Receiver (pid %d): %v CPU time (%.0f%% of one core), peak "+
+		"RSS %.1f MB, peak %d open files\n", u.pid,
+		used.Round(time.Millisecond), 100*used.Seconds()/elapsed.Seconds(),
+		float64(u.peakRSS)/1024/1024, u.peakFiles)
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// the receiver's resource usage is read from /proc, which only Linux has
+type usageSampler struct{}
+
+func newUsageSampler(pid int) (*usageSampler, error) {
+	return nil, errors.New("-recv-pid is only supported on Linux")
+}
+
+func (u *usageSampler) run(interval time.Duration) {}
+
+func (u *usageSampler) snapshot() usageSnapshot {
+	return usageSnapshot{}
+}
+
+func (u *usageSampler) report(elapsed time.Duration) {}
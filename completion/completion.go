@@ -0,0 +1,168 @@
+// Package completion generates shell completion scripts for the flags and
+// subcommands of the sender and receiver commands.
+package completion
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Script returns the completion script for prog in the given shell (bash,
+// zsh or fish). choices lists the possible values of flags which only take
+// a few; the values of all other flags are completed as file names, as are
+// the positional arguments. The first argument may also be one of the
+// subcommands, keys of commands, whose arguments complete to the values
+// listed, or to file names if there are none.
+func Script(shell string, prog string, flags *flag.FlagSet,
+	choices map[string][]string, commands map[string][]string) (string, error) {
+	var opts []*flag.Flag
+	flags.VisitAll(func(f *flag.Flag) {
+		opts = append(opts, f)
+	})
+	sort.Slice(opts, func(i, j int) bool { return opts[i].Name < opts[j].Name })
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cmds := subcommands{names, commands}
+
+	switch shell {
+	case "bash":
+		return bashScript(prog, opts, choices, cmds), nil
+	case "zsh":
+		return zshScript(prog, opts, choices, cmds), nil
+	case "fish":
+		return fishScript(prog, opts, choices, cmds), nil
+	}
+	return "", fmt.Errorf("unknown shell %q, want bash, zsh or fish", shell)
+}
+
+type subcommands struct {
+	// sorted
+	names []string
+	args  map[string][]string
+}
+
+func isBool(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// turns a program name into a valid shell function name
+func funcName(prog string) string {
+	return "_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, prog)
+}
+
+func bashScript(prog string, opts []*flag.Flag, choices map[string][]string,
+	cmds subcommands) string {
+	var buf bytes.Buffer
+	var names, valueFlags []string
+	for _, f := range opts {
+		names = append(names, "-"+f.Name)
+		if !isBool(f) && choices[f.Name] == nil {
+			valueFlags = append(valueFlags, "-"+f.Name)
+		}
+	}
+
+	fn := funcName(prog)
+	fmt.Fprintf(&buf, "# bash completion for %s\n", prog)
+	fmt.Fprintf(&buf, "%s() {\n", fn)
+	fmt.Fprintf(&buf, "\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	fmt.Fprintf(&buf, "\tlocal prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	fmt.Fprintf(&buf, "\tcase \"$prev\" in\n")
+	for _, f := range opts {
+		if values := choices[f.Name]; values != nil {
+			fmt.Fprintf(&buf, "\t-%s)\n\t\tCOMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n\t\treturn ;;\n",
+				f.Name, strings.Join(values, " "))
+		}
+	}
+	for _, name := range cmds.names {
+		if values := cmds.args[name]; values != nil {
+			fmt.Fprintf(&buf, "\t%s)\n\t\tCOMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n\t\treturn ;;\n",
+				name, strings.Join(values, " "))
+		}
+	}
+	if len(valueFlags) > 0 {
+		fmt.Fprintf(&buf, "\t%s)\n\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n\t\treturn ;;\n",
+			strings.Join(valueFlags, "|"))
+	}
+	fmt.Fprintf(&buf, "\tesac\n")
+	fmt.Fprintf(&buf, "\tif [[ \"$cur\" == -* ]]; then\n")
+	fmt.Fprintf(&buf, "\t\tCOMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n",
+		strings.Join(names, " "))
+	fmt.Fprintf(&buf, "\telse\n\t\tCOMPREPLY=($(compgen -W \"%s\" -- \"$cur\") $(compgen -f -- \"$cur\"))\n\tfi\n",
+		strings.Join(cmds.names, " "))
+	fmt.Fprintf(&buf, "}\ncomplete -F %s %s\n", fn, prog)
+	return buf.String()
+}
+
+// escapes a flag description for zsh's _arguments
+func zshEscape(s string) string {
+	r := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:")
+	return r.Replace(s)
+}
+
+func zshScript(prog string, opts []*flag.Flag, choices map[string][]string,
+	cmds subcommands) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "#compdef %s\n\n_arguments -C \\\n", prog)
+	for _, f := range opts {
+		spec := fmt.Sprintf("-%s[%s]", f.Name, zshEscape(f.Usage))
+		if values := choices[f.Name]; values != nil {
+			spec += ":" + f.Name + ":(" + strings.Join(values, " ") + ")"
+		} else if !isBool(f) {
+			spec += ":" + f.Name + ":_files"
+		}
+		fmt.Fprintf(&buf, "\t'%s' \\\n", spec)
+	}
+	fmt.Fprintf(&buf, "\t'1: :->first' \\\n")
+	fmt.Fprintf(&buf, "\t'*::argument:->args'\n\n")
+	fmt.Fprintf(&buf, "case $state in\n")
+	fmt.Fprintf(&buf, "first)\n\t_alternative 'commands:command:(%s)' "+
+		"'files:file:_files' ;;\n", strings.Join(cmds.names, " "))
+	fmt.Fprintf(&buf, "args)\n\tcase $words[1] in\n")
+	for _, name := range cmds.names {
+		if values := cmds.args[name]; values != nil {
+			fmt.Fprintf(&buf, "\t%s) _values %s %s ;;\n", name, name,
+				strings.Join(values, " "))
+		}
+	}
+	fmt.Fprintf(&buf, "\t*) _files ;;\n\tesac ;;\nesac\n")
+	return buf.String()
+}
+
+func fishScript(prog string, opts []*flag.Flag, choices map[string][]string,
+	cmds subcommands) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# fish completion for %s\n", prog)
+	for _, f := range opts {
+		desc := strings.Replace(f.Usage, "'", "\\'", -1)
+		fmt.Fprintf(&buf, "complete -c %s -o %s -d '%s'", prog, f.Name, desc)
+		if values := choices[f.Name]; values != nil {
+			fmt.Fprintf(&buf, " -x -a '%s'", strings.Join(values, " "))
+		} else if !isBool(f) {
+			fmt.Fprintf(&buf, " -r -F")
+		}
+		fmt.Fprintf(&buf, "\n")
+	}
+	if len(cmds.names) > 0 {
+		fmt.Fprintf(&buf, "complete -c %s -n __fish_use_subcommand -a '%s'\n",
+			prog, strings.Join(cmds.names, " "))
+	}
+	for _, name := range cmds.names {
+		if values := cmds.args[name]; values != nil {
+			fmt.Fprintf(&buf, "complete -c %s -n '__fish_seen_subcommand_from %s' -x -a '%s'\n",
+				prog, name, strings.Join(values, " "))
+		}
+	}
+	return buf.String()
+}
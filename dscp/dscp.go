@@ -0,0 +1,36 @@
+// Package dscp marks the packets of a socket with a Differentiated
+// Services Code Point (the upper six bits of the IPv4 TOS or IPv6 Traffic
+// Class byte), so QoS policies of the network can classify bulk ABP
+// traffic, e.g. as CS1 (scavenger) to make way for everything else.
+package dscp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the code points of RFC 4594 by name
+var names = map[string]int{
+	"CS0": 0, "CS1": 8, "CS2": 16, "CS3": 24,
+	"CS4": 32, "CS5": 40, "CS6": 48, "CS7": 56,
+	"AF11": 10, "AF12": 12, "AF13": 14,
+	"AF21": 18, "AF22": 20, "AF23": 22,
+	"AF31": 26, "AF32": 28, "AF33": 30,
+	"AF41": 34, "AF42": 36, "AF43": 38,
+	"EF": 46, "LE": 1,
+}
+
+// Parse parses a code point given by name (e.g. CS1, AF21, EF) or number
+// (0-63).
+func Parse(spec string) (int, error) {
+	if value, ok := names[strings.ToUpper(spec)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(spec)
+	if err != nil || value < 0 || value > 63 {
+		return 0, fmt.Errorf("invalid DSCP %q, want 0-63 or a name like "+
+			"CS1, AF21 or EF", spec)
+	}
+	return value, nil
+}
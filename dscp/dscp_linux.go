@@ -0,0 +1,30 @@
+package dscp
+
+import (
+	"net"
+	"syscall"
+)
+
+// Set marks all packets sent on conn with the code point. Both the IPv4
+// and the IPv6 option are set, as a socket bound to all interfaces may
+// carry either; it is an error only if neither applies.
+func Set(conn *net.UDPConn, dscp int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var v4Err, v6Err error
+	err = raw.Control(func(fd uintptr) {
+		v4Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP,
+			syscall.IP_TOS, dscp<<2)
+		v6Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6,
+			syscall.IPV6_TCLASS, dscp<<2)
+	})
+	if err != nil {
+		return err
+	}
+	if v4Err != nil && v6Err != nil {
+		return v4Err
+	}
+	return nil
+}
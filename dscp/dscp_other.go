@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package dscp
+
+import (
+	"errors"
+	"net"
+)
+
+// Set is not supported on this platform.
+func Set(conn *net.UDPConn, dscp int) error {
+	return errors.New("setting the DSCP is not supported on this platform")
+}
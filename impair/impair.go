@@ -0,0 +1,175 @@
+// Package impair simulates a bad network for the sender and receiver
+// commands: arriving datagrams are dropped, duplicated, corrupted and
+// delayed at random, so the protocol can be watched recovering on a single
+// machine.
+package impair
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Impairment describes what happens to arriving datagrams. Probabilities
+// range from 0 to 1.
+type Impairment struct {
+	Loss    float64
+	Dup     float64
+	Corrupt float64
+	// every copy is delivered this much later, plus or minus up to Jitter
+	Delay  time.Duration
+	Jitter time.Duration
+
+	once sync.Once
+	line *delayLine
+}
+
+// Register adds the -simulate-* flags to flags.
+func (imp *Impairment) Register(flags *flag.FlagSet) {
+	flags.Float64Var(&imp.Loss, "simulate-loss", 0, "drop this share of "+
+		"the arriving datagrams, e.g. 0.05")
+	flags.Float64Var(&imp.Dup, "simulate-dup", 0, "duplicate this share "+
+		"of the arriving datagrams")
+	flags.Float64Var(&imp.Corrupt, "simulate-corrupt", 0, "flip a bit in "+
+		"this share of the arriving datagrams")
+	flags.Var((*delayValue)(imp), "simulate-delay", "delay arriving "+
+		"datagrams, optionally with jitter, e.g. 50ms±20ms or 50ms+-20ms")
+}
+
+// Enabled tells whether datagrams are impaired at all.
+func (imp *Impairment) Enabled() bool {
+	return imp.Loss > 0 || imp.Dup > 0 || imp.Corrupt > 0 || imp.Delay > 0 ||
+		imp.Jitter > 0
+}
+
+// Apply impairs an arriving datagram and calls deliver for every copy of
+// it that gets through. With a delay, deliver is called later from another
+// goroutine.
+func (imp *Impairment) Apply(data []byte, deliver func([]byte)) {
+	if !imp.Enabled() {
+		deliver(data)
+		return
+	}
+
+	copies := 1
+	if rand.Float64() < imp.Loss {
+		fmt.Print("========== DROPPING PACKET ==============\n")
+		copies--
+	}
+	if rand.Float64() < imp.Dup {
+		fmt.Print("========== DUPLICATING PACKET ==============\n")
+		copies++
+	}
+	if len(data) > 0 && rand.Float64() < imp.Corrupt {
+		fmt.Print("========== INJECTING BIT ERROR ==============\n")
+		data[rand.Intn(len(data))] ^= 1 << uint(rand.Intn(8))
+	}
+
+	for i := 0; i < copies; i++ {
+		// copies are handed on (and possibly changed) independently
+		buf := data
+		if i > 0 {
+			buf = append([]byte(nil), data...)
+		}
+		if imp.Delay <= 0 && imp.Jitter <= 0 {
+			deliver(buf)
+			continue
+		}
+		delay := imp.Delay
+		if imp.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(2*int64(imp.Jitter)+1)) -
+				imp.Jitter
+		}
+		imp.delayLine().add(time.Now().Add(delay), buf, deliver)
+	}
+}
+
+// how many delayed datagrams may be in flight; more are dropped like by
+// a router with a full queue
+const delayQueue = 1024
+
+// delivers delayed datagrams in the order they arrived. the alternating
+// bit protocol relies on datagrams not overtaking each other, so jitter
+// only delays a datagram up to its predecessor rather than reordering
+// them.
+type delayLine struct {
+	mu     sync.Mutex
+	last   time.Time
+	queued chan delayed
+}
+
+type delayed struct {
+	due     time.Time
+	data    []byte
+	deliver func([]byte)
+}
+
+// the delay line of imp, shared by all readers applying it.
+func (imp *Impairment) delayLine() *delayLine {
+	imp.once.Do(func() {
+		imp.line = &delayLine{queued: make(chan delayed, delayQueue)}
+		go imp.line.run()
+	})
+	return imp.line
+}
+
+func (l *delayLine) add(due time.Time, data []byte, deliver func([]byte)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if due.Before(l.last) {
+		due = l.last
+	}
+	select {
+	case l.queued <- delayed{due, data, deliver}:
+		l.last = due
+	default:
+		fmt.Print("========== DELAY QUEUE FULL, DROPPING PACKET ==============\n")
+	}
+}
+
+func (l *delayLine) run() {
+	for d := range l.queued {
+		time.Sleep(time.Until(d.due))
+		d.deliver(d.data)
+	}
+}
+
+// flag.Value of -simulate-delay
+type delayValue Impairment
+
+func (v *delayValue) String() string {
+	if v == nil || v.Jitter == 0 {
+		if v == nil || v.Delay == 0 {
+			return ""
+		}
+		return v.Delay.String()
+	}
+	return v.Delay.String() + "±" + v.Jitter.String()
+}
+
+func (v *delayValue) Set(s string) error {
+	delay, jitter := s, ""
+	for _, sep := range []string{"±", "+-"} {
+		if i := strings.Index(s, sep); i >= 0 {
+			delay, jitter = s[:i], s[i+len(sep):]
+			break
+		}
+	}
+	var err error
+	if v.Delay, err = time.ParseDuration(delay); err != nil {
+		return err
+	}
+	v.Jitter = 0
+	if jitter != "" {
+		if v.Jitter, err = time.ParseDuration(jitter); err != nil {
+			return err
+		}
+	}
+	if v.Delay < 0 || v.Jitter < 0 {
+		return fmt.Errorf("negative delay %s", s)
+	}
+	return nil
+}
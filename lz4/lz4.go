@@ -0,0 +1,173 @@
+// Package lz4 compresses and decompresses lz4 blocks, the format lz4
+// frames carry their data in, without the framing: a datagram payload
+// has its own length and checksum. See
+// https://github.com/lz4/lz4/blob/dev/doc/lz4_Block_format.md.
+package lz4
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	minMatch = 4
+	hashBits = 14
+	// the last match starts this many bytes before the end of a block
+	// at the latest, and the last 5 bytes are literals; decoders copying
+	// 8 bytes at a time rely on it
+	mfLimit      = 12
+	lastLiterals = 5
+	maxOffset    = 1<<16 - 1
+)
+
+var (
+	ErrCorrupt  = errors.New("lz4: corrupt block")
+	ErrTooLarge = errors.New("lz4: decompressed block exceeds limit")
+)
+
+func hash4(v uint32) uint32 {
+	return (v * 2654435761) >> (32 - hashBits)
+}
+
+// Compress appends the lz4 block of src to dst and returns the result.
+// Matches are found with a table of the last position of each 4 byte
+// sequence, like the reference implementation's fast mode.
+func Compress(dst, src []byte) []byte {
+	var table [1 << hashBits]int32
+	anchor := 0
+	for i := 0; i+mfLimit < len(src); {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := hash4(v)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if candidate < 0 || i-candidate > maxOffset ||
+			binary.LittleEndian.Uint32(src[candidate:]) != v {
+			i++
+			continue
+		}
+		n := minMatch
+		for i+n < len(src)-lastLiterals && src[candidate+n] == src[i+n] {
+			n++
+		}
+		dst = appendSequence(dst, src[anchor:i], i-candidate, n)
+		i += n
+		anchor = i
+	}
+	return appendSequence(dst, src[anchor:], 0, 0)
+}
+
+// appends a sequence of literals followed by a match, or just the
+// literals if offset is 0, which ends the block
+func appendSequence(dst, literals []byte, offset, match int) []byte {
+	token := len(dst)
+	dst = append(dst, 0)
+	if len(literals) < 15 {
+		dst[token] = byte(len(literals)) << 4
+	} else {
+		dst[token] = 15 << 4
+		dst = appendLength(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+	if offset == 0 {
+		return dst
+	}
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(offset))
+	if match-minMatch < 15 {
+		dst[token] |= byte(match - minMatch)
+	} else {
+		dst[token] |= 15
+		dst = appendLength(dst, match-minMatch-15)
+	}
+	return dst
+}
+
+// lengths beyond the token's 15 continue in bytes of 255 and a last one
+// below that
+func appendLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+// Decompress appends the data of the lz4 block src to dst and returns
+// the result, refusing to produce more than limit bytes so a malicious
+// block can't exhaust memory. Matches may only refer to the data of the
+// block.
+func Decompress(dst, src []byte, limit int) ([]byte, error) {
+	start := len(dst)
+	for i := 0; ; {
+		if i >= len(src) {
+			return nil, ErrCorrupt
+		}
+		token := src[i]
+		i++
+		literals := int(token >> 4)
+		if literals == 15 {
+			n, next, err := readLength(src, i)
+			if err != nil {
+				return nil, err
+			}
+			literals += n
+			i = next
+		}
+		if literals > len(src)-i {
+			return nil, ErrCorrupt
+		}
+		if len(dst)-start+literals > limit {
+			return nil, ErrTooLarge
+		}
+		dst = append(dst, src[i:i+literals]...)
+		i += literals
+		if i == len(src) {
+			// the last sequence has no match
+			return dst, nil
+		}
+
+		if i+2 > len(src) {
+			return nil, ErrCorrupt
+		}
+		offset := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+		match := int(token&15) + minMatch
+		if token&15 == 15 {
+			n, next, err := readLength(src, i)
+			if err != nil {
+				return nil, err
+			}
+			match += n
+			i = next
+		}
+		if offset == 0 || offset > len(dst)-start {
+			return nil, ErrCorrupt
+		}
+		if len(dst)-start+match > limit {
+			return nil, ErrTooLarge
+		}
+		// a match overlapping what it copies repeats the pattern, byte
+		// by byte
+		from := len(dst) - offset
+		if offset >= match {
+			dst = append(dst, dst[from:from+match]...)
+			continue
+		}
+		for j := 0; j < match; j++ {
+			dst = append(dst, dst[from+j])
+		}
+	}
+}
+
+func readLength(src []byte, i int) (int, int, error) {
+	n := 0
+	for {
+		if i >= len(src) {
+			return 0, 0, ErrCorrupt
+		}
+		b := src[i]
+		i++
+		n += int(b)
+		if b != 255 {
+			return n, i, nil
+		}
+	}
+}
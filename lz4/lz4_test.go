@@ -0,0 +1,103 @@
+package lz4
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+const testText = "Alternating bit protocol over UDP: every packet waits for " +
+	"its ACK, alternating bit protocol over UDP, every packet.\n"
+
+// testText compressed by the reference implementation, the block of its
+// frame
+var referenceBlock = []byte{
+	0xf0, 0x2b, 0x41, 0x6c, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x74, 0x69, 0x6e,
+	0x67, 0x20, 0x62, 0x69, 0x74, 0x20, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63,
+	0x6f, 0x6c, 0x20, 0x6f, 0x76, 0x65, 0x72, 0x20, 0x55, 0x44, 0x50, 0x3a,
+	0x20, 0x65, 0x76, 0x65, 0x72, 0x79, 0x20, 0x70, 0x61, 0x63, 0x6b, 0x65,
+	0x74, 0x20, 0x77, 0x61, 0x69, 0x74, 0x73, 0x20, 0x66, 0x6f, 0x72, 0x20,
+	0x08, 0x00, 0x6f, 0x41, 0x43, 0x4b, 0x2c, 0x20, 0x61, 0x43, 0x00, 0x0d,
+	0x16, 0x2c, 0x43, 0x00, 0x50, 0x6b, 0x65, 0x74, 0x2e, 0x0a,
+}
+
+func TestReference(t *testing.T) {
+	got, err := Decompress(nil, referenceBlock, 1<<10)
+	if err != nil || string(got) != testText {
+		t.Fatalf("decompressed %q, %v", got, err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 5000)
+	rnd.Read(random)
+	var text []byte
+	for len(text) < 200<<10 {
+		text = append(text, testText[rnd.Intn(len(testText)/2):]...)
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", []byte(testText[:12])},
+		{"text", []byte(testText)},
+		// overlapping matches, lengths beyond the token
+		{"rle", bytes.Repeat([]byte{7}, 1000)},
+		{"pattern", bytes.Repeat([]byte("abc"), 700)},
+		{"random", random},
+		// offsets up to the 64 KiB limit
+		{"long text", text},
+	}
+	for _, test := range tests {
+		block := Compress(nil, test.data)
+		got, err := Decompress(nil, block, len(test.data))
+		if err != nil || !bytes.Equal(got, test.data) {
+			t.Errorf("%s: round trip failed (%v)", test.name, err)
+		}
+		if len(test.data) > 1000 && test.name != "random" &&
+			len(block) > len(test.data)/2 {
+			t.Errorf("%s: compressed %d bytes to %d", test.name,
+				len(test.data), len(block))
+		}
+		if len(test.data) > 0 {
+			if _, err := Decompress(nil, block,
+				len(test.data)-1); err != ErrTooLarge {
+				t.Errorf("%s: decompressed beyond the limit (%v)",
+					test.name, err)
+			}
+		}
+	}
+
+	block := Compress(nil, []byte(testText))
+	got, err := Decompress([]byte("prefix"), block, len(testText))
+	if err != nil || string(got) != "prefix"+testText {
+		t.Errorf("didn't append to dst: %q, %v", got, err)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	tests := []struct {
+		name  string
+		block []byte
+	}{
+		{"empty", nil},
+		{"literals beyond the end", []byte{0x50, 'a', 'b'}},
+		{"truncated length", []byte{0xf0, 255}},
+		{"truncated offset", []byte{0x10, 'a', 1}},
+		{"offset before the start", []byte{0x10, 'a', 2, 0, 0x00}},
+		{"zero offset", []byte{0x10, 'a', 0, 0, 0x00}},
+		{"truncated match length", []byte{0x1f, 'a', 1, 0}},
+	}
+	for _, test := range tests {
+		if _, err := Decompress(nil, test.block, 1<<10); err != ErrCorrupt {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+	// an offset into dst isn't one into the block
+	if _, err := Decompress([]byte("xx"), []byte{0x10, 'a', 2, 0, 0x00},
+		1<<10); err != ErrCorrupt {
+		t.Errorf("match reached into dst: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+// Package rendezvous lets a sender and a receiver that are both behind a
+// NAT find each other. Both register with a rendezvous server under a
+// name they agreed on, and the server tells each of them the address the
+// other one's registration came from. The packets they then send to each
+// other from the same sockets open both NATs for the transfer (UDP hole
+// punching).
+//
+// Messages are single lines of text in a UDP datagram:
+//
+//	REGISTER <name> send|recv [<reflexive host:port>]
+//	PEER <host:port> [<reflexive host:port>]
+//
+// A peer that asked a STUN server for its reflexive address (see package
+// stun) passes it on; if it differs from the address the rendezvous server
+// saw, the peer's NAT maps every destination to another port and hole
+// punching will most likely fail.
+package rendezvous
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// The roles a peer registers with; a name pairs one of each.
+const (
+	RoleSend = "send"
+	RoleRecv = "recv"
+)
+
+// Register is the message registering the socket it is sent from under
+// name. reflexive may be nil.
+func Register(name string, role string, reflexive *net.UDPAddr) []byte {
+	if reflexive == nil {
+		return []byte(fmt.Sprintf("REGISTER %s %s\n", name, role))
+	}
+	return []byte(fmt.Sprintf("REGISTER %s %s %s\n", name, role, reflexive))
+}
+
+// ParseRegister returns the name, role and reflexive address (nil if not
+// given) of a REGISTER message.
+func ParseRegister(msg []byte) (name string, role string,
+	reflexive *net.UDPAddr, err error) {
+	fields := strings.Fields(string(msg))
+	if len(fields) < 3 || len(fields) > 4 || fields[0] != "REGISTER" {
+		return "", "", nil, fmt.Errorf("invalid registration %q", msg)
+	}
+	if fields[2] != RoleSend && fields[2] != RoleRecv {
+		return "", "", nil, fmt.Errorf("invalid role %q", fields[2])
+	}
+	if len(fields) == 4 {
+		if reflexive, err = net.ResolveUDPAddr("udp", fields[3]); err != nil {
+			return "", "", nil, err
+		}
+	}
+	return fields[1], fields[2], reflexive, nil
+}
+
+// Peer is the message telling a peer the address of the other one, and
+// the other's reflexive address, which may be nil.
+func Peer(addr *net.UDPAddr, reflexive *net.UDPAddr) []byte {
+	if reflexive == nil {
+		return []byte(fmt.Sprintf("PEER %s\n", addr))
+	}
+	return []byte(fmt.Sprintf("PEER %s %s\n", addr, reflexive))
+}
+
+// ParsePeer returns the address and reflexive address (nil if not given)
+// in a PEER message.
+func ParsePeer(msg []byte) (addr *net.UDPAddr, reflexive *net.UDPAddr,
+	err error) {
+	fields := strings.Fields(string(msg))
+	if len(fields) < 2 || len(fields) > 3 || fields[0] != "PEER" {
+		return nil, nil, fmt.Errorf("invalid peer message %q", msg)
+	}
+	if addr, err = net.ResolveUDPAddr("udp", fields[1]); err != nil {
+		return nil, nil, err
+	}
+	if len(fields) == 3 {
+		if reflexive, err = net.ResolveUDPAddr("udp", fields[2]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return addr, reflexive, nil
+}
+
+// Symmetric tells whether the reflexive address a peer learned from STUN
+// differs from the address the rendezvous server saw, i.e. its NAT maps
+// the socket to a new port for every destination.
+func Symmetric(addr *net.UDPAddr, reflexive *net.UDPAddr) bool {
+	return reflexive != nil &&
+		(!reflexive.IP.Equal(addr.IP) || reflexive.Port != addr.Port)
+}
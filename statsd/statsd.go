@@ -0,0 +1,73 @@
+// Package statsd sends counters, gauges and timings of the sender and
+// receiver commands to a statsd server over UDP, for setups without
+// Prometheus. Metrics are fire and forget: a missing or unreachable server
+// never slows down or fails a transfer.
+package statsd
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Client sends metrics named <Prefix>.<name> to Addr. The zero value (no
+// Addr) sends nothing.
+type Client struct {
+	Addr   string
+	Prefix string
+
+	once sync.Once
+	conn net.Conn
+}
+
+// Register adds the -statsd and -statsd-prefix flags to flags.
+func (c *Client) Register(flags *flag.FlagSet, prefix string) {
+	flags.StringVar(&c.Addr, "statsd", "", "send metrics to this statsd "+
+		"server, e.g. localhost:8125")
+	flags.StringVar(&c.Prefix, "statsd-prefix", prefix, "prefix of the "+
+		"metric names sent to -statsd")
+}
+
+// Enabled tells whether metrics are sent at all.
+func (c *Client) Enabled() bool {
+	return c.Addr != ""
+}
+
+// Count adds n to a counter.
+func (c *Client) Count(name string, n int64) {
+	c.send(name, fmt.Sprintf("%d|c", n))
+}
+
+// Gauge sets a gauge to v.
+func (c *Client) Gauge(name string, v int64) {
+	c.send(name, fmt.Sprintf("%d|g", v))
+}
+
+// Timing records a duration in milliseconds.
+func (c *Client) Timing(name string, d time.Duration) {
+	c.send(name, fmt.Sprintf("%.3f|ms", d.Seconds()*1000))
+}
+
+func (c *Client) send(name string, value string) {
+	if !c.Enabled() {
+		return
+	}
+	c.once.Do(func() {
+		conn, err := net.Dial("udp", c.Addr)
+		if err != nil {
+			fmt.Printf("[STATSD] can't send metrics to %s: %v\n", c.Addr,
+				err)
+			return
+		}
+		c.conn = conn
+	})
+	if c.conn == nil {
+		return
+	}
+	if c.Prefix != "" {
+		name = c.Prefix + "." + name
+	}
+	c.conn.Write([]byte(name + ":" + value))
+}
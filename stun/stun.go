@@ -0,0 +1,198 @@
+// Package stun asks STUN servers (RFC 5389) for the address a socket's
+// packets appear to come from on the internet, its reflexive address,
+// which tells whether and what kind of NAT is in the way. Only Binding
+// requests without authentication are supported, which is all public STUN
+// servers need.
+package stun
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	headerLength = 20
+	magicCookie  = 0x2112A442
+
+	bindingRequest  = 0x0001
+	bindingResponse = 0x0101
+
+	attrMappedAddress    = 0x0001
+	attrXorMappedAddress = 0x0020
+)
+
+// Attempts is how often a request is sent, Timeout apart, before a server
+// is given up.
+const Attempts = 3
+
+// Timeout is how long a request waits for its response.
+var Timeout = time.Second
+
+// Query asks server for the reflexive address of conn. conn must not be
+// connected, and nothing else may read from it meanwhile.
+func Query(conn *net.UDPConn, server *net.UDPAddr) (*net.UDPAddr, error) {
+	req := make([]byte, headerLength)
+	binary.BigEndian.PutUint16(req[0:], bindingRequest)
+	binary.BigEndian.PutUint32(req[4:], magicCookie)
+	if _, err := rand.Read(req[8:headerLength]); err != nil {
+		return nil, err
+	}
+	txID := req[8:headerLength]
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < Attempts; attempt++ {
+		if _, err := conn.WriteToUDP(req, server); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(Timeout))
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				break
+			}
+			if !from.IP.Equal(server.IP) || from.Port != server.Port {
+				continue
+			}
+			if addr, err := parseResponse(buf[:n], txID); err == nil {
+				return addr, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no response from STUN server %v", server)
+}
+
+// parses a Binding response to the request with txID.
+func parseResponse(msg []byte, txID []byte) (*net.UDPAddr, error) {
+	if len(msg) < headerLength ||
+		binary.BigEndian.Uint16(msg[0:]) != bindingResponse ||
+		binary.BigEndian.Uint32(msg[4:]) != magicCookie ||
+		!bytes.Equal(msg[8:headerLength], txID) {
+		return nil, errors.New("not a response to our request")
+	}
+	attrs := msg[headerLength:]
+	if length := int(binary.BigEndian.Uint16(msg[2:])); length <= len(attrs) {
+		attrs = attrs[:length]
+	}
+	var mapped *net.UDPAddr
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		length := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+length > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+length]
+		switch typ {
+		case attrXorMappedAddress:
+			if addr := parseAddress(value, msg[4:headerLength]); addr != nil {
+				return addr, nil
+			}
+		case attrMappedAddress:
+			mapped = parseAddress(value, nil)
+		}
+		// attributes are padded to 4 bytes, except maybe the last one
+		next := 4 + (length+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	if mapped == nil {
+		return nil, errors.New("response without mapped address")
+	}
+	return mapped, nil
+}
+
+// parses a (XOR-)MAPPED-ADDRESS attribute. for the XOR variant, key is
+// the magic cookie followed by the transaction ID.
+func parseAddress(value []byte, key []byte) *net.UDPAddr {
+	if len(value) < 4 {
+		return nil
+	}
+	var ip net.IP
+	switch value[1] {
+	case 0x01:
+		ip = make(net.IP, net.IPv4len)
+	case 0x02:
+		ip = make(net.IP, net.IPv6len)
+	default:
+		return nil
+	}
+	if len(value) < 4+len(ip) {
+		return nil
+	}
+	port := binary.BigEndian.Uint16(value[2:])
+	copy(ip, value[4:])
+	if key != nil {
+		port ^= magicCookie >> 16
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}
+}
+
+// Discover queries the servers for the reflexive address of conn and
+// describes the NAT in front of it: none if the address is local, and, if
+// two servers are given, whether the NAT maps the socket to the same
+// public address for every destination, which UDP hole punching needs, or
+// a different one (symmetric NAT).
+func Discover(conn *net.UDPConn, servers []*net.UDPAddr) (*net.UDPAddr,
+	string, error) {
+	if len(servers) == 0 {
+		return nil, "", errors.New("no STUN server")
+	}
+	addr, err := Query(conn, servers[0])
+	if err != nil {
+		return nil, "", err
+	}
+	local := conn.LocalAddr().(*net.UDPAddr)
+	if isLocal(addr.IP) && addr.Port == local.Port {
+		return addr, "no NAT", nil
+	}
+	if len(servers) < 2 {
+		return addr, "NAT (type unknown, needs two STUN servers)", nil
+	}
+	other, err := Query(conn, servers[1])
+	if err != nil {
+		return addr, "NAT (type unknown: " + err.Error() + ")", nil
+	}
+	if other.IP.Equal(addr.IP) && other.Port == addr.Port {
+		return addr, "NAT with endpoint-independent mapping", nil
+	}
+	return addr, fmt.Sprintf("symmetric NAT (%v for the second server)",
+		other), nil
+}
+
+// tells whether ip belongs to one of our interfaces
+func isLocal(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveServers resolves a comma separated list of STUN servers.
+func ResolveServers(spec string) ([]*net.UDPAddr, error) {
+	var servers []*net.UDPAddr
+	for _, s := range strings.Split(spec, ",") {
+		addr, err := net.ResolveUDPAddr("udp", s)
+		if err != nil {
+			return nil, fmt.Errorf("STUN server %s: %v", s, err)
+		}
+		servers = append(servers, addr)
+	}
+	return servers, nil
+}
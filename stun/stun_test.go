@@ -0,0 +1,77 @@
+package stun
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+var testTxID = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+
+// builds a Binding response to testTxID carrying attrs, which are given
+// with their padding.
+func response(attrs ...[]byte) []byte {
+	msg := make([]byte, headerLength)
+	binary.BigEndian.PutUint16(msg[0:], bindingResponse)
+	binary.BigEndian.PutUint32(msg[4:], magicCookie)
+	copy(msg[8:], testTxID)
+	for _, attr := range attrs {
+		msg = append(msg, attr...)
+	}
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)-headerLength))
+	return msg
+}
+
+func attribute(typ uint16, value []byte) []byte {
+	attr := make([]byte, 4, 4+len(value))
+	binary.BigEndian.PutUint16(attr[0:], typ)
+	binary.BigEndian.PutUint16(attr[2:], uint16(len(value)))
+	return append(attr, value...)
+}
+
+func xorMapped(ip net.IP, port int) []byte {
+	value := []byte{0, 0x01, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(value[2:], uint16(port)^magicCookie>>16)
+	var cookie [4]byte
+	binary.BigEndian.PutUint32(cookie[:], magicCookie)
+	for i, b := range ip.To4() {
+		value[4+i] = b ^ cookie[i]
+	}
+	return attribute(attrXorMappedAddress, value)
+}
+
+func TestParseResponse(t *testing.T) {
+	msg := response(attribute(0x8022, []byte("abcd")),
+		xorMapped(net.IPv4(203, 0, 113, 7), 40000))
+	addr, err := parseResponse(msg, testTxID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !addr.IP.Equal(net.IPv4(203, 0, 113, 7)) || addr.Port != 40000 {
+		t.Errorf("got %v, want 203.0.113.7:40000", addr)
+	}
+}
+
+// the last attribute has an odd length and comes without its padding
+func TestParseResponseUnpaddedLastAttribute(t *testing.T) {
+	for _, length := range []int{1, 3, 5} {
+		msg := response(attribute(0x8022, make([]byte, length)))
+		if _, err := parseResponse(msg, testTxID); err == nil {
+			t.Errorf("length %d: response without address accepted",
+				length)
+		}
+		msg = response(xorMapped(net.IPv4(198, 51, 100, 1), 1234),
+			attribute(0x8022, make([]byte, length)))
+		addr, err := parseResponse(msg, testTxID)
+		if err != nil || addr.Port != 1234 {
+			t.Errorf("length %d: got %v, %v", length, addr, err)
+		}
+	}
+}
+
+func TestParseResponseOtherTransaction(t *testing.T) {
+	msg := response(xorMapped(net.IPv4(203, 0, 113, 7), 40000))
+	if _, err := parseResponse(msg, make([]byte, 12)); err == nil {
+		t.Error("response to another request accepted")
+	}
+}
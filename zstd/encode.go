@@ -0,0 +1,341 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// the compressor finds matches of at least minMatch bytes with a table of
+// the last position of each 4 byte sequence, like lz4
+const (
+	minMatch   = 4
+	hashBits   = 14
+	maxBlock   = 128 << 10
+	maxOffset  = 1 << 22
+	frameMagic = 0xfd2fb528
+)
+
+// Compress appends a zstd frame holding src to dst and returns the
+// result. It is meant for payloads of a datagram or a few and trades
+// ratio for speed: the sequences of each block are coded with the
+// predefined FSE tables of RFC 8878 and literals are Huffman coded when
+// that pays off, blocks that don't get smaller are stored as they are.
+// The frame announces its size and has no checksum.
+func Compress(dst, src []byte) []byte {
+	dst = appendFrameHeader(dst, len(src))
+	var e encoder
+	if len(src) == 0 {
+		return appendBlockHeader(dst, true, 0, 0)
+	}
+	for start := 0; start < len(src); start += maxBlock {
+		end := start + maxBlock
+		if end > len(src) {
+			end = len(src)
+		}
+		last := end == len(src)
+		mark := len(dst)
+		dst = appendBlockHeader(dst, last, 2, 0)
+		dst = e.compressBlock(dst, src, start, end)
+		size := len(dst) - mark - 3
+		if size >= end-start {
+			// incompressible; store the block raw
+			dst = append(appendBlockHeader(dst[:mark], last, 0, end-start),
+				src[start:end]...)
+			continue
+		}
+		appendBlockHeader(dst[:mark], last, 2, size)
+	}
+	return dst
+}
+
+// a single segment frame with the content size, the smallest header that
+// announces it. RFC 3.1.1.1.
+func appendFrameHeader(dst []byte, size int) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, frameMagic)
+	const singleSegment = 1 << 5
+	switch {
+	case size < 256:
+		return append(dst, singleSegment, byte(size))
+	case size < 256+0x10000:
+		dst = append(dst, 1<<6|singleSegment)
+		return binary.LittleEndian.AppendUint16(dst, uint16(size-256))
+	case uint64(size) < 1<<32:
+		dst = append(dst, 2<<6|singleSegment)
+		return binary.LittleEndian.AppendUint32(dst, uint32(size))
+	default:
+		dst = append(dst, 3<<6|singleSegment)
+		return binary.LittleEndian.AppendUint64(dst, uint64(size))
+	}
+}
+
+// RFC 3.1.1.2.
+func appendBlockHeader(dst []byte, last bool, typ, size int) []byte {
+	header := uint32(size)<<3 | uint32(typ)<<1
+	if last {
+		header |= 1
+	}
+	return append(dst, byte(header), byte(header>>8), byte(header>>16))
+}
+
+// a code of a sequence with its extra bits
+type coded struct {
+	code  uint8
+	extra uint32
+	bits  uint8
+}
+
+type sequence struct {
+	literals uint32
+	offset   uint32
+	match    uint32
+}
+
+type encoder struct {
+	// position+1 of the last occurrence of each hashed 4 byte sequence
+	table     [1 << hashBits]int32
+	literals  []byte
+	sequences []sequence
+	// the Huffman coded literals of a block
+	scratch []byte
+}
+
+func hash4(v uint32) uint32 {
+	return (v * 2654435761) >> (32 - hashBits)
+}
+
+// appends the literals and sequences sections of src[start:end] as a
+// compressed block; matches may reach back before start, the frame is a
+// single segment.
+func (e *encoder) compressBlock(dst, src []byte, start, end int) []byte {
+	e.literals = e.literals[:0]
+	e.sequences = e.sequences[:0]
+	anchor := start
+	for i := start; i+minMatch <= end; {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := hash4(v)
+		candidate := int(e.table[h]) - 1
+		e.table[h] = int32(i + 1)
+		if candidate < 0 || i-candidate > maxOffset ||
+			binary.LittleEndian.Uint32(src[candidate:]) != v {
+			i++
+			continue
+		}
+		n := minMatch
+		for i+n < end && src[candidate+n] == src[i+n] {
+			n++
+		}
+		e.literals = append(e.literals, src[anchor:i]...)
+		e.sequences = append(e.sequences, sequence{
+			literals: uint32(i - anchor),
+			offset:   uint32(i - candidate),
+			match:    uint32(n),
+		})
+		i += n
+		anchor = i
+	}
+	e.literals = append(e.literals, src[anchor:end]...)
+
+	dst = e.appendLiterals(dst, e.literals)
+	return appendSequences(dst, e.sequences)
+}
+
+// appends the sequences section, coded with the predefined tables.
+// RFC 3.1.1.3.2.
+func appendSequences(dst []byte, seqs []sequence) []byte {
+	n := len(seqs)
+	switch {
+	case n < 0x80:
+		dst = append(dst, byte(n))
+	case n < 0x7f00:
+		dst = append(dst, byte(n>>8)+0x80, byte(n))
+	default:
+		dst = append(dst, 0xff, byte(n-0x7f00), byte((n-0x7f00)>>8))
+	}
+	if n == 0 {
+		return dst
+	}
+	// all three in Predefined_Mode
+	dst = append(dst, 0)
+
+	code := func(seq sequence) (ll, of, ml coded) {
+		ll = lengthCode(seq.literals, 0, literalLengthOffset,
+			literalLengthBase)
+		ml = lengthCode(seq.match, 3, matchLengthOffset, matchLengthBase)
+		// no repeat offsets, Offset_Value is offset+3
+		value := seq.offset + 3
+		c := uint8(bits.Len32(value) - 1)
+		of = coded{c, value - 1<<c, c}
+		return
+	}
+
+	w := bitWriter{buf: dst}
+	ll, of, ml := code(seqs[n-1])
+	llState := predefinedLiteralEncoder.init(ll.code)
+	ofState := predefinedOffsetEncoder.init(of.code)
+	mlState := predefinedMatchEncoder.init(ml.code)
+	w.add(ll.extra, ll.bits)
+	w.add(ml.extra, ml.bits)
+	w.add(of.extra, of.bits)
+	for i := n - 2; i >= 0; i-- {
+		ll, of, ml := code(seqs[i])
+		predefinedOffsetEncoder.encode(&w, &ofState, of.code)
+		predefinedMatchEncoder.encode(&w, &mlState, ml.code)
+		predefinedLiteralEncoder.encode(&w, &llState, ll.code)
+		w.add(ll.extra, ll.bits)
+		w.add(ml.extra, ml.bits)
+		w.add(of.extra, of.bits)
+	}
+	w.add(mlState, predefinedMatchEncoder.tableLog)
+	w.add(ofState, predefinedOffsetEncoder.tableLog)
+	w.add(llState, predefinedLiteralEncoder.tableLog)
+	return w.close()
+}
+
+// the code of a literal or match length: below the first baseline (after
+// taking off min) the code is the value itself, see literalLengthBase.
+func lengthCode(value, min uint32, offset int, base []uint32) (c coded) {
+	if value-min < uint32(offset) {
+		c.code = uint8(value - min)
+		return
+	}
+	i := len(base) - 1
+	for base[i]&0xffffff > value {
+		i--
+	}
+	c.code = uint8(offset + i)
+	c.extra = value - base[i]&0xffffff
+	c.bits = uint8(base[i] >> 24)
+	return
+}
+
+// writes a bit stream the decoder reads backwards, see
+// makeReverseBitReader.
+type bitWriter struct {
+	buf  []byte
+	bits uint64
+	cnt  uint8
+}
+
+func (w *bitWriter) add(value uint32, n uint8) {
+	w.bits |= uint64(value&(1<<n-1)) << w.cnt
+	w.cnt += n
+	for w.cnt >= 8 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits >>= 8
+		w.cnt -= 8
+	}
+}
+
+// ends the stream with the 1 bit that marks where it starts
+func (w *bitWriter) close() []byte {
+	w.add(1, 1)
+	if w.cnt > 0 {
+		w.buf = append(w.buf, byte(w.bits))
+	}
+	return w.buf
+}
+
+// fseEncoder codes symbols with an FSE table of the given distribution,
+// the inverse of the tables buildFSE makes. States are kept as
+// 1<<tableLog plus the index into the decoding table.
+type fseEncoder struct {
+	tableLog   uint8
+	stateTable []uint16
+	// per symbol: added to the state, the upper 16 bits are the number
+	// of bits to write for it
+	deltaBits []uint32
+	// per symbol: the offset of its states in stateTable
+	deltaState []int32
+}
+
+// builds the encoder of a normalized distribution; -1 stands for a
+// probability below 1, which takes a single cell at the end of the
+// table. RFC 4.1.1.
+func newFSEEncoder(norm []int16, tableLog uint8) *fseEncoder {
+	size := 1 << tableLog
+	mask := size - 1
+	high := size - 1
+	symbols := make([]uint8, size)
+	cumul := make([]int, len(norm)+1)
+	for s, n := range norm {
+		if n == -1 {
+			cumul[s+1] = cumul[s] + 1
+			symbols[high] = uint8(s)
+			high--
+		} else {
+			cumul[s+1] = cumul[s] + int(n)
+		}
+	}
+
+	step := size>>1 + size>>3 + 3
+	pos := 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			symbols[pos] = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+
+	e := &fseEncoder{
+		tableLog:   tableLog,
+		stateTable: make([]uint16, size),
+		deltaBits:  make([]uint32, len(norm)),
+		deltaState: make([]int32, len(norm)),
+	}
+	for u, s := range symbols {
+		e.stateTable[cumul[s]] = uint16(size + u)
+		cumul[s]++
+	}
+	total := 0
+	for s, n := range norm {
+		switch n {
+		case 0:
+		case -1, 1:
+			e.deltaBits[s] = uint32(tableLog)<<16 - uint32(size)
+			e.deltaState[s] = int32(total - 1)
+			total++
+		default:
+			maxBits := uint32(tableLog) - uint32(bits.Len16(uint16(n-1))-1)
+			e.deltaBits[s] = maxBits<<16 - uint32(n)<<maxBits
+			e.deltaState[s] = int32(total - int(n))
+			total += int(n)
+		}
+	}
+	return e
+}
+
+// the state the last symbol of a stream, the first one decoded, starts
+// in
+func (e *fseEncoder) init(sym uint8) uint32 {
+	n := (e.deltaBits[sym] + 1<<15) >> 16
+	state := n<<16 - e.deltaBits[sym]
+	return uint32(e.stateTable[int32(state>>n)+e.deltaState[sym]])
+}
+
+func (e *fseEncoder) encode(w *bitWriter, state *uint32, sym uint8) {
+	n := (*state + e.deltaBits[sym]) >> 16
+	w.add(*state, uint8(n))
+	*state = uint32(e.stateTable[int32(*state>>n)+e.deltaState[sym]])
+}
+
+// the predefined distributions of RFC 3.1.1.3.2.2
+var (
+	predefinedLiteralEncoder = newFSEEncoder([]int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}, 6)
+	predefinedMatchEncoder = newFSEEncoder([]int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}, 6)
+	predefinedOffsetEncoder = newFSEEncoder([]int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}, 5)
+)
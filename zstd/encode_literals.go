@@ -0,0 +1,389 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// literals sections shorter than this are stored raw; the Huffman table
+// would cost more than it saves
+const minHuffmanLiterals = 64
+
+// appends the literals section: raw, RLE or Huffman coded, whichever is
+// smallest. RFC 3.1.1.3.1.
+func (e *encoder) appendLiterals(dst, literals []byte) []byte {
+	var counts [256]int
+	for _, b := range literals {
+		counts[b]++
+	}
+	if len(literals) > 1 && counts[literals[0]] == len(literals) {
+		return append(appendLiteralsHeader(dst, 1, len(literals)),
+			literals[0])
+	}
+	if len(literals) >= minHuffmanLiterals {
+		mark := len(dst)
+		dst = e.appendHuffmanLiterals(dst, literals, &counts)
+		if len(dst) > mark {
+			return dst
+		}
+	}
+	return append(appendLiteralsHeader(dst, 0, len(literals)), literals...)
+}
+
+// the header of raw (typ 0) and RLE (typ 1) literals
+func appendLiteralsHeader(dst []byte, typ byte, n int) []byte {
+	switch {
+	case n < 1<<5:
+		return append(dst, byte(n<<3)|typ)
+	case n < 1<<12:
+		return append(dst, byte(n<<4)|1<<2|typ, byte(n>>4))
+	default:
+		return append(dst, byte(n<<4)|3<<2|typ, byte(n>>4), byte(n>>12))
+	}
+}
+
+// appends Huffman coded literals, or nothing if they wouldn't be smaller
+// than raw ones. RFC 3.1.1.3.1.4.
+func (e *encoder) appendHuffmanLiterals(dst, literals []byte,
+	counts *[256]int) []byte {
+	var lengths [256]uint8
+	maxBits := huffmanLengths(counts, &lengths)
+
+	var weights [256]uint8
+	last := 0
+	for s, l := range lengths {
+		if l > 0 {
+			weights[s] = maxBits + 1 - l
+			last = s
+		}
+	}
+	var codes [256]uint16
+	huffmanCodes(&weights, &lengths, maxBits, &codes)
+
+	e.scratch = appendHuffmanWeights(e.scratch[:0], weights[:last])
+	if e.scratch == nil {
+		return dst
+	}
+	tree := len(e.scratch)
+
+	n := len(literals)
+	streams := 1
+	if n > 1023 {
+		streams = 4
+		e.scratch = append(e.scratch, 0, 0, 0, 0, 0, 0)
+	}
+	size := (n + 3) / 4
+	for i := 0; i < streams; i++ {
+		part := literals
+		if streams == 4 {
+			part = literals[i*size:]
+			if i < 3 {
+				part = part[:size]
+			}
+		}
+		mark := len(e.scratch)
+		w := bitWriter{buf: e.scratch}
+		for j := len(part) - 1; j >= 0; j-- {
+			w.add(uint32(codes[part[j]]), lengths[part[j]])
+		}
+		e.scratch = w.close()
+		if streams == 4 && i < 3 {
+			binary.LittleEndian.PutUint16(e.scratch[tree+2*i:],
+				uint16(len(e.scratch)-mark))
+		}
+	}
+
+	compressed := len(e.scratch)
+	var header uint64
+	var headerLen int
+	switch {
+	case streams == 1:
+		header, headerLen = 0<<2, 3
+	case n < 1<<10 && compressed < 1<<10:
+		header, headerLen = 1<<2, 3
+	case n < 1<<14 && compressed < 1<<14:
+		header, headerLen = 2<<2, 4
+	case compressed < 1<<18:
+		header, headerLen = 3<<2, 5
+	default:
+		return dst
+	}
+	if headerLen+compressed >= n+3 {
+		// the single stream's sizes have 10 bits, it's no bigger than
+		// that either
+		return dst
+	}
+	bits := uint(headerLen*8-4) / 2
+	header |= 2 | uint64(n)<<4 | uint64(compressed)<<(4+bits)
+	for i := 0; i < headerLen; i++ {
+		dst = append(dst, byte(header>>(8*i)))
+	}
+	return append(dst, e.scratch...)
+}
+
+// computes the code lengths of a complete prefix code for counts with at
+// least two symbols, at most maxHuffmanBits long, and returns the
+// longest.
+func huffmanLengths(counts *[256]int, lengths *[256]uint8) uint8 {
+	type node struct {
+		count  int
+		parent int
+		symbol int
+	}
+	var nodes []node
+	for s, c := range counts {
+		if c > 0 {
+			nodes = append(nodes, node{count: c, symbol: s})
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].count < nodes[j].count
+	})
+
+	// the internal nodes come in ascending order too, so the two
+	// smallest are always at the front of either queue
+	leaves := len(nodes)
+	leaf, inner := 0, leaves
+	smallest := func() int {
+		if leaf < leaves && (inner == len(nodes) ||
+			nodes[leaf].count <= nodes[inner].count) {
+			leaf++
+			return leaf - 1
+		}
+		inner++
+		return inner - 1
+	}
+	for len(nodes) < 2*leaves-1 {
+		a, b := smallest(), smallest()
+		nodes = append(nodes, node{count: nodes[a].count + nodes[b].count})
+		nodes[a].parent = len(nodes) - 1
+		nodes[b].parent = len(nodes) - 1
+	}
+	depths := make([]uint8, len(nodes))
+	for i := len(nodes) - 2; i >= 0; i-- {
+		depths[i] = depths[nodes[i].parent] + 1
+	}
+
+	// clamp the longest codes and lengthen the shortest ones until the
+	// code fits again, then shorten the longest ones while there is
+	// room, so it stays complete. kraft is in units of the longest code.
+	const limit = maxHuffmanBits
+	kraft := 0
+	for i := 0; i < leaves; i++ {
+		if depths[i] > limit {
+			depths[i] = limit
+		}
+		kraft += 1 << (limit - depths[i])
+	}
+	for kraft > 1<<limit {
+		// leaves are sorted by count, take the rarest of the longest
+		// codes below the limit
+		best := -1
+		for i := 0; i < leaves; i++ {
+			if depths[i] < limit && (best < 0 || depths[i] > depths[best]) {
+				best = i
+			}
+		}
+		kraft -= 1 << (limit - depths[best] - 1)
+		depths[best]++
+	}
+	for kraft < 1<<limit {
+		best := -1
+		for i := leaves - 1; i >= 0; i-- {
+			if depths[i] > 1 && 1<<(limit-depths[i]) <= 1<<limit-kraft &&
+				(best < 0 || depths[i] > depths[best]) {
+				best = i
+			}
+		}
+		kraft += 1 << (limit - depths[best])
+		depths[best]--
+	}
+
+	var maxBits uint8
+	for i := 0; i < leaves; i++ {
+		lengths[nodes[i].symbol] = depths[i]
+		if depths[i] > maxBits {
+			maxBits = depths[i]
+		}
+	}
+	return maxBits
+}
+
+// assigns the codes of the lengths the way readHuff lays out its table:
+// by ascending weight, then symbol.
+func huffmanCodes(weights *[256]uint8, lengths *[256]uint8, maxBits uint8,
+	codes *[256]uint16) {
+	var start [maxHuffmanBits + 2]uint32
+	for _, w := range weights {
+		if w > 0 {
+			start[w] += 1 << (w - 1)
+		}
+	}
+	next := uint32(0)
+	for w := 1; w <= int(maxBits); w++ {
+		cur := next
+		next += start[w]
+		start[w] = cur
+	}
+	for s, w := range weights {
+		if w == 0 {
+			continue
+		}
+		codes[s] = uint16(start[w] >> (w - 1))
+		start[w] += 1 << (w - 1)
+	}
+}
+
+// appends the Huffman tree description, the weights of all symbols but
+// the last, which the decoder derives. they are FSE compressed if that's
+// smaller, or stored 4 bits each if there are at most 128 of them. returns
+// nil if neither is possible. RFC 4.2.1.
+func appendHuffmanWeights(dst []byte, weights []uint8) []byte {
+	direct := len(weights) <= 128
+	compressed := appendFSEWeights(nil, weights)
+	if compressed != nil && len(compressed) < 128 &&
+		(!direct || len(compressed) < (len(weights)+1)/2) {
+		dst = append(dst, byte(len(compressed)))
+		return append(dst, compressed...)
+	}
+	if !direct {
+		return nil
+	}
+	dst = append(dst, byte(127+len(weights)))
+	for i := 0; i < len(weights); i += 2 {
+		b := weights[i] << 4
+		if i+1 < len(weights) {
+			b |= weights[i+1]
+		}
+		dst = append(dst, b)
+	}
+	return dst
+}
+
+// the accuracy of the FSE table of Huffman weights
+const weightsTableLog = 6
+
+// appends the weights FSE compressed with two interleaved states, or
+// returns nil if they take less than two distinct values, which FSE can't
+// code. RFC 4.2.1.2.
+func appendFSEWeights(dst []byte, weights []uint8) []byte {
+	var counts [maxHuffmanBits + 1]int
+	distinct := 0
+	for _, w := range weights {
+		if counts[w] == 0 {
+			distinct++
+		}
+		counts[w]++
+	}
+	if distinct < 2 {
+		return nil
+	}
+	alphabet := len(counts)
+	for counts[alphabet-1] == 0 {
+		alphabet--
+	}
+	norm := normalizeCounts(counts[:alphabet], len(weights), weightsTableLog)
+	dst = appendNormalizedCounts(dst, norm, weightsTableLog)
+
+	e := newFSEEncoder(norm, weightsTableLog)
+	w := bitWriter{buf: dst}
+	// even weights are decoded with the first state, odd ones with the
+	// second; the last two start them
+	var states [2]uint32
+	for i := len(weights) - 1; i >= 0; i-- {
+		state := &states[i&1]
+		if i >= len(weights)-2 {
+			*state = e.init(weights[i])
+			continue
+		}
+		e.encode(&w, state, weights[i])
+	}
+	w.add(states[1], weightsTableLog)
+	w.add(states[0], weightsTableLog)
+	return w.close()
+}
+
+// scales counts summing to total to 1<<tableLog, keeping every symbol
+// that occurs at 1 or more
+func normalizeCounts(counts []int, total int, tableLog uint8) []int16 {
+	size := 1 << tableLog
+	norm := make([]int16, len(counts))
+	sum := 0
+	for s, c := range counts {
+		if c == 0 {
+			continue
+		}
+		n := c * size / total
+		if n < 1 {
+			n = 1
+		}
+		norm[s] = int16(n)
+		sum += n
+	}
+	largest := func() int {
+		best := 0
+		for s := range norm {
+			if norm[s] > norm[best] {
+				best = s
+			}
+		}
+		return best
+	}
+	for ; sum > size; sum-- {
+		norm[largest()]--
+	}
+	for ; sum < size; sum++ {
+		norm[largest()]++
+	}
+	return norm
+}
+
+// appends the FSE table description readFSE reads. RFC 4.1.1.
+func appendNormalizedCounts(dst []byte, norm []int16, tableLog uint8) []byte {
+	w := bitWriter{buf: dst}
+	w.add(uint32(tableLog-5), 4)
+	remaining := 1<<tableLog + 1
+	threshold := 1 << tableLog
+	nbBits := tableLog + 1
+	previous0 := false
+	for s := 0; s < len(norm) && remaining > 1; {
+		if previous0 {
+			// the number of further zero counts, 3 at a time
+			start := s
+			for norm[s] == 0 {
+				s++
+			}
+			for ; s >= start+3; start += 3 {
+				w.add(3, 2)
+			}
+			w.add(uint32(s-start), 2)
+		}
+		count := int(norm[s])
+		s++
+		max := 2*threshold - 1 - remaining
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		value := count + 1
+		if value >= threshold {
+			value += max
+		}
+		if value < max {
+			w.add(uint32(value), nbBits-1)
+		} else {
+			w.add(uint32(value), nbBits)
+		}
+		previous0 = value == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	// the description ends on a byte boundary
+	if w.cnt > 0 {
+		w.buf = append(w.buf, byte(w.bits))
+	}
+	return w.buf
+}
@@ -0,0 +1,151 @@
+package zstd
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+const testText = "Alternating bit protocol over UDP: every packet waits for " +
+	"its ACK, alternating bit protocol over UDP, every packet.\n"
+
+// testText compressed by the reference implementation (zstd -19
+// --no-check): Huffman coded literals, sequences with their own FSE tables
+var referenceFrame = []byte{
+	0x28, 0xb5, 0x2f, 0xfd, 0x20, 0x74, 0x3d, 0x02, 0x00, 0x32, 0x84, 0x0e,
+	0x11, 0xa0, 0x6f, 0x48, 0xbb, 0x73, 0x95, 0xad, 0x98, 0x1d, 0x03, 0x06,
+	0x5f, 0xe2, 0xcb, 0x6f, 0x17, 0x0f, 0x40, 0x04, 0xd5, 0xe1, 0x14, 0xd2,
+	0x75, 0xb6, 0xb6, 0xff, 0x14, 0xee, 0x77, 0xc8, 0x14, 0xed, 0x5c, 0xfd,
+	0x7a, 0x0f, 0x64, 0x52, 0x57, 0xbf, 0xb3, 0xe3, 0x64, 0xf3, 0x67, 0xa5,
+	0xfd, 0x6f, 0xb5, 0x93, 0xef, 0x95, 0xac, 0xfe, 0x91, 0x04, 0x03, 0x00,
+	0x70, 0x08, 0x23, 0x60, 0xd1, 0xec, 0x85, 0x02,
+}
+
+func decompress(t *testing.T, frame []byte) []byte {
+	t.Helper()
+	data, err := io.ReadAll(NewReader(bytes.NewReader(frame)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReference(t *testing.T) {
+	if got := decompress(t, referenceFrame); string(got) != testText {
+		t.Fatalf("decompressed %q", got)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 5000)
+	rnd.Read(random)
+	// text with few symbols (weights stored 4 bits each) and binary data
+	// using all of them (FSE compressed weights)
+	var text, binary []byte
+	for len(text) < 300<<10 {
+		text = append(text, testText[rnd.Intn(len(testText)/2):]...)
+		n := rnd.Intn(200)
+		for i := 0; i < n; i++ {
+			binary = append(binary, byte(rnd.Intn(256)),
+				byte(rnd.NormFloat64()*8))
+		}
+		binary = append(binary, random[:rnd.Intn(len(random))]...)
+	}
+	// counts growing like the Fibonacci numbers make a Huffman tree
+	// deeper than the 11 bits codes may have
+	var skewed []byte
+	for a, b, sym := 1, 1, 0; sym < 20; a, b, sym = b, a+b, sym+1 {
+		skewed = append(skewed, bytes.Repeat([]byte{byte(sym)}, a)...)
+	}
+	rnd.Shuffle(len(skewed), func(i, j int) {
+		skewed[i], skewed[j] = skewed[j], skewed[i]
+	})
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"byte", []byte{42}},
+		{"short", []byte(testText[:20])},
+		{"text", []byte(testText)},
+		{"rle", bytes.Repeat([]byte{7}, 1000)},
+		{"random", random},
+		// several blocks, matches reaching into earlier ones
+		{"long text", text},
+		{"binary", binary},
+		{"packet", text[:1400]},
+		{"skewed", skewed},
+	}
+	for _, test := range tests {
+		frame := Compress(nil, test.data)
+		if got := decompress(t, frame); !bytes.Equal(got, test.data) {
+			t.Errorf("%s: round trip changed the data", test.name)
+		}
+		if len(test.data) > 1000 && test.name != "random" &&
+			len(frame) > len(test.data)*3/4 {
+			t.Errorf("%s: compressed %d bytes to %d", test.name,
+				len(test.data), len(frame))
+		}
+	}
+
+	// appends to dst
+	frame := Compress([]byte("prefix"), []byte(testText))
+	if !bytes.HasPrefix(frame, []byte("prefix")) ||
+		string(decompress(t, frame[6:])) != testText {
+		t.Errorf("didn't append to dst")
+	}
+}
+
+func TestHuffmanLengths(t *testing.T) {
+	var counts [256]int
+	for a, b, sym := 1, 1, 0; sym < 30; a, b, sym = b, a+b, sym+1 {
+		counts[sym] = a
+	}
+	var lengths [256]uint8
+	maxBits := huffmanLengths(&counts, &lengths)
+	kraft := 0
+	for _, l := range lengths {
+		if l > maxBits {
+			t.Fatalf("code of %d bits, the longest is %d", l, maxBits)
+		}
+		if l > 0 {
+			kraft += 1 << (maxHuffmanBits - l)
+		}
+	}
+	if maxBits != maxHuffmanBits || kraft != 1<<maxHuffmanBits {
+		t.Errorf("longest code %d bits, Kraft sum %d/%d", maxBits, kraft,
+			1<<maxHuffmanBits)
+	}
+}
+
+func TestRandomLengths(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		// a small alphabet, so there are matches and Huffman literals
+		data := make([]byte, rnd.Intn(4000))
+		alphabet := 2 + rnd.Intn(60)
+		for j := range data {
+			data[j] = byte(rnd.Intn(alphabet))
+		}
+		if got := decompress(t, Compress(nil, data)); !bytes.Equal(got, data) {
+			t.Fatalf("round trip of %d bytes from %d symbols failed",
+				len(data), alphabet)
+		}
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	frame := Compress(nil, []byte(testText+testText))
+	for i := 4; i < len(frame); i++ {
+		corrupt := append([]byte(nil), frame...)
+		corrupt[i] ^= 0x55
+		// anything but a panic or a hang will do
+		io.ReadAll(NewReader(bytes.NewReader(corrupt)))
+	}
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(
+		frame[:len(frame)-1]))); err == nil {
+		t.Errorf("truncated frame decompressed")
+	}
+}